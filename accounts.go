@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mailAccount is one account of mail.accountmanager.accounts in prefs.js,
+// joined with its incoming server and identities.
+type mailAccount struct {
+	Key        string   `json:"key"`  // account1, ...
+	Name       string   `json:"name"` // the server's display name
+	Server     string   `json:"server"`
+	Type       string   `json:"type"` // imap, pop3, nntp, none (Local Folders), rss
+	Hostname   string   `json:"hostname,omitempty"`
+	Port       int64    `json:"port,omitempty"` // unset means the type's default
+	Username   string   `json:"username,omitempty"`
+	Identities []string `json:"identities,omitempty"`
+	Emails     []string `json:"emails,omitempty"`
+	Directory  string   `json:"directory,omitempty"` // where its folders live
+}
+
+// loadAccounts reads the profile's accounts in the order Thunderbird lists
+// them.
+func (a *App) loadAccounts(p Profile) ([]mailAccount, error) {
+	prefs, err := profilePrefs(p)
+	if err != nil {
+		return nil, err
+	}
+	return accountsFromPrefs(p, prefs), nil
+}
+
+func accountsFromPrefs(p Profile, prefs prefMap) []mailAccount {
+	var accounts []mailAccount
+	for _, key := range splitCSV(prefs.String("mail.accountmanager.accounts")) {
+		server := prefs.String(fmt.Sprintf("mail.account.%s.server", key))
+		acct := mailAccount{
+			Key:        key,
+			Name:       prefs.String(fmt.Sprintf("mail.server.%s.name", server)),
+			Server:     server,
+			Type:       prefs.String(fmt.Sprintf("mail.server.%s.type", server)),
+			Hostname:   prefs.String(fmt.Sprintf("mail.server.%s.hostname", server)),
+			Username:   prefs.String(fmt.Sprintf("mail.server.%s.userName", server)),
+			Identities: splitCSV(prefs.String(fmt.Sprintf("mail.account.%s.identities", key))),
+			Directory:  serverDirectory(p, prefs, server),
+		}
+		acct.Port, _ = prefs.Int(fmt.Sprintf("mail.server.%s.port", server))
+		for _, id := range acct.Identities {
+			if email := prefs.String(fmt.Sprintf("mail.identity.%s.useremail", id)); email != "" {
+				acct.Emails = append(acct.Emails, strings.ToLower(email))
+			}
+		}
+		accounts = append(accounts, acct)
+	}
+	return accounts
+}
+
+// serverDirectory is the folder directory of server: its absolute directory
+// pref, else directory-rel resolved against the profile.
+func serverDirectory(p Profile, prefs prefMap, server string) string {
+	if dir := prefs.String(fmt.Sprintf("mail.server.%s.directory", server)); dir != "" {
+		return dir
+	}
+	dirRel := prefs.String(fmt.Sprintf("mail.server.%s.directory-rel", server))
+	if strings.HasPrefix(dirRel, "[ProfD]") {
+		return filepath.Join(p.AbsolutePath, filepath.FromSlash(strings.TrimPrefix(dirRel, "[ProfD]")))
+	}
+	if dirRel != "" {
+		return filepath.Clean(dirRel)
+	}
+	return ""
+}
+
+// printAccounts lists the profile's accounts as a table, or as one JSON
+// object per account.
+func (a *App) printAccounts(profileName, format string) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	accounts, err := a.loadAccounts(profile)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		for _, acct := range accounts {
+			if err := enc.Encode(acct); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(accounts) == 0 {
+		fmt.Printf("No accounts configured in %s\n", profile.AbsolutePath)
+		return nil
+	}
+	rows := make([][]string, 0, len(accounts))
+	for _, acct := range accounts {
+		dir := acct.Directory
+		if rel, err := filepath.Rel(profile.AbsolutePath, dir); err == nil && !strings.HasPrefix(rel, "..") {
+			dir = rel
+		}
+		host := firstNonEmpty(acct.Hostname, "-")
+		if acct.Port != 0 {
+			host += ":" + strconv.FormatInt(acct.Port, 10)
+		}
+		rows = append(rows, []string{acct.Key, acct.Type, host, firstNonEmpty(acct.Username, "-"),
+			firstNonEmpty(strings.Join(acct.Emails, ", "), "-"), firstNonEmpty(dir, "-")})
+	}
+	renderTable(os.Stdout, []string{"Account", "Type", "Host", "User", "Emails", "Directory"}, rows)
+	return nil
+}
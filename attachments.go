@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// attachmentInfo describes one attachment part of a message.
+type attachmentInfo struct {
+	Index       string // dotted MIME part path, e.g. 1.2
+	Filename    string
+	ContentType string
+	Size        int64 // decoded size in bytes
+	part        mimePart
+}
+
+// partFilename returns the decoded filename of a part (RFC 2231 via ParseMediaType,
+// RFC 2047 via decodeHeader), falling back to the Content-Type name parameter.
+func partFilename(p mimePart) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(p.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		if _, params, err := mime.ParseMediaType(p.Header.Get("Content-Type")); err == nil {
+			name = params["name"]
+		}
+	}
+	name = decodeHeader(name)
+	return filepath.Base(filepath.FromSlash(strings.TrimSpace(name)))
+}
+
+// decodedPart returns the transfer-decoded bytes of a part.
+func decodedPart(p mimePart) []byte {
+	b, err := decodeBodyContent(p.Header.Get("Content-Transfer-Encoding"), p.Body)
+	if err != nil {
+		return p.Body
+	}
+	return b
+}
+
+// listAttachments walks the MIME tree of a raw message and returns its attachments.
+func listAttachments(raw []byte) ([]attachmentInfo, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
+	if err != nil {
+		return nil, err
+	}
+	var out []attachmentInfo
+	walkMIME(msg.Header, body, "", func(p mimePart) {
+		if !isAttachment(p) {
+			return
+		}
+		ctype, _, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil || ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		out = append(out, attachmentInfo{
+			Index:       p.Index,
+			Filename:    partFilename(p),
+			ContentType: ctype,
+			Size:        int64(len(decodedPart(p))),
+			part:        p,
+		})
+	})
+	return out, nil
+}
+
+func printAttachments(atts []attachmentInfo) {
+	if len(atts) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Attachments:")
+	for _, a := range atts {
+		fmt.Printf("  [%s] %s (%s, %s)\n", a.Index, a.saveName(), a.ContentType, byteSize(a.Size))
+	}
+}
+
+// saveName is the file name the attachment is saved under: its filename
+// with anything but a plain name character replaced, so names like ".." or
+// "a/../b" cannot leave the save directory, else part-N with an extension
+// for its type.
+func (a attachmentInfo) saveName() string {
+	if name := strings.Trim(emlNameUnsafe.ReplaceAllString(a.Filename, "_"), "_."); name != "" {
+		return name
+	}
+	name := "part-" + a.Index
+	if exts, _ := mime.ExtensionsByType(a.ContentType); len(exts) > 0 {
+		name += exts[0]
+	}
+	return name
+}
+
+// saveAttachments writes decoded attachment parts into dir, never overwriting
+// existing files, and returns the written paths.
+func saveAttachments(dir string, atts []attachmentInfo) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, a := range atts {
+		path, err := uniquePath(filepath.Join(dir, a.saveName()))
+		if err != nil {
+			return paths, err
+		}
+		if err := os.WriteFile(path, decodedPart(a.part), 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// uniquePath returns path, or path with a -N suffix before the extension if it exists.
+func uniquePath(path string) (string, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path, nil
+	}
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	for i := 1; i < 1000; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", stem, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("too many files named like %s", path)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// storedAttachment is one row of tb_attachments.
+type storedAttachment struct {
+	profile, messageID, part string
+	filename, contentType    string
+	size                     int64
+	sha256                   string
+}
+
+// UpsertAttachments replaces the attachment rows of every message in atts.
+// Rows for messages tb_messages does not hold are dropped.
+func (s *pgStore) UpsertAttachments(ctx context.Context, atts []storedAttachment) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if len(atts) == 0 {
+		return nil
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE tb_att_stage (profile text, message_id text, part text, filename text, content_type text, size_bytes bigint, sha256 text) ON COMMIT DROP"); err != nil {
+		return err
+	}
+	rows := make([][]any, 0, len(atts))
+	for _, a := range atts {
+		rows = append(rows, []any{a.profile, forceUTF8(a.messageID), a.part, forceUTF8(a.filename), forceUTF8(a.contentType), a.size, a.sha256})
+	}
+	cols := []string{"profile", "message_id", "part", "filename", "content_type", "size_bytes", "sha256"}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"tb_att_stage"}, cols, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("attachments: copy %d row(s): %w", len(atts), err)
+	}
+	if _, err := tx.Exec(ctx, `
+DELETE FROM tb_attachments a
+USING (SELECT DISTINCT profile, message_id FROM tb_att_stage) s
+WHERE a.profile = s.profile AND a.message_id = s.message_id
+`); err != nil {
+		return fmt.Errorf("attachments: clear: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO tb_attachments (profile, message_id, part, filename, content_type, size_bytes, sha256)
+SELECT DISTINCT ON (s.profile, s.message_id, s.part) s.profile, s.message_id, s.part, s.filename, s.content_type, s.size_bytes, s.sha256
+FROM tb_att_stage s
+JOIN tb_messages m USING (profile, message_id)
+ON CONFLICT DO NOTHING
+`); err != nil {
+		return fmt.Errorf("attachments: insert %d row(s): %w", len(atts), err)
+	}
+	return tx.Commit(ctx)
+}
+
+// attachmentQuery filters tb mail attachments search.
+type attachmentQuery struct {
+	profile     string
+	name        string // filename substring, case-insensitive
+	contentType string // content type substring, e.g. pdf or image/
+	from        string // sender substring
+	sha256      string // exact digest (hex)
+	accounts    []string
+	larger      int64
+	smaller     int64
+	limit       int
+}
+
+// attachmentHit is an attachment with the message it came from.
+type attachmentHit struct {
+	storedAttachment
+	when                        time.Time
+	date, from, subject, folder string
+}
+
+func (s *pgStore) SearchAttachments(ctx context.Context, q attachmentQuery) ([]attachmentHit, error) {
+	where := []string{"a.profile = $1"}
+	args := []any{q.profile}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if q.name != "" {
+		where = append(where, fmt.Sprintf("a.filename ILIKE '%%' || %s || '%%'", arg(q.name)))
+	}
+	if q.contentType != "" {
+		where = append(where, fmt.Sprintf("a.content_type ILIKE '%%' || %s || '%%'", arg(q.contentType)))
+	}
+	if q.from != "" {
+		where = append(where, fmt.Sprintf("m.sender ILIKE '%%' || %s || '%%'", arg(q.from)))
+	}
+	if q.sha256 != "" {
+		where = append(where, fmt.Sprintf("a.sha256 = %s", arg(strings.ToLower(q.sha256))))
+	}
+	if len(q.accounts) > 0 {
+		where = append(where, fmt.Sprintf("m.account = ANY(%s)", arg(q.accounts)))
+	}
+	if q.larger > 0 {
+		where = append(where, fmt.Sprintf("a.size_bytes > %s", arg(q.larger)))
+	}
+	if q.smaller > 0 {
+		where = append(where, fmt.Sprintf("a.size_bytes < %s", arg(q.smaller)))
+	}
+	limit := ""
+	if q.limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", q.limit)
+	}
+	rows, err := s.pool.Query(ctx, `
+SELECT a.message_id, a.part, a.filename, a.content_type, a.size_bytes, a.sha256,
+       m.when_ts, coalesce(m.date_str, ''), coalesce(m.sender, ''), coalesce(m.subject, ''), m.folder
+FROM tb_attachments a JOIN tb_messages m USING (profile, message_id)
+WHERE `+strings.Join(where, " AND ")+`
+ORDER BY m.when_ts DESC NULLS LAST, a.part`+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []attachmentHit
+	for rows.Next() {
+		var h attachmentHit
+		var when *time.Time
+		if err := rows.Scan(&h.messageID, &h.part, &h.filename, &h.contentType, &h.size, &h.sha256, &when, &h.date, &h.from, &h.subject, &h.folder); err != nil {
+			return nil, err
+		}
+		if when != nil {
+			h.when = *when
+		}
+		h.profile = q.profile
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// searchAttachments answers "who sent me contract_v3.docx" from the
+// attachment metadata sync records, without opening any mbox.
+func (a *App) searchAttachments(profileName, storeName string, q attachmentQuery) error {
+	if storeName != "pg" {
+		return fmt.Errorf("unknown --store %q (use pg)", storeName)
+	}
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	store, err := openPG()
+	if err != nil {
+		return fmt.Errorf("postgres required for attachments search: %w", err)
+	}
+	defer store.Close()
+	q.profile = profile.Name
+	hits, err := store.SearchAttachments(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	if len(hits) == 0 {
+		fmt.Println("No matches.")
+		return nil
+	}
+	rows := make([][]string, 0, len(hits))
+	for _, h := range hits {
+		date := h.date
+		if !h.when.IsZero() {
+			date = h.when.In(time.Local).Format("2006-01-02 15:04")
+		}
+		name := h.filename
+		if name == "" || name == "." {
+			name = "(unnamed)"
+		}
+		rows = append(rows, []string{date, truncate(h.from, 32), name, h.contentType, byteSize(h.size), truncate(h.folder, 30), h.messageID})
+	}
+	renderTable(os.Stdout, []string{"Date", "From", "Filename", "Type", "Size", "Folder", "Message-Id"}, rows)
+	return nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// authResult is one method=result entry of an Authentication-Results header.
+type authResult struct {
+	AuthServ string `json:"authserv_id"`
+	Method   string `json:"method"` // spf, dkim, dmarc, arc, ...
+	Result   string `json:"result"` // pass, fail, softfail, neutral, none, ...
+	Props    string `json:"props,omitempty"`
+}
+
+// dkimCheck is a DKIM-Signature header and, when requested, our own verification of it.
+type dkimCheck struct {
+	Domain   string `json:"domain"`
+	Selector string `json:"selector"`
+	Algo     string `json:"algorithm"`
+	Result   string `json:"result,omitempty"` // pass, fail, temperror, permerror (only with --verify-dkim)
+	Reason   string `json:"reason,omitempty"`
+}
+
+// authReport summarizes the authentication evidence of a message.
+type authReport struct {
+	FromDomain string       `json:"from_domain"`
+	Results    []authResult `json:"results"`
+	SPF        string       `json:"received_spf,omitempty"`
+	DKIM       []dkimCheck  `json:"dkim_signatures,omitempty"`
+	Verdict    string       `json:"verdict"`
+}
+
+var authComment = regexp.MustCompile(`\([^()]*\)`)
+
+// parseAuthResults parses Authentication-Results values (RFC 8601).
+func parseAuthResults(value string) []authResult {
+	// Comments can nest one level in practice; strip twice.
+	value = authComment.ReplaceAllString(authComment.ReplaceAllString(value, ""), "")
+	parts := strings.Split(value, ";")
+	authServ := strings.Fields(strings.TrimSpace(parts[0]))
+	serv := ""
+	if len(authServ) > 0 {
+		serv = authServ[0]
+	}
+	var out []authResult
+	for _, p := range parts[1:] {
+		fields := strings.Fields(p)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			continue
+		}
+		out = append(out, authResult{
+			AuthServ: serv,
+			Method:   strings.ToLower(method),
+			Result:   strings.ToLower(result),
+			Props:    strings.Join(fields[1:], " "),
+		})
+	}
+	return out
+}
+
+// parseTagList parses a DKIM tag=value list.
+func parseTagList(v string) map[string]string {
+	tags := map[string]string{}
+	for _, kv := range strings.Split(v, ";") {
+		k, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.TrimSpace(k)] = strings.Join(strings.Fields(val), "")
+	}
+	return tags
+}
+
+// buildAuthReport collects Authentication-Results, Received-SPF, and
+// DKIM-Signature evidence; with verify, DKIM signatures are checked via DNS.
+func buildAuthReport(m MailSummary, raw []byte, verify bool) authReport {
+	rep := authReport{FromDomain: addressDomain(senderAddress(m.From))}
+	fields := parseHeaderFields(raw)
+	for _, f := range fields {
+		switch strings.ToLower(f.Name) {
+		case "authentication-results":
+			rep.Results = append(rep.Results, parseAuthResults(f.Value)...)
+		case "received-spf":
+			if rep.SPF == "" {
+				if fs := strings.Fields(f.Value); len(fs) > 0 {
+					rep.SPF = strings.ToLower(fs[0])
+				}
+			}
+		}
+	}
+	var rawFields []rawHeader
+	var body []byte
+	if verify {
+		rawFields, body = splitRawHeaders(raw)
+	}
+	for _, f := range fields {
+		if !strings.EqualFold(f.Name, "DKIM-Signature") {
+			continue
+		}
+		tags := parseTagList(f.Value)
+		c := dkimCheck{Domain: strings.ToLower(tags["d"]), Selector: tags["s"], Algo: tags["a"]}
+		rep.DKIM = append(rep.DKIM, c)
+	}
+	if verify {
+		i := 0
+		for _, rf := range rawFields {
+			if !strings.EqualFold(rf.name, "DKIM-Signature") || i >= len(rep.DKIM) {
+				continue
+			}
+			rep.DKIM[i].Result, rep.DKIM[i].Reason = verifyDKIM(rf, rawFields, body)
+			i++
+		}
+	}
+	rep.Verdict = authVerdict(rep)
+	for _, d := range rep.DKIM {
+		if d.Result == "fail" {
+			rep.Verdict += "; local DKIM check failed for d=" + d.Domain
+			break
+		}
+	}
+	return rep
+}
+
+func addressDomain(addr string) string {
+	addr = strings.Trim(addr, "<>")
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return strings.ToLower(addr[i+1:])
+	}
+	return ""
+}
+
+// authVerdict combines the topmost receiver's results (the one added by your own
+// mail server; lower headers can be forged by the sender) with our DKIM checks.
+func authVerdict(rep authReport) string {
+	first := map[string]string{}
+	serv := ""
+	for _, r := range rep.Results {
+		if serv == "" {
+			serv = r.AuthServ
+		}
+		if r.AuthServ != serv {
+			break
+		}
+		if _, seen := first[r.Method]; !seen || r.Result == "pass" {
+			first[r.Method] = r.Result
+		}
+	}
+	for _, d := range rep.DKIM {
+		if d.Result == "pass" && (d.Domain == rep.FromDomain || strings.HasSuffix(rep.FromDomain, "."+d.Domain)) {
+			first["dkim-aligned"] = "pass"
+		}
+	}
+	switch {
+	case first["dmarc"] == "pass":
+		return "pass (dmarc)"
+	case first["dmarc"] == "fail":
+		return "fail (dmarc)"
+	case first["dkim-aligned"] == "pass":
+		return "pass (aligned dkim verified locally)"
+	case first["dkim"] == "pass" && (first["spf"] == "pass" || first["spf"] == ""):
+		return "pass (dkim)"
+	case first["spf"] == "fail" || first["dkim"] == "fail":
+		return "fail"
+	case first["spf"] == "softfail":
+		return "suspicious (spf softfail)"
+	case len(first) == 0 && rep.SPF == "":
+		return "none (no authentication results)"
+	case first["spf"] == "pass" || rep.SPF == "pass":
+		return "weak pass (spf only)"
+	}
+	return "none"
+}
+
+func printAuthReport(rep authReport) {
+	fmt.Println()
+	fmt.Println("Authentication:")
+	fmt.Printf("  From domain: %s\n", rep.FromDomain)
+	if len(rep.Results) == 0 {
+		fmt.Println("  Authentication-Results: (none)")
+	}
+	serv := ""
+	for _, r := range rep.Results {
+		note := ""
+		if serv == "" {
+			serv = r.AuthServ
+		}
+		if r.AuthServ != serv {
+			note = " [lower header, not trusted]"
+		}
+		props := r.Props
+		if props != "" {
+			props += " "
+		}
+		fmt.Printf("  %-6s %-9s %s(%s)%s\n", strings.ToUpper(r.Method)+":", r.Result, props, r.AuthServ, note)
+	}
+	if rep.SPF != "" {
+		fmt.Printf("  Received-SPF: %s\n", rep.SPF)
+	}
+	for _, d := range rep.DKIM {
+		line := fmt.Sprintf("  DKIM-Signature: d=%s s=%s a=%s", d.Domain, d.Selector, d.Algo)
+		if d.Result != "" {
+			line += " → " + d.Result
+			if d.Reason != "" {
+				line += " (" + d.Reason + ")"
+			}
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("  Verdict: %s\n", rep.Verdict)
+}
+
+// rawHeader is a header field exactly as it appears in the message, folding included.
+type rawHeader struct {
+	name string
+	raw  string // "Name: value" and any continuation lines, with their LF or CRLF endings
+}
+
+// splitRawHeaders returns the untouched header fields and the body of raw.
+func splitRawHeaders(raw []byte) ([]rawHeader, []byte) {
+	var fields []rawHeader
+	r := bufio.NewReader(bytes.NewReader(raw))
+	offset := 0
+	for {
+		line, err := r.ReadString('\n')
+		offset += len(line)
+		if strings.TrimRight(line, "\r\n") == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+		} else if name, _, ok := strings.Cut(line, ":"); ok {
+			fields = append(fields, rawHeader{name: strings.TrimSpace(name), raw: line})
+		}
+		if err != nil {
+			break
+		}
+	}
+	if offset > len(raw) {
+		offset = len(raw)
+	}
+	return fields, raw[offset:]
+}
+
+// dkimLookupTXT is the DNS resolver used for DKIM keys.
+var dkimLookupTXT = net.LookupTXT
+
+var (
+	wspRun   = regexp.MustCompile(`[ \t]+`)
+	dkimBTag = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+)
+
+// verifyDKIM checks one DKIM-Signature (RFC 6376) against the message.
+func verifyDKIM(sig rawHeader, fields []rawHeader, body []byte) (string, string) {
+	_, value, _ := strings.Cut(sig.raw, ":")
+	tags := parseTagList(value)
+	headerCanon, bodyCanon, _ := strings.Cut(tags["c"], "/")
+	if headerCanon == "" {
+		headerCanon = "simple"
+	}
+	if bodyCanon == "" {
+		bodyCanon = "simple"
+	}
+	var newHash func() hash.Hash
+	var cryptoHash crypto.Hash
+	switch tags["a"] {
+	case "rsa-sha256", "ed25519-sha256":
+		newHash, cryptoHash = sha256.New, crypto.SHA256
+	case "rsa-sha1":
+		newHash, cryptoHash = sha1.New, crypto.SHA1
+	default:
+		return "permerror", "unsupported algorithm " + tags["a"]
+	}
+
+	cbody := canonBody(body, bodyCanon)
+	if l := tags["l"]; l != "" {
+		var n int
+		if _, err := fmt.Sscan(l, &n); err == nil && n < len(cbody) {
+			cbody = cbody[:n]
+		}
+	}
+	bh := newHash()
+	bh.Write(cbody)
+	if base64.StdEncoding.EncodeToString(bh.Sum(nil)) != tags["bh"] {
+		return "fail", "body hash mismatch (body altered)"
+	}
+
+	// Signed headers are taken bottom-up when a name repeats.
+	used := map[int]bool{}
+	h := newHash()
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.TrimSpace(name)
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				h.Write([]byte(canonHeader(fields[i].raw, headerCanon)))
+				break
+			}
+		}
+	}
+	unsigned := dkimBTag.ReplaceAllString(sig.raw, "$1$2")
+	h.Write([]byte(strings.TrimRight(canonHeader(unsigned, headerCanon), "\r\n")))
+	digest := h.Sum(nil)
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return "permerror", "bad signature encoding"
+	}
+	txts, err := dkimLookupTXT(tags["s"] + "._domainkey." + tags["d"])
+	if err != nil {
+		return "temperror", "key lookup: " + err.Error()
+	}
+	key := parseTagList(strings.Join(txts, ""))
+	pub, err := base64.StdEncoding.DecodeString(key["p"])
+	if err != nil || len(pub) == 0 {
+		return "permerror", "no usable key (revoked or malformed)"
+	}
+	switch {
+	case strings.HasPrefix(tags["a"], "ed25519"):
+		if len(pub) != ed25519.PublicKeySize {
+			return "permerror", "bad ed25519 key"
+		}
+		if !ed25519.Verify(ed25519.PublicKey(pub), digest, signature) {
+			return "fail", "signature mismatch (headers altered or wrong key)"
+		}
+	default:
+		parsed, err := x509.ParsePKIXPublicKey(pub)
+		if err != nil {
+			parsed, err = x509.ParsePKCS1PublicKey(pub)
+		}
+		rsaKey, ok := parsed.(*rsa.PublicKey)
+		if err != nil || !ok {
+			return "permerror", "bad rsa key"
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, cryptoHash, digest, signature); err != nil {
+			return "fail", "signature mismatch (headers altered or wrong key)"
+		}
+	}
+	return "pass", ""
+}
+
+// canonHeader applies the simple or relaxed header canonicalization. Lines
+// read from an mbox end in a bare LF; both modes hash them with CRLF, as
+// canonBody does for the body.
+func canonHeader(raw, mode string) string {
+	if mode != "relaxed" {
+		return strings.ReplaceAll(strings.ReplaceAll(raw, "\r\n", "\n"), "\n", "\r\n")
+	}
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+	value = strings.TrimSpace(wspRun.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// canonBody applies the simple or relaxed body canonicalization.
+func canonBody(body []byte, mode string) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	if mode == "relaxed" {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(wspRun.ReplaceAllString(l, " "), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if mode == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
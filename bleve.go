@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// The bleve store (tb mail index --store bleve, tb mail search --store
+// bleve) is a Bleve full-text index built from the local index, so ranked
+// search needs no Postgres. Hits are scored with BM25, words go through
+// Bleve's English analyzer (stemming "invoices" to "invoice"), and matches in
+// the subject and sender are boosted over the body.
+
+// bleveBoost weighs a match per field; text is the message's search text.
+var bleveBoost = map[string]float64{"subject": 3, "from": 2, "to": 1, "text": 1}
+
+func bleveIndexPath(profile Profile) string {
+	return filepath.Join(indexDir(profile), "bleve")
+}
+
+func hasBleveIndex(profile Profile) bool {
+	_, err := os.Stat(bleveIndexPath(profile))
+	return err == nil
+}
+
+// bleveMapping indexes the searchable fields with the English analyzer and
+// keeps each message's MailSummary as a stored, unindexed field.
+func bleveMapping() *mapping.IndexMappingImpl {
+	doc := bleve.NewDocumentMapping()
+	for field := range bleveBoost {
+		text := bleve.NewTextFieldMapping()
+		text.Analyzer = en.AnalyzerName
+		text.Store = false
+		text.IncludeTermVectors = true // for "quoted phrases"
+		doc.AddFieldMappingsAt(field, text)
+	}
+	summary := bleve.NewTextFieldMapping()
+	summary.Index = false
+	summary.IncludeInAll = false
+	doc.AddFieldMappingsAt("summary", summary)
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	m.DefaultAnalyzer = en.AnalyzerName
+	return m
+}
+
+// bleveDocID identifies a message by its content, so a message whose flags
+// or folder changed is replaced rather than kept stale.
+func bleveDocID(summary []byte) string {
+	h := fnv.New64a()
+	h.Write(summary)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// updateBleveIndex brings the profile's Bleve index in line with its local
+// index: messages gone from it are deleted and new or changed ones added.
+func updateBleveIndex(profile Profile) error {
+	msgs, err := loadIndexedMessages(profile)
+	if err != nil {
+		return err
+	}
+	idx, err := bleve.Open(bleveIndexPath(profile))
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		idx, err = bleve.New(bleveIndexPath(profile), bleveMapping())
+	}
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	stale := map[string]bool{}
+	if n, err := idx.DocCount(); err != nil {
+		return err
+	} else if n > 0 {
+		res, err := idx.Search(bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(n), 0, false))
+		if err != nil {
+			return err
+		}
+		for _, h := range res.Hits {
+			stale[h.ID] = true
+		}
+	}
+	batch := idx.NewBatch()
+	added := 0
+	flush := func() error {
+		if batch.Size() == 0 {
+			return nil
+		}
+		if err := idx.Batch(batch); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+	for _, m := range msgs {
+		summary, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		id := bleveDocID(summary)
+		if stale[id] {
+			delete(stale, id)
+			continue
+		}
+		err = batch.Index(id, map[string]any{
+			"subject": m.Subject,
+			"from":    m.From,
+			"to":      m.To + " " + m.Cc,
+			"text":    m.Search,
+			"summary": string(summary),
+		})
+		if err != nil {
+			return err
+		}
+		added++
+		if batch.Size() >= 1000 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	for id := range stale {
+		batch.Delete(id)
+		if batch.Size() >= 1000 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	total, err := idx.DocCount()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Bleve index: %d messages (%d added, %d removed)\n", total, added, len(stale))
+	return nil
+}
+
+// loadIndexedMessages returns every message in the local index (SQLite, else
+// the JSON shards).
+func loadIndexedMessages(profile Profile) ([]MailSummary, error) {
+	db := sqliteIndexPath(profile)
+	if _, err := os.Stat(db); err == nil {
+		return sqliteIndexedMessages(db, "")
+	}
+	idx, err := loadIndex(profile)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []MailSummary
+	for _, fi := range idx.Folders {
+		msgs = append(msgs, fi.Messages...)
+	}
+	return msgs, nil
+}
+
+// bleveStore is the Bleve index as a Store. It only searches: tb mail index
+// keeps it in step with the local index.
+type bleveStore struct {
+	idx     bleve.Index
+	a       *App
+	profile Profile
+}
+
+func openBleveStore(a *App, profile Profile) (Store, error) {
+	idx, err := bleve.Open(bleveIndexPath(profile))
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		return nil, fmt.Errorf("no Bleve index for %s; run tb mail index --store bleve", profile.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bleveStore{idx: idx, a: a, profile: profile}, nil
+}
+
+// bleveQuery renders a search query for Bleve: every term must match in some
+// field, a "quoted phrase" as a phrase and a term ending in * as a prefix.
+func bleveQuery(q string) query.Query {
+	var must []query.Query
+	for _, t := range splitQueryTerms(q) {
+		prefix := strings.HasSuffix(t, "*")
+		if t = strings.TrimRight(t, "*"); t == "" {
+			continue
+		}
+		var fields []query.Query
+		for field, boost := range bleveBoost {
+			var fq query.Query
+			switch {
+			case prefix:
+				p := bleve.NewPrefixQuery(strings.ToLower(t))
+				p.SetField(field)
+				p.SetBoost(boost)
+				fq = p
+			case strings.Contains(t, " "):
+				p := bleve.NewMatchPhraseQuery(t)
+				p.SetField(field)
+				p.SetBoost(boost)
+				fq = p
+			default:
+				m := bleve.NewMatchQuery(t)
+				m.SetField(field)
+				m.SetBoost(boost)
+				m.SetOperator(query.MatchQueryOperatorAnd)
+				fq = m
+			}
+			fields = append(fields, fq)
+		}
+		must = append(must, bleve.NewDisjunctionQuery(fields...))
+	}
+	if len(must) == 0 {
+		return bleve.NewMatchAllQuery()
+	}
+	return bleve.NewConjunctionQuery(must...)
+}
+
+// Search returns the messages matching every term of q.query that pass q's
+// filters, best match first. Bleve matches words, so q.substring searches
+// the local index instead.
+func (s *bleveStore) Search(ctx context.Context, q queryOptions) ([]MailSummary, error) {
+	if q.substring {
+		local, err := s.a.openLocalStore(s.profile)
+		if err != nil {
+			return nil, err
+		}
+		defer local.Close()
+		return local.Search(ctx, q)
+	}
+	n, err := s.idx.DocCount()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	docs, err := s.search(bleveQuery(q.query), int(n))
+	if err != nil {
+		return nil, err
+	}
+	var hits []MailSummary
+	for _, m := range docs {
+		if filterMatches(m, q) {
+			hits = append(hits, m)
+		}
+	}
+	return hits, nil
+}
+
+// search returns up to size messages matching bq, best first, with their scores.
+func (s *bleveStore) search(bq query.Query, size int) ([]MailSummary, error) {
+	req := bleve.NewSearchRequestOptions(bq, size, 0, false)
+	req.Fields = []string{"summary"}
+	res, err := s.idx.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]MailSummary, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		raw, _ := h.Fields["summary"].(string)
+		var m MailSummary
+		if err := json.Unmarshal([]byte(raw), &m); err != nil {
+			return nil, fmt.Errorf("bleve document %s: %w", h.ID, err)
+		}
+		m.Score = h.Score
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+func (s *bleveStore) Upsert(context.Context, []MailSummary) error {
+	return fmt.Errorf("the Bleve index is built by tb mail index --store bleve")
+}
+
+func (s *bleveStore) Prune(context.Context, string, []string) (int64, error) {
+	return 0, fmt.Errorf("the Bleve index is built by tb mail index --store bleve")
+}
+
+// Stats ignores profile: the index belongs to one.
+func (s *bleveStore) Stats(context.Context, string) (StoreStats, error) {
+	st := StoreStats{Backend: "bleve"}
+	n, err := s.idx.DocCount()
+	if err != nil {
+		return st, err
+	}
+	all, err := s.search(bleve.NewMatchAllQuery(), int(n))
+	if err != nil {
+		return st, err
+	}
+	folders := map[string]bool{}
+	for _, m := range all {
+		st.Messages++
+		st.SearchBytes += int64(len(m.Search))
+		folders[m.Folder] = true
+		if m.When.IsZero() {
+			continue
+		}
+		if st.Oldest.IsZero() || m.When.Before(st.Oldest) {
+			st.Oldest = m.When
+		}
+		if m.When.After(st.Newest) {
+			st.Newest = m.When
+		}
+	}
+	st.Folders = int64(len(folders))
+	st.ByFolder = countBy(all, func(m MailSummary) string { return m.Folder })
+	st.ByAccount = countBy(all, func(m MailSummary) string { return m.Account })
+	st.ByYear = countBy(all, statsYear)
+	st.Storage = []StoreSpace{{Name: "bleve/", Bytes: dirSize(bleveIndexPath(s.profile)), Reclaimable: -1}}
+	return st, nil
+}
+
+func (s *bleveStore) Close() { s.idx.Close() }
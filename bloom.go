@@ -0,0 +1,78 @@
+package main
+
+// folderBloom is a Bloom filter over the byte trigrams of a folder's search
+// text, stored with the folder in the local index. A substring can only occur
+// in a folder holding all of its trigrams, so a search skips every folder
+// whose filter lacks one of them without reading its messages. Trigrams
+// rather than words are used because search terms match anywhere in the text.
+type folderBloom []byte
+
+// bloomBitsPerTrigram gives about a 1% false-positive rate with bloomHashes.
+const (
+	bloomBitsPerTrigram = 10
+	bloomHashes         = 7
+)
+
+// bloomForMessages builds the filter for one folder's messages.
+func bloomForMessages(msgs []MailSummary) folderBloom {
+	grams := map[uint32]bool{}
+	for _, m := range msgs {
+		for j := 0; j+3 <= len(m.Search); j++ {
+			grams[trigramKey(m.Search[j:])] = true
+		}
+	}
+	b := make(folderBloom, max(64, (len(grams)*bloomBitsPerTrigram+7)/8))
+	for g := range grams {
+		b.add(g)
+	}
+	return b
+}
+
+func trigramKey(s string) uint32 {
+	return uint32(s[0])<<16 | uint32(s[1])<<8 | uint32(s[2])
+}
+
+// probe derives the filter positions of g by double hashing.
+func (b folderBloom) probe(g uint32, fn func(bit uint64) bool) bool {
+	h := uint64(g) + 0x9e3779b97f4a7c15
+	h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h>>27) * 0x94d049bb133111eb
+	h ^= h >> 31
+	h1, h2 := h&0xffffffff, h>>32|1
+	bits := uint64(len(b)) * 8
+	for i := uint64(0); i < bloomHashes; i++ {
+		if !fn((h1 + i*h2) % bits) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b folderBloom) add(g uint32) {
+	b.probe(g, func(bit uint64) bool {
+		b[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+func (b folderBloom) has(g uint32) bool {
+	return b.probe(g, func(bit uint64) bool {
+		return b[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+// mayContain reports whether the folder can hold every term. Folders indexed
+// before filters existed, and terms under three bytes, are never ruled out.
+func (b folderBloom) mayContain(terms []string) bool {
+	if len(b) == 0 {
+		return true
+	}
+	for _, t := range terms {
+		for j := 0; j+3 <= len(t); j++ {
+			if !b.has(trigramKey(t[j:])) {
+				return false
+			}
+		}
+	}
+	return true
+}
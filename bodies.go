@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// storedBody is one row of tb_bodies. raw is nil unless sync --raw-bodies
+// asked for the original message too.
+type storedBody struct {
+	profile, messageID string
+	text               string
+	raw                []byte
+}
+
+// UpsertBodies stores full bodies for messages already in tb_messages; rows
+// for messages it does not hold are dropped. A body stored without raw
+// keeps the raw message an earlier --raw-bodies sync saved.
+func (s *pgStore) UpsertBodies(ctx context.Context, bodies []storedBody) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	size := s.batchSize
+	if size <= 0 {
+		size = defaultUpsertBatch
+	}
+	for start := 0; start < len(bodies); start += size {
+		if err := s.upsertBodyBatch(ctx, bodies[start:min(start+size, len(bodies))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pgStore) upsertBodyBatch(ctx context.Context, bodies []storedBody) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE tb_body_stage (profile text, message_id text, body_text text, raw bytea, ord integer NOT NULL) ON COMMIT DROP"); err != nil {
+		return err
+	}
+	rows := make([][]any, 0, len(bodies))
+	for i, b := range bodies {
+		var raw any
+		if b.raw != nil {
+			raw = b.raw
+		}
+		rows = append(rows, []any{b.profile, forceUTF8(b.messageID), forceUTF8(b.text), raw, i})
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"tb_body_stage"}, []string{"profile", "message_id", "body_text", "raw", "ord"}, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("bodies: copy %d body(ies): %w", len(bodies), err)
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO tb_bodies (profile, message_id, body_text, raw)
+SELECT DISTINCT ON (s.profile, s.message_id) s.profile, s.message_id, s.body_text, s.raw
+FROM tb_body_stage s
+JOIN tb_messages m USING (profile, message_id)
+ORDER BY s.profile, s.message_id, s.ord DESC
+ON CONFLICT (profile, message_id) DO UPDATE
+  SET body_text = EXCLUDED.body_text,
+      raw = coalesce(EXCLUDED.raw, tb_bodies.raw)
+`); err != nil {
+		return fmt.Errorf("bodies: merge %d body(ies): %w", len(bodies), err)
+	}
+	return tx.Commit(ctx)
+}
+
+// CountMissingDetails reports how many stored messages of folder lack the
+// body, attachment rows, reply links, or addresses opts asks for, so sync fills in
+// folders that were synced without them.
+func (s *pgStore) CountMissingDetails(ctx context.Context, profile, folder string, opts ingestOptions) (int64, error) {
+	var missing []string
+	if opts.bodies {
+		missing = append(missing, "NOT EXISTS (SELECT 1 FROM tb_bodies b WHERE b.profile = m.profile AND b.message_id = m.message_id)")
+	}
+	if opts.attachments {
+		missing = append(missing, "m.attachments > 0 AND NOT EXISTS (SELECT 1 FROM tb_attachments a WHERE a.profile = m.profile AND a.message_id = m.message_id)")
+	}
+	if opts.threads {
+		missing = append(missing, "NOT EXISTS (SELECT 1 FROM tb_threads t WHERE t.profile = m.profile AND t.message_id = m.message_id)")
+	}
+	if opts.contacts {
+		missing = append(missing, "coalesce(m.sender, '') <> '' AND NOT EXISTS (SELECT 1 FROM tb_addresses a WHERE a.profile = m.profile AND a.message_id = m.message_id)")
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	var n int64
+	err := s.pool.QueryRow(ctx, `
+SELECT count(*) FROM tb_messages m
+WHERE m.profile = $1 AND m.folder = $2 AND m.message_id <> '' AND (`+strings.Join(missing, " OR ")+`)
+`, profile, folder).Scan(&n)
+	return n, err
+}
+
+// ScanBodies yields the stored messages show would otherwise read from the
+// mbox files, oldest first within each folder, until fn returns false.
+// Messages synced without --raw-bodies get a plain-text stand-in for raw.
+func (s *pgStore) ScanBodies(ctx context.Context, profile string, opts showOptions, fn func(sm shownMessage) (bool, error)) error {
+	where := "profile = $1"
+	args := []any{profile}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if opts.folderLike != "" {
+		where += fmt.Sprintf(" AND folder ILIKE '%%' || %s || '%%'", arg(opts.folderLike))
+	}
+	if len(opts.accounts) > 0 {
+		where += fmt.Sprintf(" AND account = ANY(%s)", arg(opts.accounts))
+	}
+	if id := normalizeMessageID(opts.messageID); id != "" && opts.relativeTo == "" && !opts.thread {
+		p := arg(id)
+		where += fmt.Sprintf(" AND message_id IN (%s, '<' || %s || '>')", p, p)
+	}
+	return s.scanBodies(ctx, where, args, fn)
+}
+
+// scanBodies runs ScanBodies for the messages matching where.
+func (s *pgStore) scanBodies(ctx context.Context, where string, args []any, fn func(sm shownMessage) (bool, error)) error {
+	rows, err := s.pool.Query(ctx, `
+SELECT `+pgSummaryColumns+`, b.body_text, b.raw
+FROM tb_messages JOIN tb_bodies b USING (profile, message_id)
+WHERE `+where+`
+ORDER BY folder, when_ts NULLS FIRST`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			sm   shownMessage
+			when time.Time
+		)
+		if err := rows.Scan(append(pgSummaryDest(&sm.summary, &when), &sm.bodyText, &sm.raw)...); err != nil {
+			return err
+		}
+		sm.summary.When = when
+		if sm.raw == nil {
+			sm.raw = synthesizeRaw(sm.summary, sm.bodyText)
+		}
+		more, err := fn(sm)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// synthesizeRaw rebuilds a minimal text/plain message from stored fields, for
+// the parts of show that work on raw bytes.
+func synthesizeRaw(m MailSummary, body string) []byte {
+	var b bytes.Buffer
+	header := func(name, val string) {
+		if val != "" {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, val)
+		}
+	}
+	header("From", mime.QEncoding.Encode("utf-8", m.From))
+	header("To", mime.QEncoding.Encode("utf-8", m.To))
+	header("Cc", mime.QEncoding.Encode("utf-8", m.Cc))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	if !m.When.IsZero() {
+		header("Date", m.When.Format(time.RFC1123Z))
+	}
+	if id := normalizeMessageID(m.MessageID); id != "" {
+		header("Message-ID", "<"+id+">")
+	}
+	if m.HasStatus() {
+		header("X-Mozilla-Status", fmt.Sprintf("%04x", m.MozStatus))
+	}
+	header("X-Mozilla-Keys", m.Tags)
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	return b.Bytes()
+}
+
+// upsertSummaryDetails stores what opts asks for that the summaries
+// themselves carry: reply links and correspondents.
+func (s *pgStore) upsertSummaryDetails(ctx context.Context, msgs []MailSummary, opts ingestOptions) error {
+	if opts.threads {
+		if err := s.UpsertThreads(ctx, msgs); err != nil {
+			return err
+		}
+	}
+	if opts.contacts {
+		if err := s.UpsertAddresses(ctx, msgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detailWriter batches what opts asks for beyond the summaries: each
+// message's full body (with the raw message when opts.rawBodies is set), its
+// attachment metadata, its reply links, and its correspondents.
+type detailWriter struct {
+	ctx       context.Context
+	store     *pgStore
+	profile   string
+	opts      ingestOptions
+	bodies    []storedBody
+	atts      []storedAttachment
+	summaries []MailSummary
+}
+
+func (w *detailWriter) add(sm shownMessage) error {
+	id := cleanUTF8(sm.summary.MessageID)
+	if id == "" {
+		return nil
+	}
+	if w.opts.bodies {
+		body := storedBody{profile: w.profile, messageID: id, text: cleanUTF8(sm.bodyText)}
+		if w.opts.rawBodies {
+			body.raw = sm.raw
+		}
+		w.bodies = append(w.bodies, body)
+	}
+	if w.opts.attachments && sm.summary.Attachments > 0 {
+		if list, err := listAttachments(sm.raw); err == nil {
+			for _, a := range list {
+				sum := sha256.Sum256(decodedPart(a.part))
+				w.atts = append(w.atts, storedAttachment{
+					profile: w.profile, messageID: id, part: a.Index,
+					filename: cleanUTF8(a.Filename), contentType: a.ContentType,
+					size: a.Size, sha256: hex.EncodeToString(sum[:]),
+				})
+			}
+		}
+	}
+	if w.opts.threads || w.opts.contacts {
+		m := sm.summary
+		m.Profile, m.MessageID = w.profile, id
+		w.summaries = append(w.summaries, m)
+	}
+	size := w.store.batchSize
+	if size <= 0 {
+		size = defaultUpsertBatch
+	}
+	if len(w.bodies) >= size || len(w.atts) >= size || len(w.summaries) >= size {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *detailWriter) flush() error {
+	if err := w.store.UpsertBodies(w.ctx, w.bodies); err != nil {
+		return err
+	}
+	if err := w.store.UpsertAttachments(w.ctx, w.atts); err != nil {
+		return err
+	}
+	if err := w.store.upsertSummaryDetails(w.ctx, w.summaries, w.opts); err != nil {
+		return err
+	}
+	w.bodies, w.atts, w.summaries = w.bodies[:0], w.atts[:0], w.summaries[:0]
+	return nil
+}
+
+// storeFolderDetails rereads b and stores the details opts asks for.
+func storeFolderDetails(ctx context.Context, store *pgStore, profile string, b Mailbox, opts ingestOptions) error {
+	w := &detailWriter{ctx: ctx, store: store, profile: profile, opts: opts}
+	if _, err := scanShownMailbox(b, func(sm shownMessage) (bool, error) {
+		return true, w.add(sm)
+	}); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// storeMessageDetails stores the details opts asks for of msgs alone, reading
+// each from its recorded place in b.
+func storeMessageDetails(ctx context.Context, store *pgStore, profile string, b Mailbox, msgs []MailSummary, opts ingestOptions) error {
+	w := &detailWriter{ctx: ctx, store: store, profile: profile, opts: opts}
+	for _, m := range msgs {
+		raw, err := readMessageAt(b.Path, mboxSpan{Offset: m.Offset, Length: m.Length})
+		if err != nil {
+			log.Printf("warn: %s: %v", b.Name, err)
+			continue
+		}
+		summary, bodyText, err := parseMessageFull(bytes.NewReader(raw), b.Name)
+		if err != nil {
+			continue
+		}
+		summary.Account = m.Account
+		if err := w.add(shownMessage{summary: summary, bodyText: bodyText, raw: raw}); err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
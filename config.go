@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// configPath is tb's own settings file: $TB_CONFIG, else config.ini in the
+// user config dir (~/.config/tb on Linux).
+func configPath() string {
+	if p := strings.TrimSpace(os.Getenv("TB_CONFIG")); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tb", "config.ini")
+}
+
+// configFile is name in the directory of the config file, where tb keeps
+// its other state, such as the import list and the retention log; "" when there is no config dir.
+func configFile(name string) string {
+	if p := configPath(); p != "" {
+		return filepath.Join(filepath.Dir(p), name)
+	}
+	return ""
+}
+
+// readConfigSection returns the key = value pairs of one [section] of the
+// INI file at path, keys lowercased. A missing file is an empty section.
+func readConfigSection(path, section string) (map[string]string, error) {
+	out := map[string]string{}
+	if path == "" {
+		return out, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	in := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			in = strings.EqualFold(strings.TrimSpace(strings.Trim(line, "[]")), section)
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !in || !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if uq, err := strconv.Unquote(v); err == nil && strings.HasPrefix(v, `"`) {
+			v = uq
+		}
+		out[strings.ToLower(strings.TrimSpace(k))] = v
+	}
+	return out, scanner.Err()
+}
+
+// pgDSNOverride is set by --pg-dsn; it wins over every other source.
+var pgDSNOverride string
+
+// pgDSNFlag registers --pg-dsn on a command that talks to Postgres.
+func pgDSNFlag(cmd *flag.FlagSet) {
+	cmd.StringVar(&pgDSNOverride, "pg-dsn", "", "Postgres connection string (default $TB_PG_DSN, else the [store] section of the tb config file, else PGHOST/PGUSER/...)")
+}
+
+// pgConnKeys are the [store] keys passed on to the server connection, with
+// the libpq keyword each becomes.
+var pgConnKeys = []struct{ key, keyword string }{
+	{"host", "host"}, {"port", "port"}, {"user", "user"}, {"password", "password"},
+	{"dbname", "dbname"}, {"database", "dbname"},
+	{"sslmode", "sslmode"}, {"sslrootcert", "sslrootcert"}, {"sslcert", "sslcert"}, {"sslkey", "sslkey"},
+	{"application_name", "application_name"}, {"connect_timeout", "connect_timeout"},
+}
+
+// pgConnSettings says how to reach Postgres and where that came from.
+type pgConnSettings struct {
+	dsn      string // as pgx.ParseConfig takes it; "" leaves everything to PG* variables
+	source   string // --pg-dsn, TB_PG_DSN, the config file, or PG* environment
+	readOnly bool   // readonly = true in [store]
+}
+
+// pgSettings finds the Postgres connection: --pg-dsn, then TB_PG_DSN, then
+// the [store] section of the config file (dsn = ..., or host/port/user/
+// password/dbname/sslmode/... keys), then the standard PG* variables that
+// libpq reads. pgx also fills anything a DSN leaves out from PG* variables
+// (PGPASSWORD, PGSSLMODE, ...), as psql does. readonly = true in [store]
+// applies whichever source wins.
+func pgSettings() (pgConnSettings, error) {
+	path := configPath()
+	cfg, err := readConfigSection(path, "store")
+	if err != nil {
+		return pgConnSettings{}, fmt.Errorf("read %s: %w", path, err)
+	}
+	var s pgConnSettings
+	if v, ok := cfg["readonly"]; ok {
+		if s.readOnly, err = strconv.ParseBool(v); err != nil {
+			return pgConnSettings{}, fmt.Errorf("%s: [store] readonly: %w", path, err)
+		}
+	}
+	if dsn := strings.TrimSpace(pgDSNOverride); dsn != "" {
+		s.dsn, s.source = dsn, "--pg-dsn"
+		return s, nil
+	}
+	if dsn := strings.TrimSpace(os.Getenv("TB_PG_DSN")); dsn != "" {
+		s.dsn, s.source = dsn, "TB_PG_DSN"
+		return s, nil
+	}
+	if cfg["password"] != "" {
+		if fi, err := os.Stat(path); err == nil && fi.Mode().Perm()&0o077 != 0 {
+			log.Printf("warn: %s holds a password but is readable by others; chmod 600 it", path)
+		}
+	}
+	s.source = path + " [store]"
+	if dsn := strings.TrimSpace(cfg["dsn"]); dsn != "" {
+		s.dsn = dsn
+		return s, nil
+	}
+	var kv []string
+	for _, k := range pgConnKeys {
+		if v := cfg[k.key]; v != "" {
+			kv = append(kv, k.keyword+"="+pgQuoteConnValue(v))
+		}
+	}
+	if len(kv) > 0 {
+		s.dsn = strings.Join(kv, " ")
+		return s, nil
+	}
+	for _, v := range []string{"PGHOST", "PGDATABASE", "PGSERVICE"} {
+		if os.Getenv(v) != "" {
+			s.source = "PG* environment"
+			return s, nil
+		}
+	}
+	return pgConnSettings{}, fmt.Errorf("no Postgres configured: set TB_PG_DSN or --pg-dsn, add a [store] section to %s, or set PGHOST/PGDATABASE", path)
+}
+
+// pgQuoteConnValue quotes v for a keyword=value connection string.
+func pgQuoteConnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// messageAddress is one correspondent of a message.
+type messageAddress struct {
+	role    string // from, to, or cc
+	address string // lowercased
+	name    string
+}
+
+var looseAddressPattern = regexp.MustCompile(`[^\s<>,;:"'()\[\]]+@[^\s<>,;:"'()\[\]]+\.[^\s<>,;:"'()\[\]]+`)
+
+// messageAddresses extracts the From/To/Cc addresses of m, once per role.
+// Headers net/mail cannot parse (bare lists, stray quotes) still yield
+// anything shaped like an address.
+func messageAddresses(m MailSummary) []messageAddress {
+	var out []messageAddress
+	seen := map[string]bool{}
+	for _, h := range []struct{ role, value string }{{"from", m.From}, {"to", m.To}, {"cc", m.Cc}} {
+		if strings.TrimSpace(h.value) == "" {
+			continue
+		}
+		var found []messageAddress
+		if list, err := mail.ParseAddressList(h.value); err == nil {
+			for _, a := range list {
+				found = append(found, messageAddress{role: h.role, address: a.Address, name: strings.TrimSpace(a.Name)})
+			}
+		} else {
+			for _, a := range looseAddressPattern.FindAllString(h.value, -1) {
+				found = append(found, messageAddress{role: h.role, address: a})
+			}
+		}
+		for _, a := range found {
+			a.address = strings.ToLower(strings.Trim(a.address, "."))
+			if a.name == a.address {
+				a.name = ""
+			}
+			if !strings.Contains(a.address, "@") || seen[h.role+" "+a.address] {
+				continue
+			}
+			seen[h.role+" "+a.address] = true
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// UpsertAddresses replaces the stored correspondents of every message in
+// msgs. Messages tb_messages does not hold are skipped.
+func (s *pgStore) UpsertAddresses(ctx context.Context, msgs []MailSummary) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	var rows [][]any
+	for _, m := range msgs {
+		if m.MessageID == "" {
+			continue
+		}
+		for _, a := range messageAddresses(m) {
+			rows = append(rows, []any{m.Profile, forceUTF8(m.MessageID), a.role, forceUTF8(a.address), forceUTF8(a.name)})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE tb_addr_stage (profile text, message_id text, role text, address text, name text) ON COMMIT DROP"); err != nil {
+		return err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"tb_addr_stage"}, []string{"profile", "message_id", "role", "address", "name"}, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("addresses: copy %d row(s): %w", len(rows), err)
+	}
+	if _, err := tx.Exec(ctx, `
+DELETE FROM tb_addresses a
+USING (SELECT DISTINCT profile, message_id FROM tb_addr_stage) s
+WHERE a.profile = s.profile AND a.message_id = s.message_id
+`); err != nil {
+		return fmt.Errorf("addresses: clear: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO tb_addresses (profile, message_id, role, address, name)
+SELECT DISTINCT ON (s.profile, s.message_id, s.role, s.address) s.profile, s.message_id, s.role, s.address, s.name
+FROM tb_addr_stage s
+JOIN tb_messages m USING (profile, message_id)
+ON CONFLICT DO NOTHING
+`); err != nil {
+		return fmt.Errorf("addresses: insert %d row(s): %w", len(rows), err)
+	}
+	return tx.Commit(ctx)
+}
+
+// RefreshContacts recomputes tb_contacts from tb_addresses. Readers keep
+// seeing the previous totals until it finishes.
+func (s *pgStore) RefreshContacts(ctx context.Context) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	_, err := s.pool.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY tb_contacts")
+	return err
+}
+
+// contactQuery filters tb mail contacts.
+type contactQuery struct {
+	profile string
+	search  string // substring of address or name, e.g. acme.com
+	sortBy  string // last (default), first, messages, or address
+	limit   int
+}
+
+type contact struct {
+	address, name        string
+	firstSeen, lastSeen  time.Time
+	messages, from, toCc int64
+}
+
+func (s *pgStore) Contacts(ctx context.Context, q contactQuery) ([]contact, error) {
+	where := "profile = $1"
+	args := []any{q.profile}
+	if q.search != "" {
+		args = append(args, q.search)
+		where += " AND (address ILIKE '%' || $2 || '%' OR name ILIKE '%' || $2 || '%')"
+	}
+	order := "last_seen DESC NULLS LAST"
+	switch q.sortBy {
+	case "", "last":
+	case "first":
+		order = "first_seen NULLS LAST"
+	case "messages":
+		order = "messages DESC, last_seen DESC NULLS LAST"
+	case "address":
+		order = "domain, address"
+	default:
+		return nil, fmt.Errorf("unknown --sort %q (use last, first, messages, or address)", q.sortBy)
+	}
+	limit := ""
+	if q.limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", q.limit)
+	}
+	rows, err := s.pool.Query(ctx, `
+SELECT address, name, first_seen, last_seen, messages, from_count, to_count
+FROM tb_contacts
+WHERE `+where+`
+ORDER BY `+order+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []contact
+	for rows.Next() {
+		var c contact
+		var first, last *time.Time
+		if err := rows.Scan(&c.address, &c.name, &first, &last, &c.messages, &c.from, &c.toCc); err != nil {
+			return nil, err
+		}
+		if first != nil {
+			c.firstSeen = *first
+		}
+		if last != nil {
+			c.lastSeen = *last
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// listContacts prints the correspondents sync aggregated into tb_contacts.
+func (a *App) listContacts(profileName, storeName string, q contactQuery) error {
+	if storeName != "pg" {
+		return fmt.Errorf("unknown --store %q (use pg)", storeName)
+	}
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	store, err := openPG()
+	if err != nil {
+		return fmt.Errorf("postgres required for contacts: %w", err)
+	}
+	defer store.Close()
+	q.profile = profile.Name
+	contacts, err := store.Contacts(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	if len(contacts) == 0 {
+		fmt.Println("No matches.")
+		return nil
+	}
+	day := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.In(time.Local).Format("2006-01-02")
+	}
+	rows := make([][]string, 0, len(contacts))
+	for _, c := range contacts {
+		rows = append(rows, []string{
+			c.address, truncate(c.name, 30), strconv.FormatInt(c.messages, 10),
+			strconv.FormatInt(c.from, 10), strconv.FormatInt(c.toCc, 10), day(c.firstSeen), day(c.lastSeen),
+		})
+	}
+	renderTable(os.Stdout, []string{"Address", "Name", "Messages", "From", "To/Cc", "First seen", "Last seen"}, rows)
+	return nil
+}
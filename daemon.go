@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// tb daemon keeps one profile's index warm: it runs the watch loop, holds
+// every indexed Message-Id location in memory, keeps a Postgres pool open,
+// and answers other tb invocations over a unix socket. Requests and
+// responses are single JSON lines.
+
+type daemonRequest struct {
+	Op    string     `json:"op"` // status, stop, search, group, spans
+	Query *wireQuery `json:"query,omitempty"`
+	IDs   []string   `json:"ids,omitempty"`
+}
+
+type daemonResponse struct {
+	Error  string                   `json:"error,omitempty"`
+	Hits   []MailSummary            `json:"hits,omitempty"`
+	Groups []groupCount             `json:"groups,omitempty"`
+	Spans  map[string][]indexedSpan `json:"spans,omitempty"`
+	Status *daemonStatus            `json:"status,omitempty"`
+}
+
+type daemonStatus struct {
+	PID        int       `json:"pid"`
+	Profile    string    `json:"profile"`
+	Socket     string    `json:"socket"`
+	Started    time.Time `json:"started"`
+	LastUpdate time.Time `json:"last_update"`
+	Messages   int       `json:"messages"`
+	Postgres   bool      `json:"postgres"`
+}
+
+// wireQuery carries queryOptions across the socket.
+type wireQuery struct {
+	Query, From, To, Subject, Body string
+	Excludes                       []string
+	Larger, Smaller                int64
+	HasAttachment                  bool
+	Unread, Read, Flagged          bool
+	Tag, GroupBy, SortBy           string
+	Reverse                        bool
+	Accounts                       []string
+	FolderLike                     string
+	Since, Till                    time.Time
+	Limit                          int
+	Profile                        string
+	IncludeTrash, IncludeSpam      bool
+	Semantic, Rank                 string
+	Like                           bool
+}
+
+func toWireQuery(q queryOptions) *wireQuery {
+	return &wireQuery{
+		Query: q.query, From: q.from, To: q.to, Subject: q.subject, Body: q.body,
+		Excludes: q.excludes, Larger: q.larger, Smaller: q.smaller, HasAttachment: q.hasAttachment,
+		Unread: q.unread, Read: q.read, Flagged: q.flagged,
+		Tag: q.tag, GroupBy: q.groupBy, SortBy: q.sortBy, Reverse: q.reverse,
+		Accounts: q.accounts, FolderLike: q.folderLike, Since: q.since, Till: q.till,
+		Limit: q.limit, Profile: q.profile, IncludeTrash: q.includeTrash, IncludeSpam: q.includeSpam, Semantic: q.semantic,
+		Rank: q.rank, Like: q.like,
+	}
+}
+
+func (w *wireQuery) options() queryOptions {
+	return queryOptions{
+		query: w.Query, from: w.From, to: w.To, subject: w.Subject, body: w.Body,
+		excludes: w.Excludes, larger: w.Larger, smaller: w.Smaller, hasAttachment: w.HasAttachment,
+		unread: w.Unread, read: w.Read, flagged: w.Flagged,
+		tag: w.Tag, groupBy: w.GroupBy, sortBy: w.SortBy, reverse: w.Reverse,
+		accounts: w.Accounts, folderLike: w.FolderLike, since: w.Since, till: w.Till,
+		limit: w.Limit, profile: w.Profile, includeTrash: w.IncludeTrash, includeSpam: w.IncludeSpam, semantic: w.Semantic,
+		rank: w.Rank, like: w.Like,
+	}
+}
+
+// daemonSocketPath is per profile and lives in a tb-<uid> directory of
+// XDG_RUNTIME_DIR (else the temp dir) that only the user can enter; the
+// profile path is hashed to stay under the unix socket length limit.
+func daemonSocketPath(profile Profile) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	h := fnv.New32a()
+	h.Write([]byte(profile.AbsolutePath))
+	return filepath.Join(dir, fmt.Sprintf("tb-%d", os.Getuid()), fmt.Sprintf("daemon-%08x.sock", h.Sum32()))
+}
+
+// makeDaemonSocketDir creates the directory of sock as 0700, or checks that
+// an existing one is the user's own and closed to others. In a shared /tmp
+// another user could otherwise create it first and serve answers of their
+// own.
+func makeDaemonSocketDir(sock string) error {
+	dir := filepath.Dir(sock)
+	if err := os.Mkdir(dir, 0o700); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return checkOwnedPrivate(dir, true)
+}
+
+// errUntrustedSocket is returned by callDaemon for a socket it will not talk to.
+var errUntrustedSocket = errors.New("untrusted daemon socket")
+
+// callDaemon sends one request to the profile's daemon. It fails fast when no
+// daemon is listening so callers can fall back to doing the work themselves.
+// A socket, or a directory holding it, that belongs to another user is never
+// trusted.
+func callDaemon(profile Profile, req daemonRequest) (*daemonResponse, error) {
+	sock := daemonSocketPath(profile)
+	for _, check := range []struct {
+		path string
+		dir  bool
+	}{{filepath.Dir(sock), true}, {sock, false}} {
+		if err := checkOwnedPrivate(check.path, check.dir); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", errUntrustedSocket, err)
+		}
+	}
+	conn, err := net.DialTimeout("unix", sock, 200*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp daemonResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// loadAllSpans reads every indexed message location (SQLite, else JSON).
+func loadAllSpans(profile Profile) (map[string][]indexedSpan, error) {
+	spans := map[string][]indexedSpan{}
+	db := sqliteIndexPath(profile)
+	if _, err := os.Stat(db); err == nil {
+		return sqliteMessageSpans(db, nil)
+	}
+	idx, err := loadIndex(profile)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range idx.Folders {
+		for _, m := range fi.Messages {
+			if m.Length > 0 {
+				id := normalizeMessageID(m.MessageID)
+				spans[id] = append(spans[id], indexedSpan{Folder: m.Folder, Span: mboxSpan{Offset: m.Offset, Length: m.Length}})
+			}
+		}
+	}
+	return spans, nil
+}
+
+type daemon struct {
+	profile Profile
+	store   *pgStore // nil without TB_PG_DSN
+	stop    context.CancelFunc
+
+	mu     sync.RWMutex
+	spans  map[string][]indexedSpan
+	status daemonStatus
+}
+
+func (d *daemon) reload() {
+	spans, err := loadAllSpans(d.profile)
+	if err != nil {
+		log.Printf("warn: daemon: load index: %v", err)
+		return
+	}
+	d.mu.Lock()
+	d.spans = spans
+	d.status.Messages = len(spans)
+	d.status.LastUpdate = time.Now()
+	d.mu.Unlock()
+}
+
+func (d *daemon) handle(ctx context.Context, req daemonRequest) daemonResponse {
+	switch req.Op {
+	case "status":
+		d.mu.RLock()
+		st := d.status
+		d.mu.RUnlock()
+		return daemonResponse{Status: &st}
+	case "stop":
+		// serve shuts the daemon down once this reply is written.
+		return daemonResponse{}
+	case "spans":
+		out := map[string][]indexedSpan{}
+		d.mu.RLock()
+		for _, id := range req.IDs {
+			id = normalizeMessageID(id)
+			if s, ok := d.spans[id]; ok {
+				out[id] = s
+			}
+		}
+		d.mu.RUnlock()
+		return daemonResponse{Spans: out}
+	case "search":
+		if d.store == nil {
+			return daemonResponse{Error: "daemon has no Postgres connection (TB_PG_DSN)"}
+		}
+		if req.Query == nil {
+			return daemonResponse{Error: "search: missing query"}
+		}
+		q := req.Query.options()
+		search := d.store.Search
+		if q.semantic != "" {
+			search = func(ctx context.Context, q queryOptions) ([]MailSummary, error) {
+				return semanticSearch(ctx, d.store, q)
+			}
+		}
+		hits, err := search(ctx, q)
+		if err != nil {
+			return daemonResponse{Error: err.Error()}
+		}
+		return daemonResponse{Hits: hits}
+	case "group":
+		if d.store == nil {
+			return daemonResponse{Error: "daemon has no Postgres connection (TB_PG_DSN)"}
+		}
+		if req.Query == nil {
+			return daemonResponse{Error: "group: missing query"}
+		}
+		q := req.Query.options()
+		if q.semantic != "" {
+			hits, err := semanticSearch(ctx, d.store, q)
+			if err != nil {
+				return daemonResponse{Error: err.Error()}
+			}
+			return daemonResponse{Groups: groupHits(hits, q.groupBy)}
+		}
+		groups, err := d.store.Group(ctx, q)
+		if err != nil {
+			return daemonResponse{Error: err.Error()}
+		}
+		return daemonResponse{Groups: groups}
+	}
+	return daemonResponse{Error: fmt.Sprintf("unknown op %q", req.Op)}
+}
+
+func (d *daemon) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	var req daemonRequest
+	var resp daemonResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		resp = daemonResponse{Error: "bad request: " + err.Error()}
+	} else {
+		resp = d.handle(ctx, req)
+	}
+	json.NewEncoder(conn).Encode(resp)
+	if req.Op == "stop" {
+		d.stop()
+	}
+}
+
+// runDaemon serves profileName until stopped by a signal or `tb daemon stop`.
+func (a *App) runDaemon(profileName string, opts indexOptions, w watchOptions) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	sock := daemonSocketPath(profile)
+	if err := makeDaemonSocketDir(sock); err != nil {
+		return fmt.Errorf("socket directory: %w", err)
+	}
+	if resp, err := callDaemon(profile, daemonRequest{Op: "status"}); err == nil {
+		return fmt.Errorf("already running for %s (pid %d)", profile.Name, resp.Status.PID)
+	}
+	os.Remove(sock) // left behind by a daemon that did not shut down cleanly
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sock)
+	defer ln.Close()
+	if err := os.Chmod(sock, 0o600); err != nil {
+		return fmt.Errorf("socket permissions: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	d := &daemon{profile: profile, stop: stop, spans: map[string][]indexedSpan{}}
+	d.status = daemonStatus{PID: os.Getpid(), Profile: profile.Name, Socket: sock, Started: time.Now()}
+	if store, err := openPG(); err == nil {
+		d.store = store
+		d.status.Postgres = true
+		defer store.Close()
+	} else {
+		log.Printf("info: daemon: postgres unavailable (%v); serving index lookups only", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(ctx, conn)
+		}
+	}()
+	log.Printf("info: daemon for %s listening on %s", profile.Name, sock)
+	return a.watchFolders(ctx, profileName, opts, w, d.reload)
+}
+
+func daemonMain(args []string) {
+	sub := "run"
+	if len(args) > 0 && (args[0] == "status" || args[0] == "stop") {
+		sub, args = args[0], args[1:]
+	}
+	cmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+	pgDSNFlag(cmd)
+	profileName := cmd.String("profile", "", "profile name or path")
+	interval := cmd.Duration("interval", 10*time.Second, "how long a change must hold still before re-indexing (the polling period without file notifications)")
+	fetch := cmd.Bool("fetch", false, "also ingest changed folders into Postgres")
+	tailCount := cmd.Int("tail", defaultIndexTail, "keep only last N messages per folder in the index (0 = all)")
+	jobs := cmd.Int("jobs", 1, "scan up to N folders concurrently")
+	indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+	cmd.Parse(args)
+	indexDirOverride = *indexDir
+
+	app := newApp()
+	profile, err := app.resolveProfile(*profileName)
+	if err != nil {
+		log.Fatalf("daemon: %v", err)
+	}
+	switch sub {
+	case "status":
+		resp, err := callDaemon(profile, daemonRequest{Op: "status"})
+		if errors.Is(err, errUntrustedSocket) {
+			log.Fatalf("daemon: %v", err)
+		}
+		if err != nil {
+			fmt.Printf("No daemon running for %s\n", profile.Name)
+			os.Exit(1)
+		}
+		st := resp.Status
+		fmt.Printf("Daemon for %s: pid %d, up %s\n", st.Profile, st.PID, time.Since(st.Started).Round(time.Second))
+		fmt.Printf("Socket: %s\n", st.Socket)
+		fmt.Printf("Indexed Message-Ids in memory: %d (updated %s)\n", st.Messages, st.LastUpdate.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Postgres: %v\n", st.Postgres)
+	case "stop":
+		_, err := callDaemon(profile, daemonRequest{Op: "stop"})
+		if errors.Is(err, errUntrustedSocket) {
+			log.Fatalf("daemon: %v", err)
+		}
+		if err != nil {
+			fmt.Printf("No daemon running for %s\n", profile.Name)
+			os.Exit(1)
+		}
+		fmt.Printf("Stopped daemon for %s\n", profile.Name)
+	default:
+		progressQuiet = true
+		opts := indexOptions{tailCount: *tailCount, jobs: *jobs}
+		if err := app.runDaemon(*profileName, opts, watchOptions{interval: *interval, fetch: *fetch}); err != nil {
+			log.Fatalf("daemon: %v", err)
+		}
+	}
+}
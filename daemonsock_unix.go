@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkOwnedPrivate fails unless path (not followed if a symlink) belongs
+// to the current user and, for a directory, is closed to everyone else.
+func checkOwnedPrivate(path string, dir bool) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("%s: cannot read its owner", path)
+	}
+	switch {
+	case int(st.Uid) != os.Getuid():
+		return fmt.Errorf("%s belongs to uid %d, not to you", path, st.Uid)
+	case dir && !fi.IsDir():
+		return fmt.Errorf("%s is not a directory", path)
+	case dir && fi.Mode().Perm()&0o077 != 0:
+		return fmt.Errorf("%s is open to other users (mode %o); chmod 700 it", path, fi.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// duUsage is disk usage split the way tb mail du reports it: message
+// storage (mbox files and maildir folders), .msf summary files, and the rest
+// (.mozmsgs search-integration copies, filter and POP state, logs).
+type duUsage struct {
+	Mail  int64 `json:"mail"`
+	MSF   int64 `json:"msf"`
+	Other int64 `json:"other"`
+	Total int64 `json:"total"`
+}
+
+func (u *duUsage) add(kind string, n int64) {
+	switch kind {
+	case "mail":
+		u.Mail += n
+	case "msf":
+		u.MSF += n
+	default:
+		u.Other += n
+	}
+	u.Total += n
+}
+
+type duFolder struct {
+	Folder string `json:"folder"`
+	duUsage
+}
+
+// duAccount is one account directory (Mail/<server>, ImapMail/<server>) or
+// the imported mbox files, with its top-level folders; a folder counts
+// everything under its .sbd directory too.
+type duAccount struct {
+	Account   string `json:"account"`
+	Directory string `json:"directory,omitempty"`
+	duUsage
+	Folders []duFolder `json:"folders"`
+}
+
+// duKind classifies a file under an account directory.
+func duKind(rel string) string {
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasSuffix(part, ".mozmsgs") {
+			return "other"
+		}
+	}
+	switch ext := filepath.Ext(rel); {
+	case ext == ".msf":
+		return "msf"
+	case ext == "" || ext == ".mbox":
+		return "mail"
+	}
+	// Message files of a maildir folder carry any name under cur/ or new/.
+	dir := filepath.Base(filepath.Dir(rel))
+	if dir == "cur" || dir == "new" {
+		return "mail"
+	}
+	return "other"
+}
+
+// duTopFolder names the top-level folder a file belongs to from the first
+// element of its path, or "" for the account's own files.
+func duTopFolder(first string, isDir bool) string {
+	for _, suffix := range []string{".msf", ".sbd", ".mozmsgs"} {
+		if strings.HasSuffix(first, suffix) {
+			return strings.TrimSuffix(first, suffix)
+		}
+	}
+	if ext := filepath.Ext(first); isDir || ext == "" || ext == ".mbox" {
+		return first
+	}
+	return ""
+}
+
+// duDirectory adds up an account directory.
+func duDirectory(label, dir string) duAccount {
+	acct := duAccount{Account: label, Directory: dir}
+	folders := map[string]*duFolder{}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		first, _, nested := strings.Cut(filepath.ToSlash(rel), "/")
+		name := duTopFolder(first, nested)
+		if name == "" {
+			name = "(account files)"
+		}
+		f := folders[name]
+		if f == nil {
+			f = &duFolder{Folder: name}
+			folders[name] = f
+		}
+		kind := duKind(rel)
+		f.add(kind, info.Size())
+		acct.add(kind, info.Size())
+		return nil
+	})
+	for _, f := range folders {
+		acct.Folders = append(acct.Folders, *f)
+	}
+	sort.Slice(acct.Folders, func(i, j int) bool {
+		if acct.Folders[i].Total != acct.Folders[j].Total {
+			return acct.Folders[i].Total > acct.Folders[j].Total
+		}
+		return acct.Folders[i].Folder < acct.Folders[j].Folder
+	})
+	return acct
+}
+
+// diskUsage reports the profile's mail storage per account and top-level
+// folder, and the size of the whole profile directory.
+func (a *App) diskUsage(profileName, format string) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{}
+	if accounts, err := a.loadAccounts(profile); err == nil {
+		for _, acct := range accounts {
+			if acct.Directory == "" {
+				continue
+			}
+			label := firstNonEmpty(strings.Join(acct.Emails, ", "), acct.Name, acct.Key)
+			labels[filepath.Clean(acct.Directory)] = label
+		}
+	}
+
+	var report []duAccount
+	for _, root := range []string{"Mail", "ImapMail"} {
+		entries, err := os.ReadDir(filepath.Join(profile.AbsolutePath, root))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			dir := filepath.Join(profile.AbsolutePath, root, e.Name())
+			label := firstNonEmpty(labels[dir], filepath.Join(root, e.Name()))
+			report = append(report, duDirectory(label, dir))
+		}
+	}
+	if imported := importedMailboxes(profile); len(imported) > 0 {
+		acct := duAccount{Account: strings.TrimSuffix(importedFolderPrefix, "/")}
+		for _, b := range imported {
+			f := duFolder{Folder: strings.TrimPrefix(b.Name, importedFolderPrefix)}
+			f.add("mail", b.Size)
+			acct.add("mail", b.Size)
+			acct.Folders = append(acct.Folders, f)
+		}
+		report = append(report, acct)
+	}
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Total > report[j].Total })
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		for _, acct := range report {
+			if err := enc.Encode(acct); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(report) == 0 {
+		fmt.Printf("No mail directories under %s\n", profile.AbsolutePath)
+		return nil
+	}
+	var sum duUsage
+	var rows [][]string
+	usage := func(u duUsage) []string {
+		return []string{byteSize(u.Mail), byteSize(u.MSF), byteSize(u.Other), byteSize(u.Total)}
+	}
+	for _, acct := range report {
+		rows = append(rows, append([]string{acct.Account}, usage(acct.duUsage)...))
+		for _, f := range acct.Folders {
+			rows = append(rows, append([]string{"  " + f.Folder}, usage(f.duUsage)...))
+		}
+		sum.Mail += acct.Mail
+		sum.MSF += acct.MSF
+		sum.Other += acct.Other
+		sum.Total += acct.Total
+	}
+	rows = append(rows, append([]string{"Total"}, usage(sum)...))
+	renderTable(os.Stdout, []string{"Account / folder", "Mail", "Summaries (.msf)", "Other", "Total"}, rows)
+
+	var profileSize int64
+	filepath.WalkDir(profile.AbsolutePath, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				profileSize += info.Size()
+			}
+		}
+		return nil
+	})
+	fmt.Printf("\nProfile %s: %s on disk, %s of it mail directories.\n", profile.AbsolutePath, byteSize(profileSize), byteSize(sum.Total))
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dupeCopy is one stored copy of a duplicated message.
+type dupeCopy struct {
+	Folder  string `json:"folder"`
+	Account string `json:"account,omitempty"`
+	Offset  int64  `json:"offset"` // in the folder's mbox
+	Length  int64  `json:"length,omitempty"`
+}
+
+// dupeGroup is a message the store holds more than once.
+type dupeGroup struct {
+	MessageID string     `json:"message_id,omitempty"`
+	MatchedBy string     `json:"matched_by"` // message-id, or subject+date+from when there is none
+	Subject   string     `json:"subject"`
+	From      string     `json:"from"`
+	Time      *time.Time `json:"time,omitempty"`
+	Copies    []dupeCopy `json:"copies"`
+}
+
+// dupeKey groups copies of one message: its normalized Message-Id, else
+// the lowercased subject and sender with the date. Messages with none of
+// those cannot be matched and get "".
+func dupeKey(m MailSummary) (key, matchedBy string) {
+	if id := normalizeMessageID(m.MessageID); id != "" {
+		return "id " + id, "message-id"
+	}
+	subject := strings.ToLower(strings.TrimSpace(m.Subject))
+	from := strings.ToLower(strings.TrimSpace(m.From))
+	date := strings.TrimSpace(m.Date)
+	if !m.When.IsZero() {
+		date = strconv.FormatInt(m.When.Unix(), 10)
+	}
+	if subject == "" && from == "" && date == "" {
+		return "", ""
+	}
+	return "sdf " + subject + "\x00" + date + "\x00" + from, "subject+date+from"
+}
+
+// findDupes groups msgs held more than once, most copies first, then newest.
+func findDupes(msgs []MailSummary) []dupeGroup {
+	byKey := map[string]*dupeGroup{}
+	var order []string
+	for _, m := range msgs {
+		key, matchedBy := dupeKey(m)
+		if key == "" {
+			continue
+		}
+		g := byKey[key]
+		if g == nil {
+			g = &dupeGroup{MatchedBy: matchedBy, Subject: m.Subject, From: m.From}
+			if matchedBy == "message-id" {
+				g.MessageID = normalizeMessageID(m.MessageID)
+			}
+			if !m.When.IsZero() {
+				when := m.When
+				g.Time = &when
+			}
+			byKey[key] = g
+			order = append(order, key)
+		}
+		g.Copies = append(g.Copies, dupeCopy{Folder: m.Folder, Account: m.Account, Offset: m.Offset, Length: m.Length})
+	}
+	var out []dupeGroup
+	for _, key := range order {
+		if g := byKey[key]; len(g.Copies) > 1 {
+			sort.Slice(g.Copies, func(i, j int) bool {
+				if g.Copies[i].Folder != g.Copies[j].Folder {
+					return g.Copies[i].Folder < g.Copies[j].Folder
+				}
+				return g.Copies[i].Offset < g.Copies[j].Offset
+			})
+			out = append(out, *g)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if len(out[i].Copies) != len(out[j].Copies) {
+			return len(out[i].Copies) > len(out[j].Copies)
+		}
+		ti, tj := out[i].Time, out[j].Time
+		return ti != nil && (tj == nil || ti.After(*tj))
+	})
+	return out
+}
+
+// localMessages reads every message of the profile's local index, from
+// SQLite when storeName is sqlite (or empty or index, and the SQLite index
+// exists), else from the JSON shards.
+func (a *App) localMessages(profile Profile, storeName string) ([]MailSummary, error) {
+	useSQLite := storeName == "sqlite"
+	if storeName == "" || storeName == "index" {
+		_, err := os.Stat(sqliteIndexPath(profile))
+		useSQLite = err == nil
+	}
+	if useSQLite {
+		if _, err := openSQLiteStore(a, profile); err != nil {
+			return nil, err
+		}
+		return sqliteIndexedMessages(sqliteIndexPath(profile), "")
+	}
+	if !hasFallbackIndex(profile) {
+		return nil, fmt.Errorf("no local index for %s; run tb mail index", profile.Name)
+	}
+	idx, err := loadIndex(profile)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []MailSummary
+	for _, fi := range idx.Folders {
+		msgs = append(msgs, fi.Messages...)
+	}
+	return msgs, nil
+}
+
+// reportDupes lists the messages the local index holds more than once, in
+// several folders or accounts or twice in one folder. Postgres cannot hold
+// such copies (it keeps one row per Message-Id), so this reads the local
+// index.
+func (a *App) reportDupes(profileName, storeName, format string, limit int) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockIndex(profile, false)
+	if err != nil {
+		return fmt.Errorf("lock index: %w", err)
+	}
+	msgs, err := a.localMessages(profile, storeName)
+	unlock()
+	if err != nil {
+		return err
+	}
+	groups := findDupes(msgs)
+	total, extra := len(groups), 0
+	for _, g := range groups {
+		extra += len(g.Copies) - 1
+	}
+	if limit > 0 && len(groups) > limit {
+		groups = groups[:limit]
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		for _, g := range groups {
+			if err := enc.Encode(g); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(groups) == 0 {
+		fmt.Println("No duplicates.")
+		return nil
+	}
+	rows := make([][]string, 0, len(groups))
+	for _, g := range groups {
+		date := "-"
+		if g.Time != nil {
+			date = g.Time.In(time.Local).Format("2006-01-02 15:04")
+		}
+		var where []string
+		for _, c := range g.Copies {
+			w := c.Folder
+			if c.Account != "" {
+				w += " (" + c.Account + ")"
+			}
+			where = append(where, w)
+		}
+		rows = append(rows, []string{
+			strconv.Itoa(len(g.Copies)), date, truncate(g.From, 30), truncate(g.Subject, 50),
+			strings.Join(where, "; "), g.MatchedBy,
+		})
+	}
+	renderTable(os.Stdout, []string{"Copies", "Date", "From", "Subject", "Where", "Matched by"}, rows)
+	fmt.Printf("\n%d message(s) stored more than once, %d extra copy(ies)\n", total, extra)
+	return nil
+}
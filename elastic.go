@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// esStore is an Elasticsearch (or OpenSearch) index as a Store. Documents
+// are engineDocs under their id; the first sync creates the index with
+// esMappings.
+type esStore struct {
+	cfg      engineConfig
+	prepared bool // index checked or created by this process
+}
+
+func openES(*App, Profile) (Store, error) {
+	cfg, err := searchEngineConfig("es")
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.call(context.Background(), http.MethodGet, "/", nil, nil); err != nil {
+		return nil, fmt.Errorf("elasticsearch at %s: %w", cfg.url, err)
+	}
+	return &esStore{cfg: cfg}, nil
+}
+
+func (s *esStore) config() engineConfig { return s.cfg }
+
+func (s *esStore) Close() {}
+
+func (s *esStore) path(rest string) string {
+	return "/" + url.PathEscape(s.cfg.index) + rest
+}
+
+// esMappings keeps profile, folder, account, and year whole for filters and
+// aggregations, and analyzes the text fields for search.
+var esMappings = map[string]any{
+	"mappings": map[string]any{
+		"properties": map[string]any{
+			"id":           map[string]any{"type": "keyword"},
+			"profile":      map[string]any{"type": "keyword"},
+			"message_id":   map[string]any{"type": "keyword"},
+			"folder":       map[string]any{"type": "keyword"},
+			"account":      map[string]any{"type": "keyword"},
+			"year":         map[string]any{"type": "keyword"},
+			"subject":      map[string]any{"type": "text"},
+			"from":         map[string]any{"type": "text"},
+			"to":           map[string]any{"type": "text"},
+			"cc":           map[string]any{"type": "text"},
+			"search_text":  map[string]any{"type": "text"},
+			"snippet":      map[string]any{"type": "text", "index": false},
+			"body_text":    map[string]any{"type": "text", "index": false},
+			"date":         map[string]any{"type": "keyword", "index": false},
+			"when":         map[string]any{"type": "date", "format": "epoch_second"},
+			"tags":         map[string]any{"type": "text", "analyzer": "whitespace"},
+			"size":         map[string]any{"type": "long"},
+			"search_bytes": map[string]any{"type": "long"},
+			"attachments":  map[string]any{"type": "integer"},
+			"moz_status":   map[string]any{"type": "integer"},
+			"unread":       map[string]any{"type": "boolean"},
+			"flagged":      map[string]any{"type": "boolean"},
+		},
+	},
+}
+
+// notSynced explains a missing index.
+func (s *esStore) notSynced(err error) error {
+	if errors.Is(err, errEngineNotFound) {
+		return fmt.Errorf("no Elasticsearch index %s at %s; run tb mail sync --store es", s.cfg.index, s.cfg.url)
+	}
+	return err
+}
+
+// bulk sends NDJSON actions, waiting until they are searchable, and fails
+// with the first rejected item.
+func (s *esStore) bulk(ctx context.Context, body []byte) error {
+	var resp struct {
+		Errors bool                         `json:"errors"`
+		Items  []map[string]json.RawMessage `json:"items"`
+	}
+	if err := s.cfg.call(ctx, http.MethodPost, s.path("/_bulk?refresh=wait_for"), body, &resp); err != nil {
+		return err
+	}
+	if !resp.Errors {
+		return nil
+	}
+	for _, item := range resp.Items {
+		for _, raw := range item {
+			var r struct {
+				ID    string `json:"_id"`
+				Error *struct {
+					Reason string `json:"reason"`
+				} `json:"error"`
+			}
+			if json.Unmarshal(raw, &r) == nil && r.Error != nil {
+				return fmt.Errorf("bulk: document %s: %s", r.ID, r.Error.Reason)
+			}
+		}
+	}
+	return fmt.Errorf("bulk: some documents were rejected")
+}
+
+// Upsert replaces the documents of msgs, creating the index on the first
+// call when it is missing.
+func (s *esStore) Upsert(ctx context.Context, msgs []MailSummary) error {
+	if err := s.cfg.writable(); err != nil {
+		return err
+	}
+	if !s.prepared {
+		err := s.cfg.call(ctx, http.MethodGet, s.path("/_mapping"), nil, nil)
+		if errors.Is(err, errEngineNotFound) {
+			err = s.cfg.call(ctx, http.MethodPut, s.path(""), esMappings, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("elasticsearch index: %w", err)
+		}
+		s.prepared = true
+	}
+	for start := 0; start < len(msgs); start += engineBatch {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		batch := msgs[start:min(start+engineBatch, len(msgs))]
+		for _, m := range batch {
+			d := newEngineDoc(m)
+			enc.Encode(map[string]any{"index": map[string]string{"_id": d.ID}})
+			enc.Encode(d)
+		}
+		if err := s.bulk(ctx, b.Bytes()); err != nil {
+			return fmt.Errorf("upsert %d message(s) of %s: %w", len(batch), batch[0].Folder, err)
+		}
+	}
+	return nil
+}
+
+// esFilter narrows a search to q's profile and dates; filterMatches applies
+// the rest.
+func esFilter(q queryOptions) []any {
+	var f []any
+	if q.profile != "" {
+		f = append(f, map[string]any{"term": map[string]any{"profile": q.profile}})
+	}
+	when := map[string]any{}
+	if !q.since.IsZero() {
+		when["gte"] = q.since.Unix()
+	}
+	if !q.till.IsZero() {
+		when["lt"] = q.till.Unix()
+	}
+	if len(when) > 0 {
+		f = append(f, map[string]any{"range": map[string]any{"when": when}})
+	}
+	return f
+}
+
+// esQuery matches every query term in the search text, allowing typos, and
+// ranks subject and sender matches higher.
+func esQuery(q queryOptions) map[string]any {
+	if q.query == "" {
+		return map[string]any{"bool": map[string]any{"filter": esFilter(q)}}
+	}
+	return map[string]any{"bool": map[string]any{
+		"must": map[string]any{"match": map[string]any{"search_text": map[string]any{
+			"query": q.query, "operator": "and", "fuzziness": "AUTO",
+		}}},
+		"should": map[string]any{"multi_match": map[string]any{
+			"query": q.query, "fields": []string{"subject^3", "from^2"}, "fuzziness": "AUTO",
+		}},
+		"filter": esFilter(q),
+	}}
+}
+
+// esHit is one document of a search reply.
+type esHit struct {
+	ID     string    `json:"_id"`
+	Score  *float64  `json:"_score"`
+	Source engineDoc `json:"_source"`
+	Sort   []any     `json:"sort"`
+}
+
+func (s *esStore) search(ctx context.Context, req map[string]any) ([]esHit, error) {
+	var resp struct {
+		Hits struct {
+			Hits []esHit `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := s.cfg.call(ctx, http.MethodPost, s.path("/_search"), req, &resp); err != nil {
+		return nil, s.notSynced(err)
+	}
+	return resp.Hits.Hits, nil
+}
+
+func (s *esStore) Search(ctx context.Context, q queryOptions) ([]MailSummary, error) {
+	req := map[string]any{"query": esQuery(q), "track_total_hits": false}
+	if byDate, asc, _ := engineSort(q); byDate {
+		order := "desc"
+		if asc {
+			order = "asc"
+		}
+		req["sort"] = []any{map[string]any{"when": map[string]any{"order": order, "missing": "_last"}}}
+	}
+	return collectHits(q, func(offset, size int) ([]MailSummary, error) {
+		req["from"], req["size"] = offset, size
+		hits, err := s.search(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		page := make([]MailSummary, 0, len(hits))
+		for _, h := range hits {
+			m := h.Source.summary()
+			if h.Score != nil {
+				m.Score = *h.Score
+			}
+			page = append(page, m)
+		}
+		return page, nil
+	})
+}
+
+// Prune walks the profile's documents in id order and deletes those whose
+// Message-Id is not in keepIDs.
+func (s *esStore) Prune(ctx context.Context, profile string, keepIDs []string) (int64, error) {
+	if err := s.cfg.writable(); err != nil {
+		return 0, err
+	}
+	if len(keepIDs) == 0 {
+		return 0, nil
+	}
+	stored := map[string]string{}
+	req := map[string]any{
+		"query":   map[string]any{"term": map[string]any{"profile": profile}},
+		"_source": []string{"message_id"}, "size": engineBatch, "sort": []string{"id"},
+		"track_total_hits": false,
+	}
+	for {
+		hits, err := s.search(ctx, req)
+		if err != nil {
+			return 0, err
+		}
+		for _, h := range hits {
+			stored[h.ID] = h.Source.MessageID
+		}
+		if len(hits) < engineBatch {
+			break
+		}
+		req["search_after"] = hits[len(hits)-1].Sort
+	}
+	drop := unkeptDocs(stored, keepIDs)
+	for start := 0; start < len(drop); start += engineBatch {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		for _, id := range drop[start:min(start+engineBatch, len(drop))] {
+			enc.Encode(map[string]any{"delete": map[string]string{"_id": id}})
+		}
+		if err := s.bulk(ctx, b.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(drop)), nil
+}
+
+// Stats aggregates over the profile's documents. Reclaimable space is the
+// index's share of deleted documents, which a merge drops.
+func (s *esStore) Stats(ctx context.Context, profile string) (StoreStats, error) {
+	st := StoreStats{Backend: "es"}
+	terms := func(field string) map[string]any {
+		return map[string]any{"terms": map[string]any{"field": field, "size": engineWindow}}
+	}
+	var resp struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+		} `json:"hits"`
+		Aggs map[string]struct {
+			Value   *float64 `json:"value"`
+			Buckets []struct {
+				Key   string `json:"key"`
+				Count int64  `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"aggregations"`
+	}
+	if err := s.cfg.call(ctx, http.MethodPost, s.path("/_search"), map[string]any{
+		"size": 0, "track_total_hits": true,
+		"query": map[string]any{"term": map[string]any{"profile": profile}},
+		"aggs": map[string]any{
+			"folders": terms("folder"), "accounts": terms("account"), "years": terms("year"),
+			"oldest": map[string]any{"min": map[string]any{"field": "when"}},
+			"newest": map[string]any{"max": map[string]any{"field": "when"}},
+			"bytes":  map[string]any{"sum": map[string]any{"field": "search_bytes"}},
+		},
+	}, &resp); err != nil {
+		return st, s.notSynced(err)
+	}
+	breakdown := func(name string) []StoreCount {
+		var out []StoreCount
+		for _, b := range resp.Aggs[name].Buckets {
+			out = append(out, StoreCount{b.Key, b.Count})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+		return out
+	}
+	st.Messages = resp.Hits.Total.Value
+	st.ByFolder, st.ByAccount, st.ByYear = breakdown("folders"), breakdown("accounts"), breakdown("years")
+	st.Folders = int64(len(st.ByFolder))
+	if v := resp.Aggs["oldest"].Value; v != nil {
+		st.Oldest = time.UnixMilli(int64(*v))
+	}
+	if v := resp.Aggs["newest"].Value; v != nil {
+		st.Newest = time.UnixMilli(int64(*v))
+	}
+	if v := resp.Aggs["bytes"].Value; v != nil {
+		st.SearchBytes = int64(*v)
+	}
+
+	var stats struct {
+		Indices map[string]struct {
+			Primaries struct {
+				Docs struct {
+					Count   int64 `json:"count"`
+					Deleted int64 `json:"deleted"`
+				} `json:"docs"`
+				Store struct {
+					Size int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"primaries"`
+		} `json:"indices"`
+	}
+	if err := s.cfg.call(ctx, http.MethodGet, s.path("/_stats/docs,store"), nil, &stats); err != nil {
+		return st, err
+	}
+	for name, idx := range stats.Indices {
+		p := idx.Primaries
+		reclaim := int64(0)
+		if all := p.Docs.Count + p.Docs.Deleted; all > 0 {
+			reclaim = p.Store.Size * p.Docs.Deleted / all
+		}
+		st.Storage = append(st.Storage, StoreSpace{Name: name, Bytes: p.Store.Size, Reclaimable: reclaim})
+	}
+	sort.Slice(st.Storage, func(i, j int) bool { return st.Storage[i].Name < st.Storage[j].Name })
+	return st, nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Semantic search keeps one embedding per message in tb_messages.embedding, a
+// pgvector column, and ranks messages by cosine distance to the embedded
+// query. Embeddings come from a local command (TB_EMBED_CMD) or an
+// OpenAI-compatible HTTP endpoint (TB_EMBED_URL, e.g. Ollama's
+// http://localhost:11434/v1/embeddings), so any model can be plugged in.
+
+// embedTextLimit caps the characters of a message that are embedded; models
+// only look at the first few thousand tokens anyway.
+const embedTextLimit = 4000
+
+type embedder struct {
+	model string   // recorded with every embedding; a new model re-embeds
+	cmd   []string // TB_EMBED_CMD: JSON strings in, JSON arrays out, one per line
+	url   string   // TB_EMBED_URL
+	key   string   // TB_EMBED_API_KEY, sent as a bearer token
+}
+
+func newEmbedder() (*embedder, error) {
+	e := &embedder{
+		model: strings.TrimSpace(os.Getenv("TB_EMBED_MODEL")),
+		url:   strings.TrimSpace(os.Getenv("TB_EMBED_URL")),
+		key:   strings.TrimSpace(os.Getenv("TB_EMBED_API_KEY")),
+	}
+	if cmd := strings.Fields(os.Getenv("TB_EMBED_CMD")); len(cmd) > 0 {
+		e.cmd = cmd
+		if e.model == "" {
+			e.model = filepath.Base(cmd[0])
+		}
+		return e, nil
+	}
+	if e.url == "" {
+		return nil, fmt.Errorf("no embedding model configured (set TB_EMBED_CMD, or TB_EMBED_URL and TB_EMBED_MODEL)")
+	}
+	if e.model == "" {
+		return nil, fmt.Errorf("TB_EMBED_URL needs TB_EMBED_MODEL")
+	}
+	return e, nil
+}
+
+// embed returns one vector per text, in order.
+func (e *embedder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var (
+		vecs [][]float32
+		err  error
+	)
+	if e.cmd != nil {
+		vecs, err = e.embedCommand(ctx, texts)
+	} else {
+		vecs, err = e.embedHTTP(ctx, texts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("embed: %w", err)
+	}
+	if len(vecs) != len(texts) {
+		return nil, fmt.Errorf("embed: model returned %d vectors for %d texts", len(vecs), len(texts))
+	}
+	return vecs, nil
+}
+
+func (e *embedder) embedCommand(ctx context.Context, texts []string) ([][]float32, error) {
+	var in bytes.Buffer
+	enc := json.NewEncoder(&in)
+	for _, t := range texts {
+		enc.Encode(t)
+	}
+	c := exec.CommandContext(ctx, e.cmd[0], e.cmd[1:]...)
+	c.Stdin = &in
+	c.Stderr = os.Stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.cmd[0], err)
+	}
+	var vecs [][]float32
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 1<<20), 64<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var v []float32
+		if err := json.Unmarshal(line, &v); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.cmd[0], err)
+		}
+		vecs = append(vecs, v)
+	}
+	return vecs, sc.Err()
+}
+
+func (e *embedder) embedHTTP(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]any{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.key != "" {
+		req.Header.Set("Authorization", "Bearer "+e.key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s: %s", e.url, resp.Status, bytes.TrimSpace(msg))
+	}
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("%s: %w", e.url, err)
+	}
+	vecs := make([][]float32, len(texts))
+	for _, d := range parsed.Data {
+		if d.Index < 0 || d.Index >= len(vecs) {
+			return nil, fmt.Errorf("%s: embedding index %d out of range", e.url, d.Index)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	return vecs, nil
+}
+
+// vectorLiteral renders v in pgvector's text form.
+func vectorLiteral(v []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(f), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// ensureVectorSchema adds the embedding columns. It is separate from
+// ensureSchema so that only semantic search needs the pgvector extension.
+func (s *pgStore) ensureVectorSchema(ctx context.Context) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	_, err := s.pool.Exec(ctx, `
+CREATE EXTENSION IF NOT EXISTS vector;
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS embedding vector;
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS embedding_model text;
+`)
+	if err != nil {
+		return fmt.Errorf("pgvector: %w", err)
+	}
+	return nil
+}
+
+// pgEmbedWhere selects the messages of a profile with no embedding from model.
+const pgEmbedWhere = "profile = $1 AND (embedding IS NULL OR embedding_model IS DISTINCT FROM $2)"
+
+// CountUnembedded returns how many messages of profile still need an embedding from model.
+func (s *pgStore) CountUnembedded(ctx context.Context, profile, model string) (int64, error) {
+	var n int64
+	err := s.pool.QueryRow(ctx, "SELECT COUNT(*) FROM tb_messages WHERE "+pgEmbedWhere, profile, model).Scan(&n)
+	return n, err
+}
+
+// Unembedded returns up to limit messages needing an embedding from model,
+// newest first, with the text to embed.
+func (s *pgStore) Unembedded(ctx context.Context, profile, model string, limit int) (ids, texts []string, err error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT message_id, left(concat_ws(E'\n', subject, sender, coalesce(nullif(body_text, ''), snippet)), $3)
+FROM tb_messages
+WHERE `+pgEmbedWhere+`
+ORDER BY when_ts DESC NULLS LAST
+LIMIT $4
+`, profile, model, embedTextLimit, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return nil, nil, err
+		}
+		if strings.TrimSpace(text) == "" {
+			text = "(empty)"
+		}
+		ids = append(ids, id)
+		texts = append(texts, forceUTF8(text))
+	}
+	return ids, texts, rows.Err()
+}
+
+// SetEmbeddings stores one vector per message id.
+func (s *pgStore) SetEmbeddings(ctx context.Context, profile, model string, ids []string, vecs [][]float32) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	var batch pgx.Batch
+	for i, id := range ids {
+		batch.Queue("UPDATE tb_messages SET embedding = $1::vector, embedding_model = $2 WHERE profile = $3 AND message_id = $4",
+			vectorLiteral(vecs[i]), model, profile, id)
+	}
+	return s.pool.SendBatch(ctx, &batch).Close()
+}
+
+// Nearest returns the messages matching q's filters whose embedding is
+// closest to vec, ignoring q.query.
+func (s *pgStore) Nearest(ctx context.Context, q queryOptions, vec []float32, model string) ([]MailSummary, error) {
+	q.query = ""
+	clause, args := pgWhere(q)
+	args = append(args, model, vectorLiteral(vec))
+	limitClause := ""
+	if q.limit > 0 {
+		limitClause = fmt.Sprintf("LIMIT %d", q.limit)
+	}
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+SELECT %s
+FROM tb_messages
+WHERE %s AND embedding IS NOT NULL AND embedding_model = $%d
+ORDER BY embedding <=> $%d::vector
+%s
+`, pgSummaryColumns, clause, len(args)-1, len(args), limitClause), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%w (run tb mail embed first)", err)
+	}
+	return scanPGSummaries(rows)
+}
+
+// semanticSearch ranks messages by meaning and merges them with the keyword
+// hits for the same text (or for q.query when one is given), so messages
+// found both ways come first.
+func semanticSearch(ctx context.Context, store *pgStore, q queryOptions) ([]MailSummary, error) {
+	e, err := newEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	vecs, err := e.embed(ctx, []string{q.semantic})
+	if err != nil {
+		return nil, err
+	}
+	near, err := store.Nearest(ctx, q, vecs[0], e.model)
+	if err != nil {
+		return nil, err
+	}
+	kq := q
+	if kq.query == "" {
+		kq.query = q.semantic
+	}
+	keyword, err := store.Search(ctx, kq)
+	if err != nil {
+		return nil, err
+	}
+	return fuseRanked(q.limit, near, keyword), nil
+}
+
+// fuseRanked merges ranked lists by reciprocal rank fusion: each list adds
+// 1/(60+rank) to a message's score.
+func fuseRanked(limit int, lists ...[]MailSummary) []MailSummary {
+	score := map[string]float64{}
+	first := map[string]MailSummary{}
+	var order []string
+	for _, list := range lists {
+		for rank, m := range list {
+			key := m.Profile + "\x00" + m.MessageID
+			if _, ok := first[key]; !ok {
+				first[key] = m
+				order = append(order, key)
+			}
+			score[key] += 1 / float64(60+rank+1)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return score[order[i]] > score[order[j]] })
+	var out []MailSummary
+	for _, key := range order {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		out = append(out, first[key])
+	}
+	return out
+}
+
+// embedMessages fills in missing embeddings for one profile's messages.
+func (a *App) embedMessages(profileName, storeName string, batchSize, limit int) error {
+	if storeName != "pg" {
+		return fmt.Errorf("unknown --store %q (use pg)", storeName)
+	}
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	e, err := newEmbedder()
+	if err != nil {
+		return err
+	}
+	store, err := openPG()
+	if err != nil {
+		return fmt.Errorf("postgres required for embed: %w", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if err := store.ensureVectorSchema(ctx); err != nil {
+		return err
+	}
+	pending, err := store.CountUnembedded(ctx, profile.Name, e.model)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && int64(limit) < pending {
+		pending = int64(limit)
+	}
+	if pending == 0 {
+		fmt.Printf("Every message of %s already has an embedding from %s\n", profile.Name, e.model)
+		return nil
+	}
+	fmt.Printf("Embedding %d message(s) of %s with %s...\n", pending, profile.Name, e.model)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	done := 0
+	for int64(done) < pending {
+		ids, texts, err := store.Unembedded(ctx, profile.Name, e.model, min(batchSize, int(pending)-done))
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		vecs, err := e.embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		if err := store.SetEmbeddings(ctx, profile.Name, e.model, ids, vecs); err != nil {
+			return err
+		}
+		done += len(ids)
+		if !progressQuiet {
+			fmt.Fprintf(os.Stderr, "\r  %d/%d", done, pending)
+		}
+	}
+	if !progressQuiet {
+		fmt.Fprintln(os.Stderr)
+	}
+	fmt.Printf("Embedded %d message(s)\n", done)
+	return nil
+}
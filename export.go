@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-mbox"
+)
+
+// forEachRawMessage re-reads the mbox folders behind hits and calls fn with the
+// untouched bytes of every hit, located by Message-Id. Hits with a current
+// offset in the local index are read directly; each remaining folder is scanned once.
+func (a *App) forEachRawMessage(profile Profile, hits []MailSummary, fn func(h MailSummary, raw []byte) error) error {
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return err
+	}
+	byName := map[string]Mailbox{}
+	for _, b := range boxes {
+		byName[b.Name] = b
+	}
+	wanted := map[string]map[string]MailSummary{}
+	var order, allIDs []string
+	for _, h := range hits {
+		if h.MessageID == "" {
+			log.Printf("warn: %s: message has no Message-Id; cannot locate it", h.Subject)
+			continue
+		}
+		if wanted[h.Folder] == nil {
+			wanted[h.Folder] = map[string]MailSummary{}
+			order = append(order, h.Folder)
+		}
+		wanted[h.Folder][h.MessageID] = h
+		allIDs = append(allIDs, h.MessageID)
+	}
+	spans := indexedSpans(profile, allIDs)
+	for _, folder := range order {
+		box, ok := byName[folder]
+		if !ok {
+			log.Printf("warn: folder %s no longer exists on disk", folder)
+			continue
+		}
+		ids := wanted[folder]
+		// Messages the local index knows are read directly; the rest need a scan.
+		for id, h := range ids {
+			for _, sp := range spans[normalizeMessageID(id)] {
+				if sp.Folder != folder {
+					continue
+				}
+				if raw, ok := readIndexedMessage(box, sp.Span, id); ok {
+					delete(ids, id)
+					if err := fn(h, raw); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		if err := scanRawMessages(box, func(id string, raw []byte) (bool, error) {
+			h, ok := ids[id]
+			if !ok {
+				return true, nil
+			}
+			delete(ids, id)
+			if err := fn(h, raw); err != nil {
+				return false, err
+			}
+			return len(ids) > 0, nil
+		}); err != nil {
+			return err
+		}
+		for id := range ids {
+			log.Printf("warn: %s not found in %s (folder changed since ingest?)", id, folder)
+		}
+	}
+	return nil
+}
+
+// scanRawMessages walks box and passes each message's Message-Id and raw bytes to fn
+// until fn returns false.
+func scanRawMessages(box Mailbox, fn func(id string, raw []byte) (bool, error)) error {
+	f, err := openMailbox(box)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := mbox.NewReader(f)
+	for {
+		msgReader, err := reader.NextMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("warn: %s: %v", box.Name, err)
+			continue
+		}
+		raw, err := io.ReadAll(msgReader)
+		if err != nil {
+			return err
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			continue
+		}
+		more, err := fn(msg.Header.Get("Message-Id"), raw)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// forEachRawMessageIn is forEachRawMessage for hits that may come from several
+// profiles; each hit is looked up in the profile named by its Profile field.
+func (a *App) forEachRawMessageIn(profiles []Profile, hits []MailSummary, fn func(h MailSummary, raw []byte) error) error {
+	if len(profiles) == 1 {
+		return a.forEachRawMessage(profiles[0], hits, fn)
+	}
+	for _, p := range profiles {
+		var own []MailSummary
+		for _, h := range hits {
+			if h.Profile == p.Name {
+				own = append(own, h)
+			}
+		}
+		if len(own) == 0 {
+			continue
+		}
+		if err := a.forEachRawMessage(p, own, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportMbox writes the raw messages behind hits to a new mbox file at path.
+func (a *App) exportMbox(profiles []Profile, hits []MailSummary, path string) (int, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := mbox.NewWriter(f)
+	n := 0
+	err = a.forEachRawMessageIn(profiles, hits, func(h MailSummary, raw []byte) error {
+		mw, err := w.CreateMessage(strings.Trim(senderAddress(h.From), "<>"), h.When)
+		if err != nil {
+			return err
+		}
+		if _, err := mw.Write(raw); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := w.Close(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		f.Close()
+		os.Remove(path)
+		return 0, fmt.Errorf("no messages could be located on disk")
+	}
+	return n, f.Close()
+}
+
+// exportEML writes the raw messages behind hits as individual .eml files in dir.
+func (a *App) exportEML(profiles []Profile, hits []MailSummary, dir string) (int, error) {
+	n := 0
+	err := a.forEachRawMessageIn(profiles, hits, func(h MailSummary, raw []byte) error {
+		if _, err := writeEML(dir, h, raw); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+var emlNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._@+-]+`)
+
+// emlFileName builds a stable, filesystem-safe name from the message date and Message-Id.
+func emlFileName(m MailSummary) string {
+	date := "undated"
+	if !m.When.IsZero() {
+		date = m.When.UTC().Format("20060102-150405")
+	}
+	id := strings.Trim(m.MessageID, "<> ")
+	if id == "" {
+		id = normalizeSubject(m.Subject)
+	}
+	id = strings.Trim(emlNameUnsafe.ReplaceAllString(id, "_"), "_.")
+	if len(id) > 120 {
+		id = id[:120]
+	}
+	if id == "" {
+		id = "message"
+	}
+	return date + "_" + id + ".eml"
+}
+
+// writeEML saves raw into dir (created if needed) and returns the file path.
+func writeEML(dir string, m MailSummary, raw []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, emlFileName(m))
+	return path, os.WriteFile(path, raw, 0o644)
+}
+
+// saveRawMessage writes raw to path, or into path when it is a directory.
+// Existing files are never overwritten; a -N suffix is added instead, which
+// also keeps every message when several are saved to the same path.
+func saveRawMessage(path string, m MailSummary, raw []byte) (string, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, emlFileName(m))
+	}
+	path, err := uniquePath(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-mbox"
+)
+
+// tb mail fetch --imap reads a folder straight from the IMAP server of one
+// of the profile's accounts, for mail Thunderbird has not downloaded for
+// offline use. Messages are appended to an mbox of tb's own next to the
+// config file, registered as an import (Imported/IMAP/<account>/<folder>),
+// and indexed, so search and show find them like any other folder. The
+// folder is opened with EXAMINE, so nothing on the server changes.
+
+// imapFetchOptions are the flags of tb mail fetch --imap.
+type imapFetchOptions struct {
+	account     string    // account key or email; may be empty when the profile has one IMAP account
+	folder      string    // folder name on the server
+	since       time.Time // only messages the server received on or after this day
+	maxMessages int       // cap on messages downloaded per run; 0 = all
+	headers     bool      // list the newest tail messages' headers instead of downloading
+	tail        int
+	format      string
+}
+
+// imapFetchState is what tb remembers of one fetched folder. UIDs only
+// mean something under the same UIDVALIDITY.
+type imapFetchState struct {
+	UIDValidity uint32    `json:"uidvalidity"`
+	LastUID     uint32    `json:"last_uid"`
+	Messages    int       `json:"messages"`
+	Fetched     time.Time `json:"fetched"`
+}
+
+// imapFetchBatch is how many messages one UID FETCH asks for.
+const imapFetchBatch = 50
+
+// imapAccount picks the IMAP account of profile named by want (its key or
+// one of its emails), or the only one when want is empty.
+func imapAccount(p Profile, prefs prefMap, want string) (mailAccount, error) {
+	var imap []mailAccount
+	for _, acct := range accountsFromPrefs(p, prefs) {
+		if acct.Type != "imap" {
+			continue
+		}
+		if want == "" || strings.EqualFold(acct.Key, want) || containsFold(acct.Emails, want) {
+			imap = append(imap, acct)
+		}
+	}
+	switch {
+	case len(imap) == 1:
+		return imap[0], nil
+	case len(imap) == 0 && want != "":
+		return mailAccount{}, fmt.Errorf("no IMAP account %s in prefs.js", want)
+	case len(imap) == 0:
+		return mailAccount{}, errors.New("the profile has no IMAP accounts")
+	}
+	var names []string
+	for _, acct := range imap {
+		names = append(names, firstNonEmpty(strings.Join(acct.Emails, ","), acct.Key))
+	}
+	return mailAccount{}, fmt.Errorf("several IMAP accounts; pick one with --account (%s)", strings.Join(names, ", "))
+}
+
+// imapPassword finds the password of user at host in the system keyring
+// (secret-tool on Linux and the BSDs, the login keychain on macOS), else asks
+// for it on the terminal. Thunderbird's own saved passwords are encrypted
+// with the profile's key4.db and cannot be read without NSS.
+func imapPassword(host, user string) (string, error) {
+	var lookup *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		lookup = exec.Command("security", "find-internet-password", "-s", host, "-a", user, "-w")
+	case "windows":
+	default:
+		lookup = exec.Command("secret-tool", "lookup", "service", "tb", "protocol", "imap", "server", host, "user", user)
+	}
+	if lookup != nil {
+		if out, err := lookup.Output(); err == nil {
+			if pw := strings.TrimRight(string(out), "\r\n"); pw != "" {
+				return pw, nil
+			}
+		}
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return "", fmt.Errorf("no password for %s@%s in the keyring, and stdin is not a terminal to ask on", user, host)
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s@%s: ", user, host)
+	if err := setTerminalEcho(false); err == nil {
+		defer func() {
+			setTerminalEcho(true)
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// imapFetch connects to the account's server and lists or downloads folder.
+func (a *App) imapFetch(profileName string, opts imapFetchOptions) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	prefs, err := profilePrefs(profile)
+	if err != nil {
+		return err
+	}
+	acct, err := imapAccount(profile, prefs, opts.account)
+	if err != nil {
+		return err
+	}
+	if acct.Hostname == "" || acct.Username == "" {
+		return fmt.Errorf("account %s has no hostname or user name in prefs.js", acct.Key)
+	}
+	socketType, _ := prefs.Int(fmt.Sprintf("mail.server.%s.socketType", acct.Server))
+	password, err := imapPassword(acct.Hostname, acct.Username)
+	if err != nil {
+		return err
+	}
+	c, err := dialIMAP(acct.Hostname, acct.Port, socketType)
+	if err != nil {
+		return err
+	}
+	defer c.logout()
+	if err := c.login(acct.Username, password); err != nil {
+		return err
+	}
+	box, err := c.examine(opts.folder)
+	if err != nil {
+		return err
+	}
+	if opts.headers {
+		return printIMAPHeaders(c, box, opts)
+	}
+	return a.downloadIMAPFolder(profile, acct, c, box, opts)
+}
+
+// imapHeader is one row of tb mail fetch --imap --headers.
+type imapHeader struct {
+	UID       uint32    `json:"uid"`
+	Date      time.Time `json:"date"`
+	From      string    `json:"from"`
+	Subject   string    `json:"subject"`
+	MessageID string    `json:"message_id,omitempty"`
+	Size      int64     `json:"size"`
+	Flags     []string  `json:"flags"`
+}
+
+// printIMAPHeaders lists the headers of the newest tail messages of box,
+// newest first.
+func printIMAPHeaders(c *imapClient, box imapMailbox, opts imapFetchOptions) error {
+	var msgs []imapMessage
+	if box.Exists > 0 {
+		first := max(box.Exists-opts.tail+1, 1)
+		var err error
+		msgs, err = c.fetch(false, fmt.Sprintf("%d:%d", first, box.Exists), "(UID FLAGS INTERNALDATE RFC822.SIZE BODY.PEEK[HEADER])")
+		if err != nil {
+			return err
+		}
+	}
+	headers := make([]imapHeader, 0, len(msgs))
+	for i := len(msgs) - 1; i >= 0; i-- {
+		m := msgs[i]
+		h := imapHeader{UID: m.UID, Date: m.InternalDate, Size: m.Size, Flags: m.Flags}
+		if h.Flags == nil {
+			h.Flags = []string{}
+		}
+		if msg, err := mail.ReadMessage(bytes.NewReader(m.Body)); err == nil {
+			h.From = decodeHeader(msg.Header.Get("From"))
+			h.Subject = decodeHeader(msg.Header.Get("Subject"))
+			h.MessageID = strings.TrimSpace(msg.Header.Get("Message-ID"))
+			if when, ok := parseDateFlexible(msg.Header.Get("Date")); ok {
+				h.Date = when
+			}
+		}
+		headers = append(headers, h)
+	}
+	if opts.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		for _, h := range headers {
+			if err := enc.Encode(h); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(headers) == 0 {
+		fmt.Printf("%s is empty.\n", opts.folder)
+		return nil
+	}
+	fmt.Printf("%s: %d messages, newest %d:\n\n", opts.folder, box.Exists, len(headers))
+	rows := make([][]string, 0, len(headers))
+	for _, h := range headers {
+		flags := ""
+		if !containsFold(h.Flags, `\Seen`) {
+			flags += "U"
+		}
+		if containsFold(h.Flags, `\Flagged`) {
+			flags += "*"
+		}
+		rows = append(rows, []string{strconv.FormatUint(uint64(h.UID), 10), h.Date.In(time.Local).Format("2006-01-02 15:04"),
+			truncate(h.From, 30), truncate(h.Subject, 60), byteSize(h.Size), flags})
+	}
+	renderTable(os.Stdout, []string{"UID", "Date", "From", "Subject", "Size", "Flags"}, rows)
+	return nil
+}
+
+// downloadIMAPFolder appends the messages of box not fetched before to tb's
+// mbox for it, then brings that into the local index.
+func (a *App) downloadIMAPFolder(profile Profile, acct mailAccount, c *imapClient, box imapMailbox, opts imapFetchOptions) error {
+	dir := configFile("imap")
+	if dir == "" {
+		return errors.New("no config directory to keep fetched mail in")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	// Folder names such as ".." must not leave the directory.
+	safe := func(name string) string {
+		return firstNonEmpty(strings.Trim(emlNameUnsafe.ReplaceAllString(name, "_"), "_."), "_")
+	}
+	base := filepath.Join(dir, safe(profile.Name), safe(acct.Key), safe(opts.folder))
+	mboxPath, statePath := base+".mbox", base+".json"
+	if err := os.MkdirAll(filepath.Dir(base), 0o700); err != nil {
+		return err
+	}
+	var state imapFetchState
+	if b, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(b, &state); err != nil {
+			return fmt.Errorf("%s: %w", statePath, err)
+		}
+	}
+	if state.UIDValidity != 0 && state.UIDValidity != box.UIDValidity {
+		log.Printf("warn: %s was renumbered on the server (UIDVALIDITY %d, was %d); fetching it again", opts.folder, box.UIDValidity, state.UIDValidity)
+		if err := os.Truncate(mboxPath, 0); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		state = imapFetchState{}
+	}
+	state.UIDValidity = box.UIDValidity
+
+	criteria := fmt.Sprintf("UID %d:*", state.LastUID+1)
+	if !opts.since.IsZero() {
+		criteria += " SINCE " + opts.since.Format("2-Jan-2006")
+	}
+	var uids []uint32
+	if box.Exists > 0 {
+		found, err := c.searchUIDs(criteria)
+		if err != nil {
+			return err
+		}
+		// n:* always matches the highest UID, even one below n.
+		for _, uid := range found {
+			if uid > state.LastUID {
+				uids = append(uids, uid)
+			}
+		}
+	}
+	if opts.maxMessages > 0 && len(uids) > opts.maxMessages {
+		uids = uids[:opts.maxMessages]
+	}
+	if len(uids) == 0 {
+		fmt.Printf("No new messages in %s on %s.\n", opts.folder, acct.Hostname)
+		return nil
+	}
+
+	f, err := os.OpenFile(mboxPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fetched := 0
+	for start := 0; start < len(uids); start += imapFetchBatch {
+		batch := uids[start:min(start+imapFetchBatch, len(uids))]
+		msgs, err := c.fetch(true, uidSet(batch), "(UID FLAGS INTERNALDATE BODY.PEEK[])")
+		if err != nil {
+			return err
+		}
+		w := mbox.NewWriter(f)
+		for _, m := range msgs {
+			if m.UID <= state.LastUID || m.Body == nil {
+				continue
+			}
+			if err := writeIMAPMessage(w, m); err != nil {
+				return err
+			}
+			state.LastUID = m.UID
+			state.Messages++
+			fetched++
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		state.Fetched = time.Now().UTC()
+		if err := saveIMAPFetchState(statePath, state); err != nil {
+			return err
+		}
+		if !progressQuiet {
+			fmt.Fprintf(os.Stderr, "  %s: %d / %d messages\n", opts.folder, start+len(batch), len(uids))
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	label := "IMAP/" + firstNonEmpty(append(acct.Emails, acct.Username)...) + "/" + opts.folder
+	mb, err := importedIMAPMailbox(profile, mboxPath, label)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fetched %d messages from %s on %s into %s (%s)\n", fetched, opts.folder, acct.Hostname, mb.Name, mboxPath)
+	return a.buildIndex(profile.AbsolutePath, indexOptions{tailCount: defaultIndexTail, jobs: 1, folderLike: mb.Name})
+}
+
+// writeIMAPMessage appends m to w with the X-Mozilla-Status Thunderbird
+// would have written for its flags.
+func writeIMAPMessage(w *mbox.Writer, m imapMessage) error {
+	from := ""
+	if msg, err := mail.ReadMessage(bytes.NewReader(m.Body)); err == nil {
+		from = strings.Trim(senderAddress(msg.Header.Get("From")), "<>")
+	}
+	mw, err := w.CreateMessage(from, m.InternalDate)
+	if err != nil {
+		return err
+	}
+	status := 0
+	if containsFold(m.Flags, `\Seen`) {
+		status |= mozFlagRead
+	}
+	if containsFold(m.Flags, `\Flagged`) {
+		status |= mozFlagMarked
+	}
+	if _, err := fmt.Fprintf(mw, "X-Mozilla-Status: %04x\r\nX-Mozilla-Status2: 00000000\r\n", status); err != nil {
+		return err
+	}
+	_, err = mw.Write(m.Body)
+	return err
+}
+
+func saveIMAPFetchState(path string, state imapFetchState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// importedIMAPMailbox registers the fetched mbox at path as an import of
+// profile under label the first time, and returns its mailbox.
+func importedIMAPMailbox(profile Profile, path, label string) (Mailbox, error) {
+	for _, b := range importedMailboxes(profile) {
+		if b.Path == path {
+			return b, nil
+		}
+	}
+	return addImport(profile, path, label)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// folderStat is one row of tb mail folder-stats.
+type folderStat struct {
+	Folder   string     `json:"folder"`
+	Messages int        `json:"messages"`
+	Unread   int        `json:"unread"`
+	Oldest   *time.Time `json:"oldest,omitempty"`
+	Newest   *time.Time `json:"newest,omitempty"`
+	Size     int64      `json:"size"`
+	Source   string     `json:"source"` // index, or scan when the folder was read for this report
+}
+
+// scanFolderStat reads box to count its messages, unread ones, and dates.
+func scanFolderStat(box Mailbox) (folderStat, error) {
+	st := folderStat{Folder: box.Name, Size: box.Size, Source: "scan"}
+	err := scanIndexMailbox(box, 0, func(string) bool { return true }, time.Time{}, "", func(_ int64, m *MailSummary) error {
+		if m == nil {
+			return nil
+		}
+		st.Messages++
+		if m.Unread() {
+			st.Unread++
+		}
+		if when := m.When; !when.IsZero() {
+			if st.Oldest == nil || when.Before(*st.Oldest) {
+				st.Oldest = &when
+			}
+			if st.Newest == nil || when.After(*st.Newest) {
+				st.Newest = &when
+			}
+		}
+		return nil
+	})
+	return st, err
+}
+
+// folderStats reports per-folder message, unread, and date figures. Folders
+// the local index holds as they are on disk are answered from it; the others,
+// or all of them with scan, are read. Unread counts from the index are as of
+// the last index run, since Thunderbird marks mail read in place.
+func (a *App) folderStats(profileName, folderLike, sortBy, format string, scan bool) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return err
+	}
+	if folderLike != "" {
+		needle := strings.ToLower(folderLike)
+		boxes = slices.DeleteFunc(boxes, func(b Mailbox) bool { return !strings.Contains(strings.ToLower(b.Name), needle) })
+		if len(boxes) == 0 {
+			return fmt.Errorf("no folders match %q", folderLike)
+		}
+	}
+
+	indexed := map[string]indexedFolder{}
+	if !scan {
+		onDisk := map[string]Mailbox{}
+		for _, b := range boxes {
+			onDisk[b.Path] = b
+		}
+		if unlock, err := lockIndex(profile, false); err == nil {
+			if folders, _, _, err := loadIndexedFolders(profile); err == nil {
+				for _, f := range folders {
+					if _, ok := onDisk[f.Path]; ok && folderIndexStatus(f, onDisk) == "ok" {
+						indexed[f.Path] = f
+					}
+				}
+			}
+			unlock()
+		}
+	}
+	stats := make([]folderStat, 0, len(boxes))
+	for _, b := range boxes {
+		if f, ok := indexed[b.Path]; ok {
+			st := folderStat{Folder: b.Name, Messages: f.Messages, Unread: f.Unread, Size: b.Size, Source: "index"}
+			if f.First != 0 {
+				t := time.Unix(f.First, 0)
+				st.Oldest = &t
+			}
+			if f.Last != 0 {
+				t := time.Unix(f.Last, 0)
+				st.Newest = &t
+			}
+			stats = append(stats, st)
+			continue
+		}
+		st, err := scanFolderStat(b)
+		if err != nil {
+			log.Printf("warn: %s: %v", b.Name, err)
+			continue
+		}
+		stats = append(stats, st)
+	}
+
+	before := func(x, y *time.Time) bool {
+		switch {
+		case x == nil:
+			return false
+		case y == nil:
+			return true
+		}
+		return x.Before(*y)
+	}
+	switch sortBy {
+	case "", "name":
+	case "size":
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].Size > stats[j].Size })
+	case "messages":
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].Messages > stats[j].Messages })
+	case "unread":
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].Unread > stats[j].Unread })
+	case "oldest":
+		sort.SliceStable(stats, func(i, j int) bool { return before(stats[i].Oldest, stats[j].Oldest) })
+	case "newest":
+		sort.SliceStable(stats, func(i, j int) bool { return before(stats[j].Newest, stats[i].Newest) })
+	default:
+		return fmt.Errorf("unknown --sort %q (use name, size, messages, unread, oldest, or newest)", sortBy)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		for _, st := range stats {
+			if err := enc.Encode(st); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	day := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.In(time.Local).Format("2006-01-02")
+	}
+	var total folderStat
+	rows := make([][]string, 0, len(stats)+1)
+	for _, st := range stats {
+		rows = append(rows, []string{st.Folder, strconv.Itoa(st.Messages), strconv.Itoa(st.Unread), day(st.Oldest), day(st.Newest), byteSize(st.Size), st.Source})
+		total.Messages += st.Messages
+		total.Unread += st.Unread
+		total.Size += st.Size
+		if before(st.Oldest, total.Oldest) {
+			total.Oldest = st.Oldest
+		}
+		if st.Newest != nil && (total.Newest == nil || st.Newest.After(*total.Newest)) {
+			total.Newest = st.Newest
+		}
+	}
+	rows = append(rows, []string{fmt.Sprintf("Total (%d folders)", len(stats)), strconv.Itoa(total.Messages), strconv.Itoa(total.Unread), day(total.Oldest), day(total.Newest), byteSize(total.Size), ""})
+	renderTable(os.Stdout, []string{"Folder", "Messages", "Unread", "Oldest", "Newest", "Size", "Source"}, rows)
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// folderNode is one folder of the tree tb mail folders prints. The top
+// level holds the account directories (Mail/Local Folders,
+// ImapMail/<server>) and Imported.
+type folderNode struct {
+	Name     string        `json:"name"`
+	Folder   string        `json:"folder,omitempty"` // what --folder takes; empty when only subfolders exist on disk
+	Size     int64         `json:"size"`
+	Messages *int          `json:"messages,omitempty"`
+	Children []*folderNode `json:"children,omitempty"`
+}
+
+// folderTree nests boxes the way Thunderbird shows them: the subfolders of
+// a folder live in the directory named after it plus .sbd.
+func folderTree(boxes []Mailbox) []*folderNode {
+	var roots []*folderNode
+	child := func(list *[]*folderNode, name string) *folderNode {
+		for _, n := range *list {
+			if n.Name == name {
+				return n
+			}
+		}
+		n := &folderNode{Name: name}
+		*list = append(*list, n)
+		return n
+	}
+	for _, b := range boxes {
+		parts := strings.Split(filepath.ToSlash(b.Name), "/")
+		top := 2 // Mail/<server> or ImapMail/<server>
+		if strings.HasPrefix(b.Name, importedFolderPrefix) {
+			top = 1
+		}
+		top = min(top, len(parts)-1)
+		node := child(&roots, strings.Join(parts[:top], "/"))
+		for _, part := range parts[top:] {
+			node = child(&node.Children, strings.TrimSuffix(part, ".sbd"))
+		}
+		node.Folder, node.Size = b.Name, b.Size
+	}
+	sortFolderNodes(roots)
+	return roots
+}
+
+// specialFolderOrder puts the folders Thunderbird lists first in its order.
+var specialFolderOrder = []string{"inbox", "drafts", "templates", "sent", "archives", "junk", "trash", "unsent messages"}
+
+func sortFolderNodes(nodes []*folderNode) {
+	rank := func(n *folderNode) int {
+		for i, name := range specialFolderOrder {
+			if strings.EqualFold(n.Name, name) {
+				return i
+			}
+		}
+		return len(specialFolderOrder)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		ri, rj := rank(nodes[i]), rank(nodes[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return strings.ToLower(nodes[i].Name) < strings.ToLower(nodes[j].Name)
+	})
+	for _, n := range nodes {
+		sortFolderNodes(n.Children)
+	}
+}
+
+// treeTotals returns the size and, when counted, the messages of n with all
+// its subfolders.
+func (n *folderNode) treeTotals() (int64, *int) {
+	size, msgs := n.Size, n.Messages
+	for _, c := range n.Children {
+		s, m := c.treeTotals()
+		size += s
+		if m != nil {
+			sum := *m
+			if msgs != nil {
+				sum += *msgs
+			}
+			msgs = &sum
+		}
+	}
+	return size, msgs
+}
+
+// folderMessageCounts counts the messages of boxes by folder name, taking
+// the count from the local index for folders it has fully indexed at their
+// current size and scanning the others.
+func (a *App) folderMessageCounts(profile Profile, boxes []Mailbox) map[string]int {
+	byPath := map[string]Mailbox{}
+	for _, b := range boxes {
+		byPath[b.Path] = b
+	}
+	counts := map[string]int{}
+	if unlock, err := lockIndex(profile, false); err == nil {
+		if folders, _, _, err := loadIndexedFolders(profile); err == nil {
+			for _, f := range folders {
+				if b, ok := byPath[f.Path]; ok && f.Complete != 0 && f.Size == b.Size {
+					counts[b.Name] = f.Messages
+				}
+			}
+		}
+		unlock()
+	}
+	for _, b := range boxes {
+		if _, ok := counts[b.Name]; ok {
+			continue
+		}
+		n := 0
+		err := scanIndexMailbox(b, 0, func(string) bool { return true }, time.Time{}, "", func(_ int64, m *MailSummary) error {
+			if m != nil {
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("warn: count %s: %v", b.Name, err)
+			continue
+		}
+		counts[b.Name] = n
+	}
+	return counts
+}
+
+// printFolders prints the profile's folders as an indented tree, or as JSON,
+// or with flat the folder names as other commands take them.
+func (a *App) printFolders(profileName string, counts, flat bool, format string) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return err
+	}
+	if flat && format == "text" {
+		if len(boxes) == 0 {
+			fmt.Printf("No mailboxes found under %s\n", profile.AbsolutePath)
+			return nil
+		}
+		fmt.Printf("Mailboxes for %s (%s):\n", profile.Name, profile.AbsolutePath)
+		for _, b := range boxes {
+			fmt.Printf("- %s [%s]\n", b.Name, byteSize(b.Size))
+		}
+		return nil
+	}
+	tree := folderTree(boxes)
+	if counts {
+		n := a.folderMessageCounts(profile, boxes)
+		var fill func(nodes []*folderNode)
+		fill = func(nodes []*folderNode) {
+			for _, node := range nodes {
+				if c, ok := n[node.Folder]; ok && node.Folder != "" {
+					node.Messages = &c
+				}
+				fill(node.Children)
+			}
+		}
+		fill(tree)
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if tree == nil {
+			tree = []*folderNode{}
+		}
+		return enc.Encode(tree)
+	}
+	if len(boxes) == 0 {
+		fmt.Printf("No mailboxes found under %s\n", profile.AbsolutePath)
+		return nil
+	}
+	var rows [][]string
+	var walk func(nodes []*folderNode, depth int)
+	walk = func(nodes []*folderNode, depth int) {
+		for _, node := range nodes {
+			size, msgs := node.Size, node.Messages
+			if depth == 0 {
+				// Account rows sum up everything below them.
+				size, msgs = node.treeTotals()
+			}
+			row := []string{strings.Repeat("  ", depth) + node.Name, byteSize(size)}
+			if node.Folder == "" && depth > 0 {
+				row[1] = "-"
+			}
+			if counts {
+				row = append(row, "-")
+				if msgs != nil {
+					row[2] = strconv.Itoa(*msgs)
+				}
+			}
+			rows = append(rows, row)
+			walk(node.Children, depth+1)
+		}
+	}
+	walk(tree, 0)
+	header := []string{"Folder", "Size"}
+	if counts {
+		header = append(header, "Messages")
+	}
+	fmt.Printf("Folders of %s (%s):\n\n", profile.Name, profile.AbsolutePath)
+	renderTable(os.Stdout, header, rows)
+	return nil
+}
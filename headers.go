@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// assumeCharset decodes header bytes that carry no usable charset: raw 8-bit
+// headers and encoded-words with unknown labels. Set with --assume-charset.
+var assumeCharset = "windows-1252"
+
+// setAssumeCharset validates and sets the fallback header charset.
+func setAssumeCharset(label string) error {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return nil
+	}
+	if _, name := charset.Lookup(label); name == "" {
+		return fmt.Errorf("unknown charset %q", label)
+	}
+	assumeCharset = label
+	return nil
+}
+
+var headerWordDecoder = &mime.WordDecoder{CharsetReader: headerCharsetReader}
+
+// headerCharsetReader resolves any WHATWG charset label (gb2312 maps to GBK,
+// latin1 to windows-1252, ...) and falls back to assumeCharset for unknown ones.
+func headerCharsetReader(label string, input io.Reader) (io.Reader, error) {
+	if r, err := charset.NewReaderLabel(label, input); err == nil {
+		return r, nil
+	}
+	return charset.NewReaderLabel(assumeCharset, input)
+}
+
+// decodeHeader decodes an RFC 2047 header value, tolerating raw 8-bit bytes,
+// unregistered charsets, and malformed encoded-words.
+func decodeHeader(v string) string {
+	if !utf8.ValidString(v) {
+		if conv, err := convertCharset([]byte(v), assumeCharset); err == nil {
+			v = string(conv)
+		}
+	}
+	if !strings.Contains(v, "=?") {
+		return v
+	}
+	if out, err := headerWordDecoder.DecodeHeader(v); err == nil && !strings.Contains(out, "=?") {
+		return out
+	}
+	return decodeWordsLenient(v)
+}
+
+var (
+	encodedWord        = regexp.MustCompile(`=\?([^?\s]+)\?([bBqQ])\?([^?]*)\?=`)
+	encodedWordSpacing = regexp.MustCompile(`(\?=)\s+(=\?)`)
+)
+
+// decodeWordsLenient decodes encoded-words one by one, accepting the mistakes
+// mailers make: missing base64 padding, spaces inside Q words, words embedded
+// in other text.
+func decodeWordsLenient(v string) string {
+	// Whitespace between adjacent encoded-words is not part of the text.
+	v = encodedWordSpacing.ReplaceAllString(v, "$1$2")
+	return encodedWord.ReplaceAllStringFunc(v, func(word string) string {
+		m := encodedWord.FindStringSubmatch(word)
+		label, enc, text := m[1], strings.ToUpper(m[2]), m[3]
+		// RFC 2231 language suffix: charset*lang.
+		label, _, _ = strings.Cut(label, "*")
+		var raw []byte
+		var err error
+		if enc == "B" {
+			raw, err = base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				raw, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(text, "="))
+			}
+		} else {
+			raw, err = io.ReadAll(quotedprintable.NewReader(strings.NewReader(strings.ReplaceAll(text, "_", " "))))
+		}
+		if err != nil {
+			return word
+		}
+		r, err := headerCharsetReader(label, bytes.NewReader(raw))
+		if err != nil {
+			return string(raw)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return string(raw)
+		}
+		return string(out)
+	})
+}
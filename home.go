@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// thunderbirdRoots lists where profiles.ini may live on this system,
+// Thunderbird's directory before Betterbird's.
+func thunderbirdRoots() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.Getenv("HOME")
+	}
+	switch runtime.GOOS {
+	case "windows":
+		appData := os.Getenv("APPDATA")
+		if appData == "" {
+			appData = filepath.Join(home, "AppData", "Roaming")
+		}
+		return []string{filepath.Join(appData, "Thunderbird"), filepath.Join(appData, "Betterbird")}
+	case "darwin":
+		// Betterbird on macOS shares Thunderbird's directory.
+		return []string{filepath.Join(home, "Library", "Thunderbird")}
+	default:
+		return []string{filepath.Join(home, ".thunderbird")}
+	}
+}
+
+// defaultThunderbirdRoot is the first of thunderbirdRoots holding a
+// profiles.ini, else the first, so errors name the usual place.
+func defaultThunderbirdRoot() string {
+	roots := thunderbirdRoots()
+	for _, root := range roots {
+		if _, err := os.Stat(filepath.Join(root, "profiles.ini")); err == nil {
+			return root
+		}
+	}
+	return roots[0]
+}
+
+// installedMailApps lists where a standard install puts the Thunderbird or
+// Betterbird executable on this system, for findMailCommand to try when
+// neither is on PATH. On Linux both are expected on PATH.
+func installedMailApps() []string {
+	switch runtime.GOOS {
+	case "windows":
+		var paths []string
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)", "LOCALAPPDATA"} {
+			dir := os.Getenv(env)
+			if dir == "" {
+				continue
+			}
+			if env == "LOCALAPPDATA" {
+				dir = filepath.Join(dir, "Programs") // per-user installs
+			}
+			paths = append(paths,
+				filepath.Join(dir, "Betterbird", "betterbird.exe"),
+				filepath.Join(dir, "Mozilla Thunderbird", "thunderbird.exe"))
+		}
+		return paths
+	case "darwin":
+		paths := []string{
+			"/Applications/Betterbird.app/Contents/MacOS/betterbird",
+			"/Applications/Thunderbird.app/Contents/MacOS/thunderbird",
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, p := range paths {
+				paths = append(paths, filepath.Join(home, p))
+			}
+		}
+		return paths
+	}
+	return nil
+}
+
+// installedMailApp returns the first of installedMailApps that exists, or
+// "" when there is none.
+func installedMailApp() string {
+	for _, p := range installedMailApps() {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
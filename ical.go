@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// calendarEvent is the part of a VEVENT worth showing in a terminal.
+type calendarEvent struct {
+	Method    string          `json:"method,omitempty"` // REQUEST, REPLY, CANCEL, ...
+	Summary   string          `json:"summary"`
+	Organizer string          `json:"organizer,omitempty"`
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end,omitempty"`
+	AllDay    bool            `json:"all_day,omitempty"`
+	Location  string          `json:"location,omitempty"`
+	Status    string          `json:"status,omitempty"`
+	Attendees []eventAttendee `json:"attendees,omitempty"`
+}
+
+type eventAttendee struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // PARTSTAT, lowercased: accepted, declined, tentative, needs-action
+}
+
+// icalProp is one unfolded content line: NAME;PARAM=V:VALUE.
+type icalProp struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// messageEvents returns the events of every text/calendar or .ics part of a raw message.
+func messageEvents(raw []byte) ([]calendarEvent, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
+	if err != nil {
+		return nil, err
+	}
+	var events []calendarEvent
+	seen := map[string]bool{}
+	walkMIME(msg.Header, body, "", func(p mimePart) {
+		mediaType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if mediaType != "text/calendar" && mediaType != "application/ics" && !strings.HasSuffix(strings.ToLower(partFilename(p)), ".ics") {
+			return
+		}
+		data := decodedPart(p)
+		if cs := params["charset"]; cs != "" && !strings.EqualFold(cs, "utf-8") {
+			if conv, err := convertCharset(data, cs); err == nil {
+				data = conv
+			}
+		}
+		// Invites often carry the same calendar inline and as an attachment.
+		for _, ev := range parseICS(string(data)) {
+			key := ev.Summary + "|" + ev.Start.String() + "|" + ev.Method
+			if !seen[key] {
+				seen[key] = true
+				events = append(events, ev)
+			}
+		}
+	})
+	return events, nil
+}
+
+// parseICS extracts VEVENTs from iCalendar (RFC 5545) text.
+func parseICS(text string) []calendarEvent {
+	var events []calendarEvent
+	var method string
+	var cur *calendarEvent
+	for _, p := range icalProps(text) {
+		switch {
+		case p.name == "METHOD" && cur == nil:
+			method = strings.ToUpper(p.value)
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VEVENT"):
+			cur = &calendarEvent{Method: method}
+		case p.name == "END" && strings.EqualFold(p.value, "VEVENT") && cur != nil:
+			events = append(events, *cur)
+			cur = nil
+		case cur == nil:
+		case p.name == "SUMMARY":
+			cur.Summary = icalText(p.value)
+		case p.name == "LOCATION":
+			cur.Location = icalText(p.value)
+		case p.name == "STATUS":
+			cur.Status = strings.ToLower(p.value)
+		case p.name == "ORGANIZER":
+			cur.Organizer = icalPerson(p)
+		case p.name == "ATTENDEE":
+			status := strings.ToLower(p.params["PARTSTAT"])
+			if status == "" {
+				status = "needs-action"
+			}
+			cur.Attendees = append(cur.Attendees, eventAttendee{Name: icalPerson(p), Status: status})
+		case p.name == "DTSTART":
+			cur.Start, cur.AllDay = icalTime(p)
+		case p.name == "DTEND":
+			cur.End, _ = icalTime(p)
+		}
+	}
+	return events
+}
+
+// icalProps unfolds continuation lines and splits each into name, params, and value.
+func icalProps(text string) []icalProp {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	var props []icalProp
+	for _, l := range lines {
+		head, value, ok := cutUnquoted(l, ':')
+		if !ok {
+			continue
+		}
+		fields := splitUnquoted(head, ';')
+		p := icalProp{name: strings.ToUpper(fields[0]), params: map[string]string{}, value: value}
+		for _, f := range fields[1:] {
+			k, v, _ := strings.Cut(f, "=")
+			p.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+		props = append(props, p)
+	}
+	return props
+}
+
+// cutUnquoted is strings.Cut that ignores sep inside double quotes (e.g. CN="Doe: Jane").
+func cutUnquoted(s string, sep byte) (string, string, bool) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+func splitUnquoted(s string, sep byte) []string {
+	var out []string
+	for {
+		before, after, ok := cutUnquoted(s, sep)
+		out = append(out, before)
+		if !ok {
+			return out
+		}
+		s = after
+	}
+}
+
+// icalText unescapes a TEXT value.
+func icalText(v string) string {
+	r := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return strings.TrimSpace(r.Replace(v))
+}
+
+// icalPerson renders an ORGANIZER/ATTENDEE as "CN <email>".
+func icalPerson(p icalProp) string {
+	email := p.value
+	if len(email) >= 7 && strings.EqualFold(email[:7], "mailto:") {
+		email = email[7:]
+	}
+	if cn := p.params["CN"]; cn != "" && !strings.EqualFold(cn, email) {
+		return cn + " <" + email + ">"
+	}
+	return email
+}
+
+// icalTime parses DATE and DATE-TIME values (UTC "Z", TZID, or floating).
+func icalTime(p icalProp) (time.Time, bool) {
+	v := strings.TrimSpace(p.value)
+	if p.params["VALUE"] == "DATE" || len(v) == 8 {
+		t, err := time.ParseInLocation("20060102", v, time.Local)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	if strings.HasSuffix(v, "Z") {
+		t, err := time.Parse("20060102T150405Z", v)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, false
+	}
+	loc := time.Local
+	if tzid := p.params["TZID"]; tzid != "" {
+		// Outlook uses Windows zone names that Go cannot load; treat those as local time.
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", v, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, false
+}
+
+// eventWhen formats the start/end of an event in local time.
+func eventWhen(ev calendarEvent) string {
+	if ev.Start.IsZero() {
+		return "(no start time)"
+	}
+	if ev.AllDay {
+		start := ev.Start.Format("Mon 2006-01-02")
+		// DTEND of an all-day event is exclusive.
+		if !ev.End.IsZero() && ev.End.Sub(ev.Start) > 24*time.Hour {
+			return start + " – " + ev.End.AddDate(0, 0, -1).Format("Mon 2006-01-02") + " (all day)"
+		}
+		return start + " (all day)"
+	}
+	start := ev.Start.Local()
+	out := start.Format("Mon 2006-01-02 15:04")
+	if !ev.End.IsZero() {
+		end := ev.End.Local()
+		if end.YearDay() == start.YearDay() && end.Year() == start.Year() {
+			out += " – " + end.Format("15:04")
+		} else {
+			out += " – " + end.Format("Mon 2006-01-02 15:04")
+		}
+	}
+	return out + " " + start.Format("MST")
+}
+
+var eventMethodLabels = map[string]string{
+	"REQUEST": "Invitation",
+	"REPLY":   "Reply",
+	"CANCEL":  "Cancelled",
+	"PUBLISH": "Event",
+	"COUNTER": "Counter-proposal",
+}
+
+func printEvents(events []calendarEvent) {
+	for _, ev := range events {
+		label := eventMethodLabels[ev.Method]
+		if label == "" {
+			label = "Event"
+		}
+		if ev.Status == "cancelled" {
+			label = "Cancelled"
+		}
+		fmt.Println()
+		fmt.Printf("%s: %s\n", label, ev.Summary)
+		fmt.Printf("  When:      %s\n", eventWhen(ev))
+		if ev.Location != "" {
+			fmt.Printf("  Where:     %s\n", strings.ReplaceAll(ev.Location, "\n", ", "))
+		}
+		if ev.Organizer != "" {
+			fmt.Printf("  Organizer: %s\n", ev.Organizer)
+		}
+		for i, a := range ev.Attendees {
+			prefix := "  Attendees:"
+			if i > 0 {
+				prefix = "            "
+			}
+			fmt.Printf("%s %s (%s)\n", prefix, a.Name, strings.ReplaceAll(a.Status, "-", " "))
+		}
+	}
+}
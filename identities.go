@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mailIdentity is one mail.identity.* of prefs.js: an address the user sends
+// as, under the account that lists it first.
+type mailIdentity struct {
+	Key          string `json:"key"` // id1, ...
+	Account      string `json:"account"`
+	Email        string `json:"email"`
+	FullName     string `json:"full_name,omitempty"`
+	ReplyTo      string `json:"reply_to,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	SigFile      string `json:"signature_file,omitempty"` // a signature kept in a file
+	SigText      string `json:"signature_text,omitempty"` // a signature typed into the settings
+	SigFromFile  bool   `json:"signature_from_file"`      // SigFile is used rather than SigText
+	Default      bool   `json:"default,omitempty"`
+}
+
+// loadIdentities reads the profile's identities in account order. The
+// default is the first identity of mail.accountmanager.defaultaccount, else
+// the first one, which is what Thunderbird composes new mail as.
+func (a *App) loadIdentities(p Profile) ([]mailIdentity, error) {
+	prefs, err := profilePrefs(p)
+	if err != nil {
+		return nil, err
+	}
+	defaultAccount := prefs.String("mail.accountmanager.defaultaccount")
+	var ids []mailIdentity
+	seen := map[string]bool{}
+	def := -1
+	for _, acct := range accountsFromPrefs(p, prefs) {
+		for i, key := range acct.Identities {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			pref := func(name string) string { return prefs.String(fmt.Sprintf("mail.identity.%s.%s", key, name)) }
+			id := mailIdentity{
+				Key:          key,
+				Account:      acct.Key,
+				Email:        strings.ToLower(pref("useremail")),
+				FullName:     pref("fullName"),
+				ReplyTo:      pref("reply_to"),
+				Organization: pref("organization"),
+				SigFile:      pref("sig_file"),
+				SigText:      pref("htmlSigText"),
+			}
+			id.SigFromFile, _ = prefs.Bool(fmt.Sprintf("mail.identity.%s.attach_signature", key))
+			if rel := pref("sig_file-rel"); id.SigFile == "" && strings.HasPrefix(rel, "[ProfD]") {
+				id.SigFile = filepath.Join(p.AbsolutePath, filepath.FromSlash(strings.TrimPrefix(rel, "[ProfD]")))
+			}
+			if i == 0 && acct.Key == defaultAccount && def < 0 {
+				def = len(ids)
+			}
+			ids = append(ids, id)
+		}
+	}
+	if def < 0 && len(ids) > 0 {
+		def = 0
+	}
+	if def >= 0 {
+		ids[def].Default = true
+	}
+	return ids, nil
+}
+
+// myAddresses is the set of lowercased addresses the profile sends as, for
+// telling the user's own mail from what they received. It is empty when
+// prefs.js cannot be read.
+func (a *App) myAddresses(p Profile) map[string]bool {
+	me := map[string]bool{}
+	ids, err := a.loadIdentities(p)
+	if err != nil {
+		return me
+	}
+	for _, id := range ids {
+		if id.Email != "" {
+			me[id.Email] = true
+		}
+	}
+	return me
+}
+
+// sentByMe reports whether the From of m is one of me.
+func sentByMe(m MailSummary, me map[string]bool) bool {
+	if len(me) == 0 {
+		return false
+	}
+	if from, err := mail.ParseAddress(m.From); err == nil {
+		return me[strings.ToLower(from.Address)]
+	}
+	return me[strings.ToLower(strings.Trim(senderAddress(m.From), "<>"))]
+}
+
+// printIdentities lists the profile's identities, only those of account (an
+// account key or one of its emails) when set, as a table or one JSON object
+// per identity.
+func (a *App) printIdentities(profileName, account, format string) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	ids, err := a.loadIdentities(profile)
+	if err != nil {
+		return err
+	}
+	if account != "" {
+		accounts, err := a.loadAccounts(profile)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for _, acct := range accounts {
+			if strings.EqualFold(acct.Key, account) || containsFold(acct.Emails, account) {
+				keys = append(keys, acct.Key)
+			}
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("account %s not found in prefs.js", account)
+		}
+		var kept []mailIdentity
+		for _, id := range ids {
+			if containsFold(keys, id.Account) {
+				kept = append(kept, id)
+			}
+		}
+		ids = kept
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		for _, id := range ids {
+			if err := enc.Encode(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(ids) == 0 {
+		fmt.Println("No identities.")
+		return nil
+	}
+	rows := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		sig := "-"
+		switch {
+		case id.SigFromFile && id.SigFile != "":
+			sig = "file " + id.SigFile
+		case id.SigText != "":
+			sig = fmt.Sprintf("text (%d chars)", len([]rune(id.SigText)))
+		}
+		def := ""
+		if id.Default {
+			def = "yes"
+		}
+		rows = append(rows, []string{id.Key, id.Account, firstNonEmpty(id.Email, "-"), firstNonEmpty(truncate(id.FullName, 30), "-"),
+			firstNonEmpty(id.ReplyTo, "-"), truncate(sig, 40), def})
+	}
+	renderTable(os.Stdout, []string{"Identity", "Account", "Email", "Name", "Reply-To", "Signature", "Default"}, rows)
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// imapClient speaks the little of IMAP4rev1 (RFC 3501) tb mail fetch --imap
+// needs: LOGIN, EXAMINE, UID SEARCH, and UID FETCH, one command at a time.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	caps map[string]bool
+}
+
+// imapLiteral is a command argument sent as a literal, for strings a quoted
+// string cannot carry (8-bit bytes, CR, LF).
+type imapLiteral string
+
+// imapTimeout bounds each read and write; a message body arrives as one
+// literal, so it covers the slowest single response rather than the run.
+const imapTimeout = 2 * time.Minute
+
+// dialIMAP connects to host and leaves the connection encrypted: security
+// is Thunderbird's socketType (3 SSL/TLS, 2 STARTTLS, 0 or 1 plain with
+// STARTTLS when offered). A server that offers no TLS at all is refused, as
+// the password would travel in the clear.
+func dialIMAP(host string, port int64, socketType int64) (*imapClient, error) {
+	if port == 0 {
+		port = 143
+		if socketType == 3 {
+			port = 993
+		}
+	}
+	addr := net.JoinHostPort(host, strconv.FormatInt(port, 10))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if socketType == 3 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReaderSize(conn, 64*1024)}
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !bytes.HasPrefix(greeting, []byte("* OK")) && !bytes.HasPrefix(greeting, []byte("* PREAUTH")) {
+		conn.Close()
+		return nil, fmt.Errorf("%s: unexpected greeting %q", addr, greeting)
+	}
+	if err := c.capabilities(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if socketType != 3 {
+		if !c.caps["STARTTLS"] {
+			conn.Close()
+			return nil, fmt.Errorf("%s offers no TLS; not sending the password in the clear", addr)
+		}
+		if _, err := c.command("STARTTLS"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.conn, c.r = tc, bufio.NewReaderSize(tc, 64*1024)
+		// Capabilities from before STARTTLS must be discarded (RFC 3501 6.2.1).
+		if err := c.capabilities(); err != nil {
+			tc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *imapClient) capabilities() error {
+	untagged, err := c.command("CAPABILITY")
+	if err != nil {
+		return err
+	}
+	c.caps = map[string]bool{}
+	for _, line := range untagged {
+		if fields := strings.Fields(string(line)); len(fields) > 0 && strings.EqualFold(fields[0], "CAPABILITY") {
+			for _, f := range fields[1:] {
+				c.caps[strings.ToUpper(f)] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (c *imapClient) login(user, password string) error {
+	if c.caps["LOGINDISABLED"] {
+		return errors.New("the server does not accept LOGIN")
+	}
+	_, err := c.command("LOGIN", imapString(user), imapString(password))
+	return err
+}
+
+func (c *imapClient) logout() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
+
+// readResponse reads one response line with its literals kept inline as
+// they came ("{n}" CRLF and n bytes), without the final CRLF.
+func (c *imapClient) readResponse() ([]byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(imapTimeout))
+	var buf []byte
+	for {
+		line, err := c.r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, line...)
+		n, ok := literalLength(line)
+		if !ok {
+			return bytes.TrimRight(buf, "\r\n"), nil
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, n)...)
+		if _, err := io.ReadFull(c.r, buf[start:]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// literalLength reports the size of the literal announced at the end of
+// line, if any.
+func literalLength(line []byte) (int, bool) {
+	line = bytes.TrimRight(line, "\r\n")
+	if !bytes.HasSuffix(line, []byte("}")) {
+		return 0, false
+	}
+	open := bytes.LastIndexByte(line, '{')
+	if open < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(string(line[open+1:len(line)-1]), "+"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// command sends one command and returns the untagged responses (without
+// "* ") that came before its completion, failing on NO or BAD. Arguments
+// are strings sent as written, or imapLiterals.
+func (c *imapClient) command(args ...any) ([][]byte, error) {
+	c.tag++
+	tag := fmt.Sprintf("t%d", c.tag)
+	name := fmt.Sprint(args[0])
+	var untagged [][]byte
+	var b bytes.Buffer
+	b.WriteString(tag)
+	for _, arg := range args {
+		b.WriteByte(' ')
+		lit, ok := arg.(imapLiteral)
+		if !ok {
+			fmt.Fprint(&b, arg)
+			continue
+		}
+		fmt.Fprintf(&b, "{%d}\r\n", len(lit))
+		if err := c.write(b.Bytes()); err != nil {
+			return nil, err
+		}
+		b.Reset()
+		for {
+			line, err := c.readResponse()
+			if err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(line, []byte("+")) {
+				break
+			}
+			if rest, ok := bytes.CutPrefix(line, []byte(tag+" ")); ok {
+				return nil, fmt.Errorf("%s: %s", name, rest)
+			}
+			untagged = append(untagged, bytes.TrimPrefix(line, []byte("* ")))
+		}
+		b.WriteString(string(lit))
+	}
+	b.WriteString("\r\n")
+	if err := c.write(b.Bytes()); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if rest, ok := bytes.CutPrefix(line, []byte(tag+" ")); ok {
+			if !bytes.HasPrefix(bytes.ToUpper(rest), []byte("OK")) {
+				return nil, fmt.Errorf("%s: %s", name, rest)
+			}
+			return untagged, nil
+		}
+		if rest, ok := bytes.CutPrefix(line, []byte("* ")); ok {
+			untagged = append(untagged, rest)
+		}
+	}
+}
+
+func (c *imapClient) write(b []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(imapTimeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// imapString quotes s, or makes it a literal when a quoted string cannot
+// hold it.
+func imapString(s string) any {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\r' || s[i] == '\n' || s[i] == 0 || s[i] >= 0x80 {
+			return imapLiteral(s)
+		}
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// imapMailboxName encodes name in IMAP's modified UTF-7 (RFC 3501 5.1.3).
+func imapMailboxName(name string) string {
+	var b strings.Builder
+	var pending []rune
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		var raw []byte
+		for _, u := range utf16.Encode(pending) {
+			raw = append(raw, byte(u>>8), byte(u))
+		}
+		b.WriteByte('&')
+		b.WriteString(strings.ReplaceAll(base64.RawStdEncoding.EncodeToString(raw), "/", ","))
+		b.WriteByte('-')
+		pending = pending[:0]
+	}
+	for _, r := range name {
+		switch {
+		case r == '&':
+			flush()
+			b.WriteString("&-")
+		case r >= 0x20 && r <= 0x7e:
+			flush()
+			b.WriteRune(r)
+		default:
+			pending = append(pending, r)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// imapMailbox is what EXAMINE reports about a folder.
+type imapMailbox struct {
+	Exists      int
+	UIDValidity uint32
+	UIDNext     uint32
+}
+
+var imapRespCode = regexp.MustCompile(`(?i)\[(UIDVALIDITY|UIDNEXT) (\d+)\]`)
+
+// examine opens folder read-only, so fetching leaves \Seen flags alone.
+func (c *imapClient) examine(folder string) (imapMailbox, error) {
+	untagged, err := c.command("EXAMINE", imapString(imapMailboxName(folder)))
+	if err != nil {
+		return imapMailbox{}, err
+	}
+	var box imapMailbox
+	for _, line := range untagged {
+		fields := strings.Fields(string(line))
+		if len(fields) == 2 && strings.EqualFold(fields[1], "EXISTS") {
+			box.Exists, _ = strconv.Atoi(fields[0])
+		}
+		if m := imapRespCode.FindStringSubmatch(string(line)); m != nil {
+			n, _ := strconv.ParseUint(m[2], 10, 32)
+			if strings.EqualFold(m[1], "UIDVALIDITY") {
+				box.UIDValidity = uint32(n)
+			} else {
+				box.UIDNext = uint32(n)
+			}
+		}
+	}
+	return box, nil
+}
+
+// searchUIDs runs UID SEARCH with criteria and returns the UIDs in
+// ascending order.
+func (c *imapClient) searchUIDs(criteria string) ([]uint32, error) {
+	untagged, err := c.command("UID SEARCH", criteria)
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, line := range untagged {
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "SEARCH") {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if n, err := strconv.ParseUint(f, 10, 32); err == nil {
+				uids = append(uids, uint32(n))
+			}
+		}
+	}
+	slices.Sort(uids)
+	return uids, nil
+}
+
+// imapMessage is one FETCH response.
+type imapMessage struct {
+	UID          uint32
+	Flags        []string
+	InternalDate time.Time
+	Size         int64
+	Body         []byte // BODY[] or BODY[HEADER], whichever was fetched
+}
+
+// fetch runs a FETCH (UID FETCH when uid is set) of items for set and
+// returns the messages in the order they came.
+func (c *imapClient) fetch(uid bool, set, items string) ([]imapMessage, error) {
+	name := "FETCH"
+	if uid {
+		name = "UID FETCH"
+	}
+	untagged, err := c.command(name, set, items)
+	if err != nil {
+		return nil, err
+	}
+	var out []imapMessage
+	for _, line := range untagged {
+		values, err := parseIMAP(line)
+		if err != nil || len(values) < 3 {
+			continue
+		}
+		if kw, _ := values[1].(string); !strings.EqualFold(kw, "FETCH") {
+			continue
+		}
+		attrs, _ := values[2].([]any)
+		var m imapMessage
+		for i := 0; i+1 < len(attrs); i += 2 {
+			key, _ := attrs[i].(string)
+			switch key = strings.ToUpper(key); {
+			case key == "UID":
+				n, _ := strconv.ParseUint(fmt.Sprint(attrs[i+1]), 10, 32)
+				m.UID = uint32(n)
+			case key == "FLAGS":
+				list, _ := attrs[i+1].([]any)
+				for _, f := range list {
+					if s, ok := f.(string); ok {
+						m.Flags = append(m.Flags, s)
+					}
+				}
+			case key == "INTERNALDATE":
+				s, _ := attrs[i+1].(string)
+				m.InternalDate, _ = time.Parse("_2-Jan-2006 15:04:05 -0700", s)
+			case key == "RFC822.SIZE":
+				m.Size, _ = strconv.ParseInt(fmt.Sprint(attrs[i+1]), 10, 64)
+			case strings.HasPrefix(key, "BODY["):
+				s, _ := attrs[i+1].(string)
+				m.Body = []byte(s)
+			}
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// uidSet writes uids (ascending) as an IMAP sequence set with ranges.
+func uidSet(uids []uint32) string {
+	var b strings.Builder
+	for i := 0; i < len(uids); {
+		j := i
+		for j+1 < len(uids) && uids[j+1] == uids[j]+1 {
+			j++
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		if j > i {
+			fmt.Fprintf(&b, "%d:%d", uids[i], uids[j])
+		} else {
+			fmt.Fprintf(&b, "%d", uids[i])
+		}
+		i = j + 1
+	}
+	return b.String()
+}
+
+// parseIMAP splits response data into atoms and strings (string), NIL
+// (nil), and parenthesized lists ([]any). Brackets keep section specs such
+// as BODY[HEADER.FIELDS (DATE)] in one atom.
+func parseIMAP(b []byte) ([]any, error) {
+	p := &imapParser{b: b}
+	return p.list(0)
+}
+
+type imapParser struct {
+	b []byte
+	i int
+}
+
+func (p *imapParser) list(end byte) ([]any, error) {
+	out := []any{}
+	for {
+		for p.i < len(p.b) && p.b[p.i] == ' ' {
+			p.i++
+		}
+		if p.i >= len(p.b) {
+			if end != 0 {
+				return nil, errors.New("imap: unterminated list")
+			}
+			return out, nil
+		}
+		switch c := p.b[p.i]; {
+		case c == end:
+			p.i++
+			return out, nil
+		case c == '(':
+			p.i++
+			l, err := p.list(')')
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, l)
+		case c == '"':
+			s, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		case c == '{':
+			s, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		case c == ')':
+			p.i++ // stray close; skip it
+		default:
+			if atom := p.atom(); strings.EqualFold(atom, "NIL") {
+				out = append(out, nil)
+			} else {
+				out = append(out, atom)
+			}
+		}
+	}
+}
+
+func (p *imapParser) quoted() (string, error) {
+	var b strings.Builder
+	for p.i++; p.i < len(p.b); p.i++ {
+		switch c := p.b[p.i]; c {
+		case '\\':
+			p.i++
+			if p.i < len(p.b) {
+				b.WriteByte(p.b[p.i])
+			}
+		case '"':
+			p.i++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("imap: unterminated quoted string")
+}
+
+func (p *imapParser) literal() (string, error) {
+	end := bytes.IndexByte(p.b[p.i:], '}')
+	if end < 0 {
+		return "", errors.New("imap: bad literal")
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(string(p.b[p.i+1:p.i+end]), "+"))
+	if err != nil {
+		return "", fmt.Errorf("imap: bad literal: %w", err)
+	}
+	start := p.i + end + 1
+	if bytes.HasPrefix(p.b[start:], []byte("\r\n")) {
+		start += 2
+	}
+	if start+n > len(p.b) {
+		return "", errors.New("imap: short literal")
+	}
+	p.i = start + n
+	return string(p.b[start:p.i]), nil
+}
+
+func (p *imapParser) atom() string {
+	start, depth := p.i, 0
+	for ; p.i < len(p.b); p.i++ {
+		switch p.b[p.i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ' ', '(', ')':
+			if depth <= 0 {
+				return string(p.b[start:p.i])
+			}
+		}
+	}
+	return string(p.b[start:])
+}
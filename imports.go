@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Imported mbox files (tb mail import) are listed with each profile's own
+// folders as Imported/<label>, so indexing, search, show, and sync treat
+// them like Thunderbird mail. The list lives next to the tb config file
+// rather than in the index cache, so clearing the cache does not lose it.
+
+// importedFolderPrefix starts the folder name of every imported mbox.
+const importedFolderPrefix = "Imported/"
+
+// importedMbox is one mbox file tb mail import added to a profile.
+type importedMbox struct {
+	Path  string    `json:"path"`
+	Label string    `json:"label"`
+	Added time.Time `json:"added"`
+}
+
+// loadImports reads the import list, keyed by profile directory.
+func loadImports() (map[string][]importedMbox, error) {
+	all := map[string][]importedMbox{}
+	path := configFile("imports.json")
+	if path == "" {
+		return all, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return all, nil
+}
+
+func saveImports(all map[string][]importedMbox) error {
+	path := configFile("imports.json")
+	if path == "" {
+		return fmt.Errorf("no config directory for the import list")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// importedMailboxes lists profile's imported mbox files as mailboxes. A file
+// that has gone missing is left out, like a deleted folder.
+func importedMailboxes(profile Profile) []Mailbox {
+	all, err := loadImports()
+	if err != nil {
+		return nil
+	}
+	var boxes []Mailbox
+	for _, im := range all[profile.AbsolutePath] {
+		fi, err := os.Stat(im.Path)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		boxes = append(boxes, Mailbox{Name: importedFolderPrefix + im.Label, Path: im.Path, Size: fi.Size()})
+	}
+	return boxes
+}
+
+// looksLikeMbox checks that path starts with an mbox "From " line.
+func looksLikeMbox(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory, not an mbox file", path)
+	}
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("%s is empty", path)
+	}
+	if !strings.HasPrefix(line, "From ") {
+		return fmt.Errorf("%s does not start with a \"From \" line; is it an mbox file?", path)
+	}
+	return nil
+}
+
+// addImport records path under label for profile and returns its mailbox.
+// Importing the same file again keeps it under its new label.
+func addImport(profile Profile, path, label string) (Mailbox, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Mailbox{}, err
+	}
+	if err := looksLikeMbox(abs); err != nil {
+		return Mailbox{}, err
+	}
+	if rel, err := filepath.Rel(profile.AbsolutePath, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return Mailbox{}, fmt.Errorf("%s is inside the profile; it is indexed as one of its folders already", path)
+	}
+	label = strings.Trim(strings.TrimSpace(label), "/")
+	if label == "" {
+		label = strings.TrimSuffix(filepath.Base(abs), filepath.Ext(abs))
+	}
+	all, err := loadImports()
+	if err != nil {
+		return Mailbox{}, err
+	}
+	list := all[profile.AbsolutePath]
+	var kept []importedMbox
+	for _, im := range list {
+		switch {
+		case im.Path == abs:
+			continue
+		case strings.EqualFold(im.Label, label):
+			return Mailbox{}, fmt.Errorf("label %q is already used by %s", label, im.Path)
+		}
+		kept = append(kept, im)
+	}
+	kept = append(kept, importedMbox{Path: abs, Label: label, Added: time.Now().UTC()})
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Label < kept[j].Label })
+	all[profile.AbsolutePath] = kept
+	if err := saveImports(all); err != nil {
+		return Mailbox{}, err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return Mailbox{}, err
+	}
+	return Mailbox{Name: importedFolderPrefix + label, Path: abs, Size: fi.Size()}, nil
+}
+
+// removeImport drops label from profile's import list. The local index
+// forgets the folder on its next full run; stored copies stay until a
+// sync --prune.
+func removeImport(profile Profile, label string) error {
+	all, err := loadImports()
+	if err != nil {
+		return err
+	}
+	list := all[profile.AbsolutePath]
+	for i, im := range list {
+		if strings.EqualFold(im.Label, label) {
+			all[profile.AbsolutePath] = append(list[:i], list[i+1:]...)
+			if len(all[profile.AbsolutePath]) == 0 {
+				delete(all, profile.AbsolutePath)
+			}
+			return saveImports(all)
+		}
+	}
+	return fmt.Errorf("no import labelled %q in profile %s", label, profile.Name)
+}
+
+// importMbox adds an mbox file to profile and brings it into the local
+// index and, when store is set, into that store as well.
+func (a *App) importMbox(profileName, path, label string, opts syncOptions) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	box, err := addImport(profile, path, label)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Importing %s as %s (%s)\n", box.Path, box.Name, byteSize(box.Size))
+	opts.index.folderLike = box.Name
+	if opts.store == "" {
+		return a.buildIndex(profile.AbsolutePath, opts.index)
+	}
+	return a.syncStore(profile.AbsolutePath, opts)
+}
+
+// listImports prints profile's imported mbox files.
+func (a *App) listImports(profileName string) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	all, err := loadImports()
+	if err != nil {
+		return err
+	}
+	list := all[profile.AbsolutePath]
+	if len(list) == 0 {
+		fmt.Println("No imported mbox files.")
+		return nil
+	}
+	rows := make([][]string, 0, len(list))
+	for _, im := range list {
+		size := "missing"
+		if fi, err := os.Stat(im.Path); err == nil {
+			size = byteSize(fi.Size())
+		}
+		rows = append(rows, []string{importedFolderPrefix + im.Label, im.Path, size, im.Added.In(time.Local).Format("2006-01-02")})
+	}
+	renderTable(os.Stdout, []string{"Folder", "File", "Size", "Added"}, rows)
+	return nil
+}
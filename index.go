@@ -1,48 +1,289 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type IndexFile struct {
 	Folders map[string]FolderIndex `json:"folders"`
 	SavedAt time.Time              `json:"saved_at"`
+
+	// How the file was stored when loaded; saves keep it unless setStorage
+	// passes on the --compress/--encoding flags.
+	compression string
+	encoding    string
+	legacy      bool // loaded from the monolithic index.json
 }
 
 type FolderIndex struct {
-	ModTime  int64         `json:"mod_time"`
-	Size     int64         `json:"size"`
-	Messages []MailSummary `json:"messages"`
-	SavedAt  time.Time     `json:"saved_at"`
-	Complete bool          `json:"complete"` // true when the index was built by scanning the full folder (match-all)
+	ModTime     int64         `json:"mod_time"`
+	Size        int64         `json:"size"`
+	Messages    []MailSummary `json:"messages"`
+	SavedAt     time.Time     `json:"saved_at"`
+	Complete    bool          `json:"complete"` // true when the index was built by scanning the full folder (match-all)
+	Bloom       folderBloom   `json:"bloom,omitempty"`
+	Fingerprint string        `json:"fingerprint,omitempty"` // contentFingerprint of the file when indexed
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// A gob-encoded index starts with gobIndexMagic and a version byte, so a
+// format change is detected instead of misdecoded.
+var gobIndexMagic = []byte("TBIDX")
+
+const gobIndexVersion = 1
+
+// parseIndexCompression checks a --compress value: "gzip", "zstd", or
+// "none". Empty keeps the format of the existing file (gzip for new ones).
+func parseIndexCompression(name string) (string, error) {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "", "gzip", "zstd", "none":
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown --compress %q (use gzip, zstd, or none)", name)
+}
+
+// parseIndexEncoding checks an --encoding value: "json" or "gob"; empty
+// keeps the existing file's (json when new).
+func parseIndexEncoding(name string) (string, error) {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "", "json", "gob":
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown --encoding %q (use json or gob)", name)
+}
+
+// indexFormat names the encoding of an index file from its first bytes.
+func indexFormat(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(b, zstdMagic):
+		return "zstd"
+	}
+	return "none"
 }
 
-func loadIndex(path string) (*IndexFile, error) {
+// readIndexData decodes an index file of any supported compression and
+// encoding into v and reports which ones it used.
+func readIndexData(path string, v any) (compression, encoding string, err error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
-	var idx IndexFile
-	if err := json.Unmarshal(b, &idx); err != nil {
-		return nil, err
+	compression = indexFormat(b)
+	switch compression {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return "", "", err
+		}
+		if b, err = io.ReadAll(zr); err != nil {
+			return "", "", err
+		}
+	case "zstd":
+		zr, err := zstd.NewReader(nil)
+		if err != nil {
+			return "", "", err
+		}
+		defer zr.Close()
+		if b, err = zr.DecodeAll(b, nil); err != nil {
+			return "", "", fmt.Errorf("%s: %w", path, err)
+		}
 	}
-	if idx.Folders == nil {
-		idx.Folders = map[string]FolderIndex{}
+	if bytes.HasPrefix(b, gobIndexMagic) {
+		b = b[len(gobIndexMagic):]
+		if len(b) == 0 || b[0] != gobIndexVersion {
+			return "", "", fmt.Errorf("%s: unsupported binary index version; rebuild with tb mail index --full", path)
+		}
+		return compression, "gob", gob.NewDecoder(bytes.NewReader(b[1:])).Decode(v)
 	}
-	return &idx, nil
+	return compression, "json", json.Unmarshal(b, v)
 }
 
-func saveIndex(path string, idx *IndexFile) error {
-	idx.SavedAt = time.Now().UTC()
-	b, err := json.MarshalIndent(idx, "", "  ")
+// writeIndexData encodes v to path through a tmp file and rename.
+func writeIndexData(path string, v any, compression, encoding string) error {
+	var b []byte
+	var err error
+	switch encoding {
+	case "gob":
+		var buf bytes.Buffer
+		buf.Write(gobIndexMagic)
+		buf.WriteByte(gobIndexVersion)
+		err = gob.NewEncoder(&buf).Encode(v)
+		b = buf.Bytes()
+	default:
+		if compression == "none" {
+			b, err = json.MarshalIndent(v, "", "  ")
+		} else {
+			b, err = json.Marshal(v)
+		}
+	}
 	if err != nil {
 		return err
 	}
+	switch compression {
+	case "gzip":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(b); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		b = buf.Bytes()
+	case "zstd":
+		zw, err := zstd.NewWriter(nil)
+		if err != nil {
+			return err
+		}
+		b = zw.EncodeAll(b, nil)
+		zw.Close()
+	}
 	tmp := path + ".tmp"
 	if err := os.WriteFile(tmp, b, 0o644); err != nil {
 		return err
 	}
 	return os.Rename(tmp, path)
 }
+
+// The JSON index (--store json) is one shard file per folder under shards/,
+// so updating a folder rewrites only its shard. A monolithic index.json from
+// older versions is still read and is split into shards on the next index
+// run.
+
+type indexShard struct {
+	Path   string      `json:"path"`
+	Folder FolderIndex `json:"folder"`
+}
+
+func indexShardDir(profile Profile) string {
+	return indexFile(profile, "shards")
+}
+
+var shardNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// indexShardPath names a folder's shard after the folder (for people looking
+// at the directory) plus a hash of its path (for uniqueness).
+func indexShardPath(profile Profile, folderPath string) string {
+	h := fnv.New32a()
+	h.Write([]byte(folderPath))
+	base := shardNameUnsafe.ReplaceAllString(filepath.Base(folderPath), "_")
+	return filepath.Join(indexShardDir(profile), fmt.Sprintf("%s-%08x.idx", base, h.Sum32()))
+}
+
+// hasFallbackIndex reports whether the profile has shards or a legacy index file.
+func hasFallbackIndex(profile Profile) bool {
+	if shards, _ := filepath.Glob(filepath.Join(indexShardDir(profile), "*.idx")); len(shards) > 0 {
+		return true
+	}
+	_, err := os.Stat(indexPath(profile))
+	return err == nil
+}
+
+// loadIndex reads the whole JSON index: the legacy file, if any, overlaid
+// with every shard.
+func loadIndex(profile Profile) (*IndexFile, error) {
+	idx := &IndexFile{Folders: map[string]FolderIndex{}}
+	found := false
+	if _, err := os.Stat(indexPath(profile)); err == nil {
+		compression, encoding, err := readIndexData(indexPath(profile), idx)
+		if err != nil {
+			return nil, err
+		}
+		if idx.Folders == nil {
+			idx.Folders = map[string]FolderIndex{}
+		}
+		idx.compression, idx.encoding, idx.legacy = compression, encoding, true
+		found = true
+	}
+	shards, _ := filepath.Glob(filepath.Join(indexShardDir(profile), "*.idx"))
+	for _, path := range shards {
+		var shard indexShard
+		compression, encoding, err := readIndexData(path, &shard)
+		if err != nil {
+			return nil, err
+		}
+		idx.Folders[shard.Path] = shard.Folder
+		idx.compression, idx.encoding = compression, encoding
+		found = true
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	return idx, nil
+}
+
+// setStorage makes later saves use compression and encoding; empty values
+// keep what the index was loaded with.
+func (idx *IndexFile) setStorage(compression, encoding string) {
+	idx.compression = firstNonEmpty(compression, idx.compression)
+	idx.encoding = firstNonEmpty(encoding, idx.encoding)
+}
+
+func (idx *IndexFile) storage() (compression, encoding string) {
+	return firstNonEmpty(idx.compression, "gzip"), firstNonEmpty(idx.encoding, "json")
+}
+
+// saveIndexShard writes the shard of one folder of idx.
+func saveIndexShard(profile Profile, idx *IndexFile, folderPath string) error {
+	if err := os.MkdirAll(indexShardDir(profile), 0o755); err != nil {
+		return err
+	}
+	compression, encoding := idx.storage()
+	shard := indexShard{Path: folderPath, Folder: idx.Folders[folderPath]}
+	return writeIndexData(indexShardPath(profile, folderPath), &shard, compression, encoding)
+}
+
+// removeIndexShard forgets one folder.
+func removeIndexShard(profile Profile, folderPath string) error {
+	err := os.Remove(indexShardPath(profile, folderPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// migrateLegacyIndex splits a monolithic index.json into shards and
+// removes it.
+func migrateLegacyIndex(profile Profile, idx *IndexFile) error {
+	if !idx.legacy {
+		return nil
+	}
+	for path := range idx.Folders {
+		if _, err := os.Stat(indexShardPath(profile, path)); err == nil {
+			continue // a shard is newer than the legacy copy
+		}
+		if err := saveIndexShard(profile, idx, path); err != nil {
+			return err
+		}
+	}
+	idx.legacy = false
+	return os.Remove(indexPath(profile))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -2,46 +2,121 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
-func (a *App) buildIndex(profileName, folderLike, accountEmail string, tailCount int) error {
+type indexOptions struct {
+	folderLike string
+	accounts   []string
+	tailCount  int
+	excludes   []string
+	full       bool      // rescan folders even when their content is unchanged
+	since      time.Time // leave older messages out; zero means no cutoff
+	jobs       int       // folders scanned concurrently
+	store      string    // "sqlite" or "json"; empty keeps the kind the profile has, and "bleve" also builds the Bleve index
+
+	compression, encoding string // JSON shard storage; empty keeps the existing files'
+
+	includeTrash, includeSpam bool // index trash and spam folders too
+	dryRun                    bool // only report what would be scanned
+
+	quietUnchanged bool // do not list skipped folders (watch mode)
+}
+
+func (a *App) buildIndex(profileName string, opts indexOptions) error {
 	profile, err := a.resolveProfile(profileName)
 	if err != nil {
 		return err
 	}
-	accountEmail = strings.ToLower(strings.TrimSpace(accountEmail))
-
-	boxes, err := a.listMailboxes(profile)
+	filtered, err := a.indexTargets(profile, opts)
 	if err != nil {
 		return err
 	}
-	if accountEmail != "" {
-		idx, err := a.loadAccountDirIndex(profile)
-		if err != nil {
-			return err
+	unlock, err := lockIndex(profile, !opts.dryRun)
+	if err != nil {
+		return fmt.Errorf("lock index: %w", err)
+	}
+	defer unlock()
+	markLive(profile, filtered)
+	if opts.dryRun {
+		return a.planIndex(profile, filtered, opts)
+	}
+	match := withExcludes(func(string) bool { return true }, opts.excludes)
+	if localIndexKind(profile, opts.store) == "json" {
+		err = a.buildJSONIndex(profile, filtered, match, opts)
+	} else {
+		err = a.buildSQLiteIndex(profile, filtered, match, opts)
+	}
+	if err != nil {
+		return err
+	}
+	// Once created, the Bleve index is kept in step with every run.
+	if opts.store == "bleve" || hasBleveIndex(profile) {
+		if err := updateBleveIndex(profile); err != nil {
+			return fmt.Errorf("bleve index: %w", err)
 		}
-		dirs := idx[accountEmail]
-		if len(dirs) == 0 {
-			return fmt.Errorf("account %s not found in prefs.js", accountEmail)
+	}
+	return nil
+}
+
+// localIndexKind resolves an index --store value to the local index it
+// writes: empty (or bleve, which is built on top) means the kind the profile
+// already has, SQLite unless only JSON shards exist.
+func localIndexKind(profile Profile, store string) string {
+	if store == "sqlite" || store == "json" {
+		return store
+	}
+	if _, err := os.Stat(sqliteIndexPath(profile)); err != nil && hasFallbackIndex(profile) {
+		return "json"
+	}
+	return "sqlite"
+}
+
+// buildAllIndexes indexes every profile in profiles.ini. A profile that fails
+// (e.g. --account names none of its accounts) is reported and skipped.
+func (a *App) buildAllIndexes(opts indexOptions) error {
+	profiles, err := a.loadProfiles()
+	if err != nil {
+		return fmt.Errorf("load profiles: %w", err)
+	}
+	failed := 0
+	for _, p := range profiles {
+		fmt.Printf("Profile %s:\n", p.Name)
+		if err := a.buildIndex(p.AbsolutePath, opts); err != nil {
+			log.Printf("warn: profile %s: %v", p.Name, err)
+			failed++
 		}
-		var scoped []Mailbox
-		for _, b := range boxes {
-			for _, d := range dirs {
-				if strings.HasPrefix(b.Path, d) {
-					scoped = append(scoped, b)
-					break
-				}
-			}
+	}
+	if failed == len(profiles) {
+		return fmt.Errorf("no profile could be indexed")
+	}
+	return nil
+}
+
+// indexTargets lists the profile's folders narrowed by --account and --folder.
+func (a *App) indexTargets(profile Profile, opts indexOptions) ([]Mailbox, error) {
+	folderLike := opts.folderLike
+
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.accounts) > 0 {
+		if boxes, err = a.scopeToAccounts(profile, boxes, opts.accounts); err != nil {
+			return nil, err
 		}
-		boxes = scoped
 	}
 
 	var filtered []Mailbox
 	for _, b := range boxes {
+		if skipTaggedFolder(b.Name, folderLike, opts.includeTrash, opts.includeSpam) {
+			continue
+		}
 		if folderLike == "" {
 			filtered = append(filtered, b)
 			continue
@@ -52,26 +127,203 @@ func (a *App) buildIndex(profileName, folderLike, accountEmail string, tailCount
 		}
 	}
 	if len(filtered) == 0 {
-		return fmt.Errorf("no folders match %q", folderLike)
+		return nil, fmt.Errorf("no folders match %q", folderLike)
 	}
+	return filtered, nil
+}
 
-	cache := &IndexFile{Folders: map[string]FolderIndex{}}
-	for _, b := range filtered {
-		fmt.Printf("Indexing %s...\n", b.Name)
-		more, err := searchMailbox(b, func(string) bool { return true }, 0, time.Time{}, time.Time{}, tailCount, accountEmail, tailCount)
+// buildJSONIndex is --store json: one shard file per folder under shards/,
+// written as soon as that folder is done.
+func (a *App) buildJSONIndex(profile Profile, boxes []Mailbox, match matcherFunc, opts indexOptions) error {
+	// Keep folders that are unchanged (or outside this run's filter) from the previous index.
+	cache, err := loadIndex(profile)
+	if err != nil {
+		cache = &IndexFile{Folders: map[string]FolderIndex{}}
+	}
+	cache.setStorage(opts.compression, opts.encoding)
+	if err := migrateLegacyIndex(profile, cache); err != nil {
+		return fmt.Errorf("split %s into shards: %w", indexPath(profile), err)
+	}
+	var mu sync.Mutex
+	return forEachMailbox(boxes, opts.jobs, func(b Mailbox) error {
+		fi, err := statMailbox(b)
 		if err != nil {
 			fmt.Printf("skip %s: %v\n", b.Name, err)
-			continue
+			return nil
+		}
+		if skipBeforeSince(b, fi, opts) {
+			return nil
 		}
-		if fi, err := os.Stat(b.Path); err == nil {
-			cache.Folders[b.Path] = FolderIndex{
-				ModTime:  fi.ModTime().Unix(),
-				Size:     fi.Size(),
-				Messages: more,
-				SavedAt:  time.Now().UTC(),
-				Complete: true,
+		mu.Lock()
+		prev, ok := cache.Folders[b.Path]
+		mu.Unlock()
+		fp, same := folderUnchanged(prev.ModTime, prev.Size, prev.Fingerprint, b.Path, fi)
+		if ok && !opts.full && same {
+			if !opts.quietUnchanged {
+				fmt.Printf("Unchanged %s\n", b.Name)
+			}
+			if prev.ModTime == fi.ModTime().Unix() && prev.Fingerprint == fp {
+				return nil
 			}
+			prev.ModTime, prev.Fingerprint = fi.ModTime().Unix(), fp
+			mu.Lock()
+			defer mu.Unlock()
+			cache.Folders[b.Path] = prev
+			return saveIndexShard(profile, cache, b.Path)
 		}
+		announceIndexing(b, ok, prev.Size, fi.Size())
+		more, err := indexMailbox(b, match, opts.since, opts.tailCount, b.Account)
+		if err != nil {
+			fmt.Printf("skip %s: %v\n", b.Name, err)
+			return nil
+		}
+		mu.Lock()
+		cache.Folders[b.Path] = FolderIndex{
+			ModTime:     fi.ModTime().Unix(),
+			Size:        fi.Size(),
+			Messages:    more,
+			SavedAt:     time.Now().UTC(),
+			Complete:    true,
+			Bloom:       bloomForMessages(more),
+			Fingerprint: fp,
+		}
+		err = saveIndexShard(profile, cache, b.Path)
+		mu.Unlock()
+		return err
+	})
+}
+
+// sqliteIndexBatch is how many messages are committed to the SQLite index at a
+// time; it bounds the work an interrupted index run has to redo.
+const sqliteIndexBatch = 2000
+
+// buildSQLiteIndex updates index.sqlite, rescanning only folders whose
+// mtime or size changed since the last run and resuming interrupted ones.
+func (a *App) buildSQLiteIndex(profile Profile, boxes []Mailbox, match matcherFunc, opts indexOptions) error {
+	db := sqliteIndexPath(profile)
+	states, err := sqliteFolderStates(db)
+	if err != nil {
+		return err
+	}
+	// Folders are scanned in parallel; their writes stay one at a time.
+	var writeMu sync.Mutex
+	write := func(fn func() error) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return fn()
+	}
+	err = forEachMailbox(boxes, opts.jobs, func(b Mailbox) error {
+		fi, err := statMailbox(b)
+		if err != nil {
+			fmt.Printf("skip %s: %v\n", b.Name, err)
+			return nil
+		}
+		if skipBeforeSince(b, fi, opts) {
+			return nil
+		}
+		prev, seen := states[b.Path]
+		fp, same := folderUnchanged(prev.ModTime, prev.Size, prev.Fingerprint, b.Path, fi)
+		sameFile := seen && !opts.full && same
+		if sameFile && prev.Complete != 0 {
+			if !opts.quietUnchanged {
+				fmt.Printf("Unchanged %s\n", b.Name)
+			}
+			if prev.ModTime == fi.ModTime().Unix() && prev.Fingerprint == fp {
+				return nil
+			}
+			return write(func() error { return sqliteTouchFolder(db, b, fi, fp) })
+		}
+		var from int64
+		if sameFile && prev.ScannedTo > 0 && prev.ScannedTo < fi.Size() {
+			from = prev.ScannedTo
+			fmt.Printf("Resuming %s at %d%%...\n", b.Name, from*100/fi.Size())
+		} else {
+			announceIndexing(b, seen, prev.Size, fi.Size())
+			if err := write(func() error { return sqliteBeginFolder(db, b, fi, fp) }); err != nil {
+				return fmt.Errorf("%s: %w", b.Name, err)
+			}
+		}
+		// Messages are committed in batches so an interrupted run loses at
+		// most one batch of work per folder.
+		var batch []MailSummary
+		var scanned int64
+		var writeErr error
+		flush := func() error {
+			if err := write(func() error { return sqliteAppendMessages(db, b, batch, scanned) }); err != nil {
+				writeErr = fmt.Errorf("%s: %w", b.Name, err)
+				return writeErr
+			}
+			batch = batch[:0]
+			return nil
+		}
+		err = scanIndexMailbox(b, from, match, opts.since, b.Account, func(end int64, m *MailSummary) error {
+			scanned = end
+			if m != nil {
+				batch = append(batch, *m)
+			}
+			if len(batch) >= sqliteIndexBatch {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil && len(batch) > 0 {
+			err = flush()
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+		if err != nil {
+			fmt.Printf("skip %s: %v\n", b.Name, err)
+			return nil
+		}
+		if err := write(func() error {
+			if err := sqliteFinishFolder(db, b, fi, opts.tailCount); err != nil {
+				return err
+			}
+			return sqliteStoreBloom(db, b)
+		}); err != nil {
+			return fmt.Errorf("%s: %w", b.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if opts.folderLike == "" && len(opts.accounts) == 0 {
+		// A full run also forgets folders that no longer exist on disk.
+		var keep []any
+		for _, b := range boxes {
+			keep = append(keep, b.Path)
+		}
+		if len(keep) > 0 {
+			if err := sqliteExec(db, "DELETE FROM folders WHERE path NOT IN ("+sqlPlaceholders(len(keep))+")", keep...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipBeforeSince reports whether --since rules out the whole folder: an mbox
+// not written to since the cutoff holds no message delivered after it. What
+// an earlier run indexed for such a folder is left as is.
+func skipBeforeSince(b Mailbox, fi os.FileInfo, opts indexOptions) bool {
+	if opts.since.IsZero() || !fi.ModTime().Before(opts.since) {
+		return false
+	}
+	if !opts.quietUnchanged {
+		fmt.Printf("Skipping %s (not modified since %s)\n", b.Name, opts.since.Format("2006-01-02"))
+	}
+	return true
+}
+
+// announceIndexing prints the per-folder start line, calling out folders that
+// shrank since the last run: Thunderbird compacted them, so their stored
+// offsets are void and the folder is rebuilt from scratch.
+func announceIndexing(b Mailbox, indexed bool, oldSize, newSize int64) {
+	if indexed && newSize < oldSize {
+		fmt.Printf("Compacted %s (%s -> %s), rebuilding...\n", b.Name, byteSize(oldSize), byteSize(newSize))
+		return
 	}
-	return saveIndex(indexPath(profile), cache)
+	fmt.Printf("Indexing %s...\n", b.Name)
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// planIndex prints what tb mail index would do with the same flags: every
+// folder it would scan, why, and roughly how many bytes that reads. Nothing
+// is written.
+func (a *App) planIndex(profile Profile, boxes []Mailbox, opts indexOptions) error {
+	var folders []indexedFolder
+	db := sqliteIndexPath(profile)
+	if localIndexKind(profile, opts.store) == "sqlite" {
+		if _, err := os.Stat(db); err == nil {
+			if folders, err = sqliteIndexedFolders(db); err != nil {
+				return err
+			}
+		}
+	} else if hasFallbackIndex(profile) {
+		idx, err := loadIndex(profile)
+		if err != nil {
+			return fmt.Errorf("index is unreadable (%v); run tb mail index --full", err)
+		}
+		folders = jsonIndexedFolders(idx)
+	}
+	indexed := map[string]indexedFolder{}
+	for _, f := range folders {
+		indexed[f.Path] = f
+	}
+
+	var (
+		rows             [][]string
+		total            int64
+		unchanged, older int
+	)
+	for _, b := range boxes {
+		fi, err := statMailbox(b)
+		if err != nil {
+			rows = append(rows, []string{b.Name, "skip", err.Error(), "-"})
+			continue
+		}
+		if !opts.since.IsZero() && fi.ModTime().Before(opts.since) {
+			older++
+			continue
+		}
+		prev, seen := indexed[b.Path]
+		_, same := folderUnchanged(prev.ModTime, prev.Size, prev.Fingerprint, b.Path, fi)
+		action, scan := "index", fi.Size()
+		var reason string
+		switch {
+		case !seen:
+			reason = "new"
+		case opts.full:
+			reason = "--full"
+		case same && prev.Complete != 0:
+			unchanged++
+			continue
+		case same && prev.ScannedTo > 0 && prev.ScannedTo < fi.Size():
+			action, scan = "resume", fi.Size()-prev.ScannedTo
+			reason = fmt.Sprintf("interrupted at %d%%", prev.ScannedTo*100/fi.Size())
+		case same:
+			reason = "incomplete"
+		case fi.Size() < prev.Size:
+			reason = fmt.Sprintf("compacted (%s -> %s)", byteSize(prev.Size), byteSize(fi.Size()))
+		case fi.Size() > prev.Size:
+			reason = fmt.Sprintf("grown by %s", byteSize(fi.Size()-prev.Size))
+		case prev.Fingerprint == "":
+			reason = "mtime changed"
+		default:
+			reason = "content changed"
+		}
+		total += scan
+		rows = append(rows, []string{b.Name, action, reason, byteSize(scan)})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+
+	if len(rows) > 0 {
+		renderTable(os.Stdout, []string{"Folder", "Action", "Reason", "To scan"}, rows)
+		fmt.Println()
+	}
+	fmt.Printf("Would scan %d folder(s), about %s; %d unchanged", len(rows), byteSize(total), unchanged)
+	if older > 0 {
+		fmt.Printf(", %d not modified since %s", older, opts.since.Format("2006-01-02"))
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// The SQLite index runs in process through modernc.org/sqlite, a pure-Go
+// build of SQLite with FTS5, so the binary keeps building without cgo and
+// every install has the same SQLite. tb mail index --store json writes the
+// older JSON shards instead.
+
+const sqliteIndexSchema = `
+CREATE TABLE IF NOT EXISTS folders (
+	path     TEXT PRIMARY KEY,
+	name     TEXT NOT NULL,
+	mod_time INTEGER NOT NULL,
+	size     INTEGER NOT NULL,
+	complete INTEGER NOT NULL DEFAULT 1,
+	saved_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	folder_path TEXT NOT NULL REFERENCES folders(path) ON DELETE CASCADE,
+	folder      TEXT NOT NULL,
+	message_id  TEXT NOT NULL,
+	subject     TEXT,
+	sender      TEXT,
+	to_addrs    TEXT,
+	cc_addrs    TEXT,
+	date_str    TEXT,
+	when_ts     INTEGER,
+	snippet     TEXT,
+	search_text TEXT,
+	account     TEXT,
+	size_bytes  INTEGER DEFAULT 0,
+	attachments INTEGER DEFAULT 0,
+	moz_status  INTEGER DEFAULT 0,
+	tags        TEXT DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS messages_message_id ON messages(message_id);
+CREATE INDEX IF NOT EXISTS messages_folder_path ON messages(folder_path);
+`
+
+// sqliteAddedColumns are columns introduced after the first schema;
+// ensureSQLiteSchema adds them to older index files. Those with rescan set
+// are only filled in by scanning the folder again.
+var sqliteAddedColumns = []struct {
+	table, name, decl string
+	rescan            bool
+}{
+	{"messages", "mbox_offset", "INTEGER DEFAULT 0", true},
+	{"messages", "mbox_length", "INTEGER DEFAULT 0", true},
+	{"folders", "scanned_to", "INTEGER NOT NULL DEFAULT 0", true},
+	{"folders", "bloom", "BLOB", true},
+	{"folders", "fingerprint", "TEXT NOT NULL DEFAULT ''", false},
+	{"messages", "body_text", "TEXT", true},
+}
+
+// sqliteFTSSchema is the FTS5 table behind search --store sqlite. It
+// indexes the messages table in place (external content), and the triggers
+// keep it in step with every insert and delete, including the cascade when
+// a folder is dropped.
+const sqliteFTSSchema = `
+CREATE VIRTUAL TABLE messages_fts USING fts5(
+	subject, sender, to_addrs, cc_addrs, search_text,
+	content='messages', content_rowid='rowid', tokenize='unicode61 remove_diacritics 2'
+);
+CREATE TRIGGER messages_fts_ai AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts (rowid, subject, sender, to_addrs, cc_addrs, search_text)
+	VALUES (new.rowid, new.subject, new.sender, new.to_addrs, new.cc_addrs, new.search_text);
+END;
+CREATE TRIGGER messages_fts_ad AFTER DELETE ON messages BEGIN
+	INSERT INTO messages_fts (messages_fts, rowid, subject, sender, to_addrs, cc_addrs, search_text)
+	VALUES ('delete', old.rowid, old.subject, old.sender, old.to_addrs, old.cc_addrs, old.search_text);
+END;
+CREATE TRIGGER messages_fts_au AFTER UPDATE ON messages BEGIN
+	INSERT INTO messages_fts (messages_fts, rowid, subject, sender, to_addrs, cc_addrs, search_text)
+	VALUES ('delete', old.rowid, old.subject, old.sender, old.to_addrs, old.cc_addrs, old.search_text);
+	INSERT INTO messages_fts (rowid, subject, sender, to_addrs, cc_addrs, search_text)
+	VALUES (new.rowid, new.subject, new.sender, new.to_addrs, new.cc_addrs, new.search_text);
+END;
+INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
+`
+
+// ensureSQLiteSchema creates or upgrades the index schema. When columns that
+// need a rescan had to be added, every folder is marked stale so the next run
+// fills them in. The FTS5 table is added, and filled from the stored
+// messages, when missing.
+func ensureSQLiteSchema(db string) error {
+	if err := sqliteExec(db, sqliteIndexSchema); err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	err := sqliteEach(db, func(rows *sql.Rows) error {
+		var table, name string
+		if err := rows.Scan(&table, &name); err != nil {
+			return err
+		}
+		have[table+"."+name] = true
+		return nil
+	}, `SELECT 'folders', name FROM pragma_table_info('folders') UNION ALL SELECT 'messages', name FROM pragma_table_info('messages')
+UNION ALL SELECT 'fts', name FROM sqlite_master WHERE name = 'messages_fts'`)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	rescan := false
+	for _, c := range sqliteAddedColumns {
+		if !have[c.table+"."+c.name] {
+			fmt.Fprintf(&b, "ALTER TABLE %s ADD COLUMN %s %s;\n", c.table, c.name, c.decl)
+			rescan = rescan || c.rescan
+		}
+	}
+	if !have["fts.messages_fts"] {
+		b.WriteString(sqliteFTSSchema)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	if rescan {
+		b.WriteString("UPDATE folders SET mod_time = 0;\n")
+	}
+	return sqliteTx(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(b.String())
+		return err
+	})
+}
+
+func sqliteIndexPath(profile Profile) string {
+	return indexFile(profile, "index.sqlite")
+}
+
+// sqliteDBs holds one handle per index file for the life of the process.
+var sqliteDBs = struct {
+	sync.Mutex
+	open map[string]*sql.DB
+}{open: map[string]*sql.DB{}}
+
+// tb_lower lowercases text the way Go does. SQLite's lower() folds ASCII
+// only, and the SQL filters of search --group-by have to match what
+// filterMatches matches.
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("tb_lower", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		s, _ := args[0].(string)
+		return strings.ToLower(s), nil
+	})
+}
+
+// sqliteOpen returns the handle of the index at db, opening it on first use.
+// It keeps a single connection, so statements of concurrent folder scans
+// queue up instead of failing with SQLITE_BUSY; other processes wait up to
+// five seconds for a lock.
+func sqliteOpen(db string) (*sql.DB, error) {
+	sqliteDBs.Lock()
+	defer sqliteDBs.Unlock()
+	if h := sqliteDBs.open[db]; h != nil {
+		return h, nil
+	}
+	abs, err := filepath.Abs(db)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.ToSlash(abs)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // C:/... on Windows
+	}
+	dsn := url.URL{Scheme: "file", Path: path, RawQuery: "_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"}
+	h, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	h.SetMaxOpenConns(1)
+	sqliteDBs.open[db] = h
+	return h, nil
+}
+
+// sqliteExec runs one or more statements against db.
+func sqliteExec(db, query string, args ...any) error {
+	h, err := sqliteOpen(db)
+	if err != nil {
+		return err
+	}
+	_, err = h.Exec(query, args...)
+	return err
+}
+
+// sqliteTx runs fn in a transaction on db and commits it when fn succeeds.
+// fn must use tx only: the handle's one connection is busy until it returns.
+func sqliteTx(db string, fn func(tx *sql.Tx) error) error {
+	h, err := sqliteOpen(db)
+	if err != nil {
+		return err
+	}
+	tx, err := h.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqliteEach runs query against db and calls scan for every row. scan must
+// not query db itself: the handle's one connection is busy until it returns.
+func sqliteEach(db string, scan func(rows *sql.Rows) error, query string, args ...any) error {
+	h, err := sqliteOpen(db)
+	if err != nil {
+		return err
+	}
+	rows, err := h.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// sqlPlaceholders returns n comma-separated ? placeholders.
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+type sqliteFolderState struct {
+	Path        string `json:"path"`
+	ModTime     int64  `json:"mod_time"`
+	Size        int64  `json:"size"`
+	Complete    int    `json:"complete"`
+	ScannedTo   int64  `json:"scanned_to"` // end of the last stored message while incomplete
+	Fingerprint string `json:"fingerprint"`
+}
+
+// sqliteFolderStates returns the recorded mtime/size/fingerprint and scan progress of every indexed folder.
+func sqliteFolderStates(db string) (map[string]sqliteFolderState, error) {
+	if err := ensureSQLiteSchema(db); err != nil {
+		return nil, err
+	}
+	states := map[string]sqliteFolderState{}
+	err := sqliteEach(db, func(rows *sql.Rows) error {
+		var r sqliteFolderState
+		if err := rows.Scan(&r.Path, &r.ModTime, &r.Size, &r.Complete, &r.ScannedTo, &r.Fingerprint); err != nil {
+			return err
+		}
+		states[r.Path] = r
+		return nil
+	}, "SELECT path, mod_time, size, complete, scanned_to, fingerprint FROM folders")
+	return states, err
+}
+
+// sqliteBeginFolder drops the rows of one folder and records it as started
+// but incomplete, so an interrupted run can pick up from scanned_to.
+func sqliteBeginFolder(db string, box Mailbox, fi os.FileInfo, fingerprint string) error {
+	return sqliteTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM messages WHERE folder_path = ?", box.Path); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT OR REPLACE INTO folders (path, name, mod_time, size, complete, scanned_to, saved_at, fingerprint) VALUES (?, ?, ?, ?, 0, 0, ?, ?)",
+			box.Path, box.Name, fi.ModTime().Unix(), fi.Size(), time.Now().UTC().Format(time.RFC3339), fingerprint)
+		return err
+	})
+}
+
+// sqliteTouchFolder records a new mtime and fingerprint for a folder whose
+// content did not change, so later runs compare against them.
+func sqliteTouchFolder(db string, box Mailbox, fi os.FileInfo, fingerprint string) error {
+	return sqliteExec(db, "UPDATE folders SET mod_time = ?, fingerprint = ? WHERE path = ?", fi.ModTime().Unix(), fingerprint, box.Path)
+}
+
+// sqliteInsertMessage is the statement sqliteInsertArgs fills in.
+const sqliteInsertMessage = `INSERT INTO messages (folder_path, folder, message_id, subject, sender, to_addrs, cc_addrs, date_str, when_ts, snippet,
+	search_text, body_text, account, size_bytes, attachments, moz_status, tags, mbox_offset, mbox_length)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// sqliteInsertArgs returns the values of sqliteInsertMessage for a message
+// filed under the mbox at folderPath.
+func sqliteInsertArgs(folderPath string, m MailSummary) []any {
+	var when any
+	if !m.When.IsZero() {
+		when = m.When.Unix()
+	}
+	return []any{folderPath, m.Folder, m.MessageID, m.Subject, m.From, m.To, m.Cc, m.Date, when, m.Snippet,
+		m.Search, m.Body, m.Account, m.Size, m.Attachments, m.statusValue(), m.Tags, m.Offset, m.Length}
+}
+
+// sqliteAppendMessages stores a batch of a folder's messages and advances its
+// scanned_to in the same transaction.
+func sqliteAppendMessages(db string, box Mailbox, msgs []MailSummary, scannedTo int64) error {
+	return sqliteTx(db, func(tx *sql.Tx) error {
+		insert, err := tx.Prepare(sqliteInsertMessage)
+		if err != nil {
+			return err
+		}
+		defer insert.Close()
+		for _, m := range msgs {
+			if _, err := insert.Exec(sqliteInsertArgs(box.Path, m)...); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("UPDATE folders SET scanned_to = ?, saved_at = ? WHERE path = ?",
+			scannedTo, time.Now().UTC().Format(time.RFC3339), box.Path)
+		return err
+	})
+}
+
+// sqliteFinishFolder marks a folder complete, first trimming it to its last
+// tailCount messages when a tail was requested.
+func sqliteFinishFolder(db string, box Mailbox, fi os.FileInfo, tailCount int) error {
+	return sqliteTx(db, func(tx *sql.Tx) error {
+		if tailCount > 0 {
+			if _, err := tx.Exec("DELETE FROM messages WHERE folder_path = ?1 AND rowid NOT IN (SELECT rowid FROM messages WHERE folder_path = ?1 ORDER BY mbox_offset DESC LIMIT ?2)",
+				box.Path, tailCount); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec("UPDATE folders SET complete = 1, scanned_to = ?, saved_at = ? WHERE path = ?",
+			fi.Size(), time.Now().UTC().Format(time.RFC3339), box.Path)
+		return err
+	})
+}
+
+// sqliteFindMessageFolders returns the folder names holding a Message-Id.
+func sqliteFindMessageFolders(db, messageID string) ([]string, error) {
+	if _, err := os.Stat(db); err != nil {
+		return nil, err
+	}
+	id := normalizeMessageID(messageID)
+	var folders []string
+	err := sqliteEach(db, func(rows *sql.Rows) error {
+		var folder string
+		if err := rows.Scan(&folder); err != nil {
+			return err
+		}
+		folders = append(folders, folder)
+		return nil
+	}, "SELECT DISTINCT folder FROM messages WHERE message_id IN (?, ?)", id, "<"+id+">")
+	return folders, err
+}
+
+// sqliteMessageSpans returns where the index saw each of ids, keyed by
+// normalized Message-Id; every span when ids is nil.
+func sqliteMessageSpans(db string, ids []string) (map[string][]indexedSpan, error) {
+	if _, err := os.Stat(db); err != nil {
+		return nil, err
+	}
+	spans := map[string][]indexedSpan{}
+	scan := func(rows *sql.Rows) error {
+		var id string
+		var s indexedSpan
+		if err := rows.Scan(&id, &s.Folder, &s.Span.Offset, &s.Span.Length); err != nil {
+			return err
+		}
+		id = normalizeMessageID(id)
+		spans[id] = append(spans[id], s)
+		return nil
+	}
+	const query = "SELECT message_id, folder, mbox_offset, mbox_length FROM messages WHERE mbox_length > 0"
+	if ids == nil {
+		return spans, sqliteEach(db, scan, query)
+	}
+	for start := 0; start < len(ids); start += 500 {
+		end := min(start+500, len(ids))
+		var args []any
+		for _, id := range ids[start:end] {
+			id = normalizeMessageID(id)
+			args = append(args, id, "<"+id+">")
+		}
+		if err := sqliteEach(db, scan, query+" AND message_id IN ("+sqlPlaceholders(len(args))+")", args...); err != nil {
+			return nil, err
+		}
+	}
+	return spans, nil
+}
+
+// sqliteMessageColumns are the messages columns (table alias m) a
+// MailSummary is read from. A NULL moz_status reads as mozStatusUnknown.
+const sqliteMessageColumns = `m.folder, m.message_id, m.subject, m.sender, m.to_addrs, m.cc_addrs, m.date_str, m.when_ts, m.snippet,
+	m.search_text, m.body_text, m.account, m.size_bytes, m.attachments, coalesce(m.moz_status, -1), m.tags, m.mbox_offset, m.mbox_length`
+
+// sqliteIndexedMessages returns the stored messages matching the SQL
+// condition where (all of them when empty), with args for its placeholders.
+func sqliteIndexedMessages(db, where string, args ...any) ([]MailSummary, error) {
+	if where == "" {
+		where = "1=1"
+	}
+	return sqliteQueryMessages(db, fmt.Sprintf("SELECT %s FROM messages m WHERE %s", sqliteMessageColumns, where), args...)
+}
+
+// sqliteHasFTS reports whether the index has the FTS5 table.
+func sqliteHasFTS(db string) bool {
+	found := false
+	err := sqliteEach(db, func(*sql.Rows) error {
+		found = true
+		return nil
+	}, "SELECT name FROM sqlite_master WHERE name = 'messages_fts'")
+	return err == nil && found
+}
+
+// sqliteRankedMessages returns the messages matching the FTS5 expression
+// match, best first. Subject and sender hits weigh more than body text.
+func sqliteRankedMessages(db, match string) ([]MailSummary, error) {
+	return sqliteQueryMessages(db, fmt.Sprintf(`SELECT %s, -bm25(messages_fts, 4.0, 2.0, 1.0, 1.0, 1.0) AS score
+FROM messages_fts JOIN messages m ON m.rowid = messages_fts.rowid
+WHERE messages_fts MATCH ?
+ORDER BY score DESC, m.when_ts DESC`, sqliteMessageColumns), match)
+}
+
+// sqliteMatchQuery renders a search query as an FTS5 expression: every term
+// (a "quoted phrase" is one) must appear as whole words, and a term ending
+// in * matches words starting with it.
+func sqliteMatchQuery(query string) string {
+	var parts []string
+	for _, t := range splitQueryTerms(query) {
+		prefix := strings.HasSuffix(t, "*")
+		if t = strings.TrimRight(t, "*"); t == "" {
+			continue
+		}
+		p := `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+		if prefix {
+			p += "*"
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, " ")
+}
+
+// sqliteQueryMessages runs a query selecting sqliteMessageColumns, and
+// optionally a score, and reads the rows as MailSummary.
+func sqliteQueryMessages(db, query string, args ...any) ([]MailSummary, error) {
+	msgs := []MailSummary{}
+	err := sqliteEach(db, func(rows *sql.Rows) error {
+		var m MailSummary
+		var subject, sender, to, cc, date, snippet, search, body, account, tags sql.NullString
+		var when sql.NullInt64
+		dest := []any{&m.Folder, &m.MessageID, &subject, &sender, &to, &cc, &date, &when, &snippet,
+			&search, &body, &account, &m.Size, &m.Attachments, &m.MozStatus, &tags, &m.Offset, &m.Length}
+		if cols, _ := rows.Columns(); len(cols) > len(dest) {
+			dest = append(dest, &m.Score)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		m.Subject, m.From, m.To, m.Cc, m.Date = subject.String, sender.String, to.String, cc.String, date.String
+		m.Snippet, m.Search, m.Body, m.Account, m.Tags = snippet.String, search.String, body.String, account.String, tags.String
+		if when.Valid {
+			m.When = time.Unix(when.Int64, 0)
+		}
+		msgs = append(msgs, m)
+		return nil
+	}, query, args...)
+	return msgs, err
+}
+
+// sqliteStoreBloom recomputes a folder's trigram filter from its stored messages.
+func sqliteStoreBloom(db string, box Mailbox) error {
+	msgs, err := sqliteIndexedMessages(db, "folder_path = ?", box.Path)
+	if err != nil {
+		return err
+	}
+	return sqliteExec(db, "UPDATE folders SET bloom = ? WHERE path = ?", []byte(bloomForMessages(msgs)), box.Path)
+}
+
+// sqliteFolderBlooms returns every indexed folder's trigram filter, empty for
+// folders indexed before filters were stored.
+func sqliteFolderBlooms(db string) (map[string]folderBloom, error) {
+	if err := ensureSQLiteSchema(db); err != nil {
+		return nil, err
+	}
+	blooms := map[string]folderBloom{}
+	err := sqliteEach(db, func(rows *sql.Rows) error {
+		var path string
+		var bloom []byte
+		if err := rows.Scan(&path, &bloom); err != nil {
+			return err
+		}
+		blooms[path] = bloom
+		return nil
+	}, "SELECT path, bloom FROM folders")
+	return blooms, err
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// indexedFolder is what the local index recorded about one folder.
+type indexedFolder struct {
+	Path        string `json:"path"`
+	Name        string `json:"name"`
+	ModTime     int64  `json:"mod_time"`
+	Size        int64  `json:"size"`
+	Complete    int    `json:"complete"`
+	ScannedTo   int64  `json:"scanned_to"`
+	Messages    int    `json:"messages"`
+	Unread      int    `json:"unread"`
+	First       int64  `json:"first_ts"`
+	Last        int64  `json:"last_ts"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+func sqliteIndexedFolders(db string) ([]indexedFolder, error) {
+	if err := ensureSQLiteSchema(db); err != nil {
+		return nil, err
+	}
+	var rows []indexedFolder
+	err := sqliteEach(db, func(r *sql.Rows) error {
+		var f indexedFolder
+		if err := r.Scan(&f.Path, &f.Name, &f.ModTime, &f.Size, &f.Complete, &f.ScannedTo, &f.Fingerprint,
+			&f.Messages, &f.Unread, &f.First, &f.Last); err != nil {
+			return err
+		}
+		rows = append(rows, f)
+		return nil
+	}, `SELECT f.path, f.name, f.mod_time, f.size, f.complete, f.scanned_to, f.fingerprint,
+	COUNT(m.message_id) AS messages, COALESCE(SUM(m.moz_status & 1 = 0), 0) AS unread,
+	COALESCE(MIN(m.when_ts), 0) AS first_ts, COALESCE(MAX(m.when_ts), 0) AS last_ts
+FROM folders f LEFT JOIN messages m ON m.folder_path = f.path
+GROUP BY f.path`)
+	return rows, err
+}
+
+func jsonIndexedFolders(idx *IndexFile) []indexedFolder {
+	var rows []indexedFolder
+	for path, fi := range idx.Folders {
+		f := indexedFolder{Path: path, ModTime: fi.ModTime, Size: fi.Size, Messages: len(fi.Messages), Fingerprint: fi.Fingerprint}
+		if fi.Complete {
+			f.Complete = 1
+		}
+		for _, m := range fi.Messages {
+			if f.Name == "" {
+				f.Name = m.Folder
+			}
+			if m.Unread() {
+				f.Unread++
+			}
+			if m.When.IsZero() {
+				continue
+			}
+			if ts := m.When.Unix(); f.First == 0 || ts < f.First {
+				f.First = ts
+			}
+			if ts := m.When.Unix(); ts > f.Last {
+				f.Last = ts
+			}
+		}
+		rows = append(rows, f)
+	}
+	return rows
+}
+
+// loadIndexedFolders reads the per-folder records of the profile's local
+// index, with where it lives and what kind it is; path is "" when there is
+// no index. The caller holds the index lock.
+func loadIndexedFolders(profile Profile) (folders []indexedFolder, path, kind string, err error) {
+	db := sqliteIndexPath(profile)
+	if _, err := os.Stat(db); err == nil {
+		folders, err := sqliteIndexedFolders(db)
+		return folders, db, "sqlite", err
+	}
+	if !hasFallbackIndex(profile) {
+		return nil, "", "", nil
+	}
+	idx, err := loadIndex(profile)
+	if err != nil {
+		return nil, "", "", err
+	}
+	path, kind = indexShardDir(profile), idx.encoding
+	if idx.legacy {
+		path = indexPath(profile)
+	}
+	if idx.compression != "none" {
+		kind += ", " + idx.compression
+	}
+	return jsonIndexedFolders(idx), path, kind, nil
+}
+
+// indexStats compares the local index with the folders on disk.
+func (a *App) indexStats(profileName string) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockIndex(profile, false)
+	if err != nil {
+		return fmt.Errorf("lock index: %w", err)
+	}
+	defer unlock()
+
+	folders, path, kind, err := loadIndexedFolders(profile)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		fmt.Printf("No local index for %s; run tb mail index\n", profile.Name)
+		return nil
+	}
+
+	onDisk := map[string]Mailbox{}
+	for _, b := range boxes {
+		onDisk[b.Path] = b
+	}
+	indexed := map[string]bool{}
+	total := 0
+	var rows [][]string
+	for _, f := range folders {
+		indexed[f.Path] = true
+		total += f.Messages
+		name := f.Name
+		if name == "" {
+			if rel, err := filepath.Rel(profile.AbsolutePath, f.Path); err == nil {
+				name = rel
+			}
+		}
+		rows = append(rows, []string{name, strconv.Itoa(f.Messages), statsDate(f.First), statsDate(f.Last), folderIndexStatus(f, onDisk)})
+	}
+	for _, b := range boxes {
+		if !indexed[b.Path] {
+			rows = append(rows, []string{b.Name, "-", "-", "-", "not indexed"})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+
+	fmt.Printf("Index: %s (%s, %s)\n", path, kind, byteSize(dirSize(path)))
+	if hasBleveIndex(profile) {
+		fmt.Printf("Bleve index: %s (%s)\n", bleveIndexPath(profile), byteSize(dirSize(bleveIndexPath(profile))))
+	}
+	fmt.Printf("Folders: %d indexed, %d on disk; messages: %d\n\n", len(folders), len(boxes), total)
+	renderTable(os.Stdout, []string{"Folder", "Messages", "First", "Last", "Status"}, rows)
+	return nil
+}
+
+// folderIndexStatus says whether an indexed folder still matches the file on disk.
+func folderIndexStatus(f indexedFolder, onDisk map[string]Mailbox) string {
+	box, ok := onDisk[f.Path]
+	if !ok {
+		return "missing on disk"
+	}
+	fi, err := statMailbox(box)
+	if err != nil {
+		return "missing on disk"
+	}
+	_, same := folderUnchanged(f.ModTime, f.Size, f.Fingerprint, f.Path, fi)
+	changed := !same
+	switch {
+	case f.Complete == 0 && changed:
+		return "incomplete, changed since"
+	case f.Complete == 0:
+		pct := int64(0)
+		if f.Size > 0 {
+			pct = f.ScannedTo * 100 / f.Size
+		}
+		return fmt.Sprintf("incomplete (%d%%)", pct)
+	case changed:
+		age := fi.ModTime().Sub(time.Unix(f.ModTime, 0)).Round(time.Minute)
+		if age > 0 {
+			return fmt.Sprintf("stale (modified %s after indexing)", age)
+		}
+		return "stale"
+	}
+	return "ok"
+}
+
+func statsDate(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return time.Unix(ts, 0).Local().Format("2006-01-02")
+}
+
+// dirSize adds up the files under path (or the size of path itself).
+func dirSize(path string) int64 {
+	size := int64(0)
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
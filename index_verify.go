@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// verifyOptions controls tb mail index verify.
+type verifyOptions struct {
+	sample    int // messages per folder whose offsets are read back
+	repair    bool
+	tailCount int
+	excludes  []string
+}
+
+// spanSample is one indexed message picked for an offset spot check.
+type spanSample struct {
+	ID   string
+	Span mboxSpan
+}
+
+// indexHealth is what verify gathers from the index beyond the folder rows.
+type indexHealth struct {
+	samples   map[string][]spanSample // by folder path
+	dupRows   map[string]int          // the same message stored twice at one offset
+	dupIDs    map[string]int          // one Message-Id at several offsets (copies in the mbox)
+	noDate    map[string]int
+	noOffsets map[string]int
+}
+
+func newIndexHealth() indexHealth {
+	return indexHealth{
+		samples:   map[string][]spanSample{},
+		dupRows:   map[string]int{},
+		dupIDs:    map[string]int{},
+		noDate:    map[string]int{},
+		noOffsets: map[string]int{},
+	}
+}
+
+func sqliteIndexHealth(db string, sample int) (indexHealth, error) {
+	h := newIndexHealth()
+	err := sqliteEach(db, func(rows *sql.Rows) error {
+		var path string
+		var sample spanSample
+		if err := rows.Scan(&path, &sample.ID, &sample.Span.Offset, &sample.Span.Length); err != nil {
+			return err
+		}
+		h.samples[path] = append(h.samples[path], sample)
+		return nil
+	}, `SELECT folder_path, message_id, mbox_offset, mbox_length FROM (
+	SELECT folder_path, message_id, mbox_offset, mbox_length,
+		row_number() OVER (PARTITION BY folder_path ORDER BY random()) AS rn
+	FROM messages WHERE mbox_length > 0
+) WHERE rn <= ?`, sample)
+	if err != nil {
+		return h, err
+	}
+
+	counts := []struct {
+		into  map[string]int
+		query string
+	}{
+		{h.dupRows, "SELECT folder_path, COUNT(*) AS n FROM (SELECT folder_path FROM messages GROUP BY folder_path, message_id, mbox_offset HAVING COUNT(*) > 1) GROUP BY folder_path"},
+		{h.dupIDs, "SELECT folder_path, COUNT(*) AS n FROM (SELECT folder_path FROM messages WHERE message_id <> '' GROUP BY folder_path, message_id HAVING COUNT(DISTINCT mbox_offset) > 1) GROUP BY folder_path"},
+		{h.noDate, "SELECT folder_path, COUNT(*) AS n FROM messages WHERE when_ts IS NULL GROUP BY folder_path"},
+		{h.noOffsets, "SELECT folder_path, COUNT(*) AS n FROM messages WHERE mbox_length = 0 GROUP BY folder_path"},
+	}
+	for _, c := range counts {
+		err := sqliteEach(db, func(rows *sql.Rows) error {
+			var path string
+			var n int
+			if err := rows.Scan(&path, &n); err != nil {
+				return err
+			}
+			c.into[path] = n
+			return nil
+		}, c.query)
+		if err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+func jsonIndexHealth(idx *IndexFile, sample int) indexHealth {
+	h := newIndexHealth()
+	for path, fi := range idx.Folders {
+		step := 1
+		if sample > 0 && len(fi.Messages) > sample {
+			step = len(fi.Messages) / sample
+		}
+		rows := map[string]bool{}
+		offsets := map[string]map[int64]bool{}
+		for i, m := range fi.Messages {
+			if m.Length == 0 {
+				h.noOffsets[path]++
+			} else if i%step == 0 && len(h.samples[path]) < sample {
+				h.samples[path] = append(h.samples[path], spanSample{ID: m.MessageID, Span: mboxSpan{Offset: m.Offset, Length: m.Length}})
+			}
+			if m.When.IsZero() {
+				h.noDate[path]++
+			}
+			key := m.MessageID + "\x00" + strconv.FormatInt(m.Offset, 10)
+			if rows[key] {
+				h.dupRows[path]++
+			}
+			rows[key] = true
+			if m.MessageID != "" {
+				if offsets[m.MessageID] == nil {
+					offsets[m.MessageID] = map[int64]bool{}
+				}
+				offsets[m.MessageID][m.Offset] = true
+			}
+		}
+		for _, seen := range offsets {
+			if len(seen) > 1 {
+				h.dupIDs[path]++
+			}
+		}
+	}
+	return h
+}
+
+// verifyIndex checks the local index against the mbox files and, with
+// opts.repair, rebuilds the folders that failed.
+func (a *App) verifyIndex(profileName string, opts verifyOptions) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return err
+	}
+	if opts.sample < 1 {
+		opts.sample = 1
+	}
+	unlock, err := lockIndex(profile, opts.repair)
+	if err != nil {
+		return fmt.Errorf("lock index: %w", err)
+	}
+	defer unlock()
+
+	var (
+		folders []indexedFolder
+		health  indexHealth
+		cache   *IndexFile
+	)
+	db := sqliteIndexPath(profile)
+	useSQLite := false
+	if _, err := os.Stat(db); err == nil {
+		useSQLite = true
+		if folders, err = sqliteIndexedFolders(db); err != nil {
+			return err
+		}
+		if health, err = sqliteIndexHealth(db, opts.sample); err != nil {
+			return err
+		}
+	} else if hasFallbackIndex(profile) {
+		if cache, err = loadIndex(profile); err != nil {
+			return fmt.Errorf("index is unreadable (%v); run tb mail index --full", err)
+		}
+		folders = jsonIndexedFolders(cache)
+		health = jsonIndexHealth(cache, opts.sample)
+	} else {
+		fmt.Printf("No local index for %s; run tb mail index\n", profile.Name)
+		return nil
+	}
+
+	onDisk := map[string]Mailbox{}
+	for _, b := range boxes {
+		onDisk[b.Path] = b
+	}
+	var (
+		rows    [][]string
+		rebuild []Mailbox
+		gone    []string
+	)
+	for _, f := range folders {
+		box, exists := onDisk[f.Path]
+		name := f.Name
+		if exists {
+			name = box.Name
+		}
+		var problems, notes []string
+		checked := "-"
+		if !exists {
+			problems = append(problems, "missing on disk")
+			gone = append(gone, f.Path)
+		} else {
+			if status := folderIndexStatus(f, onDisk); status != "ok" {
+				problems = append(problems, status)
+			}
+			samples := health.samples[f.Path]
+			bad := 0
+			for _, s := range samples {
+				if _, ok := readIndexedMessage(box, s.Span, s.ID); !ok {
+					bad++
+				}
+			}
+			checked = fmt.Sprintf("%d/%d", len(samples)-bad, len(samples))
+			if bad > 0 {
+				problems = append(problems, fmt.Sprintf("%d offsets wrong", bad))
+			}
+			if n := health.dupRows[f.Path]; n > 0 {
+				problems = append(problems, fmt.Sprintf("%d duplicate rows", n))
+			}
+			if n := health.noOffsets[f.Path]; n > 0 {
+				problems = append(problems, fmt.Sprintf("%d without offsets", n))
+			}
+			if len(problems) > 0 {
+				rebuild = append(rebuild, box)
+			}
+		}
+		if n := health.dupIDs[f.Path]; n > 0 {
+			notes = append(notes, fmt.Sprintf("%d Message-Ids stored more than once", n))
+		}
+		if n := health.noDate[f.Path]; n > 0 {
+			notes = append(notes, fmt.Sprintf("%d without a Date", n))
+		}
+		status := "ok"
+		if len(problems) > 0 {
+			status = strings.Join(problems, "; ")
+		}
+		rows = append(rows, []string{name, strconv.Itoa(f.Messages), checked, status, strings.Join(notes, "; ")})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	renderTable(os.Stdout, []string{"Folder", "Messages", "Offsets OK", "Status", "Notes"}, rows)
+
+	broken := len(rebuild) + len(gone)
+	if broken == 0 {
+		fmt.Printf("\nIndex OK (%d folders)\n", len(folders))
+		return nil
+	}
+	if !opts.repair {
+		return fmt.Errorf("%d folder(s) need repair; rerun with --repair", broken)
+	}
+
+	fmt.Printf("\nRepairing %d folder(s)...\n", broken)
+	if useSQLite {
+		if len(gone) > 0 {
+			var in []any
+			for _, p := range gone {
+				in = append(in, p)
+			}
+			if err := sqliteExec(db, "DELETE FROM folders WHERE path IN ("+sqlPlaceholders(len(in))+")", in...); err != nil {
+				return err
+			}
+		}
+	} else if len(gone) > 0 {
+		if err := migrateLegacyIndex(profile, cache); err != nil {
+			return err
+		}
+		for _, p := range gone {
+			if err := removeIndexShard(profile, p); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rebuild) == 0 {
+		return nil
+	}
+	match := withExcludes(func(string) bool { return true }, opts.excludes)
+	build := indexOptions{tailCount: opts.tailCount, excludes: opts.excludes, full: true, jobs: 1}
+	if useSQLite {
+		return a.buildSQLiteIndex(profile, rebuild, match, build)
+	}
+	return a.buildJSONIndex(profile, rebuild, match, build)
+}
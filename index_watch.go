@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchOptions controls tb mail index --watch.
+type watchOptions struct {
+	interval time.Duration
+	fetch    bool // also ingest changed folders into Postgres
+}
+
+// folderStamp is the mtime/size pair a watch tick compares.
+type folderStamp struct {
+	modTime int64
+	size    int64
+}
+
+func folderStamps(boxes []Mailbox) map[string]folderStamp {
+	stamps := map[string]folderStamp{}
+	for _, b := range boxes {
+		if fi, err := statMailbox(b); err == nil {
+			stamps[b.Path] = folderStamp{fi.ModTime().UnixNano(), fi.Size()}
+		}
+	}
+	return stamps
+}
+
+func sameStamps(x, y map[string]folderStamp) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for k, v := range x {
+		if y[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// folderNotifier wakes the watch loop on file events in the directories that
+// hold the watched folders. A nil *folderNotifier never fires, so callers
+// fall back to polling.
+type folderNotifier struct {
+	w    *fsnotify.Watcher
+	dirs map[string]bool
+}
+
+// newFolderNotifier watches the folders in boxes, or returns nil when the
+// platform or filesystem offers no file notifications.
+func newFolderNotifier(boxes []Mailbox) *folderNotifier {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("warn: file notifications unavailable (%v); polling instead", err)
+		return nil
+	}
+	n := &folderNotifier{w: w, dirs: map[string]bool{}}
+	if err := n.add(boxes); err != nil {
+		log.Printf("warn: file notifications unavailable (%v); polling instead", err)
+		n.close()
+		return nil
+	}
+	return n
+}
+
+// add starts watching the directories of folders not seen before: an mbox
+// changes its parent directory's entry, a maildir its cur and new.
+func (n *folderNotifier) add(boxes []Mailbox) error {
+	if n == nil {
+		return nil
+	}
+	for _, b := range boxes {
+		dirs := []string{filepath.Dir(b.Path)}
+		if b.Maildir {
+			dirs = []string{filepath.Join(b.Path, "cur"), filepath.Join(b.Path, "new")}
+		}
+		for _, dir := range dirs {
+			if n.dirs[dir] {
+				continue
+			}
+			if err := n.w.Add(dir); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("watch %s: %w", dir, err)
+			}
+			n.dirs[dir] = true
+		}
+	}
+	return nil
+}
+
+func (n *folderNotifier) events() <-chan fsnotify.Event {
+	if n == nil {
+		return nil
+	}
+	return n.w.Events
+}
+
+func (n *folderNotifier) errors() <-chan error {
+	if n == nil {
+		return nil
+	}
+	return n.w.Errors
+}
+
+func (n *folderNotifier) close() {
+	if n != nil {
+		n.w.Close()
+	}
+}
+
+// watchIndex keeps the local index (and with w.fetch the Postgres cache) up to
+// date until interrupted. Folders are watched through file notifications
+// (inotify, kqueue, ReadDirectoryChangesW); where those are unavailable they
+// are polled by mtime/size every interval instead. Either way an update runs
+// once a change has held still for one interval, which avoids indexing a
+// folder while Thunderbird is still writing it.
+func (a *App) watchIndex(profileName string, opts indexOptions, w watchOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return a.watchFolders(ctx, profileName, opts, w, nil)
+}
+
+// watchFolders runs the watch loop until ctx ends, calling updated after each
+// successful index update (including the initial build).
+func (a *App) watchFolders(ctx context.Context, profileName string, opts indexOptions, w watchOptions, updated func()) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	if w.interval < time.Second {
+		w.interval = time.Second
+	}
+
+	update := func() error {
+		if err := a.buildIndex(profileName, opts); err != nil {
+			return err
+		}
+		if w.fetch {
+			if err := a.fetch(profileName, opts.folderLike, opts.accounts, false, false, false, 0, 0, opts.jobs, defaultUpsertBatch); err != nil {
+				log.Printf("warn: fetch: %v", err)
+			}
+		}
+		if updated != nil {
+			updated()
+		}
+		return nil
+	}
+
+	if err := update(); err != nil {
+		return err
+	}
+	opts.full = false
+	opts.quietUnchanged = true
+	boxes, err := a.indexTargets(profile, opts)
+	if err != nil {
+		return err
+	}
+	indexed := folderStamps(boxes)
+	last := indexed
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	poll := func() {
+		ticker = time.NewTicker(w.interval)
+		tick = ticker.C
+	}
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	notes := newFolderNotifier(boxes)
+	defer func() { notes.close() }()
+	if notes == nil {
+		poll()
+		fmt.Printf("Watching %d folders every %s (Ctrl-C to stop)\n", len(boxes), w.interval)
+	} else {
+		fmt.Printf("Watching %d folders for changes (Ctrl-C to stop)\n", len(boxes))
+	}
+	// settle fires once file events have stopped for one interval.
+	settle := time.NewTimer(w.interval)
+	settle.Stop()
+	defer settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notes.events():
+			settle.Reset(w.interval)
+			continue
+		case err := <-notes.errors():
+			log.Printf("warn: file notifications failed (%v); polling every %s", err, w.interval)
+			notes.close()
+			notes = nil
+			poll()
+			continue
+		case <-settle.C:
+		case <-tick:
+		}
+		// Re-list on each check so new folders are picked up.
+		if boxes, err = a.indexTargets(profile, opts); err != nil {
+			log.Printf("warn: %v", err)
+			continue
+		}
+		if err := notes.add(boxes); err != nil {
+			log.Printf("warn: %v", err)
+		}
+		now := folderStamps(boxes)
+		if notes == nil {
+			// Polling: a change must look the same on two ticks in a row.
+			settled := sameStamps(now, last)
+			last = now
+			if !settled {
+				continue
+			}
+		}
+		if sameStamps(now, indexed) {
+			continue
+		}
+		fmt.Printf("[%s] changes detected\n", time.Now().Format("15:04:05"))
+		if err := update(); err != nil {
+			log.Printf("warn: index: %v", err)
+			continue
+		}
+		indexed = now
+	}
+}
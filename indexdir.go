@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// indexDirOverride is set by --index-dir; it wins over TB_INDEX_DIR.
+var indexDirOverride string
+
+// Local index files live in a per-profile cache directory rather than in the
+// Thunderbird profile, so they stay out of profile backups and work with a
+// read-only profile. Each entry maps the cache name to the name older
+// versions used inside the profile directory.
+var indexFileNames = map[string]string{
+	"index.sqlite":     ".tb-index.sqlite",
+	"shards":           ".tb-index",
+	"index.json":       ".tb-index.json",
+	"last-search.json": ".tb-last-search.json",
+}
+
+// indexRoot is --index-dir, else TB_INDEX_DIR, else the user cache dir
+// ($XDG_CACHE_HOME/tb on Linux).
+func indexRoot() string {
+	if indexDirOverride != "" {
+		return indexDirOverride
+	}
+	if dir := strings.TrimSpace(os.Getenv("TB_INDEX_DIR")); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tb")
+}
+
+// indexDir is the profile's directory under indexRoot, named after the
+// profile directory (e.g. abcd1234.default-release) plus a hash of its full
+// path, so same-named profiles of different installs do not share an index.
+func indexDir(profile Profile) string {
+	h := fnv.New32a()
+	h.Write([]byte(profile.AbsolutePath))
+	return filepath.Join(indexRoot(), fmt.Sprintf("%s-%08x", filepath.Base(profile.AbsolutePath), h.Sum32()))
+}
+
+// indexFile returns where the named index file lives: in indexDir, unless
+// only a copy left in the profile by an older version exists.
+func indexFile(profile Profile, name string) string {
+	path := filepath.Join(indexDir(profile), name)
+	if legacy, ok := indexFileNames[name]; ok {
+		if _, err := os.Stat(path); err != nil {
+			old := filepath.Join(profile.AbsolutePath, legacy)
+			if _, err := os.Stat(old); err == nil {
+				return old
+			}
+		}
+	}
+	return path
+}
+
+// ensureIndexDir creates indexDir and moves index files an older version
+// wrote into the profile over to it. Files on another filesystem, or in a
+// profile Thunderbird has open, are copied and the originals left in place.
+func ensureIndexDir(profile Profile) error {
+	dir := indexDir(profile)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	_, live := thunderbirdLockHolder(profile.AbsolutePath)
+	for name, legacy := range indexFileNames {
+		old := filepath.Join(profile.AbsolutePath, legacy)
+		dst := filepath.Join(dir, name)
+		if _, err := os.Stat(old); err != nil {
+			continue
+		}
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if !live {
+			if err := os.Rename(old, dst); err == nil {
+				log.Printf("info: moved %s to %s", old, dst)
+				continue
+			}
+		}
+		if err := copyTree(old, dst); err != nil {
+			return fmt.Errorf("move %s: %w", old, err)
+		}
+		log.Printf("info: copied %s to %s; the old copy can be deleted", old, dst)
+	}
+	return nil
+}
+
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func indexLockPath(profile Profile) string {
+	return filepath.Join(indexDir(profile), "index.lock")
+}
+
+// lockIndex serializes index read/modify/write cycles between tb processes
+// (cron jobs, scripts, a daemon) with an advisory lock on index.lock.
+// Writers take it exclusive, readers that need a consistent view shared.
+// The lock is released by the returned func or when the process exits.
+func lockIndex(profile Profile, exclusive bool) (unlock func(), err error) {
+	if err := ensureIndexDir(profile); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(indexLockPath(profile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// flockFile is a no-op where flock(2) is unavailable; concurrent index runs
+// are not serialized there.
+func flockFile(f *os.File, exclusive bool) error {
+	return nil
+}
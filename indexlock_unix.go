@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"syscall"
+)
+
+// flockFile takes an advisory lock on f, announcing when it has to wait for
+// another tb process.
+func flockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		log.Printf("info: waiting for another tb process to release %s", f.Name())
+		err = syscall.Flock(int(f.Fd()), how)
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// listLinks returns the distinct hrefs found in the HTML parts of a raw message,
+// in document order. With stripTracking, redirect wrappers are unwrapped and
+// utm_* style parameters dropped before deduplication.
+func listLinks(raw []byte, stripTracking bool) ([]string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	seen := map[string]bool{}
+	walkMIME(msg.Header, body, "", func(p mimePart) {
+		mediaType, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil || mediaType != "text/html" || isAttachment(p) {
+			return
+		}
+		decoded := decodedPart(p)
+		if cs := params["charset"]; cs != "" && !strings.EqualFold(cs, "utf-8") {
+			if conv, err := convertCharset(decoded, cs); err == nil {
+				decoded = conv
+			}
+		}
+		for _, href := range htmlHrefs(decoded) {
+			if stripTracking {
+				href = cleanTrackingURL(href)
+			}
+			if !seen[href] {
+				seen[href] = true
+				links = append(links, href)
+			}
+		}
+	})
+	return links, nil
+}
+
+// htmlHrefs returns the href of every <a> tag that points somewhere useful.
+func htmlHrefs(doc []byte) []string {
+	var out []string
+	z := html.NewTokenizer(bytes.NewReader(doc))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return out
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		name, hasAttr := z.TagName()
+		if string(name) != "a" || !hasAttr {
+			continue
+		}
+		for {
+			key, val, more := z.TagAttr()
+			if string(key) == "href" {
+				href := strings.TrimSpace(string(val))
+				lower := strings.ToLower(href)
+				if href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(lower, "javascript:") {
+					out = append(out, href)
+				}
+			}
+			if !more {
+				break
+			}
+		}
+	}
+}
+
+// redirectParams are query parameters that commonly carry the real target of a
+// click-tracking redirect (Google, Outlook SafeLinks, Facebook, newsletters).
+var redirectParams = []string{"url", "u", "q", "target", "dest", "destination", "redirect", "redirect_url", "link"}
+
+// trackingParams are query parameters dropped from cleaned links.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "mc_cid": true, "mc_eid": true, "_hsenc": true, "_hsmi": true,
+	"mkt_tok": true, "yclid": true, "msclkid": true,
+}
+
+// cleanTrackingURL unwraps redirect links whose query holds the real URL and
+// removes utm_* and similar tracking parameters.
+func cleanTrackingURL(raw string) string {
+	for depth := 0; depth < 3; depth++ {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return raw
+		}
+		q := u.Query()
+		unwrapped := false
+		for _, p := range redirectParams {
+			target := q.Get(p)
+			if t, err := url.Parse(target); err == nil && (t.Scheme == "http" || t.Scheme == "https") && t.Host != "" {
+				raw = target
+				unwrapped = true
+				break
+			}
+		}
+		if unwrapped {
+			continue
+		}
+		changed := false
+		for key := range q {
+			if strings.HasPrefix(strings.ToLower(key), "utm_") || trackingParams[strings.ToLower(key)] {
+				q.Del(key)
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+		return raw
+	}
+	return raw
+}
+
+func printLinks(links []string) {
+	if len(links) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Links:")
+	for i, l := range links {
+		fmt.Printf("  [%d] %s\n", i+1, l)
+	}
+}
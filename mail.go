@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -16,22 +17,29 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-mbox"
 	flag "github.com/spf13/pflag"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/charset"
+	"golang.org/x/text/width"
 	"regexp"
 	"text/tabwriter"
+	"unicode"
 	"unicode/utf8"
 )
 
 type App struct {
 	Root string
+	// ProfileDir, from --profile-dir or TB_PROFILE_DIR, is the only profile
+	// when set; profiles.ini is not read.
+	ProfileDir string
 }
 
 type Profile struct {
@@ -43,23 +51,87 @@ type Profile struct {
 }
 
 type Mailbox struct {
-	Name string
-	Path string
-	Size int64
+	Name    string
+	Path    string
+	Size    int64
+	Account string // set when the folder was selected through --account
+	Live    bool   // Thunderbird has the profile open; see markLive
+	Maildir bool   // Path is a maildir folder rather than an mbox file
 }
 
 type MailSummary struct {
-	Profile   string
-	Folder    string
-	Subject   string
-	From      string
-	Date      string
-	MessageID string
-	Snippet   string
-	When      time.Time
-	Account   string
-	Search    string
-	FolderTag string
+	Profile     string
+	Folder      string
+	Subject     string
+	From        string
+	To          string
+	Cc          string
+	Date        string
+	MessageID   string
+	Snippet     string
+	When        time.Time
+	Account     string
+	Search      string
+	Body        string // decoded body text alone, which --body matches
+	Size        int64
+	Attachments int
+	MozStatus   int    // X-Mozilla-Status flag bits as written by Thunderbird, or mozStatusUnknown
+	Tags        string // space-separated X-Mozilla-Keys tag keywords
+	InReplyTo   string
+	References  string // space-separated Message-Ids from the References header
+	FolderTag   string
+	Offset      int64   // start of the message (From_ line) in its mbox file; set by the local index
+	Length      int64   // bytes up to the next From_ line; 0 when unknown
+	Score       float64 `json:",omitempty"` // relevance from search --rank; 0 otherwise
+}
+
+// X-Mozilla-Status flag bits (see nsMsgMessageFlags).
+const (
+	mozFlagRead    = 0x0001
+	mozFlagMarked  = 0x0004
+	mozFlagExpunge = 0x0008
+)
+
+// mozStatusUnknown is the MozStatus of a message without a readable
+// X-Mozilla-Status header (mail written by other programs): it is neither
+// read nor unread, and --unread and --read both leave it out.
+const mozStatusUnknown = -1
+
+// parseMozStatus reads an X-Mozilla-Status header value.
+func parseMozStatus(v string) int {
+	n, err := strconv.ParseUint(strings.TrimSpace(v), 16, 32)
+	if err != nil {
+		return mozStatusUnknown
+	}
+	return int(n)
+}
+
+func (m MailSummary) HasStatus() bool { return m.MozStatus != mozStatusUnknown }
+func (m MailSummary) Unread() bool    { return m.HasStatus() && m.MozStatus&mozFlagRead == 0 }
+func (m MailSummary) Read() bool      { return m.HasStatus() && m.MozStatus&mozFlagRead != 0 }
+func (m MailSummary) Flagged() bool   { return m.HasStatus() && m.MozStatus&mozFlagMarked != 0 }
+
+// Expunged reports a message Thunderbird deleted but has not compacted away yet.
+func (m MailSummary) Expunged() bool { return m.HasStatus() && m.MozStatus&mozFlagExpunge != 0 }
+
+// statusValue is MozStatus for a database column, NULL when unknown.
+func (m MailSummary) statusValue() any {
+	if !m.HasStatus() {
+		return nil
+	}
+	return m.MozStatus
+}
+
+// statusMarks renders a short status column: N for unread, ★ for flagged.
+func statusMarks(m MailSummary) string {
+	var b strings.Builder
+	if m.Unread() {
+		b.WriteString("N")
+	}
+	if m.Flagged() {
+		b.WriteString("★")
+	}
+	return b.String()
 }
 
 const (
@@ -71,19 +143,53 @@ const (
 )
 
 type ingestOptions struct {
-	accountEmail string
-	folderLike   string
-	syncFirst    bool
-	prune        bool
-	maxMessages  int
-	tailCount    int
-	fullRescan   bool
+	accounts    []string
+	folderLike  string
+	syncFirst   bool
+	prune       bool
+	maxMessages int
+	tailCount   int
+	fullRescan  bool
+	jobs        int  // folders scanned concurrently
+	bodies      bool // also store full bodies in tb_bodies
+	rawBodies   bool // with bodies, keep the raw RFC822 message as well
+	attachments bool // record attachment metadata in tb_attachments
+	threads     bool // record reply links in tb_threads
+	contacts    bool // record From/To/Cc addresses in tb_addresses for tb_contacts
+	watermarks  bool // read only the mail appended to a grown folder since the last run
+
+	// onFolder, when set, is called once per folder with the number of
+	// messages upserted, or with unchanged set when the folder was skipped.
+	// With several jobs it runs on several goroutines at once.
+	onFolder func(b Mailbox, upserted int, unchanged bool)
 }
 
 func fingerprintKey(profile string, path string) string {
 	return fmt.Sprintf("fp|%s|%s", profile, path)
 }
 
+// watermarkKey names the tb_meta row holding how far a folder has been
+// ingested, as "offset:watermarkFingerprint".
+func watermarkKey(profile string, path string) string {
+	return fmt.Sprintf("wm|%s|%s", profile, path)
+}
+
+// appendedSince reports the offset to resume a folder from when its stored
+// watermark shows it has only grown since: the file is larger and the bytes
+// just before the old end are unchanged.
+func appendedSince(watermark string, path string, fi os.FileInfo) (int64, bool) {
+	offStr, want, ok := strings.Cut(watermark, ":")
+	if !ok {
+		return 0, false
+	}
+	off, err := strconv.ParseInt(offStr, 10, 64)
+	if err != nil || off <= 0 || off >= fi.Size() {
+		return 0, false
+	}
+	got, err := watermarkFingerprint(path, off)
+	return off, err == nil && got == want
+}
+
 func mailMain(args []string) {
 	if len(args) == 0 {
 		mailUsage()
@@ -97,6 +203,30 @@ func mailMain(args []string) {
 		if err := app.printProfiles(); err != nil {
 			log.Fatalf("profiles: %v", err)
 		}
+	case "accounts":
+		cmd := flag.NewFlagSet("accounts", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		format := cmd.String("format", "text", "output format: text, or json (one object per account)")
+		cmd.Parse(args[1:])
+		if *format != "text" && *format != "json" {
+			log.Fatalf("accounts: unknown --format %q (use text or json)", *format)
+		}
+		if err := app.printAccounts(*profileName, *format); err != nil {
+			log.Fatalf("accounts: %v", err)
+		}
+	case "identities":
+		cmd := flag.NewFlagSet("identities", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		account := cmd.String("account", "", "only this account's identities (account key or email)")
+		cmd.StringVar(account, "ac", "", "alias for --account")
+		format := cmd.String("format", "text", "output format: text, or json (one object per identity)")
+		cmd.Parse(args[1:])
+		if *format != "text" && *format != "json" {
+			log.Fatalf("identities: unknown --format %q (use text or json)", *format)
+		}
+		if err := app.printIdentities(*profileName, *account, *format); err != nil {
+			log.Fatalf("identities: %v", err)
+		}
 	case "read":
 		// Alias for show.
 		mailMain(append([]string{"show"}, args[1:]...))
@@ -106,60 +236,277 @@ func mailMain(args []string) {
 		mailMain(append([]string{"compose"}, args[1:]...))
 		return
 	case "index":
+		if len(args) > 1 && args[1] == "stats" {
+			cmd := flag.NewFlagSet("index stats", flag.ExitOnError)
+			profileName := cmd.String("profile", "", "profile name or path")
+			indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+			cmd.Parse(args[2:])
+			indexDirOverride = *indexDir
+			if err := app.indexStats(*profileName); err != nil {
+				log.Fatalf("index stats: %v", err)
+			}
+			return
+		}
+		if len(args) > 1 && args[1] == "verify" {
+			cmd := flag.NewFlagSet("index verify", flag.ExitOnError)
+			profileName := cmd.String("profile", "", "profile name or path")
+			sample := cmd.Int("sample", 20, "messages per folder whose offsets are read back")
+			repair := cmd.Bool("repair", false, "rebuild folders that fail verification")
+			tailCount := cmd.Int("tail", defaultIndexTail, "with --repair: keep only last N messages per folder (0 = all)")
+			excludes := cmd.StringArray("exclude", nil, "with --repair: leave messages containing this term out (repeatable)")
+			indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+			cmd.Parse(args[2:])
+			indexDirOverride = *indexDir
+			opts := verifyOptions{sample: *sample, repair: *repair, tailCount: *tailCount, excludes: *excludes}
+			if err := app.verifyIndex(*profileName, opts); err != nil {
+				log.Fatalf("index verify: %v", err)
+			}
+			return
+		}
 		cmd := flag.NewFlagSet("index", flag.ExitOnError)
+		pgDSNFlag(cmd)
 		profileName := cmd.String("profile", "", "profile name or path")
 		folderLike := cmd.String("folder", "", "restrict to folders containing this name")
-		account := cmd.String("account", "", "filter by account email")
-		accountShort := cmd.String("ac", "", "alias for --account")
+		accounts := accountFlags(cmd)
 		tailCount := cmd.Int("tail", defaultIndexTail, "keep only last N messages per folder (0 = all)")
+		excludes := cmd.StringArray("exclude", nil, "leave messages containing this term out of the index (repeatable)")
+		full := cmd.Bool("full", false, "rescan every folder, not just those changed since the last index run")
+		since := cmd.String("since", "", "only index messages on/after YYYY-MM-DD and skip folders untouched since then")
+		allProfiles := cmd.Bool("all-profiles", false, "index every profile in profiles.ini")
+		storeName := cmd.String("store", "", "sqlite (index.sqlite) or json (a shard file per folder); bleve also builds the Bleve full-text index for search --store bleve. Default: the kind the profile already has, sqlite when new")
+		includeTrash := cmd.Bool("include-trash", false, "also index trash folders")
+		includeSpam := cmd.Bool("include-spam", false, "also index spam/junk folders")
+		dryRun := cmd.Bool("dry-run", false, "list the folders that would be scanned, why, and how much, without indexing")
+		jobs := cmd.Int("jobs", 1, "scan up to N folders concurrently")
+		quiet := cmd.Bool("quiet", false, "do not report scan progress on stderr")
+		watch := cmd.Bool("watch", false, "keep running and update the index whenever folders change")
+		interval := cmd.Duration("interval", 10*time.Second, "with --watch: how long a change must hold still before re-indexing (the polling period without file notifications)")
+		watchFetch := cmd.Bool("fetch", false, "with --watch: also ingest changed folders into Postgres")
+		compress := cmd.String("compress", "", "with --store json: shard compression: gzip, zstd, or none (default: keep the current file's, gzip when new)")
+		encoding := cmd.String("encoding", "", "with --store json: shard encoding: json or gob (default: keep the current file's, json when new)")
+		indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+		assumeCharset := assumeCharsetFlag(cmd)
 		cmd.Parse(args[1:])
-		acct := *account
-		if acct == "" {
-			acct = *accountShort
+		indexDirOverride = *indexDir
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("index: %v", err)
+		}
+		compression, err := parseIndexCompression(*compress)
+		if err != nil {
+			log.Fatalf("index: %v", err)
+		}
+		indexEncoding, err := parseIndexEncoding(*encoding)
+		if err != nil {
+			log.Fatalf("index: %v", err)
+		}
+		var sinceTime time.Time
+		if *since != "" {
+			t, err := time.Parse("2006-01-02", *since)
+			if err != nil {
+				log.Fatalf("index: bad --since date (use YYYY-MM-DD): %v", err)
+			}
+			sinceTime = t
+		}
+		switch *storeName {
+		case "", "sqlite", "json", "bleve":
+		default:
+			log.Fatalf("index: unknown --store %q (use sqlite, json, or bleve)", *storeName)
+		}
+		progressQuiet = *quiet
+		opts := indexOptions{
+			folderLike:   *folderLike,
+			accounts:     accounts(),
+			tailCount:    *tailCount,
+			excludes:     *excludes,
+			full:         *full,
+			since:        sinceTime,
+			jobs:         *jobs,
+			store:        *storeName,
+			compression:  compression,
+			encoding:     indexEncoding,
+			includeTrash: *includeTrash,
+			includeSpam:  *includeSpam,
+			dryRun:       *dryRun,
+		}
+		if *dryRun && *watch {
+			log.Fatalf("index: --dry-run cannot be combined with --watch")
+		}
+		if *allProfiles {
+			if *watch || *profileName != "" {
+				log.Fatalf("index: --all-profiles cannot be combined with --watch or --profile")
+			}
+			if err := app.buildAllIndexes(opts); err != nil {
+				log.Fatalf("index: %v", err)
+			}
+			return
+		}
+		if *watch {
+			if err := app.watchIndex(*profileName, opts, watchOptions{interval: *interval, fetch: *watchFetch}); err != nil {
+				log.Fatalf("index: %v", err)
+			}
+			return
 		}
-		if err := app.buildIndex(*profileName, *folderLike, acct, *tailCount); err != nil {
+		if err := app.buildIndex(*profileName, opts); err != nil {
 			log.Fatalf("index: %v", err)
 		}
 	case "folders":
 		cmd := flag.NewFlagSet("folders", flag.ExitOnError)
 		profileName := cmd.String("profile", "", "profile name or path")
+		counts := cmd.Bool("counts", false, "also count the messages of each folder (from the local index where it is current, else by scanning)")
+		flat := cmd.Bool("flat", false, "list folder paths one per line instead of the tree")
+		format := cmd.String("format", "text", "output format: text, or json (the tree as nested objects)")
 		cmd.Parse(args[1:])
-		if err := app.printFolders(*profileName); err != nil {
+		if *format != "text" && *format != "json" {
+			log.Fatalf("folders: unknown --format %q (use text or json)", *format)
+		}
+		if err := app.printFolders(*profileName, *counts, *flat, *format); err != nil {
 			log.Fatalf("folders: %v", err)
 		}
+	case "folder-stats":
+		cmd := flag.NewFlagSet("folder-stats", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		folder := cmd.String("folder", "", "only folders whose name contains this")
+		sortBy := cmd.String("sort", "name", "order: name, size, messages, unread, oldest, or newest")
+		format := cmd.String("format", "text", "output format: text, or json (one object per folder)")
+		scan := cmd.Bool("scan", false, "read every folder instead of answering from the local index")
+		cmd.Parse(args[1:])
+		if *format != "text" && *format != "json" {
+			log.Fatalf("folder-stats: unknown --format %q (use text or json)", *format)
+		}
+		if err := app.folderStats(*profileName, *folder, *sortBy, *format, *scan); err != nil {
+			log.Fatalf("folder-stats: %v", err)
+		}
+	case "du":
+		cmd := flag.NewFlagSet("du", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		format := cmd.String("format", "text", "output format: text, or json (one object per account)")
+		cmd.Parse(args[1:])
+		if *format != "text" && *format != "json" {
+			log.Fatalf("du: unknown --format %q (use text or json)", *format)
+		}
+		if err := app.diskUsage(*profileName, *format); err != nil {
+			log.Fatalf("du: %v", err)
+		}
 	case "recent":
 		cmd := flag.NewFlagSet("recent", flag.ExitOnError)
 		profileName := cmd.String("profile", "", "profile name or path")
 		limit := cmd.Int("limit", 20, "max messages to show")
 		query := cmd.String("query", "", "substring filter against subject/from/body")
+		fuzzy := cmd.Bool("fuzzy", false, "match every word of --query anywhere (\"quoted phrases\" stay together) instead of the whole query as one substring")
+		excludes := cmd.StringArray("exclude", nil, "drop messages containing this term (repeatable)")
+		flagged := cmd.Bool("flagged", false, "only starred/flagged messages")
+		includeTrash := cmd.Bool("include-trash", false, "let the folder name match trash folders")
+		includeSpam := cmd.Bool("include-spam", false, "let the folder name match spam/junk folders")
+		assumeCharset := assumeCharsetFlag(cmd)
 		cmd.Parse(args[1:])
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("recent: %v", err)
+		}
 		pos := cmd.Args()
 		if len(pos) < 1 {
 			log.Fatalf("recent: folder name required (e.g. Inbox)")
 		}
-		if err := app.recent(pos[0], *profileName, *limit, *query); err != nil {
+		if err := app.recent(pos[0], *profileName, *limit, *query, *fuzzy, *excludes, *flagged, *includeTrash, *includeSpam); err != nil {
 			log.Fatalf("recent: %v", err)
 		}
 	case "search":
 		cmd := flag.NewFlagSet("search", flag.ExitOnError)
-		profileName := cmd.String("profile", "", "profile name or path")
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		profileNames := cmd.StringArray("profile", nil, "profile name or path; repeat (or comma-separate) to search several and merge the results")
 		folderLike := cmd.String("folder", "", "restrict to folders containing this name")
 		limit := cmd.Int("limit", 25, "max results across folders")
 		since := cmd.String("since", "", "only include messages on/after YYYY-MM-DD")
 		sinceShort := cmd.String("ds", "", "alias for --since")
 		till := cmd.String("till", "", "only include messages on/before YYYY-MM-DD")
 		tillShort := cmd.String("dt", "", "alias for --till")
-		account := cmd.String("account", "", "filter by account email")
-		accountShort := cmd.String("ac", "", "alias for --account")
+		accounts := accountFlags(cmd)
 		raw := cmd.Bool("raw", false, "plain output (no table; LLM-friendly)")
+		noColor := cmd.Bool("no-color", false, "disable match highlighting (also honors NO_COLOR)")
+		wide := cmd.Bool("wide", false, "do not truncate columns")
+		exportMbox := cmd.String("export-mbox", "", "also write the full matching messages to this mbox file")
+		exportEml := cmd.String("export-eml", "", "also save each matching message as .eml into this directory")
 		legacyNoFancy := cmd.Bool("no-fancy", false, "deprecated: use --raw")
 		refresh := cmd.Bool("refresh", false, "incremental refresh (ingest changed folders) before searching")
 		fullRescan := cmd.Bool("full-rescan", false, "force full rescan into Postgres before searching")
+		jobs := cmd.Int("jobs", 1, "scan up to N folders concurrently when refreshing the cache")
+		quiet := cmd.Bool("quiet", false, "do not report scan progress on stderr")
+		allProfiles := cmd.Bool("all-profiles", false, "search every profile in profiles.ini and merge the results")
+		storeName := cmd.String("store", firstNonEmpty(defaultStore(), "pg"), "search this store: pg (Postgres full-text index), index (the profile's local index, matching substrings), sqlite or json (the local index in that format), bleve (ranked local full-text index from tb mail index --store bleve), meili or es (Meilisearch or Elasticsearch, filled by tb mail sync); default $TB_STORE, else pg")
+		substring := cmd.Bool("substring", false, "with --store sqlite or bleve: match query terms anywhere in the text (invoice numbers, word fragments) instead of as whole words")
+		semantic := cmd.String("semantic", "", "rank by meaning: messages whose embedding is nearest this text, merged with keyword hits (needs tb mail embed)")
+		like := cmd.Bool("like", false, "with --store pg: match query terms anywhere in the text (invoice numbers, order IDs, word fragments) through the pg_trgm index instead of as whole words")
+		rank := cmd.String("rank", "", "with --store pg: order by relevance (ts_rank) or recency (relevance decayed by age) and show a SCORE column")
 		fuzzy := cmd.Bool("fuzzy", false, "fuzzy token match (all tokens must appear)")
+		fromQ := cmd.String("from", "", "match only the From header")
+		toQ := cmd.String("to", "", "match only the To/Cc headers")
+		subjectQ := cmd.String("subject", "", "match only the Subject header")
+		bodyQ := cmd.String("body", "", "match only the message body")
+		excludes := cmd.StringArray("exclude", nil, "drop hits containing this term (repeatable)")
+		larger := cmd.String("larger", "", "only messages larger than SIZE (e.g. 5M, 500K)")
+		smaller := cmd.String("smaller", "", "only messages smaller than SIZE (e.g. 10K)")
+		hasAttachment := cmd.Bool("has-attachment", false, "only messages with at least one attachment")
+		unread := cmd.Bool("unread", false, "only unread messages (X-Mozilla-Status)")
+		read := cmd.Bool("read", false, "only read messages (X-Mozilla-Status)")
+		flagged := cmd.Bool("flagged", false, "only starred/flagged messages")
+		tag := cmd.String("tag", "", "only messages carrying this Thunderbird tag (keyword or name)")
+		includeTrash := cmd.Bool("include-trash", false, "also search trash folders")
+		includeSpam := cmd.Bool("include-spam", false, "also search spam/junk folders")
+		groupBy := cmd.String("group-by", "", "print counts per sender|domain|folder|month|profile instead of rows")
+		sortBy := cmd.String("sort", "date", "order results by date|from|subject|folder|size")
+		reverse := cmd.Bool("reverse", false, "reverse the sort order")
+		assumeCharset := assumeCharsetFlag(cmd)
 		cmd.Parse(args[1:])
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("search: %v", err)
+		}
+		progressQuiet = *quiet
+		if !validSortKey(*sortBy) {
+			log.Fatalf("search: bad --sort %q (use %s)", *sortBy, strings.Join(sortKeys, "|"))
+		}
+		if _, ok := storeBackends[*storeName]; !ok {
+			log.Fatalf("search: unknown --store %q (use %s)", *storeName, storeNames())
+		}
+		if *substring && *storeName != "sqlite" && *storeName != "bleve" {
+			log.Fatalf("search: --substring needs --store sqlite or bleve (use --like with Postgres)")
+		}
+		if *like && *storeName != "pg" {
+			log.Fatalf("search: --like needs --store pg (use --store sqlite --substring locally)")
+		}
+		if *semantic != "" && *storeName != "pg" {
+			log.Fatalf("search: --semantic needs --store pg (embeddings live in Postgres)")
+		}
+		if *rank != "" {
+			switch {
+			case *rank != "relevance" && *rank != "recency":
+				log.Fatalf("search: unknown --rank %q (use relevance or recency)", *rank)
+			case *storeName != "pg":
+				log.Fatalf("search: --rank needs --store pg (ranking is Postgres ts_rank)")
+			case *semantic != "":
+				log.Fatalf("search: --rank cannot be combined with --semantic, which ranks by meaning")
+			case cmd.Changed("sort"):
+				log.Fatalf("search: --rank cannot be combined with --sort")
+			case *like:
+				log.Fatalf("search: --rank cannot be combined with --like (substring hits have no ts_rank)")
+			case len(cmd.Args()) == 0:
+				log.Fatalf("search: --rank needs a query to rank against")
+			}
+			*sortBy = "" // best match first
+		}
+		if (*semantic != "" || storeBackends[*storeName].ranked && !*substring) && !cmd.Changed("sort") {
+			*sortBy = "" // best match first
+		}
+		if *groupBy != "" && !validGroupKey(*groupBy) {
+			log.Fatalf("search: bad --group-by %q (use %s)", *groupBy, strings.Join(groupKeys, "|"))
+		}
 		pos := cmd.Args()
-		if len(pos) < 1 {
-			log.Fatalf("search: query required")
+		fieldScoped := *fromQ != "" || *toQ != "" || *subjectQ != "" || *bodyQ != ""
+		if len(pos) < 1 && !fieldScoped && *semantic == "" {
+			log.Fatalf("search: query required (or use --from/--to/--subject/--body/--semantic)")
+		}
+		query := ""
+		if len(pos) > 0 {
+			query = pos[0]
 		}
 		useRaw := *raw || *legacyNoFancy
 		var sinceTime time.Time
@@ -192,13 +539,85 @@ func mailMain(args []string) {
 			}
 			tillTime = t.Add(24 * time.Hour)
 		}
-		acct := *account
-		if acct == "" {
-			acct = *accountShort
+		if *unread && *read {
+			log.Fatalf("search: --unread and --read are mutually exclusive")
 		}
-		if err := app.search(pos[0], *profileName, *folderLike, acct, *limit, useRaw, sinceTime, tillTime, *refresh, *fullRescan, *fuzzy); err != nil {
+		largerBytes, err := parseByteSize(*larger)
+		if err != nil {
+			log.Fatalf("search: bad --larger: %v", err)
+		}
+		smallerBytes, err := parseByteSize(*smaller)
+		if err != nil {
+			log.Fatalf("search: bad --smaller: %v", err)
+		}
+		q := queryOptions{
+			query:         query,
+			from:          *fromQ,
+			to:            *toQ,
+			subject:       *subjectQ,
+			body:          *bodyQ,
+			excludes:      *excludes,
+			larger:        largerBytes,
+			smaller:       smallerBytes,
+			hasAttachment: *hasAttachment,
+			unread:        *unread,
+			read:          *read,
+			flagged:       *flagged,
+			tag:           *tag,
+			groupBy:       *groupBy,
+			sortBy:        *sortBy,
+			reverse:       *reverse,
+			accounts:      accounts(),
+			folderLike:    *folderLike,
+			since:         sinceTime,
+			till:          tillTime,
+			limit:         *limit,
+			substring:     *substring,
+			includeTrash:  *includeTrash,
+			includeSpam:   *includeSpam,
+			semantic:      *semantic,
+			rank:          *rank,
+			like:          *like,
+		}
+		out := outputOptions{raw: useRaw, color: colorEnabled(*noColor), wide: *wide, showScore: *rank != "", exportMbox: *exportMbox, exportEml: *exportEml}
+		if *allProfiles && len(*profileNames) > 0 {
+			log.Fatalf("search: --all-profiles cannot be combined with --profile")
+		}
+		if err := app.search(splitProfiles(*profileNames), *allProfiles, q, out, *storeName, *refresh, *fullRescan, *fuzzy, *jobs); err != nil {
 			log.Fatalf("search: %v", err)
 		}
+	case "open":
+		cmd := flag.NewFlagSet("open", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		profileName := cmd.String("profile", "", "profile name or path")
+		messageID := cmd.String("message-id", "", "Message-Id of the message to open")
+		cmd.Parse(args[1:])
+		hit := 0
+		if *messageID == "" {
+			if cmd.NArg() != 1 {
+				log.Fatalf("open: pass --message-id <id> or a hit number from the last search")
+			}
+			n, err := strconv.Atoi(cmd.Arg(0))
+			if err != nil {
+				log.Fatalf("open: invalid hit number %q", cmd.Arg(0))
+			}
+			hit = n
+		}
+		if err := app.openMessage(*profileName, *messageID, hit); err != nil {
+			log.Fatalf("open: %v", err)
+		}
+	case "mailto":
+		cmd := flag.NewFlagSet("mailto", flag.ExitOnError)
+		to := cmd.String("to", "", "comma-separated recipients")
+		cc := cmd.String("cc", "", "cc recipients")
+		subject := cmd.String("subject", "", "subject")
+		body := cmd.String("body", "", "body text")
+		cmd.Parse(args[1:])
+		if *to == "" && *cc == "" {
+			log.Fatalf("mailto: --to or --cc is required")
+		}
+		fmt.Println(mailtoURI(*to, *cc, *subject, *body))
 	case "compose":
 		cmd := flag.NewFlagSet("compose", flag.ExitOnError)
 		to := cmd.String("to", "", "comma-separated recipients")
@@ -219,43 +638,407 @@ func mailMain(args []string) {
 		}
 	case "fetch":
 		cmd := flag.NewFlagSet("fetch", flag.ExitOnError)
+		pgDSNFlag(cmd)
 		profileName := cmd.String("profile", "", "profile name or path")
 		folderLike := cmd.String("folder", "", "restrict to folders containing this name")
-		account := cmd.String("account", "", "filter by account email")
-		accountShort := cmd.String("ac", "", "alias for --account")
+		accounts := accountFlags(cmd)
 		syncFirst := cmd.Bool("sync", false, "run Thunderbird/Betterbird headless sync before ingest")
 		prune := cmd.Bool("prune", false, "delete DB rows for this profile that are no longer present on disk")
 		fullRescan := cmd.Bool("full", false, "force full rescan instead of incremental ingest")
 		maxScan := cmd.Int("max-messages", 0, "optional cap per folder during ingest (0 = all)")
 		tailCount := cmd.Int("tail", 0, "keep only last N messages per folder during ingest (0 = all)")
+		jobs := cmd.Int("jobs", 1, "scan up to N folders concurrently")
+		batchSize := cmd.Int("batch-size", defaultUpsertBatch, "messages copied into Postgres per transaction")
+		quiet := cmd.Bool("quiet", false, "do not report scan progress on stderr")
+		assumeCharset := assumeCharsetFlag(cmd)
+		imap := cmd.Bool("imap", false, "read --folder (default INBOX) straight from the account's IMAP server instead of ingesting into Postgres")
+		headers := cmd.Bool("headers", false, "with --imap: list the newest --tail messages' headers (default 20) instead of downloading")
+		since := cmd.String("since", "", "with --imap: only messages received on/after YYYY-MM-DD")
+		format := cmd.String("format", "text", "with --imap --headers: text, or json (one object per message)")
 		cmd.Parse(args[1:])
-		acct := *account
-		if acct == "" {
-			acct = *accountShort
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("fetch: %v", err)
+		}
+		progressQuiet = *quiet
+		if *imap {
+			if *format != "text" && *format != "json" {
+				log.Fatalf("fetch: unknown --format %q (use text or json)", *format)
+			}
+			opts := imapFetchOptions{folder: firstNonEmpty(*folderLike, "INBOX"), maxMessages: *maxScan, headers: *headers, tail: *tailCount, format: *format}
+			switch accts := accounts(); len(accts) {
+			case 0:
+			case 1:
+				opts.account = accts[0]
+			default:
+				log.Fatalf("fetch: --imap reads one account at a time")
+			}
+			if opts.tail <= 0 {
+				opts.tail = 20
+			}
+			if *since != "" {
+				t, err := time.Parse("2006-01-02", *since)
+				if err != nil {
+					log.Fatalf("fetch: bad --since date (use YYYY-MM-DD): %v", err)
+				}
+				opts.since = t
+			}
+			if err := app.imapFetch(*profileName, opts); err != nil {
+				log.Fatalf("fetch: %v", err)
+			}
+			return
 		}
-		if err := app.fetch(*profileName, *folderLike, acct, *syncFirst, *prune, *fullRescan, *maxScan, *tailCount); err != nil {
+		if err := app.fetch(*profileName, *folderLike, accounts(), *syncFirst, *prune, *fullRescan, *maxScan, *tailCount, *jobs, *batchSize); err != nil {
 			log.Fatalf("fetch: %v", err)
 		}
+	case "import":
+		cmd := flag.NewFlagSet("import", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		profileName := cmd.String("profile", "", "profile name or path")
+		label := cmd.String("folder-label", "", "folder name for the imported mail, under Imported/ (default: the file name)")
+		storeName := cmd.String("store", "", "also upsert into pg, meili, or es (default: the local index only)")
+		list := cmd.Bool("list", false, "list the mbox files imported into the profile")
+		remove := cmd.String("remove", "", "forget the import with this label")
+		jobs := cmd.Int("jobs", 1, "with --store: scan up to N folders concurrently")
+		batchSize := cmd.Int("batch-size", defaultUpsertBatch, "with --store pg: messages copied into the store per transaction")
+		quiet := cmd.Bool("quiet", false, "do not report scan progress on stderr")
+		assumeCharset := assumeCharsetFlag(cmd)
+		cmd.Parse(args[1:])
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("import: %v", err)
+		}
+		progressQuiet = *quiet
+		switch {
+		case *list:
+			if err := app.listImports(*profileName); err != nil {
+				log.Fatalf("import: %v", err)
+			}
+			return
+		case *remove != "":
+			profile, err := app.resolveProfile(*profileName)
+			if err == nil {
+				err = removeImport(profile, *remove)
+			}
+			if err != nil {
+				log.Fatalf("import: %v", err)
+			}
+			fmt.Printf("Removed %s%s; run tb mail index to drop it from the local index and sync --prune for stores.\n", importedFolderPrefix, *remove)
+			return
+		case cmd.NArg() != 1:
+			log.Fatalf("import: give one mbox file (tb mail import path/to/file.mbox --folder-label name)")
+		}
+		opts := syncOptions{
+			store:     *storeName,
+			batchSize: *batchSize,
+			index:     indexOptions{tailCount: defaultIndexTail, jobs: *jobs},
+		}
+		if err := app.importMbox(*profileName, cmd.Arg(0), *label, opts); err != nil {
+			log.Fatalf("import: %v", err)
+		}
+	case "sync":
+		cmd := flag.NewFlagSet("sync", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		profileName := cmd.String("profile", "", "profile name or path")
+		storeName := cmd.String("store", "pg", "where to upsert messages: pg (Postgres via TB_PG_DSN), meili (Meilisearch via TB_MEILI_URL), or es (Elasticsearch via TB_ES_URL)")
+		folderLike := cmd.String("folder", "", "restrict to folders containing this name")
+		accounts := accountFlags(cmd)
+		full := cmd.Bool("full", false, "rescan every folder, not just those changed since the last run")
+		prune := cmd.Bool("prune", false, "delete stored messages that are no longer on disk (implies --full)")
+		jobs := cmd.Int("jobs", 1, "scan up to N folders concurrently")
+		batchSize := cmd.Int("batch-size", defaultUpsertBatch, "messages copied into the store per transaction")
+		bodies := cmd.Bool("bodies", false, "also store each message's full decoded body, so show --store pg works without the mbox")
+		rawBodies := cmd.Bool("raw-bodies", false, "with --bodies, keep the original RFC822 message too (attachments and all)")
+		daemon := cmd.Bool("daemon", false, "keep running and sync changed folders every --interval until interrupted")
+		interval := cmd.Duration("interval", 5*time.Minute, "with --daemon: how often to check folders for changes")
+		allProfiles := cmd.Bool("all-profiles", false, "sync every profile in profiles.ini")
+		quiet := cmd.Bool("quiet", false, "do not report scan progress on stderr")
+		assumeCharset := assumeCharsetFlag(cmd)
+		cmd.Parse(args[1:])
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("sync: %v", err)
+		}
+		if *rawBodies && !*bodies {
+			log.Fatalf("sync: --raw-bodies requires --bodies")
+		}
+		if cmd.Changed("interval") && !*daemon {
+			log.Fatalf("sync: --interval requires --daemon")
+		}
+		if _, ok := engineSections[*storeName]; ok && (*bodies || *daemon) {
+			log.Fatalf("sync: --bodies and --daemon need --store pg (%s only takes message summaries)", *storeName)
+		}
+		progressQuiet = *quiet
+		opts := syncOptions{
+			store:     *storeName,
+			prune:     *prune,
+			batchSize: *batchSize,
+			bodies:    *bodies,
+			rawBodies: *rawBodies,
+			daemon:    *daemon,
+			interval:  *interval,
+			index: indexOptions{
+				folderLike: *folderLike,
+				accounts:   accounts(),
+				tailCount:  defaultIndexTail,
+				full:       *full,
+				jobs:       *jobs,
+			},
+		}
+		if *allProfiles {
+			if *daemon || *profileName != "" {
+				log.Fatalf("sync: --all-profiles cannot be combined with --daemon or --profile")
+			}
+			if err := app.syncAllProfiles(opts); err != nil {
+				log.Fatalf("sync: %v", err)
+			}
+			return
+		}
+		if err := app.syncStore(*profileName, opts); err != nil {
+			log.Fatalf("sync: %v", err)
+		}
+	case "embed":
+		cmd := flag.NewFlagSet("embed", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		profileName := cmd.String("profile", "", "profile name or path")
+		storeName := cmd.String("store", "pg", "where messages and embeddings live: pg (Postgres with pgvector)")
+		batch := cmd.Int("batch", 32, "messages sent to the model per request")
+		limit := cmd.Int("limit", 0, "embed at most N messages this run, newest first (0 = all)")
+		quiet := cmd.Bool("quiet", false, "do not report progress on stderr")
+		cmd.Parse(args[1:])
+		progressQuiet = *quiet
+		if err := app.embedMessages(*profileName, *storeName, *batch, *limit); err != nil {
+			log.Fatalf("embed: %v", err)
+		}
+	case "attachments":
+		if len(args) > 1 && args[1] == "search" {
+			cmd := flag.NewFlagSet("attachments search", flag.ExitOnError)
+			pgDSNFlag(cmd)
+			storeReadOnlyFlag(cmd)
+			profileName := cmd.String("profile", "", "profile name or path")
+			storeName := cmd.String("store", "pg", "where attachment metadata lives: pg (recorded by tb mail sync)")
+			contentType := cmd.String("type", "", "only attachments whose content type contains this (e.g. pdf, image/)")
+			from := cmd.String("from", "", "only attachments from senders containing this")
+			sum := cmd.String("sha256", "", "only attachments with this SHA-256 (hex)")
+			accounts := accountFlags(cmd)
+			larger := cmd.String("larger", "", "only attachments larger than SIZE (e.g. 5M, 500K)")
+			smaller := cmd.String("smaller", "", "only attachments smaller than SIZE (e.g. 10K)")
+			limit := cmd.Int("limit", 50, "max attachments to list (0 = all)")
+			cmd.Parse(args[2:])
+			largerBytes, err := parseByteSize(*larger)
+			if err != nil {
+				log.Fatalf("attachments search: --larger: %v", err)
+			}
+			smallerBytes, err := parseByteSize(*smaller)
+			if err != nil {
+				log.Fatalf("attachments search: --smaller: %v", err)
+			}
+			q := attachmentQuery{
+				name:        strings.Join(cmd.Args(), " "),
+				contentType: *contentType,
+				from:        *from,
+				sha256:      *sum,
+				accounts:    accounts(),
+				larger:      largerBytes,
+				smaller:     smallerBytes,
+				limit:       *limit,
+			}
+			if err := app.searchAttachments(*profileName, *storeName, q); err != nil {
+				log.Fatalf("attachments search: %v", err)
+			}
+			return
+		}
+		cmd := flag.NewFlagSet("attachments", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		profileName := cmd.String("profile", "", "profile name or path")
+		folderLike := cmd.String("folder", "", "folder name/substring to search")
+		query := cmd.String("query", "", "substring match against subject/from/body")
+		messageID := cmd.String("message-id", "", "use the message with this Message-Id (searches all folders)")
+		limit := cmd.Int("limit", 1, "max messages to process")
+		accounts := accountFlags(cmd)
+		saveDir := cmd.String("save-dir", "", "write decoded attachments into this directory (list only when empty)")
+		assumeCharset := assumeCharsetFlag(cmd)
+		cmd.Parse(args[1:])
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("attachments: %v", err)
+		}
+		if *messageID == "" && (*folderLike == "" || *query == "") {
+			log.Fatalf("attachments: --folder and --query are required (or use --message-id)")
+		}
+		opts := showOptions{
+			folderLike:      *folderLike,
+			query:           *query,
+			accounts:        accounts(),
+			limit:           *limit,
+			messageID:       *messageID,
+			saveAttachments: *saveDir,
+			attachmentsOnly: true,
+		}
+		if err := app.showMail(*profileName, opts); err != nil {
+			log.Fatalf("attachments: %v", err)
+		}
+	case "contacts":
+		cmd := flag.NewFlagSet("contacts", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		profileName := cmd.String("profile", "", "profile name or path")
+		storeName := cmd.String("store", "pg", "where correspondents live: pg (recorded by tb mail sync)")
+		search := cmd.String("search", "", "only addresses or names containing this (e.g. acme.com)")
+		sortBy := cmd.String("sort", "last", "order by last, first (seen), messages, or address")
+		limit := cmd.Int("limit", 50, "max contacts to list (0 = all)")
+		cmd.Parse(args[1:])
+		q := contactQuery{search: *search, sortBy: *sortBy, limit: *limit}
+		if q.search == "" {
+			q.search = strings.Join(cmd.Args(), " ")
+		}
+		if err := app.listContacts(*profileName, *storeName, q); err != nil {
+			log.Fatalf("contacts: %v", err)
+		}
+	case "thread":
+		cmd := flag.NewFlagSet("thread", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		profileName := cmd.String("profile", "", "profile name or path")
+		folderLike := cmd.String("folder", "", "only scan the first folder matching this name (default: all folders)")
+		messageID := cmd.String("message-id", "", "render the conversation containing this Message-Id")
+		fuzzy := cmd.Bool("fuzzy", false, "match every word of --query anywhere (\"quoted phrases\" stay together) instead of the whole query as one substring")
+		accounts := accountFlags(cmd)
+		storeName := cmd.String("store", "", "read the reply graph sync stored instead of scanning folders: pg (the argument is then a Message-Id)")
+		assumeCharset := assumeCharsetFlag(cmd)
+		cmd.Parse(args[1:])
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("thread: %v", err)
+		}
+		query := strings.Join(cmd.Args(), " ")
+		if *storeName != "" {
+			id := *messageID
+			if id == "" {
+				id = query
+			}
+			switch {
+			case *storeName != "pg":
+				log.Fatalf("thread: unknown --store %q (use pg)", *storeName)
+			case id == "":
+				log.Fatalf("thread: --store pg needs a Message-Id")
+			case *folderLike != "" || len(accounts()) > 0:
+				log.Fatalf("thread: --folder/--account do not apply with --store pg, which follows the conversation everywhere")
+			}
+			if err := app.threadTreePG(*profileName, id); err != nil {
+				log.Fatalf("thread: %v", err)
+			}
+			return
+		}
+		if query == "" && *messageID == "" {
+			log.Fatalf("thread: query or --message-id required")
+		}
+		opts := showOptions{
+			folderLike: *folderLike,
+			query:      query,
+			fuzzy:      *fuzzy,
+			accounts:   accounts(),
+			messageID:  *messageID,
+		}
+		if err := app.threadTree(*profileName, opts); err != nil {
+			log.Fatalf("thread: %v", err)
+		}
 	case "help", "-h", "--help":
 		mailUsage()
 	case "show":
 		cmd := flag.NewFlagSet("show", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
 		profileName := cmd.String("profile", "", "profile name or path")
 		folderLike := cmd.String("folder", "", "folder name/substring to search")
 		query := cmd.String("query", "", "substring match against subject/from/body")
+		fuzzy := cmd.Bool("fuzzy", false, "match every word of --query anywhere (\"quoted phrases\" stay together) instead of the whole query as one substring")
 		limit := cmd.Int("limit", 1, "max messages to display")
-		account := cmd.String("account", "", "filter by account email")
-		accountShort := cmd.String("ac", "", "alias for --account")
-		thread := cmd.Bool("thread", false, "if set, show entire thread (same subject) after first match")
+		accounts := accountFlags(cmd)
+		thread := cmd.Bool("thread", false, "if set, show the whole conversation (References/In-Reply-To) of the first match")
+		excludes := cmd.StringArray("exclude", nil, "skip messages containing this term (repeatable)")
+		exportEml := cmd.String("export-eml", "", "also save each shown message as .eml into this directory")
+		savePath := cmd.String("save", "", "write the raw original message to this file (or into this directory); never overwrites")
+		messageID := cmd.String("message-id", "", "show the message with this Message-Id (searches all folders)")
+		rawOut := cmd.Bool("raw", false, "print the untouched RFC822 message (headers, MIME parts, attachments)")
+		allHeaders := cmd.Bool("headers", false, "print all headers in message order")
+		headerNames := cmd.StringSlice("header", nil, "extra headers to print, comma-separated or repeated (e.g. Received,List-Id)")
+		saveAtts := cmd.String("save-attachments", "", "write decoded attachments into this directory")
+		noLinks := cmd.Bool("no-links", false, "omit the numbered Links: section built from HTML parts")
+		stripTracking := cmd.Bool("strip-tracking", false, "unwrap click-tracking redirects and drop utm_* parameters in Links:")
+		jsonOut := cmd.Bool("json", false, "print each message as a JSON object (headers, body, parts)")
+		auth := cmd.Bool("auth", false, "summarize Authentication-Results, Received-SPF and DKIM-Signature headers")
+		verifyDKIM := cmd.Bool("verify-dkim", false, "with --auth, re-verify DKIM signatures over the raw message (DNS lookups)")
+		mailtoReply := cmd.Bool("mailto-reply", false, "print a mailto: URI that replies to each message instead of showing it")
+		mailtoReplyAll := cmd.Bool("mailto-reply-all", false, "like --mailto-reply, copying the other recipients but not your own identities")
+		format := cmd.String("format", "text", "output format: text, json, or mbox (valid mbox of the original messages)")
+		delimiter := cmd.String("delimiter", "", "separator printed after each message in text output instead of a row of dashes (Go escapes like \\x00 allowed)")
+		noCrypto := cmd.Bool("no-crypto", false, "do not call gpg/openssl to verify signatures or decrypt PGP and S/MIME messages")
+		saveVCards := cmd.String("save-vcards", "", "write vCards found in the message into this directory as .vcf")
+		fullQuotes := cmd.Bool("full-quotes", false, "print quoted reply history instead of folding it to a marker")
+		nth := cmd.Int("nth", 0, "show the Nth message of --folder (1 = oldest, -1 = newest); --query is optional")
+		next := cmd.String("next", "", "show the message after this Message-Id in its folder")
+		prev := cmd.String("prev", "", "show the message before this Message-Id in its folder")
+		storeName := cmd.String("store", "", "read messages from this store instead of the mbox files: pg (needs sync --bodies)")
+		assumeCharset := assumeCharsetFlag(cmd)
 		cmd.Parse(args[1:])
-		if *folderLike == "" || *query == "" {
-			log.Fatalf("show: --folder and --query are required")
+		if err := assumeCharset(); err != nil {
+			log.Fatalf("show: %v", err)
+		}
+		if *jsonOut {
+			*format = "json"
 		}
-		acct := *account
-		if acct == "" {
-			acct = *accountShort
+		if *format != "text" && *format != "json" && *format != "mbox" {
+			log.Fatalf("show: unknown --format %q (use text, json, or mbox)", *format)
 		}
-		if err := app.showMail(*profileName, *folderLike, *query, acct, *limit, *thread); err != nil {
+		delim := *delimiter
+		if delim == "" {
+			delim = strings.Repeat("-", 80)
+		}
+		if unq, err := strconv.Unquote(`"` + strings.ReplaceAll(delim, `"`, `\"`) + `"`); err == nil {
+			delim = unq
+		}
+		relativeTo, step := *next, 1
+		if *prev != "" {
+			relativeTo, step = *prev, -1
+		}
+		switch {
+		case *storeName != "" && *storeName != "pg":
+			log.Fatalf("show: unknown --store %q (use pg)", *storeName)
+		case *next != "" && *prev != "":
+			log.Fatalf("show: use only one of --next and --prev")
+		case *nth != 0 && *folderLike == "":
+			log.Fatalf("show: --nth requires --folder")
+		case *messageID == "" && relativeTo == "" && *nth == 0 && (*folderLike == "" || *query == ""):
+			log.Fatalf("show: --folder and --query are required (or use --message-id, --nth, --next/--prev)")
+		}
+		opts := showOptions{
+			folderLike:      *folderLike,
+			query:           *query,
+			fuzzy:           *fuzzy,
+			accounts:        accounts(),
+			limit:           *limit,
+			thread:          *thread,
+			excludes:        *excludes,
+			exportEml:       *exportEml,
+			messageID:       *messageID,
+			raw:             *rawOut,
+			allHeaders:      *allHeaders,
+			headerNames:     *headerNames,
+			saveAttachments: *saveAtts,
+			noLinks:         *noLinks,
+			stripTracking:   *stripTracking,
+			format:          *format,
+			delimiter:       delim,
+			mailtoReply:     *mailtoReply || *mailtoReplyAll,
+			mailtoReplyAll:  *mailtoReplyAll,
+			savePath:        *savePath,
+			auth:            *auth || *verifyDKIM,
+			verifyDKIM:      *verifyDKIM,
+			noCrypto:        *noCrypto,
+			saveVCards:      *saveVCards,
+			fullQuotes:      *fullQuotes,
+			nth:             *nth,
+			relativeTo:      relativeTo,
+			step:            step,
+			store:           *storeName,
+		}
+		if err := app.showMail(*profileName, opts); err != nil {
 			log.Fatalf("show: %v", err)
 		}
 	default:
@@ -267,25 +1050,46 @@ func mailUsage() {
 	log.Println("Usage: tb mail <command> [options]")
 	log.Println("Commands:")
 	log.Println("  profiles                             list Thunderbird profiles from profiles.ini")
-	log.Println("  folders [--profile name]             list mailboxes for a profile")
-	log.Println("  recent <folder> [--query q]          show recent messages from a folder")
-	log.Println("  search <query> [--since/--ds YYYY-MM-DD] [--till/--dt YYYY-MM-DD] [--account/--ac email] [--folder name] [--refresh] [--full-rescan] [--raw] [--fuzzy]")
-	log.Println("  index [--profile p] [--folder f] [--account/--ac email] [--tail N]   prebuild cache for faster search")
-	log.Println("  fetch [--profile p] [--sync] [--prune] [--full] [--account/--ac email] [--folder f] [--max-messages N] [--tail N]  ingest mail into Postgres cache")
-	log.Println("  show/read --folder <name> --query <text> [--profile p] [--account/--ac email] [--limit N] [--thread]  print full messages matching substring (optionally whole thread)")
+	log.Println("  accounts [--profile name] [--format text|json]  list the profile's accounts: server type, host, user, emails, folder directory")
+	log.Println("  identities [--profile name] [--account key|email] [--format text|json]  list the addresses you send as, with names, reply-to, and signatures")
+	log.Println("  folders [--profile name] [--counts] [--flat] [--format text|json]  show a profile's folder tree with sizes")
+	log.Println("  folder-stats [--profile name] [--folder f] [--sort name|size|messages|unread|oldest|newest] [--scan] [--format text|json]  messages, unread, date range, and size per folder")
+	log.Println("  du [--profile name] [--format text|json]  disk usage per account and top-level folder, with .msf and other overhead")
+	log.Println("  recent <folder> [--query q [--fuzzy]] [--exclude term] [--flagged] [--include-trash] [--include-spam]  show recent messages from a folder")
+	log.Println("  search <query> [--since/--ds YYYY-MM-DD] [--till/--dt YYYY-MM-DD] [--account/--ac email]... [--folder name] [--from/--to/--subject/--body text] [--exclude term]... [--larger/--smaller SIZE] [--has-attachment] [--unread|--read] [--flagged] [--tag name] [--include-trash] [--include-spam] [--sort key] [--reverse] [--group-by key] [--store pg|index|sqlite|json|bleve|meili|es] [--substring] [--rank relevance|recency] [--like] [--store-readonly] [--semantic text] [--profile p]... [--all-profiles] [--refresh] [--full-rescan] [--jobs N] [--quiet] [--raw] [--no-color] [--wide] [--export-mbox file] [--export-eml dir] [--fuzzy]")
+	log.Println("  index [--profile p] [--folder f] [--account/--ac email]... [--tail N] [--since YYYY-MM-DD] [--exclude term] [--full] [--store sqlite|json|bleve] [--include-trash] [--include-spam] [--all-profiles] [--dry-run] [--jobs N] [--quiet] [--watch [--interval 10s] [--fetch]] [--compress gzip|zstd|none] [--encoding json|gob] [--index-dir d]   build/update the local message index (SQLite, or JSON shards with --store json)")
+	log.Println("  index stats [--profile p] [--index-dir d]  per-folder counts, date ranges, and staleness of the local index")
+	log.Println("  index verify [--profile p] [--index-dir d] [--sample N] [--repair [--tail N] [--exclude term]]  check the local index against the mbox files")
+	log.Println("  fetch [--profile p] [--sync] [--prune] [--full] [--account/--ac email]... [--folder f] [--max-messages N] [--tail N] [--jobs N] [--batch-size N] [--quiet]  ingest mail into Postgres cache")
+	log.Println("  fetch --imap [--profile p] [--account email] [--folder INBOX] [--since YYYY-MM-DD] [--max-messages N] [--headers [--tail N] [--format text|json]]  read a folder straight from the IMAP server")
+	log.Println("  import <file.mbox> [--folder-label name] [--profile p] [--store pg|meili|es] [--jobs N] [--batch-size N] [--quiet] | import --list | import --remove label  index an mbox from outside the profile (e.g. another client's export) as folder Imported/<label>")
+	log.Println("  sync [--store pg|meili|es] [--profile p] [--account/--ac email]... [--folder f] [--full] [--prune] [--jobs N] [--batch-size N] [--bodies [--raw-bodies]] [--daemon [--interval 5m]] [--all-profiles] [--quiet]  update the local index, then upsert changed folders into the store with per-folder counts")
+	log.Println("  embed [--store pg] [--profile p] [--batch N] [--limit N] [--quiet]  compute message embeddings into pgvector for search --semantic (model from TB_EMBED_CMD or TB_EMBED_URL + TB_EMBED_MODEL)")
+	log.Println("  show/read (--folder <name> --query <text> | --message-id <id> | --folder <name> --nth N | --next/--prev <id>) [--profile p] [--account/--ac email]... [--fuzzy] [--limit N] [--thread] [--raw] [--headers | --header X,Y] [--save-attachments dir] [--no-links] [--strip-tracking] [--json | --format text|json|mbox] [--delimiter s] [--mailto-reply | --mailto-reply-all] [--auth [--verify-dkim]] [--no-crypto] [--save-vcards dir] [--full-quotes] [--save file] [--export-eml dir] [--store pg [--store-readonly]]  print full messages matching substring (optionally whole thread)")
+	log.Println("  thread <query> [--message-id id] [--folder f] [--account/--ac email]... [--fuzzy]  render a conversation as a reply tree")
+	log.Println("  thread --store pg <message-id> [--profile p] [--store-readonly]  render a conversation from the reply graph sync stored, across all folders and accounts")
+	log.Println("  attachments (--folder <name> --query <text> | --message-id <id>) [--save-dir dir] [--limit N]  list or extract attachments")
+	log.Println("  attachments search [filename] [--store pg] [--profile p] [--type t] [--from s] [--sha256 hex] [--account/--ac email]... [--larger/--smaller SIZE] [--limit N] [--store-readonly]  find attachments by name, type, sender, or hash in the metadata sync records")
+	log.Println("  contacts [--store pg] [--profile p] [--store-readonly] [--search text] [--sort last|first|messages|address] [--limit N]  list correspondents with first/last seen dates and message counts")
+	log.Println("  open (<hit#> | --message-id <id>) [--profile p] [--store-readonly]  open a message in the Thunderbird GUI")
+	log.Println("  mailto --to ... [--cc] [--subject] [--body]  print an escaped mailto: URI (show --mailto-reply for replies)")
 	log.Println("  compose/send --to ...                open/send via Thunderbird composer")
 }
 
 func newApp() *App {
 	root := os.Getenv("THUNDERBIRD_HOME")
 	if root == "" {
-		root = filepath.Join(os.Getenv("HOME"), ".thunderbird")
+		root = defaultThunderbirdRoot()
+	}
+	dir := profileDirOverride
+	if dir == "" {
+		dir = strings.TrimSpace(os.Getenv("TB_PROFILE_DIR"))
 	}
-	return &App{Root: root}
+	return &App{Root: root, ProfileDir: dir}
 }
 
 func indexPath(profile Profile) string {
-	return filepath.Join(profile.AbsolutePath, ".tb-index.json")
+	return indexFile(profile, "index.json")
 }
 
 func (a *App) printProfiles() error {
@@ -308,40 +1112,26 @@ func (a *App) printProfiles() error {
 	return nil
 }
 
-func (a *App) printFolders(profileName string) error {
+func (a *App) recent(folder, profileName string, limit int, query string, fuzzy bool, excludes []string, flagged, includeTrash, includeSpam bool) error {
 	profile, err := a.resolveProfile(profileName)
 	if err != nil {
 		return err
 	}
-	boxes, err := a.listMailboxes(profile)
+	all, err := a.listMailboxes(profile)
 	if err != nil {
 		return err
 	}
-	if len(boxes) == 0 {
-		fmt.Printf("No mailboxes found under %s\n", profile.AbsolutePath)
-		return nil
-	}
-	fmt.Printf("Mailboxes for %s (%s):\n", profile.Name, profile.AbsolutePath)
-	for _, b := range boxes {
-		fmt.Printf("- %s [%s]\n", b.Name, byteSize(b.Size))
-	}
-	return nil
-}
-
-func (a *App) recent(folder, profileName string, limit int, query string) error {
-	profile, err := a.resolveProfile(profileName)
-	if err != nil {
-		return err
-	}
-	boxes, err := a.listMailboxes(profile)
-	if err != nil {
-		return err
+	var boxes []Mailbox
+	for _, b := range all {
+		if !skipTaggedFolder(b.Name, folder, includeTrash, includeSpam) {
+			boxes = append(boxes, b)
+		}
 	}
 	box, ok := findMailbox(boxes, folder)
 	if !ok {
 		return fmt.Errorf("folder %s not found; try `tb mail folders --profile %s`", folder, profile.Name)
 	}
-	messages, err := readMailboxRecent(box, limit, query)
+	messages, err := readMailboxRecent(box, limit, query, fuzzy, excludes, flagged)
 	if err != nil {
 		return err
 	}
@@ -352,25 +1142,304 @@ func (a *App) recent(folder, profileName string, limit int, query string) error
 	fmt.Printf("Recent from %s (profile %s):\n", box.Name, profile.Name)
 	for i := len(messages) - 1; i >= 0; i-- {
 		m := messages[i]
-		fmt.Printf("%-16s | %-40s | %-40s | %s\n", m.Date, truncate(m.From, 38), truncate(m.Subject, 60), truncate(m.Snippet, 80))
+		fmt.Printf("%-2s %-16s | %-40s | %-40s | %s\n", statusMarks(m), m.Date, truncate(m.From, 38), truncate(m.Subject, 60), truncate(m.Snippet, 80))
 	}
 	return nil
 }
 
-func (a *App) search(query, profileName, folderLike, accountEmail string, limit int, raw bool, since, till time.Time, refresh bool, fullRescan bool, fuzzy bool) error {
-	_ = fuzzy // currently token AND matching in Postgres
-	profile, err := a.resolveProfile(profileName)
+func (a *App) search(profileNames []string, allProfiles bool, q queryOptions, out outputOptions, storeName string, refresh bool, fullRescan bool, fuzzy bool, jobs int) error {
+	_ = fuzzy // every store already matches each term of the query
+	var profiles []Profile
+	switch {
+	case allProfiles:
+		all, err := a.loadProfiles()
+		if err != nil {
+			return fmt.Errorf("load profiles: %w", err)
+		}
+		if len(all) == 0 {
+			return fmt.Errorf("no profiles found in %s", filepath.Join(a.Root, "profiles.ini"))
+		}
+		profiles = all
+	case len(profileNames) == 0:
+		profile, err := a.resolveProfile("")
+		if err != nil {
+			return err
+		}
+		profiles = []Profile{profile}
+	default:
+		for _, name := range profileNames {
+			profile, err := a.resolveProfile(name)
+			if err != nil {
+				return err
+			}
+			if !slices.ContainsFunc(profiles, func(p Profile) bool { return p.AbsolutePath == profile.AbsolutePath }) {
+				profiles = append(profiles, profile)
+			}
+		}
+	}
+	ctx := context.Background()
+
+	// Shared stores (Postgres) are opened at most once, however many
+	// profiles are searched.
+	shared := map[string]Store{}
+	openStore := func(name string) (Store, error) {
+		if st, ok := shared[name]; ok {
+			return st, nil
+		}
+		backend := storeBackends[name]
+		st, err := backend.open(a, Profile{})
+		if err != nil {
+			return nil, fmt.Errorf("%s required for search: %w", backend.label, err)
+		}
+		shared[name] = st
+		return st, nil
+	}
+	defer func() {
+		for _, st := range shared {
+			st.Close()
+		}
+	}()
+
+	type profileSearch struct {
+		profile  Profile
+		tagNames map[string]string
+		query    profileQuery
+	}
+	var searches []profileSearch
+	tagNames := map[string]string{}
+	for _, profile := range profiles {
+		query, err := a.profileSearcher(ctx, profile, storeName, q.accounts, q.folderLike, refresh, fullRescan, jobs, openStore)
+		if err != nil {
+			switch {
+			case !allProfiles && len(profiles) > 1:
+				return fmt.Errorf("%s: %w", profile.Name, err)
+			case !allProfiles:
+				return err
+			}
+			log.Printf("warn: skip profile %s: %v", profile.Name, err)
+			continue
+		}
+		defer query.Close()
+		names := a.loadTagNames(profile)
+		for k, v := range names {
+			tagNames[k] = v
+		}
+		searches = append(searches, profileSearch{profile: profile, tagNames: names, query: query})
+	}
+	if len(searches) == 0 {
+		return fmt.Errorf("no profile could be searched")
+	}
+	// eachProfile runs fn with q narrowed to every searched profile in turn.
+	eachProfile := func(q queryOptions, fn func(profileSearch, queryOptions) error) error {
+		for _, s := range searches {
+			pq := q
+			if pq.tag != "" {
+				pq.tag = resolveTagKey(q.tag, s.tagNames)
+			}
+			pq.profile = s.profile.Name
+			if err := fn(s, pq); err != nil {
+				if len(searches) > 1 {
+					return fmt.Errorf("%s: %w", s.profile.Name, err)
+				}
+				return err
+			}
+		}
+		return nil
+	}
+
+	if q.groupBy != "" {
+		// Aggregate over every match; --limit caps the number of groups printed.
+		groupLimit := q.limit
+		q.limit = 0
+		var groups []groupCount
+		err := eachProfile(q, func(s profileSearch, pq queryOptions) error {
+			g, err := s.query.groups(pq)
+			groups = append(groups, g...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(groups) == 0 {
+			fmt.Println("No matches.")
+			return nil
+		}
+		return printGroups(mergeGroups(groups, q.groupBy), groupLimit, out)
+	}
+	var hits []MailSummary
+	err := eachProfile(q, func(s profileSearch, pq queryOptions) error {
+		h, err := s.query.hits(pq)
+		hits = append(hits, h...)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	accountEmail = strings.ToLower(strings.TrimSpace(accountEmail))
-	store, err := openPG()
+	if len(hits) == 0 {
+		fmt.Println("No matches.")
+		return nil
+	}
+	if q.sortBy == "" && len(searches) > 1 {
+		if q.rank != "" {
+			// One Postgres store scores every profile alike.
+			sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+		} else {
+			// Relevance scores of separate indexes do not compare; merge by date.
+			q.sortBy = "date"
+		}
+	}
+	if q.sortBy != "" {
+		sortHits(hits, q.sortBy, q.reverse)
+	} else if q.reverse {
+		slices.Reverse(hits)
+	}
+	if q.limit > 0 && len(hits) > q.limit {
+		hits = hits[:q.limit]
+	}
+	out.tagNames = tagNames
+	out.terms = highlightTerms(q)
+	out.showProfile = len(profiles) > 1
+	if err := printHits(hits, q.limit, out); err != nil {
+		return err
+	}
+	for _, s := range searches {
+		if err := saveLastSearch(s.profile, hits); err != nil {
+			log.Printf("warn: remember hits for tb mail open: %v", err)
+		}
+	}
+	searched := make([]Profile, 0, len(searches))
+	for _, s := range searches {
+		searched = append(searched, s.profile)
+	}
+	if out.exportMbox != "" {
+		n, err := a.exportMbox(searched, hits, out.exportMbox)
+		if err != nil {
+			return fmt.Errorf("export mbox: %w", err)
+		}
+		log.Printf("info: exported %d message(s) to %s", n, out.exportMbox)
+	}
+	if out.exportEml != "" {
+		n, err := a.exportEML(searched, hits, out.exportEml)
+		if err != nil {
+			return fmt.Errorf("export eml: %w", err)
+		}
+		log.Printf("info: exported %d message(s) to %s", n, out.exportEml)
+	}
+	return nil
+}
+
+// profileQuery searches one profile: hits returns the matching messages and
+// groups counts them per q.groupBy. close, when set, releases the store it
+// opened for the profile.
+type profileQuery struct {
+	hits   func(queryOptions) ([]MailSummary, error)
+	groups func(queryOptions) ([]groupCount, error)
+	close  func()
+}
+
+func (pq profileQuery) Close() {
+	if pq.close != nil {
+		pq.close()
+	}
+}
+
+// storeQuery searches st through hits, and groups in the database when st
+// can, so --group-by does not read every matching message.
+func storeQuery(ctx context.Context, st Store, hits func(queryOptions) ([]MailSummary, error)) profileQuery {
+	return profileQuery{hits: hits, groups: func(q queryOptions) ([]groupCount, error) {
+		if g, ok := st.(groupStore); ok && q.semantic == "" {
+			return g.Group(ctx, q)
+		}
+		msgs, err := hits(q)
+		if err != nil {
+			return nil, err
+		}
+		return groupHits(msgs, q.groupBy), nil
+	}}
+}
+
+// profileSearcher prepares profile for searching the named store (refreshing
+// its index or Postgres rows first when asked) and returns the profileQuery
+// that searches it.
+func (a *App) profileSearcher(ctx context.Context, profile Profile, storeName string, accounts []string, folderLike string, refresh, fullRescan bool, jobs int, openStore func(string) (Store, error)) (profileQuery, error) {
+	withProfile := func(hits []MailSummary) []MailSummary {
+		for i := range hits {
+			hits[i].Profile = profile.Name
+		}
+		return hits
+	}
+	backend, known := storeBackends[storeName]
+	if !known {
+		return profileQuery{}, fmt.Errorf("unknown store %q (use %s)", storeName, storeNames())
+	}
+	if backend.local {
+		if refresh || fullRescan {
+			opts := indexOptions{accounts: accounts, folderLike: folderLike, full: fullRescan, jobs: jobs, tailCount: defaultIndexTail}
+			if storeName == "bleve" {
+				opts.store = "bleve"
+			}
+			if err := a.buildIndex(profile.AbsolutePath, opts); err != nil {
+				return profileQuery{}, fmt.Errorf("refresh: %w", err)
+			}
+		}
+		store, err := backend.open(a, profile)
+		if err != nil {
+			return profileQuery{}, err
+		}
+		pq := storeQuery(ctx, store, func(q queryOptions) ([]MailSummary, error) {
+			hits, err := store.Search(ctx, q)
+			return withProfile(hits), err
+		})
+		pq.close = store.Close
+		return pq, nil
+	}
+	if storeName != "pg" {
+		store, err := openStore(storeName)
+		if err != nil {
+			return profileQuery{}, err
+		}
+		return storeQuery(ctx, store, func(q queryOptions) ([]MailSummary, error) { return store.Search(ctx, q) }), nil
+	}
+
+	// A running tb daemon keeps a Postgres pool open; plain searches go through
+	// it instead of connecting (and checking the schema) on every call.
+	if resp, err := callDaemon(profile, daemonRequest{Op: "status"}); err == nil && resp.Status.Postgres && !refresh && !fullRescan {
+		return profileQuery{
+			hits: func(q queryOptions) ([]MailSummary, error) {
+				resp, err := callDaemon(profile, daemonRequest{Op: "search", Query: toWireQuery(q)})
+				if err != nil {
+					return nil, fmt.Errorf("daemon: %w", err)
+				}
+				return resp.Hits, nil
+			},
+			groups: func(q queryOptions) ([]groupCount, error) {
+				resp, err := callDaemon(profile, daemonRequest{Op: "group", Query: toWireQuery(q)})
+				if err != nil {
+					return nil, fmt.Errorf("daemon: %w", err)
+				}
+				return resp.Groups, nil
+			},
+		}, nil
+	}
+	st, err := openStore("pg")
 	if err != nil {
-		return fmt.Errorf("postgres required for search: %w", err)
+		return profileQuery{}, err
 	}
-	defer store.Close()
+	store := st.(*pgStore)
+	if err := a.refreshStore(ctx, store, profile, accounts, folderLike, refresh, fullRescan, jobs); err != nil {
+		return profileQuery{}, err
+	}
+	return storeQuery(ctx, store, func(q queryOptions) ([]MailSummary, error) {
+		if q.semantic != "" {
+			return semanticSearch(ctx, store, q)
+		}
+		return store.Search(ctx, q)
+	}), nil
+}
 
-	ctx := context.Background()
+// refreshStore ingests changed folders before a search: always with --refresh
+// or --full-rescan, and once in full when the profile has no rows yet.
+func (a *App) refreshStore(ctx context.Context, store *pgStore, profile Profile, accounts []string, folderLike string, refresh, fullRescan bool, jobs int) error {
 	if refresh && fullRescan {
 		log.Printf("info: full rescan requested for profile %s", profile.Name)
 	}
@@ -384,39 +1453,25 @@ func (a *App) search(query, profileName, folderLike, accountEmail string, limit
 	if refresh || needInitialIngest {
 		log.Printf("info: refreshing cache from profile %s", profile.Name)
 		if err := a.ingestProfile(ctx, store, profile, ingestOptions{
-			accountEmail: accountEmail,
-			folderLike:   folderLike,
-			syncFirst:    false,
-			prune:        fullRescan, // prune only makes sense on full rescan
-			fullRescan:   fullRescan,
-			maxMessages:  0,
-			tailCount:    0,
+			accounts:    accounts,
+			folderLike:  folderLike,
+			syncFirst:   false,
+			prune:       fullRescan, // prune only makes sense on full rescan
+			fullRescan:  fullRescan,
+			maxMessages: 0,
+			tailCount:   0,
+			jobs:        jobs,
 		}); err != nil {
 			return fmt.Errorf("refresh: %w", err)
 		}
 	}
-
-	hits, err := store.Search(ctx, queryOptions{
-		query:      query,
-		account:    accountEmail,
-		folderLike: folderLike,
-		since:      since,
-		till:       till,
-		limit:      limit,
-		profile:    profile.Name,
-	})
-	if err != nil {
-		return err
-	}
-	if len(hits) == 0 {
-		fmt.Println("No matches.")
-		return nil
-	}
-	return printHits(hits, limit, raw)
+	return nil
 }
 
 func (a *App) ingestProfile(ctx context.Context, store *pgStore, profile Profile, opts ingestOptions) error {
-	accountEmail := strings.ToLower(strings.TrimSpace(opts.accountEmail))
+	if err := store.writable(); err != nil {
+		return err
+	}
 	if opts.syncFirst {
 		if err := a.syncProfile(profile); err != nil {
 			log.Printf("warn: sync profile %s: %v", profile.Name, err)
@@ -427,6 +1482,7 @@ func (a *App) ingestProfile(ctx context.Context, store *pgStore, profile Profile
 	if err != nil {
 		return err
 	}
+	markLive(profile, boxes)
 
 	fullRescan := opts.fullRescan
 	if opts.prune && !fullRescan {
@@ -440,32 +1496,20 @@ func (a *App) ingestProfile(ctx context.Context, store *pgStore, profile Profile
 			fpCache = m
 		}
 	}
+	wmCache := map[string]string{}
+	if opts.watermarks && !fullRescan {
+		if m, err := store.GetMetaPrefix(ctx, fmt.Sprintf("wm|%s|", profile.Name)); err == nil {
+			wmCache = m
+		}
+	}
 
 	dirToAccount := map[string]string{}
-	if accountEmail != "" {
-		idx, err := a.loadAccountDirIndex(profile)
-		if err != nil {
-			return fmt.Errorf("account index: %w", err)
-		}
-		accountDirs := idx[accountEmail]
-		if len(accountDirs) == 0 {
-			return fmt.Errorf("account %s not found in prefs.js", accountEmail)
-		}
-		for _, d := range accountDirs {
-			dirToAccount[d] = accountEmail
-		}
-		var scoped []Mailbox
-		for _, b := range boxes {
-			for _, d := range accountDirs {
-				if strings.HasPrefix(b.Path, d) {
-					scoped = append(scoped, b)
-					break
-				}
-			}
+	if len(opts.accounts) > 0 {
+		if boxes, err = a.scopeToAccounts(profile, boxes, opts.accounts); err != nil {
+			return err
 		}
-		boxes = scoped
 		if len(boxes) == 0 {
-			return fmt.Errorf("no folders for account %s", accountEmail)
+			return fmt.Errorf("no folders for account %s", strings.Join(opts.accounts, ", "))
 		}
 	} else {
 		// Build directory->account map for tagging.
@@ -492,47 +1536,156 @@ func (a *App) ingestProfile(ctx context.Context, store *pgStore, profile Profile
 		boxes = filtered
 	}
 
-	var keepIDs []string
-	for _, b := range boxes {
-		fi, err := os.Stat(b.Path)
+	var (
+		mu      sync.Mutex
+		keepIDs []string
+	)
+	err = forEachMailbox(boxes, opts.jobs, func(b Mailbox) error {
+		fi, err := statMailbox(b)
 		if err != nil {
 			log.Printf("warn: stat %s: %v", b.Name, err)
-			continue
+			return nil
 		}
-		fp := fmt.Sprintf("%d:%d", fi.ModTime().UnixNano(), fi.Size())
 		fpKey := fingerprintKey(profile.Name, b.Path)
-		if !fullRescan {
-			if prev, ok := fpCache[fpKey]; ok && prev == fp {
-				// Unchanged folder; skip ingest.
-				continue
+		prev, seen := fpCache[fpKey]
+		// Stored as "mtime-ns:size:content"; older entries lack the content part.
+		prevFields := strings.SplitN(prev, ":", 3)
+		var prevMod, prevSize int64
+		var prevContent string
+		if len(prevFields) >= 2 {
+			prevMod, _ = strconv.ParseInt(prevFields[0], 10, 64)
+			prevSize, _ = strconv.ParseInt(prevFields[1], 10, 64)
+		}
+		if len(prevFields) == 3 {
+			prevContent = prevFields[2]
+		}
+		content, same := folderUnchanged(prevMod/int64(time.Second), prevSize, prevContent, b.Path, fi)
+		fp := fmt.Sprintf("%d:%d:%s", fi.ModTime().UnixNano(), fi.Size(), content)
+		if !fullRescan && seen && same {
+			// Unchanged folder; skip ingest, but fill in bodies it was synced without.
+			if opts.bodies || opts.attachments || opts.threads || opts.contacts {
+				if n, err := store.CountMissingDetails(ctx, profile.Name, b.Name, opts); err != nil {
+					return err
+				} else if n > 0 {
+					if err := storeFolderDetails(ctx, store, profile.Name, b, opts); err != nil {
+						return err
+					}
+				}
+			}
+			if prev != fp {
+				if err := store.SetMeta(ctx, fpKey, fp); err != nil {
+					log.Printf("warn: save fingerprint %s: %v", b.Name, err)
+				}
 			}
+			if opts.onFolder != nil {
+				opts.onFolder(b, 0, true)
+			}
+			return nil
+		}
+		if seen && fi.Size() < prevSize {
+			log.Printf("info: %s shrank (compacted); re-ingesting it", b.Name)
 		}
 
-		targetAccount := accountEmail
+		targetAccount := b.Account
 		if targetAccount == "" {
 			targetAccount = accountForPath(b.Path, dirToAccount)
 		}
-		msgs, err := searchMailbox(b, func(string) bool { return true }, 0, time.Time{}, time.Time{}, opts.maxMessages, targetAccount, opts.tailCount)
+		wholeFolder := opts.maxMessages == 0 && opts.tailCount == 0
+		wmKey := watermarkKey(profile.Name, b.Path)
+		var msgs []MailSummary
+		end := fi.Size()
+		from, appended := appendedSince(wmCache[wmKey], b.Path, fi)
+		appended = appended && opts.watermarks && !fullRescan && wholeFolder
+		if appended {
+			// Only new mail was added: read it from the old end onwards.
+			err = scanIndexMailbox(b, from, func(string) bool { return true }, time.Time{}, targetAccount, func(e int64, m *MailSummary) error {
+				end = e
+				if m != nil {
+					msgs = append(msgs, *m)
+				}
+				return nil
+			})
+		} else {
+			msgs, err = searchMailbox(b, func(string) bool { return true }, 0, time.Time{}, time.Time{}, opts.maxMessages, targetAccount, opts.tailCount)
+		}
 		if err != nil {
 			log.Printf("warn: ingest %s: %v", b.Name, err)
-			continue
+			return nil
 		}
 		decorateMessages(msgs, profile.Name, targetAccount)
 		if err := store.Upsert(ctx, msgs); err != nil {
 			return err
 		}
+		if err := store.upsertSummaryDetails(ctx, msgs, opts); err != nil {
+			return err
+		}
+		if opts.bodies || opts.attachments {
+			// The summaries carry reply links and addresses already; rereading is for the rest.
+			details := opts
+			details.threads, details.contacts = false, false
+			if appended {
+				err = storeMessageDetails(ctx, store, profile.Name, b, msgs, details)
+			} else {
+				err = storeFolderDetails(ctx, store, profile.Name, b, details)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if wholeFolder {
+			if wm, err := watermarkFingerprint(b.Path, end); err == nil {
+				if err := store.SetMeta(ctx, wmKey, fmt.Sprintf("%d:%s", end, wm)); err != nil {
+					log.Printf("warn: save watermark %s: %v", b.Name, err)
+				}
+			}
+		}
+		var folderIDs []string
 		for _, m := range msgs {
 			if m.MessageID != "" {
-				keepIDs = append(keepIDs, m.MessageID)
+				folderIDs = append(folderIDs, forceUTF8(m.MessageID))
+			}
+		}
+		if wholeFolder && !appended {
+			// The whole folder was read, so rows it no longer holds are stale.
+			n, err := store.PruneFolder(ctx, profile.Name, b.Name, folderIDs)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				log.Printf("info: %s: removed %d deleted message(s) from the cache", b.Name, n)
 			}
 		}
+		mu.Lock()
+		keepIDs = append(keepIDs, folderIDs...)
+		mu.Unlock()
 		if err := store.SetMeta(ctx, fpKey, fp); err != nil {
 			log.Printf("warn: save fingerprint %s: %v", b.Name, err)
 		}
+		if opts.onFolder != nil {
+			opts.onFolder(b, len(msgs), false)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	if opts.prune && fullRescan {
-		if err := store.PruneMissing(ctx, profile.Name, keepIDs); err != nil {
-			return err
+		if opts.folderLike != "" || len(opts.accounts) > 0 {
+			// keepIDs only covers the selected folders; those were pruned one by one above.
+			log.Printf("info: --folder/--account given; pruned only the scanned folders")
+		} else {
+			n, err := store.PruneMissing(ctx, profile.Name, keepIDs)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				log.Printf("info: removed %d message(s) no longer on disk from the cache", n)
+			}
+		}
+	}
+	if opts.contacts {
+		if err := store.RefreshContacts(ctx); err != nil {
+			return fmt.Errorf("refresh contacts: %w", err)
 		}
 	}
 	_ = store.SetMeta(ctx, fmt.Sprintf("last_scan.%s", profile.Name), time.Now().UTC().Format(time.RFC3339))
@@ -582,7 +1735,15 @@ func (a *App) compose(to, cc, subject, body string, openComposer, sendNow bool)
 	return nil
 }
 
+// loadProfiles reads the profiles in profiles.ini. The default is the
+// profile the GUI starts with: the Default= of an [Install...] section
+// (Thunderbird 68 and later keep one per installation, a locked one
+// winning), unless [General] StartWithLastProfile=0 makes the GUI ask, and
+// else the profile marked Default=1.
 func (a *App) loadProfiles() ([]Profile, error) {
+	if a.ProfileDir != "" {
+		return a.profileDirProfile()
+	}
 	path := filepath.Join(a.Root, "profiles.ini")
 	f, err := os.Open(path)
 	if err != nil {
@@ -590,8 +1751,22 @@ func (a *App) loadProfiles() ([]Profile, error) {
 	}
 	defer f.Close()
 
-	var profiles []Profile
-	var current map[string]string
+	var (
+		profiles []Profile
+		installs []map[string]string
+		general  = map[string]string{}
+		current  map[string]string
+		section  string
+	)
+	flush := func() {
+		switch {
+		case current == nil:
+		case strings.HasPrefix(section, "profile"):
+			profiles = append(profiles, mapToProfile(a.Root, current))
+		case strings.HasPrefix(section, "install"):
+			installs = append(installs, current)
+		}
+	}
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -599,13 +1774,14 @@ func (a *App) loadProfiles() ([]Profile, error) {
 			continue
 		}
 		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			section := strings.Trim(line, "[]")
-			if current != nil {
-				profiles = append(profiles, mapToProfile(a.Root, current))
-			}
-			if strings.HasPrefix(strings.ToLower(section), "profile") {
+			flush()
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			switch {
+			case section == "general":
+				current = general
+			case strings.HasPrefix(section, "profile"), strings.HasPrefix(section, "install"):
 				current = map[string]string{}
-			} else {
+			default:
 				current = nil
 			}
 			continue
@@ -622,14 +1798,57 @@ func (a *App) loadProfiles() ([]Profile, error) {
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
-	if current != nil {
-		profiles = append(profiles, mapToProfile(a.Root, current))
+	flush()
+
+	if general["StartWithLastProfile"] != "0" {
+		def := installDefault(installs)
+		match := slices.IndexFunc(profiles, func(p Profile) bool { return p.Path == def })
+		if def != "" && match >= 0 {
+			for i := range profiles {
+				profiles[i].Default = i == match
+			}
+		}
 	}
 	return profiles, nil
 }
 
+// profileDirProfile is the profile --profile-dir names, the default and only
+// one, named after its directory.
+func (a *App) profileDirProfile() ([]Profile, error) {
+	dir, err := filepath.Abs(a.ProfileDir)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("--profile-dir: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("--profile-dir: %s is not a directory", dir)
+	}
+	return []Profile{{Name: filepath.Base(dir), Path: dir, AbsolutePath: dir, Default: true}}, nil
+}
+
+// installDefault is the Default= path of the [Install...] sections,
+// preferring a locked one, or "" when there are none.
+func installDefault(installs []map[string]string) string {
+	def := ""
+	for _, in := range installs {
+		if in["Default"] == "" {
+			continue
+		}
+		if in["Locked"] == "1" {
+			return in["Default"]
+		}
+		if def == "" {
+			def = in["Default"]
+		}
+	}
+	return def
+}
+
 // fetch ingests Thunderbird mailboxes into Postgres (optionally syncing first).
-func (a *App) fetch(profileName, folderLike, accountEmail string, syncFirst, prune, fullRescan bool, maxMessages, tailCount int) error {
+func (a *App) fetch(profileName, folderLike string, accounts []string, syncFirst, prune, fullRescan bool, maxMessages, tailCount, jobs, batchSize int) error {
 	profile, err := a.resolveProfile(profileName)
 	if err != nil {
 		return err
@@ -639,16 +1858,18 @@ func (a *App) fetch(profileName, folderLike, accountEmail string, syncFirst, pru
 		return fmt.Errorf("postgres required for fetch: %w", err)
 	}
 	defer store.Close()
+	store.batchSize = batchSize
 
 	ctx := context.Background()
 	return a.ingestProfile(ctx, store, profile, ingestOptions{
-		accountEmail: strings.ToLower(strings.TrimSpace(accountEmail)),
-		folderLike:   folderLike,
-		syncFirst:    syncFirst,
-		prune:        prune,
-		fullRescan:   fullRescan,
-		maxMessages:  maxMessages,
-		tailCount:    tailCount,
+		accounts:    accounts,
+		folderLike:  folderLike,
+		syncFirst:   syncFirst,
+		prune:       prune,
+		fullRescan:  fullRescan,
+		maxMessages: maxMessages,
+		tailCount:   tailCount,
+		jobs:        jobs,
 	})
 }
 
@@ -662,8 +1883,10 @@ func mapToProfile(root string, kv map[string]string) Profile {
 	if p.Name == "" {
 		p.Name = filepath.Base(p.Path)
 	}
+	// Relative paths use forward slashes on every system, but a profiles.ini
+	// written by hand on Windows may use backslashes.
 	if p.IsRelative {
-		p.AbsolutePath = filepath.Join(root, filepath.FromSlash(p.Path))
+		p.AbsolutePath = filepath.Join(root, filepath.FromSlash(strings.ReplaceAll(p.Path, `\`, "/")))
 	} else {
 		p.AbsolutePath = filepath.Clean(p.Path)
 	}
@@ -688,7 +1911,7 @@ func (a *App) resolveProfile(name string) (Profile, error) {
 	}
 	needle := strings.ToLower(name)
 	for _, p := range profiles {
-		if strings.ToLower(p.Name) == needle || strings.ToLower(filepath.Base(p.Path)) == needle || strings.ToLower(filepath.Base(p.AbsolutePath)) == needle {
+		if strings.ToLower(p.Name) == needle || strings.ToLower(filepath.Base(p.Path)) == needle || strings.ToLower(filepath.Base(p.AbsolutePath)) == needle || p.AbsolutePath == name {
 			return p, nil
 		}
 	}
@@ -702,37 +1925,92 @@ func (a *App) resolveProfile(name string) (Profile, error) {
 	return Profile{}, fmt.Errorf("profile %s not found", name)
 }
 
+// assumeCharsetFlag registers --assume-charset; the returned func applies
+// it once the flags are parsed.
+func assumeCharsetFlag(cmd *flag.FlagSet) func() error {
+	label := cmd.String("assume-charset", "", "charset for raw 8-bit or mislabeled headers (default windows-1252)")
+	return func() error { return setAssumeCharset(*label) }
+}
+
+// accountFlags registers --account and its --ac alias. Both may be repeated
+// and take comma-separated addresses; the returned func yields them all.
+func accountFlags(cmd *flag.FlagSet) func() []string {
+	long := cmd.StringArray("account", nil, "filter by account email (repeatable or comma-separated)")
+	short := cmd.StringArray("ac", nil, "alias for --account")
+	return func() []string {
+		return splitAccounts(append(append([]string{}, *long...), *short...))
+	}
+}
+
+// splitAccounts flattens repeated and comma-separated --account values into
+// lowercased addresses.
+func splitAccounts(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, acct := range strings.Split(v, ",") {
+			if acct = strings.ToLower(strings.TrimSpace(acct)); acct != "" && !slices.Contains(out, acct) {
+				out = append(out, acct)
+			}
+		}
+	}
+	return out
+}
+
+// splitProfiles flattens repeated, comma-separated --profile values.
+func splitProfiles(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" && !slices.Contains(out, name) {
+				out = append(out, name)
+			}
+		}
+	}
+	return out
+}
+
+// scopeToAccounts keeps the boxes stored under one of accounts, labelling each
+// with its account. Every account must be configured in prefs.js.
+func (a *App) scopeToAccounts(profile Profile, boxes []Mailbox, accounts []string) ([]Mailbox, error) {
+	idx, err := a.loadAccountDirIndex(profile)
+	if err != nil {
+		return nil, fmt.Errorf("account index: %w", err)
+	}
+	for _, acct := range accounts {
+		if len(idx[acct]) == 0 {
+			return nil, fmt.Errorf("account %s not found in prefs.js", acct)
+		}
+	}
+	var scoped []Mailbox
+	for _, b := range boxes {
+	accounts:
+		for _, acct := range accounts {
+			for _, d := range idx[acct] {
+				if strings.HasPrefix(b.Path, d) {
+					b.Account = acct
+					scoped = append(scoped, b)
+					break accounts
+				}
+			}
+		}
+	}
+	return scoped, nil
+}
+
+// loadAccountDirIndex maps each identity email to the folder directories of
+// the accounts using it.
 func (a *App) loadAccountDirIndex(p Profile) (map[string][]string, error) {
-	prefsPath := filepath.Join(p.AbsolutePath, "prefs.js")
-	prefs, err := parsePrefs(prefsPath)
+	accounts, err := a.loadAccounts(p)
 	if err != nil {
 		return nil, err
 	}
-	acctsStr := prefs["mail.accountmanager.accounts"]
-	accts := splitCSV(acctsStr)
 	emailDirs := map[string][]string{}
-	for _, acc := range accts {
-		server := prefs[fmt.Sprintf("mail.account.%s.server", acc)]
-		idents := splitCSV(prefs[fmt.Sprintf("mail.account.%s.identities", acc)])
-		dir := prefs[fmt.Sprintf("mail.server.%s.directory", server)]
-		if dir == "" {
-			dirRel := prefs[fmt.Sprintf("mail.server.%s.directory-rel", server)]
-			if strings.HasPrefix(dirRel, "[ProfD]") {
-				dir = filepath.Join(p.AbsolutePath, filepath.FromSlash(strings.TrimPrefix(dirRel, "[ProfD]")))
-			} else if dirRel != "" {
-				dir = filepath.Clean(dirRel)
-			}
-		}
-		if dir == "" {
+	for _, acct := range accounts {
+		if acct.Directory == "" {
 			continue
 		}
-		for _, id := range idents {
-			email := prefs[fmt.Sprintf("mail.identity.%s.useremail", id)]
-			if email == "" {
-				continue
-			}
-			email = strings.ToLower(email)
-			emailDirs[email] = append(emailDirs[email], dir)
+		for _, email := range acct.Emails {
+			emailDirs[email] = append(emailDirs[email], acct.Directory)
 		}
 	}
 	for k, dirs := range emailDirs {
@@ -741,20 +2019,57 @@ func (a *App) loadAccountDirIndex(p Profile) (map[string][]string, error) {
 	return emailDirs, nil
 }
 
-func parsePrefs(path string) (map[string]string, error) {
-	b, err := os.ReadFile(path)
+// defaultTagNames are the built-in Thunderbird tags; prefs.js only lists them once renamed.
+var defaultTagNames = map[string]string{
+	"$label1": "Important",
+	"$label2": "Work",
+	"$label3": "Personal",
+	"$label4": "To Do",
+	"$label5": "Later",
+}
+
+// loadTagNames maps X-Mozilla-Keys keywords to their display names from mailnews.tags.* prefs.
+func (a *App) loadTagNames(p Profile) map[string]string {
+	names := map[string]string{}
+	for k, v := range defaultTagNames {
+		names[k] = v
+	}
+	prefs, err := profilePrefs(p)
 	if err != nil {
-		return nil, err
+		return names
+	}
+	for k := range prefs {
+		if !strings.HasPrefix(k, "mailnews.tags.") || !strings.HasSuffix(k, ".tag") {
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimPrefix(k, "mailnews.tags."), ".tag")
+		names[strings.ToLower(key)] = prefs.String(k)
+	}
+	return names
+}
+
+// resolveTagKey accepts either a tag keyword or its display name and returns the keyword.
+func resolveTagKey(tag string, names map[string]string) string {
+	tag = strings.TrimSpace(tag)
+	for key, name := range names {
+		if strings.EqualFold(name, tag) {
+			return key
+		}
 	}
-	re := regexp.MustCompile(`user_pref\(\"([^\"]+)\",\s*\"(.*)\"\);`)
-	m := map[string]string{}
-	for _, line := range strings.Split(string(b), "\n") {
-		matches := re.FindStringSubmatch(line)
-		if len(matches) == 3 {
-			m[matches[1]] = matches[2]
+	return strings.ToLower(tag)
+}
+
+// tagLabels renders space-separated tag keywords using their display names.
+func tagLabels(keys string, names map[string]string) string {
+	var out []string
+	for _, k := range strings.Fields(keys) {
+		if name, ok := names[k]; ok {
+			out = append(out, name)
+		} else {
+			out = append(out, k)
 		}
 	}
-	return m, nil
+	return strings.Join(out, ", ")
 }
 
 func (a *App) listMailboxes(p Profile) ([]Mailbox, error) {
@@ -782,6 +2097,13 @@ func (a *App) listMailboxes(p Profile) ([]Mailbox, error) {
 				if strings.HasSuffix(d.Name(), ".mozmsgs") {
 					return filepath.SkipDir
 				}
+				if path != root && isMaildir(path) {
+					if info, err := statMailbox(Mailbox{Path: path, Maildir: true}); err == nil {
+						rel, _ := filepath.Rel(p.AbsolutePath, path)
+						boxes = append(boxes, Mailbox{Name: rel, Path: path, Size: info.Size(), Maildir: true})
+					}
+					return filepath.SkipDir
+				}
 				return nil
 			}
 			base := d.Name()
@@ -808,6 +2130,7 @@ func (a *App) listMailboxes(p Profile) ([]Mailbox, error) {
 			return nil
 		})
 	}
+	boxes = append(boxes, importedMailboxes(p)...)
 	sort.Slice(boxes, func(i, j int) bool { return boxes[i].Name < boxes[j].Name })
 	return boxes, nil
 }
@@ -845,6 +2168,9 @@ func findMailCommand() []string {
 	if path, err := exec.LookPath("thunderbird"); err == nil {
 		return []string{path}
 	}
+	if path := installedMailApp(); path != "" {
+		return []string{path}
+	}
 	if flatpak, err := exec.LookPath("flatpak"); err == nil {
 		id := strings.TrimSpace(os.Getenv("THUNDERBIRD_FLATPAK_ID"))
 		if id == "" {
@@ -856,14 +2182,15 @@ func findMailCommand() []string {
 	return []string{"thunderbird"}
 }
 
-func readMailboxRecent(box Mailbox, limit int, query string) ([]MailSummary, error) {
-	f, err := os.Open(box.Path)
+func readMailboxRecent(box Mailbox, limit int, query string, fuzzy bool, excludes []string, flagged bool) ([]MailSummary, error) {
+	f, err := openMailbox(box)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 	reader := mbox.NewReader(f)
-	query = strings.ToLower(query)
+	match := withExcludes(makeMatcher(query, fuzzy), excludes)
+	filtered := query != "" || len(excludes) > 0
 	var buf []MailSummary
 	for {
 		msgReader, err := reader.NextMessage()
@@ -874,10 +2201,13 @@ func readMailboxRecent(box Mailbox, limit int, query string) ([]MailSummary, err
 			return buf, err
 		}
 		summary, searchText, err := parseMessage(msgReader, box.Name)
-		if err != nil {
+		if err != nil || summary.Expunged() {
 			continue
 		}
-		if query != "" && !strings.Contains(searchText, query) {
+		if filtered && !match(searchText) {
+			continue
+		}
+		if flagged && !summary.Flagged() {
 			continue
 		}
 		buf = append(buf, summary)
@@ -895,12 +2225,15 @@ type matcherFunc func(string) bool
 
 func makeMatcher(q string, fuzzy bool) matcherFunc {
 	if !fuzzy {
-		q = strings.ToLower(q)
+		q = strings.ToLower(strings.TrimSpace(q))
+		if len(q) >= 2 && strings.HasPrefix(q, `"`) && strings.HasSuffix(q, `"`) {
+			q = q[1 : len(q)-1]
+		}
 		return func(s string) bool {
 			return strings.Contains(s, q)
 		}
 	}
-	tokens := strings.Fields(strings.ToLower(q))
+	tokens := splitQueryTerms(q)
 	return func(s string) bool {
 		for _, t := range tokens {
 			if !strings.Contains(s, t) {
@@ -911,6 +2244,52 @@ func makeMatcher(q string, fuzzy bool) matcherFunc {
 	}
 }
 
+// withExcludes wraps match so that any text containing one of the excluded terms is rejected.
+func withExcludes(match matcherFunc, excludes []string) matcherFunc {
+	var terms []string
+	for _, e := range excludes {
+		terms = append(terms, splitQueryTerms(e)...)
+	}
+	if len(terms) == 0 {
+		return match
+	}
+	return func(s string) bool {
+		for _, t := range terms {
+			if strings.Contains(s, t) {
+				return false
+			}
+		}
+		return match(s)
+	}
+}
+
+// splitQueryTerms lowercases q and splits it on whitespace, keeping "quoted phrases"
+// together as a single term. An unterminated quote runs to the end of the query.
+func splitQueryTerms(q string) []string {
+	var terms []string
+	var cur strings.Builder
+	inQuote := false
+	flush := func() {
+		if t := strings.TrimSpace(cur.String()); t != "" {
+			terms = append(terms, t)
+		}
+		cur.Reset()
+	}
+	for _, r := range strings.ToLower(q) {
+		switch {
+		case r == '"':
+			flush()
+			inQuote = !inQuote
+		case !inQuote && unicode.IsSpace(r):
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return terms
+}
+
 func decorateMessages(msgs []MailSummary, profile string, account string) {
 	for i := range msgs {
 		msgs[i].Profile = profile
@@ -922,6 +2301,9 @@ func decorateMessages(msgs []MailSummary, profile string, account string) {
 		msgs[i].Date = cleanUTF8(msgs[i].Date)
 		msgs[i].Subject = cleanUTF8(msgs[i].Subject)
 		msgs[i].From = cleanUTF8(msgs[i].From)
+		msgs[i].To = cleanUTF8(msgs[i].To)
+		msgs[i].Cc = cleanUTF8(msgs[i].Cc)
+		msgs[i].Body = cleanUTF8(msgs[i].Body)
 		msgs[i].Snippet = cleanUTF8(msgs[i].Snippet)
 		msgs[i].Search = cleanUTF8(msgs[i].Search)
 		if msgs[i].Search == "" {
@@ -945,55 +2327,254 @@ func cleanUTF8(s string) string {
 	return b.String()
 }
 
-func printHits(hits []MailSummary, limit int, raw bool) error {
-	sort.Slice(hits, func(i, j int) bool {
-		if hits[i].When.IsZero() && hits[j].When.IsZero() {
-			return hits[i].Date > hits[j].Date
+// sortKeys are the accepted --sort values. Dates and sizes sort descending
+// (newest/largest first); text keys sort A-Z. --reverse flips either.
+var sortKeys = []string{"date", "from", "subject", "folder", "size"}
+
+func validSortKey(key string) bool {
+	for _, k := range sortKeys {
+		if k == key {
+			return true
 		}
-		if hits[i].When.IsZero() {
-			return false
+	}
+	return false
+}
+
+func newerFirst(a, b MailSummary) bool {
+	if a.When.IsZero() && b.When.IsZero() {
+		return a.Date > b.Date
+	}
+	if a.When.IsZero() {
+		return false
+	}
+	if b.When.IsZero() {
+		return true
+	}
+	return a.When.After(b.When)
+}
+
+func sortHits(hits []MailSummary, key string, reverse bool) {
+	less := func(a, b MailSummary) bool {
+		switch key {
+		case "from":
+			if x, y := strings.ToLower(a.From), strings.ToLower(b.From); x != y {
+				return x < y
+			}
+		case "subject":
+			if x, y := strings.ToLower(a.Subject), strings.ToLower(b.Subject); x != y {
+				return x < y
+			}
+		case "folder":
+			if x, y := strings.ToLower(a.Folder), strings.ToLower(b.Folder); x != y {
+				return x < y
+			}
+		case "size":
+			if a.Size != b.Size {
+				return a.Size > b.Size
+			}
+		}
+		return newerFirst(a, b)
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if reverse {
+			return less(hits[j], hits[i])
 		}
-		if hits[j].When.IsZero() {
+		return less(hits[i], hits[j])
+	})
+}
+
+var groupKeys = []string{"sender", "domain", "folder", "month", "profile"}
+
+func validGroupKey(key string) bool {
+	for _, k := range groupKeys {
+		if k == key {
 			return true
 		}
-		return hits[i].When.After(hits[j].When)
+	}
+	return false
+}
+
+type groupCount struct {
+	Key    string    `json:"key"`
+	Count  int       `json:"count"`
+	Latest time.Time `json:"latest"`
+}
+
+// senderAddress returns the lowercased address from a From header, or the raw text if unparsable.
+func senderAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(from))
+}
+
+func groupKeyFor(m MailSummary, key string) string {
+	switch key {
+	case "sender":
+		return senderAddress(m.From)
+	case "domain":
+		addr := senderAddress(m.From)
+		if i := strings.LastIndex(addr, "@"); i >= 0 {
+			return strings.TrimRight(addr[i+1:], ">")
+		}
+		return addr
+	case "folder":
+		return m.Folder
+	case "profile":
+		return m.Profile
+	case "month":
+		if m.When.IsZero() {
+			return "unknown"
+		}
+		return m.When.Format("2006-01")
+	}
+	return ""
+}
+
+// groupHits counts hits per key, largest groups first (months newest first).
+func groupHits(hits []MailSummary, key string) []groupCount {
+	groups := make([]groupCount, len(hits))
+	for i, h := range hits {
+		groups[i] = groupCount{Key: groupKeyFor(h, key), Count: 1, Latest: h.When}
+	}
+	return mergeGroups(groups, key)
+}
+
+// senderGroups turns groups keyed by the raw From header, which is what the
+// stores group by for sender and domain, into groups keyed the way
+// groupKeyFor keys a message.
+func senderGroups(groups []groupCount, key string) []groupCount {
+	if key != "sender" && key != "domain" {
+		return groups
+	}
+	for i := range groups {
+		groups[i].Key = groupKeyFor(MailSummary{From: groups[i].Key}, key)
+	}
+	return mergeGroups(groups, key)
+}
+
+// mergeGroups adds up the groups sharing a key, largest first (months
+// newest first).
+func mergeGroups(groups []groupCount, key string) []groupCount {
+	idx := map[string]int{}
+	var merged []groupCount
+	for _, g := range groups {
+		i, ok := idx[g.Key]
+		if !ok {
+			i = len(merged)
+			idx[g.Key] = i
+			merged = append(merged, groupCount{Key: g.Key})
+		}
+		merged[i].Count += g.Count
+		if g.Latest.After(merged[i].Latest) {
+			merged[i].Latest = g.Latest
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if key == "month" {
+			return merged[i].Key > merged[j].Key
+		}
+		if merged[i].Count != merged[j].Count {
+			return merged[i].Count > merged[j].Count
+		}
+		return merged[i].Key < merged[j].Key
 	})
+	return merged
+}
+
+func printGroups(groups []groupCount, limit int, out outputOptions) error {
+	if limit > 0 && len(groups) > limit {
+		groups = groups[:limit]
+	}
+	if out.raw {
+		for _, g := range groups {
+			fmt.Printf("%d | %s\n", g.Count, g.Key)
+		}
+		return nil
+	}
+	var rows [][]string
+	for _, g := range groups {
+		latest := ""
+		if !g.Latest.IsZero() {
+			latest = g.Latest.Format("2006-01-02 15:04")
+		}
+		rows = append(rows, []string{strconv.Itoa(g.Count), out.truncate(g.Key, 60), latest})
+	}
+	renderTable(os.Stdout, []string{"COUNT", "GROUP", "LATEST"}, rows)
+	return nil
+}
+
+func printHits(hits []MailSummary, limit int, out outputOptions) error {
 	if limit > 0 && len(hits) > limit {
 		hits = hits[:limit]
 	}
 
-	if raw {
+	if out.raw {
 		for _, h := range hits {
 			date := h.Date
 			if !h.When.IsZero() {
 				date = h.When.Format("2006-01-02 15:04")
 			}
+			if out.showProfile {
+				date += " | " + h.Profile
+			}
+			if out.showScore {
+				date += fmt.Sprintf(" | %.4f", h.Score)
+			}
 			fmt.Printf("%s | %s | %s | %s | %s\n",
 				date,
-				truncate(h.Folder, 22),
-				truncate(h.From, 40),
-				truncate(h.Subject, 60),
-				truncate(h.Snippet, 120))
+				out.truncate(h.Folder, 22),
+				out.truncate(h.From, 40),
+				out.truncate(h.Subject, 60),
+				out.truncate(h.Snippet, 120))
 		}
 		return nil
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
-	fmt.Fprintf(w, "DATE\tFOLDER\tFROM\tSUBJECT\tSNIPPET\n")
-	fmt.Fprintf(w, "----\t------\t----\t-------\t-------\n")
+	showTags := false
 	for _, h := range hits {
+		if h.Tags != "" {
+			showTags = true
+			break
+		}
+	}
+	header := []string{"#", "ST", "DATE"}
+	if out.showScore {
+		header = append(header, "SCORE")
+	}
+	if out.showProfile {
+		header = append(header, "PROFILE")
+	}
+	header = append(header, "FOLDER", "FROM", "SUBJECT")
+	if showTags {
+		header = append(header, "TAGS")
+	}
+	header = append(header, "SNIPPET")
+	var rows [][]string
+	for i, h := range hits {
 		date := h.Date
 		if !h.When.IsZero() {
 			date = h.When.Format("2006-01-02 15:04")
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
-			date,
-			truncate(h.Folder, 24),
-			truncate(h.From, 40),
-			truncate(h.Subject, 60),
-			truncate(h.Snippet, 120))
+		row := []string{strconv.Itoa(i + 1), statusMarks(h), date}
+		if out.showScore {
+			row = append(row, fmt.Sprintf("%.4f", h.Score))
+		}
+		if out.showProfile {
+			row = append(row, out.truncate(h.Profile, 20))
+		}
+		row = append(row,
+			out.truncate(h.Folder, 24),
+			out.truncate(h.From, 40),
+			out.highlight(out.truncate(h.Subject, 60)),
+		)
+		if showTags {
+			row = append(row, out.truncate(tagLabels(h.Tags, out.tagNames), 30))
+		}
+		row = append(row, out.highlight(out.truncate(h.Snippet, 120)))
+		rows = append(rows, row)
 	}
-	w.Flush()
+	renderTable(os.Stdout, header, rows)
 	return nil
 }
 
@@ -1026,7 +2607,7 @@ func (a *App) buildFolderIndex(box Mailbox, accountLabel string, maxMessages int
 	if err != nil {
 		return FolderIndex{}, err
 	}
-	fi, err := os.Stat(box.Path)
+	fi, err := statMailbox(box)
 	if err != nil {
 		return FolderIndex{}, err
 	}
@@ -1039,13 +2620,51 @@ func (a *App) buildFolderIndex(box Mailbox, accountLabel string, maxMessages int
 	}, nil
 }
 
+// folderTagWords are the folder name fragments that mark trash and spam
+// folders. pgStore.Search matches the same fragments in SQL.
+var folderTagWords = []struct {
+	tag   string
+	words []string
+}{
+	{"trash", []string{"trash", "deleted"}},
+	{"spam", []string{"spam", "junk"}},
+}
+
+// folderTag marks trash and spam folders for awareness in results.
+func folderTag(name string) string {
+	lower := strings.ToLower(name)
+	for _, t := range folderTagWords {
+		for _, w := range t.words {
+			if strings.Contains(lower, w) {
+				return t.tag
+			}
+		}
+	}
+	return ""
+}
+
+// skipTaggedFolder reports whether a trash or spam folder is left out.
+// Both are skipped unless included; naming one through folderLike (say
+// --folder Trash) includes it as well.
+func skipTaggedFolder(name, folderLike string, includeTrash, includeSpam bool) bool {
+	switch tag := folderTag(name); tag {
+	case "trash":
+		return !includeTrash && folderTag(folderLike) != tag
+	case "spam":
+		return !includeSpam && folderTag(folderLike) != tag
+	}
+	return false
+}
+
 func searchMailbox(box Mailbox, match matcherFunc, limit int, since, till time.Time, maxMessages int, accountLabel string, tailCount int) ([]MailSummary, error) {
-	f, err := os.Open(box.Path)
+	stream, err := openMailboxStream(box, 0)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	reader := mbox.NewReader(f)
+	defer stream.Close()
+	progress, r := newScanProgress(box.Name, stream, stream.size)
+	defer progress.done()
+	reader := mbox.NewReader(r)
 	var hits []MailSummary
 	seen := 0
 	warnCount := 0
@@ -1063,11 +2682,12 @@ func searchMailbox(box Mailbox, match matcherFunc, limit int, since, till time.T
 			continue
 		}
 		seen++
+		progress.message()
 		if maxMessages > 0 && seen > maxMessages && tailCount == 0 {
 			break
 		}
 		summary, searchText, err := parseMessage(msgReader, box.Name)
-		if err != nil {
+		if err != nil || summary.Expunged() {
 			continue
 		}
 		if !since.IsZero() && !summary.When.IsZero() && summary.When.Before(since) {
@@ -1079,14 +2699,7 @@ func searchMailbox(box Mailbox, match matcherFunc, limit int, since, till time.T
 		if accountLabel != "" {
 			summary.Account = accountLabel
 		}
-		// Tag folder type for awareness (trash/spam).
-		lower := strings.ToLower(box.Name)
-		switch {
-		case strings.Contains(lower, "trash") || strings.Contains(lower, "deleted"):
-			summary.FolderTag = "trash"
-		case strings.Contains(lower, "spam") || strings.Contains(lower, "junk"):
-			summary.FolderTag = "spam"
-		}
+		summary.FolderTag = folderTag(box.Name)
 		summary.Search = searchText
 		if match(searchText) {
 			hits = append(hits, summary)
@@ -1098,6 +2711,12 @@ func searchMailbox(box Mailbox, match matcherFunc, limit int, since, till time.T
 			}
 		}
 	}
+	if box.Live {
+		// What sync stores must not come from a folder compacted mid-read.
+		if err := stream.unchanged(); err != nil {
+			return nil, err
+		}
+	}
 	return hits, nil
 }
 
@@ -1194,138 +2813,435 @@ func simpleWord(s string) bool {
 			return false
 		}
 	}
-	return len(s) > 0
-}
+	return len(s) > 0
+}
+
+func splitCSV(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	var out []string
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func uniqueStrings(in []string) []string {
+	m := map[string]struct{}{}
+	var out []string
+	for _, v := range in {
+		if _, ok := m[v]; ok {
+			continue
+		}
+		m[v] = struct{}{}
+		out = append(out, v)
+	}
+	return out
+}
+
+// showOptions carries the flags of `tb mail show`.
+type showOptions struct {
+	folderLike      string
+	query           string
+	fuzzy           bool // match every term of query anywhere instead of query as one substring
+	accounts        []string
+	limit           int
+	thread          bool
+	excludes        []string
+	exportEml       string   // directory to write each shown message to as .eml
+	messageID       string   // show the message with this Message-Id instead of querying a folder
+	raw             bool     // print the original RFC822 bytes instead of decoded text
+	allHeaders      bool     // print every header in message order
+	headerNames     []string // extra headers to print (e.g. Received, List-Id)
+	saveAttachments string   // directory to write decoded attachments to
+	attachmentsOnly bool     // list attachments per message instead of printing bodies
+	noLinks         bool     // omit the Links: section listing HTML hrefs
+	stripTracking   bool     // unwrap redirect links and drop utm_* parameters in Links:
+	noCrypto        bool     // skip PGP and S/MIME verification/decryption
+	saveVCards      string   // directory to write attached vCards to as .vcf
+	fullQuotes      bool     // print quoted history instead of folding it
+	nth             int      // show the Nth match in folder order (negative counts from the end)
+	relativeTo      string   // Message-Id that --next/--prev step from
+	step            int      // +1 for --next, -1 for --prev
+	format          string   // text (default), json, or mbox
+	delimiter       string   // separator printed after each message in text output
+	mailtoReply     bool     // print a reply mailto: URI per message instead of the message
+	mailtoReplyAll  bool     // ... addressed to all recipients but the user's identities
+	savePath        string   // file (or directory) to write the original message to
+	store           string   // "pg" reads bodies stored by sync --bodies instead of the mbox files
+	auth            bool     // summarize SPF/DKIM/DMARC results
+	verifyDKIM      bool     // also verify DKIM signatures ourselves (DNS lookups)
+}
+
+func normalizeMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// locateMessageID narrows boxes to the folders holding id, consulting the Postgres
+// cache and then the local SQLite or legacy JSON index. It falls back to every folder when none of them knows.
+func (a *App) locateMessageID(profile Profile, boxes []Mailbox, id string) []Mailbox {
+	want := normalizeMessageID(id)
+	inFolders := map[string]bool{}
+	if store, err := openPG(); err == nil {
+		folders, err := store.FindMessageFolders(context.Background(), profile.Name, want)
+		store.Close()
+		if err == nil {
+			for _, f := range folders {
+				inFolders[f] = true
+			}
+		}
+	}
+	if len(inFolders) == 0 {
+		if folders, err := sqliteFindMessageFolders(sqliteIndexPath(profile), want); err == nil {
+			for _, f := range folders {
+				inFolders[f] = true
+			}
+		}
+	}
+	if len(inFolders) == 0 {
+		if idx, err := loadIndex(profile); err == nil {
+			for _, fi := range idx.Folders {
+				for _, m := range fi.Messages {
+					if normalizeMessageID(m.MessageID) == want {
+						inFolders[m.Folder] = true
+					}
+				}
+			}
+		}
+	}
+	if len(inFolders) == 0 {
+		return boxes
+	}
+	var out []Mailbox
+	for _, b := range boxes {
+		if inFolders[b.Name] {
+			out = append(out, b)
+		}
+	}
+	if len(out) == 0 {
+		return boxes
+	}
+	return out
+}
+
+// showTargets resolves the folders a show-style command scans: the folders holding
+// --message-id, the first folder matching --folder, or every folder when neither is set.
+func (a *App) showTargets(profile Profile, opts showOptions) ([]Mailbox, error) {
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return nil, err
+	}
+	var targets []Mailbox
+	switch {
+	case opts.messageID != "":
+		targets = a.locateMessageID(profile, boxes, opts.messageID)
+	case opts.folderLike == "":
+		targets = boxes
+	default:
+		for _, b := range boxes {
+			needle := strings.ToLower(opts.folderLike)
+			if strings.Contains(strings.ToLower(b.Name), needle) || strings.Contains(strings.ToLower(filepath.Base(b.Name)), needle) {
+				targets = append(targets, b)
+				break
+			}
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("no folders match %q", opts.folderLike)
+		}
+	}
 
-func splitCSV(s string) []string {
-	if s == "" {
-		return nil
-	}
-	parts := strings.Split(s, ",")
-	var out []string
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			out = append(out, p)
+	if len(opts.accounts) > 0 {
+		scoped, err := a.scopeToAccounts(profile, targets, opts.accounts)
+		if err != nil {
+			return nil, err
 		}
+		if len(scoped) == 0 && opts.folderLike != "" && opts.messageID == "" {
+			return nil, fmt.Errorf("folder %s not in account %s", targets[0].Name, strings.Join(opts.accounts, ", "))
+		}
+		targets = scoped
 	}
-	return out
+	return targets, nil
 }
 
-func uniqueStrings(in []string) []string {
-	m := map[string]struct{}{}
-	var out []string
-	for _, v := range in {
-		if _, ok := m[v]; ok {
-			continue
-		}
-		m[v] = struct{}{}
-		out = append(out, v)
-	}
-	return out
+// shownMessage is a parsed message selected for display along with its original bytes.
+type shownMessage struct {
+	summary  MailSummary
+	bodyText string
+	raw      []byte
 }
 
-func (a *App) showMail(profileName, folderLike, query, accountEmail string, limit int, thread bool) error {
+func (a *App) showMail(profileName string, opts showOptions) error {
 	profile, err := a.resolveProfile(profileName)
 	if err != nil {
 		return err
 	}
-	accountEmail = strings.ToLower(strings.TrimSpace(accountEmail))
-	boxes, err := a.listMailboxes(profile)
-	if err != nil {
-		return err
-	}
-	var target Mailbox
-	found := false
-	for _, b := range boxes {
-		needle := strings.ToLower(folderLike)
-		if strings.Contains(strings.ToLower(b.Name), needle) || strings.Contains(strings.ToLower(filepath.Base(b.Name)), needle) {
-			target = b
-			found = true
-			break
-		}
+	targetOpts := opts
+	if opts.relativeTo != "" && opts.folderLike == "" {
+		targetOpts.messageID = opts.relativeTo
 	}
-	if !found {
-		return fmt.Errorf("no folders match %q", folderLike)
+	var targets []Mailbox
+	var store *pgStore
+	scan := func(fn func(sm shownMessage) (bool, error)) error {
+		return scanShownMessages(targets, fn)
 	}
-
-	if accountEmail != "" {
-		idx, err := a.loadAccountDirIndex(profile)
-		if err != nil {
-			return fmt.Errorf("account index: %w", err)
+	if opts.store == "pg" {
+		if store, err = openPG(); err != nil {
+			return fmt.Errorf("postgres required for --store pg: %w", err)
 		}
-		dirs := idx[accountEmail]
-		ok := false
-		for _, d := range dirs {
-			if strings.HasPrefix(target.Path, d) {
-				ok = true
-				break
-			}
-		}
-		if !ok {
-			return fmt.Errorf("folder %s not in account %s", target.Name, accountEmail)
+		defer store.Close()
+		scan = func(fn func(sm shownMessage) (bool, error)) error {
+			return store.ScanBodies(context.Background(), profile.Name, opts, fn)
 		}
+	} else if targets, err = a.showTargets(profile, targetOpts); err != nil {
+		return err
 	}
 
-	queryLower := strings.ToLower(query)
-	f, err := os.Open(target.Path)
-	if err != nil {
-		return err
+	tagNames := a.loadTagNames(profile)
+	var me map[string]bool
+	if opts.mailtoReply {
+		me = a.myAddresses(profile)
 	}
-	defer f.Close()
-	reader := mbox.NewReader(f)
-	count := 0
-	var threadSubject string
-	var threadMsgs []struct {
-		summary  MailSummary
-		bodyText string
+	var mboxOut *mbox.Writer
+	if opts.format == "mbox" {
+		mboxOut = mbox.NewWriter(os.Stdout)
+		defer mboxOut.Close()
 	}
-	for {
-		if limit > 0 && count >= limit {
-			break
+	emit := func(sm shownMessage) error {
+		atts, attErr := listAttachments(sm.raw)
+		var sec *securityInfo
+		if !opts.noCrypto && opts.format != "mbox" && !opts.raw && !opts.attachmentsOnly {
+			sec, sm.bodyText = inspectPGP(sm.raw, sm.bodyText)
+			if sec == nil {
+				sec, sm.bodyText = inspectSMIME(sm.raw, sm.bodyText)
+			}
 		}
-		msgReader, err := reader.NextMessage()
-		if err == io.EOF {
-			break
+		switch {
+		case opts.attachmentsOnly:
+			fmt.Printf("%s | %s | %s\n", sm.summary.Date, truncate(sm.summary.From, 40), truncate(sm.summary.Subject, 60))
+			if len(atts) == 0 {
+				fmt.Println("  (no attachments)")
+			}
+			for _, a := range atts {
+				fmt.Printf("  [%s] %s (%s, %s)\n", a.Index, a.Filename, a.ContentType, byteSize(a.Size))
+			}
+		case opts.mailtoReply:
+			fmt.Println(replyMailto(sm.summary, sm.raw, me, opts.mailtoReplyAll))
+			return nil
+		case opts.format == "mbox":
+			mw, err := mboxOut.CreateMessage(strings.Trim(senderAddress(sm.summary.From), "<>"), sm.summary.When)
+			if err != nil {
+				return err
+			}
+			if _, err := mw.Write(sm.raw); err != nil {
+				return err
+			}
+		case opts.raw:
+			os.Stdout.Write(sm.raw)
+		case opts.format == "json":
+			var auth *authReport
+			if opts.auth {
+				rep := buildAuthReport(sm.summary, sm.raw, opts.verifyDKIM)
+				auth = &rep
+			}
+			if err := printMessageJSON(sm, sec, auth, opts.stripTracking); err != nil {
+				return err
+			}
+		default:
+			var headers []headerField
+			if opts.allHeaders || len(opts.headerNames) > 0 {
+				headers = parseHeaderFields(sm.raw)
+				if !opts.allHeaders {
+					headers = selectHeaders(headers, opts.headerNames)
+				}
+			}
+			if sec != nil {
+				headers = append(headers, headerField{Name: "Security", Value: sec.String()})
+			}
+			body := sm.bodyText
+			if !opts.fullQuotes {
+				body = foldQuotes(body)
+			}
+			printFullMessage(sm.summary, body, tagNames, headers, opts.allHeaders)
+			if events, err := messageEvents(sm.raw); err == nil {
+				printEvents(events)
+			}
+			if cards, err := messageVCards(sm.raw); err == nil {
+				printVCards(cards)
+			}
+			if opts.auth {
+				printAuthReport(buildAuthReport(sm.summary, sm.raw, opts.verifyDKIM))
+			}
+			if attErr == nil {
+				printAttachments(atts)
+			}
+			if !opts.noLinks {
+				if links, err := listLinks(sm.raw, opts.stripTracking); err == nil {
+					printLinks(links)
+				}
+			}
 		}
-		if err != nil {
-			log.Printf("warn: %s: %v", target.Name, err)
-			continue
+		if !opts.attachmentsOnly && opts.format == "text" {
+			fmt.Println(opts.delimiter)
 		}
-		summary, bodyText, err := parseMessageFull(msgReader, target.Name)
-		if err != nil {
-			continue
+		if opts.saveAttachments != "" && len(atts) > 0 {
+			paths, err := saveAttachments(opts.saveAttachments, atts)
+			for _, p := range paths {
+				log.Printf("info: saved %s", p)
+			}
+			if err != nil {
+				return err
+			}
 		}
-		if accountEmail != "" {
-			summary.Account = accountEmail
+		if opts.saveVCards != "" {
+			cards, err := messageVCards(sm.raw)
+			if err == nil && len(cards) > 0 {
+				paths, err := saveVCards(opts.saveVCards, cards)
+				for _, p := range paths {
+					log.Printf("info: saved %s", p)
+				}
+				if err != nil {
+					return err
+				}
+			}
 		}
-		blob := strings.ToLower(strings.Join([]string{summary.Subject, summary.From, bodyText}, " "))
-		normSub := normalizeSubject(summary.Subject)
-		if thread && threadSubject != "" {
-			if normSub == threadSubject {
-				threadMsgs = append(threadMsgs, struct {
-					summary  MailSummary
-					bodyText string
-				}{summary: summary, bodyText: bodyText})
+		if opts.savePath != "" {
+			path, err := saveRawMessage(opts.savePath, sm.summary, sm.raw)
+			if err != nil {
+				return err
 			}
-			continue
+			log.Printf("info: saved %s", path)
 		}
-		if !strings.Contains(blob, queryLower) {
-			continue
+		if opts.exportEml != "" {
+			path, err := writeEML(opts.exportEml, sm.summary, sm.raw)
+			if err != nil {
+				return err
+			}
+			log.Printf("info: saved %s", path)
 		}
-		if thread {
-			threadSubject = normSub
-			threadMsgs = append(threadMsgs, struct {
-				summary  MailSummary
-				bodyText string
-			}{summary: summary, bodyText: bodyText})
-		} else {
+		return nil
+	}
+	match := withExcludes(makeMatcher(opts.query, opts.fuzzy), opts.excludes)
+	selected := func(sm shownMessage) bool {
+		if opts.messageID != "" {
+			return normalizeMessageID(sm.summary.MessageID) == normalizeMessageID(opts.messageID)
+		}
+		return match(strings.ToLower(strings.Join([]string{sm.summary.Subject, sm.summary.From, sm.bodyText}, " ")))
+	}
+	if opts.messageID != "" && !opts.thread {
+		// Seek straight to the message when the local index knows where it lives.
+		id := normalizeMessageID(opts.messageID)
+		shown := 0
+		for _, t := range targets {
+			for _, sp := range indexedSpans(profile, []string{id})[id] {
+				if sp.Folder != t.Name || (opts.limit > 0 && shown >= opts.limit) {
+					continue
+				}
+				raw, ok := readIndexedMessage(t, sp.Span, id)
+				if !ok {
+					continue
+				}
+				summary, bodyText, err := parseMessageFull(bytes.NewReader(raw), t.Name)
+				if err != nil {
+					continue
+				}
+				if t.Account != "" {
+					summary.Account = t.Account
+				}
+				if err := emit(shownMessage{summary: summary, bodyText: bodyText, raw: raw}); err != nil {
+					return err
+				}
+				shown++
+			}
+		}
+		if shown > 0 {
+			return nil
+		}
+	}
+	if opts.relativeTo != "" {
+		// Step through the folder in mbox (arrival) order from the given message.
+		want := normalizeMessageID(opts.relativeTo)
+		var prev *shownMessage
+		var picked *shownMessage
+		found := false
+		if err := scan(func(sm shownMessage) (bool, error) {
+			if found {
+				picked = &sm
+				return false, nil
+			}
+			if normalizeMessageID(sm.summary.MessageID) == want {
+				found = true
+				if opts.step < 0 {
+					picked = prev
+					return false, nil
+				}
+				return true, nil
+			}
+			prev = &sm
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		switch {
+		case !found:
+			return fmt.Errorf("message %s not found", opts.relativeTo)
+		case picked == nil && opts.step < 0:
+			fmt.Println("No earlier message.")
+			return nil
+		case picked == nil:
+			fmt.Println("No later message.")
+			return nil
+		}
+		return emit(*picked)
+	}
+	if opts.nth != 0 {
+		// Positive N counts matches from the oldest; negative N from the newest.
+		var picked *shownMessage
+		var ring []shownMessage
+		count := 0
+		if err := scan(func(sm shownMessage) (bool, error) {
+			if !selected(sm) {
+				return true, nil
+			}
 			count++
-			printFullMessage(summary, bodyText)
-			fmt.Println(strings.Repeat("-", 80))
+			if opts.nth > 0 {
+				if count == opts.nth {
+					picked = &sm
+					return false, nil
+				}
+				return true, nil
+			}
+			ring = append(ring, sm)
+			if len(ring) > -opts.nth {
+				ring = ring[1:]
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		if opts.nth < 0 && len(ring) == -opts.nth {
+			picked = &ring[0]
+		}
+		if picked == nil {
+			fmt.Printf("No message %d (%d matching).\n", opts.nth, count)
+			return nil
 		}
+		return emit(*picked)
 	}
-	if thread {
+	if opts.thread {
+		var threadMsgs []shownMessage
+		if store != nil {
+			threadMsgs, err = store.ShowConversation(context.Background(), profile.Name, opts, selected)
+		} else {
+			threadMsgs, err = a.showConversation(profile, opts, targets, selected)
+		}
+		if err != nil {
+			return err
+		}
 		if len(threadMsgs) == 0 {
 			fmt.Println("No matches.")
 			return nil
@@ -1336,27 +3252,149 @@ func (a *App) showMail(profileName, folderLike, query, accountEmail string, limi
 			}
 			return threadMsgs[i].summary.When.Before(threadMsgs[j].summary.When)
 		})
-		if limit > 0 && len(threadMsgs) > limit {
-			threadMsgs = threadMsgs[:limit]
+		if opts.limit > 0 && len(threadMsgs) > opts.limit {
+			threadMsgs = threadMsgs[:opts.limit]
 		}
 		for _, tm := range threadMsgs {
-			printFullMessage(tm.summary, tm.bodyText)
-			fmt.Println(strings.Repeat("-", 80))
+			if err := emit(tm); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	count := 0
+	if err := scan(func(sm shownMessage) (bool, error) {
+		if !selected(sm) {
+			return true, nil
+		}
+		count++
+		if err := emit(sm); err != nil {
+			return false, err
 		}
-	} else if count == 0 {
+		return opts.limit <= 0 || count < opts.limit, nil
+	}); err != nil {
+		return err
+	}
+	if count == 0 {
 		fmt.Println("No matches.")
 	}
 	return nil
 }
 
+// showConversation returns the messages of the conversation holding the
+// first message of targets that selected picks. The reply graph is built in
+// one pass over every folder of the profile, so replies filed elsewhere (Sent,
+// archives) join the thread; its messages are then read back from where the
+// pass found them.
+func (a *App) showConversation(profile Profile, opts showOptions, targets []Mailbox, selected func(shownMessage) bool) ([]shownMessage, error) {
+	graphOpts := opts
+	graphOpts.messageID, graphOpts.folderLike = "", ""
+	boxes, err := a.showTargets(profile, graphOpts)
+	if err != nil {
+		return nil, err
+	}
+	seedFolders := map[string]bool{}
+	for _, t := range targets {
+		seedFolders[t.Name] = true
+	}
+	var seed string
+	var graph []MailSummary
+	if err := scanShownMessages(boxes, func(sm shownMessage) (bool, error) {
+		if seed == "" && seedFolders[sm.summary.Folder] && selected(sm) {
+			seed = threadKey(sm.summary)
+		}
+		sm.summary.Body = ""
+		graph = append(graph, sm.summary)
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	if seed == "" {
+		return nil, nil
+	}
+	members := threadMembers(graph, seed)
+	byName := map[string]Mailbox{}
+	for _, b := range boxes {
+		byName[b.Name] = b
+	}
+	var msgs []shownMessage
+	for _, m := range graph {
+		if !members[threadKey(m)] {
+			continue
+		}
+		raw, err := readMessageAt(byName[m.Folder].Path, mboxSpan{Offset: m.Offset, Length: m.Length})
+		if err != nil {
+			log.Printf("warn: %s: %v", m.Folder, err)
+			continue
+		}
+		summary, bodyText, err := parseMessageFull(bytes.NewReader(raw), m.Folder)
+		if err != nil {
+			continue
+		}
+		summary.Account = m.Account
+		msgs = append(msgs, shownMessage{summary: summary, bodyText: bodyText, raw: raw})
+	}
+	return msgs, nil
+}
+
+// scanShownMessages parses every message in targets, in order, until fn returns false.
+func scanShownMessages(targets []Mailbox, fn func(sm shownMessage) (bool, error)) error {
+	for _, target := range targets {
+		more, err := scanShownMailbox(target, fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// errScanStopped ends scanShownMailbox early once fn wants no more messages.
+var errScanStopped = errors.New("scan stopped")
+
+// scanShownMailbox parses the messages of target in order, recording in each
+// summary where the message lives, until fn returns false.
+func scanShownMailbox(target Mailbox, fn func(sm shownMessage) (bool, error)) (bool, error) {
+	f, err := openMailbox(target)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	err = scanMboxSpans(f, func(span mboxSpan, chunk []byte) error {
+		raw, err := rawFromChunk(chunk)
+		if err != nil {
+			log.Printf("warn: %s: %v", target.Name, err)
+			return nil
+		}
+		summary, bodyText, err := parseMessageFull(bytes.NewReader(raw), target.Name)
+		if err != nil || summary.Expunged() {
+			return nil
+		}
+		if target.Account != "" {
+			summary.Account = target.Account
+		}
+		summary.Offset, summary.Length = span.Offset, span.Length
+		more, err := fn(shownMessage{summary: summary, bodyText: bodyText, raw: raw})
+		if err == nil && !more {
+			err = errScanStopped
+		}
+		return err
+	})
+	if err == errScanStopped {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func parseMessage(r io.Reader, folderName string) (MailSummary, string, error) {
-	msg, err := mail.ReadMessage(io.LimitReader(r, maxMessageBytes))
+	cr := &countingReader{r: r}
+	msg, err := mail.ReadMessage(io.LimitReader(cr, maxMessageBytes))
 	if err != nil {
 		return MailSummary{}, "", err
 	}
-	decode := new(mime.WordDecoder)
-	subject, _ := decode.DecodeHeader(msg.Header.Get("Subject"))
-	from, _ := decode.DecodeHeader(msg.Header.Get("From"))
+	subject := decodeHeader(msg.Header.Get("Subject"))
+	from := decodeHeader(msg.Header.Get("From"))
+	to := decodeHeader(msg.Header.Get("To"))
+	cc := decodeHeader(msg.Header.Get("Cc"))
 	dateHeader := msg.Header.Get("Date")
 	when := dateHeader
 	var whenTime time.Time
@@ -1365,7 +3403,12 @@ func parseMessage(r io.Reader, folderName string) (MailSummary, string, error) {
 		whenTime = t
 	}
 
+	mozStatus := parseMozStatus(msg.Header.Get("X-Mozilla-Status"))
+	tags := strings.ToLower(strings.Join(strings.Fields(msg.Header.Get("X-Mozilla-Keys")), " "))
+	inReplyTo := firstMessageID(msg.Header.Get("In-Reply-To"))
+	references := strings.Join(parseMessageIDList(msg.Header.Get("References")), " ")
 	bodyBytes, _ := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
+	attachments := countAttachments(msg.Header, bodyBytes)
 	plain, alt := extractText(msg.Header, bodyBytes)
 	bodyText := plain
 	if bodyText == "" {
@@ -1374,24 +3417,35 @@ func parseMessage(r io.Reader, folderName string) (MailSummary, string, error) {
 	snippet := firstNonEmptyLine(bodyText)
 	searchText := strings.ToLower(strings.Join([]string{subject, from, dateHeader, bodyText}, " "))
 	return MailSummary{
-		Folder:    folderName,
-		Subject:   strings.TrimSpace(subject),
-		From:      strings.TrimSpace(from),
-		Date:      when,
-		MessageID: msg.Header.Get("Message-Id"),
-		Snippet:   snippet,
-		When:      whenTime,
+		Folder:      folderName,
+		Subject:     strings.TrimSpace(subject),
+		From:        strings.TrimSpace(from),
+		To:          strings.TrimSpace(to),
+		Cc:          strings.TrimSpace(cc),
+		Date:        when,
+		MessageID:   msg.Header.Get("Message-Id"),
+		Snippet:     snippet,
+		When:        whenTime,
+		Body:        bodyText,
+		Size:        cr.drain(),
+		Attachments: attachments,
+		MozStatus:   mozStatus,
+		Tags:        tags,
+		InReplyTo:   inReplyTo,
+		References:  references,
 	}, searchText, nil
 }
 
 func parseMessageFull(r io.Reader, folderName string) (MailSummary, string, error) {
-	msg, err := mail.ReadMessage(io.LimitReader(r, maxMessageBytes))
+	cr := &countingReader{r: r}
+	msg, err := mail.ReadMessage(io.LimitReader(cr, maxMessageBytes))
 	if err != nil {
 		return MailSummary{}, "", err
 	}
-	decode := new(mime.WordDecoder)
-	subject, _ := decode.DecodeHeader(msg.Header.Get("Subject"))
-	from, _ := decode.DecodeHeader(msg.Header.Get("From"))
+	subject := decodeHeader(msg.Header.Get("Subject"))
+	from := decodeHeader(msg.Header.Get("From"))
+	to := decodeHeader(msg.Header.Get("To"))
+	cc := decodeHeader(msg.Header.Get("Cc"))
 	dateHeader := msg.Header.Get("Date")
 	when := dateHeader
 	var whenTime time.Time
@@ -1399,23 +3453,57 @@ func parseMessageFull(r io.Reader, folderName string) (MailSummary, string, erro
 		when = t.In(time.Local).Format("2006-01-02 15:04")
 		whenTime = t
 	}
+	mozStatus := parseMozStatus(msg.Header.Get("X-Mozilla-Status"))
+	tags := strings.ToLower(strings.Join(strings.Fields(msg.Header.Get("X-Mozilla-Keys")), " "))
+	inReplyTo := firstMessageID(msg.Header.Get("In-Reply-To"))
+	references := strings.Join(parseMessageIDList(msg.Header.Get("References")), " ")
 	bodyBytes, _ := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
-	plain, alt := extractText(msg.Header, bodyBytes)
+	attachments := countAttachments(msg.Header, bodyBytes)
+	plain, alt := extractTextAs(msg.Header, bodyBytes, htmlToMarkdown)
 	bodyText := plain
 	if bodyText == "" {
 		bodyText = alt
 	}
 	return MailSummary{
-		Folder:    folderName,
-		Subject:   strings.TrimSpace(subject),
-		From:      strings.TrimSpace(from),
-		Date:      when,
-		MessageID: msg.Header.Get("Message-Id"),
-		Snippet:   firstNonEmptyLine(bodyText),
-		When:      whenTime,
+		Folder:      folderName,
+		Subject:     strings.TrimSpace(subject),
+		From:        strings.TrimSpace(from),
+		To:          strings.TrimSpace(to),
+		Cc:          strings.TrimSpace(cc),
+		Date:        when,
+		MessageID:   msg.Header.Get("Message-Id"),
+		Snippet:     firstNonEmptyLine(bodyText),
+		When:        whenTime,
+		Body:        bodyText,
+		Size:        cr.drain(),
+		Attachments: attachments,
+		MozStatus:   mozStatus,
+		Tags:        tags,
+		InReplyTo:   inReplyTo,
+		References:  references,
 	}, bodyText, nil
 }
 
+// countingReader tracks how many bytes of a message have been consumed so the
+// on-disk size is known even when parsing stops at maxMessageBytes.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// drain consumes the rest of the message and returns its total size.
+func (c *countingReader) drain() int64 {
+	n, _ := io.Copy(io.Discard, c.r)
+	c.n += n
+	return c.n
+}
+
 func parseDateFlexible(dateHeader string) (time.Time, bool) {
 	if dateHeader == "" {
 		return time.Time{}, false
@@ -1484,21 +3572,90 @@ func normalizeSubject(sub string) string {
 	}
 }
 
-func printFullMessage(m MailSummary, body string) {
-	fmt.Printf("From: %s\n", m.From)
-	fmt.Printf("Subject: %s\n", m.Subject)
-	fmt.Printf("Date: %s\n", m.Date)
+// printFullMessage prints a header block and the decoded body. Extra headers are
+// appended to the standard block; with allHeaders they replace From/To/Subject/Date.
+func printFullMessage(m MailSummary, body string, tagNames map[string]string, headers []headerField, allHeaders bool) {
+	if allHeaders {
+		for _, h := range headers {
+			fmt.Printf("%s: %s\n", h.Name, h.Value)
+		}
+	} else {
+		fmt.Printf("From: %s\n", m.From)
+		if m.To != "" {
+			fmt.Printf("To: %s\n", m.To)
+		}
+		if m.Cc != "" {
+			fmt.Printf("Cc: %s\n", m.Cc)
+		}
+		fmt.Printf("Subject: %s\n", m.Subject)
+		fmt.Printf("Date: %s\n", m.Date)
+	}
 	if m.Account != "" {
 		fmt.Printf("Account: %s\n", m.Account)
 	}
 	fmt.Printf("Folder: %s\n", m.Folder)
-	if m.MessageID != "" {
-		fmt.Printf("Message-ID: %s\n", m.MessageID)
+	if m.Tags != "" {
+		fmt.Printf("Tags: %s\n", tagLabels(m.Tags, tagNames))
+	}
+	if !allHeaders {
+		if m.MessageID != "" {
+			fmt.Printf("Message-ID: %s\n", m.MessageID)
+		}
+		for _, h := range headers {
+			fmt.Printf("%s: %s\n", h.Name, h.Value)
+		}
 	}
 	fmt.Println()
 	fmt.Println(body)
 }
 
+// headerField is one header line, unfolded and RFC 2047-decoded, in message order.
+type headerField struct {
+	Name  string
+	Value string
+}
+
+// parseHeaderFields reads the header block of raw in its original order
+// (net/mail only offers an unordered map, which loses Received ordering).
+func parseHeaderFields(raw []byte) []headerField {
+	var fields []headerField
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64*1024), maxPartBytes)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields = append(fields, headerField{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+	}
+	for i := range fields {
+		fields[i].Value = decodeHeader(fields[i].Value)
+	}
+	return fields
+}
+
+// selectHeaders keeps the fields whose names appear in names (case-insensitive).
+func selectHeaders(fields []headerField, names []string) []headerField {
+	var out []headerField
+	for _, f := range fields {
+		for _, n := range names {
+			if strings.EqualFold(f.Name, n) {
+				out = append(out, f)
+				break
+			}
+		}
+	}
+	return out
+}
+
 func firstNonEmptyLine(body string) string {
 	scanner := bufio.NewScanner(strings.NewReader(body))
 	for scanner.Scan() {
@@ -1511,6 +3668,11 @@ func firstNonEmptyLine(body string) string {
 }
 
 func extractText(h mail.Header, body []byte) (plain string, fallback string) {
+	return extractTextAs(h, body, htmlToText)
+}
+
+// extractTextAs is extractText with a custom HTML renderer for the fallback text.
+func extractTextAs(h mail.Header, body []byte, renderHTML func(string) string) (plain string, fallback string) {
 	ctype := h.Get("Content-Type")
 	mediaType, params, err := mime.ParseMediaType(ctype)
 	if err != nil || mediaType == "" {
@@ -1531,7 +3693,7 @@ func extractText(h mail.Header, body []byte) (plain string, fallback string) {
 				break
 			}
 			partBody, _ := io.ReadAll(io.LimitReader(part, maxPartBytes))
-			pPlain, pFallback := extractText(mail.Header(part.Header), partBody)
+			pPlain, pFallback := extractTextAs(mail.Header(part.Header), partBody, renderHTML)
 			if pPlain != "" && plain == "" {
 				plain = pPlain
 			}
@@ -1566,11 +3728,71 @@ func extractText(h mail.Header, body []byte) (plain string, fallback string) {
 	}
 	text := string(decoded)
 	if strings.HasPrefix(mediaType, "text/html") {
-		return "", htmlToText(text)
+		return "", renderHTML(text)
 	}
 	return text, ""
 }
 
+// mimePart is a single leaf of a MIME tree. Index is the dotted part path (1, 1.2, ...).
+type mimePart struct {
+	Index  string
+	Header mail.Header
+	Body   []byte
+}
+
+// walkMIME calls fn for every non-multipart leaf of the message, depth first.
+func walkMIME(h mail.Header, body []byte, index string, fn func(mimePart)) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		if index == "" {
+			index = "1"
+		}
+		fn(mimePart{Index: index, Header: h, Body: body})
+		return
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for i := 1; ; i++ {
+		part, err := mr.NextPart()
+		if err != nil {
+			return
+		}
+		partBody, _ := io.ReadAll(io.LimitReader(part, maxMessageBytes))
+		childIndex := strconv.Itoa(i)
+		if index != "" {
+			childIndex = index + "." + childIndex
+		}
+		walkMIME(mail.Header(part.Header), partBody, childIndex, fn)
+	}
+}
+
+// isAttachment reports whether a leaf part is an attachment rather than body text:
+// either explicitly marked as such or carrying a filename.
+func isAttachment(p mimePart) bool {
+	disposition, dparams, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	if disposition == "attachment" {
+		return true
+	}
+	if dparams["filename"] != "" {
+		return true
+	}
+	_, cparams, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	return cparams["name"] != ""
+}
+
+func countAttachments(h mail.Header, body []byte) int {
+	n := 0
+	walkMIME(h, body, "", func(p mimePart) {
+		if isAttachment(p) {
+			n++
+		}
+	})
+	return n
+}
+
 func decodeBodyContent(encoding string, body []byte) ([]byte, error) {
 	switch strings.ToLower(strings.TrimSpace(encoding)) {
 	case "base64":
@@ -1614,11 +3836,64 @@ func htmlToText(htmlBody string) string {
 	return strings.Join(words, " ")
 }
 
+// truncate shortens s to at most n terminal columns, cutting on rune boundaries
+// and counting wide (CJK, emoji) runes as two columns.
 func truncate(s string, n int) string {
-	if len(s) <= n {
+	if displayWidth(s) <= n {
 		return s
 	}
-	return s[:n-3] + "..."
+	limit := n - 3
+	w := 0
+	for i, r := range s {
+		rw := runeWidth(r)
+		if w+rw > limit {
+			return s[:i] + "..."
+		}
+		w += rw
+	}
+	return s
+}
+
+// runeWidth returns the number of terminal columns r occupies.
+func runeWidth(r rune) int {
+	if r == 0 || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || r == '\u200d' {
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+// parseByteSize parses sizes such as "500", "10K", "5M", or "1.5G" (binary units).
+// An empty string yields 0.
+func parseByteSize(raw string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(raw))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			mult = int64(1) << (10 * (i + 1))
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 500K, 5M)", raw)
+	}
+	return int64(v * float64(mult)), nil
 }
 
 func byteSize(n int64) string {
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Thunderbird's maildir store (mail.server.*.storeContractID set to
+// @mozilla.org/msgstore/maildirstore;1) keeps each folder as a directory
+// holding one file per message under cur/, with subfolders in a .sbd
+// directory next to it as for mbox. tb reads such a folder as the mbox its
+// messages would make in file name order, which for Thunderbird's names is
+// delivery order, so offsets, spans, and every scanner work unchanged.
+
+// maildirFromLine is the From_ line written before each message. Its date
+// is fixed so every message adds the same number of bytes.
+const maildirFromLine = "From - Mon Jan  1 00:00:00 2001\n"
+
+// isMaildir reports whether dir is a maildir folder: it has a cur/
+// subdirectory.
+func isMaildir(dir string) bool {
+	fi, err := os.Stat(filepath.Join(dir, "cur"))
+	return err == nil && fi.IsDir()
+}
+
+type maildirFile struct {
+	path string
+	size int64
+	mod  time.Time
+}
+
+// maildirFiles lists the messages of the maildir folder dir, from cur/ and
+// new/, sorted by name.
+func maildirFiles(dir string) ([]maildirFile, error) {
+	var files []maildirFile
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || e.Name()[0] == '.' {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			files = append(files, maildirFile{filepath.Join(dir, sub, e.Name()), info.Size(), info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return filepath.Base(files[i].path) < filepath.Base(files[j].path) })
+	return files, nil
+}
+
+// maildirInfo describes a maildir folder the way os.Stat describes an mbox:
+// the size of the mbox it reads as (not counting From escapes), and the
+// newest change to its messages.
+type maildirInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+func (m maildirInfo) Name() string       { return m.name }
+func (m maildirInfo) Size() int64        { return m.size }
+func (m maildirInfo) Mode() fs.FileMode  { return 0o644 }
+func (m maildirInfo) ModTime() time.Time { return m.mtime }
+func (m maildirInfo) IsDir() bool        { return false }
+func (m maildirInfo) Sys() any           { return nil }
+
+func maildirStat(dir string, files []maildirFile) maildirInfo {
+	info := maildirInfo{name: filepath.Base(dir)}
+	// Adding or removing a message changes the directories' mtimes.
+	for _, sub := range []string{"cur", "new"} {
+		if fi, err := os.Stat(filepath.Join(dir, sub)); err == nil && fi.ModTime().After(info.mtime) {
+			info.mtime = fi.ModTime()
+		}
+	}
+	for _, f := range files {
+		info.size += int64(len(maildirFromLine)) + f.size + 1
+		if f.mod.After(info.mtime) {
+			info.mtime = f.mod
+		}
+	}
+	return info
+}
+
+// statMailbox stats box's mbox file, or sums up its maildir folder.
+func statMailbox(box Mailbox) (os.FileInfo, error) {
+	if !box.Maildir {
+		return os.Stat(box.Path)
+	}
+	files, err := maildirFiles(box.Path)
+	if err != nil {
+		return nil, err
+	}
+	return maildirStat(box.Path, files), nil
+}
+
+// maildirReader reads a maildir folder as an mbox: each message after a
+// From_ line, with its own lines starting "From " escaped to ">From ", and a
+// blank line after it.
+type maildirReader struct {
+	files []maildirFile
+	size  int64
+	next  int
+	f     *os.File
+	br    *bufio.Reader
+	buf   []byte
+}
+
+func openMaildir(dir string) (*maildirReader, error) {
+	files, err := maildirFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &maildirReader{files: files, size: maildirStat(dir, files).size}, nil
+}
+
+func (r *maildirReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill queues the next line of the stream.
+func (r *maildirReader) fill() error {
+	if r.br == nil {
+		if r.next >= len(r.files) {
+			return io.EOF
+		}
+		path := r.files[r.next].path
+		r.next++
+		f, err := os.Open(path)
+		if err != nil {
+			// Deleted since the folder was listed.
+			log.Printf("warn: %v", err)
+			return nil
+		}
+		r.f, r.br = f, bufio.NewReaderSize(f, 64*1024)
+		r.buf = append(r.buf[:0], maildirFromLine...)
+		return nil
+	}
+	line, err := r.br.ReadBytes('\n')
+	if bytes.HasPrefix(line, mboxFromLine) {
+		r.buf = append(append(r.buf[:0], '>'), line...)
+	} else {
+		r.buf = append(r.buf[:0], line...)
+	}
+	if err == nil {
+		return nil
+	}
+	r.f.Close()
+	r.f, r.br = nil, nil
+	if err != io.EOF {
+		return err
+	}
+	if len(line) > 0 && line[len(line)-1] != '\n' {
+		r.buf = append(r.buf, '\n')
+	}
+	r.buf = append(r.buf, '\n')
+	return nil
+}
+
+func (r *maildirReader) Close() error {
+	if r.f != nil {
+		return r.f.Close()
+	}
+	return nil
+}
+
+// openMailbox opens box for reading as an mbox.
+func openMailbox(box Mailbox) (io.ReadCloser, error) {
+	if box.Maildir {
+		return openMaildir(box.Path)
+	}
+	return os.Open(box.Path)
+}
+
+// mailboxStream is box opened for a scan from a byte offset on: its mbox
+// file cut to a snapshot (see mboxSnapshot), or the messages of its maildir
+// folder as listed at open.
+type mailboxStream struct {
+	io.Reader
+	size      int64 // of the whole mbox, for progress
+	closer    io.Closer
+	unchanged func() error // errFolderChanged once the mbox was replaced or shrank
+}
+
+func (s *mailboxStream) Close() error { return s.closer.Close() }
+
+func openMailboxStream(box Mailbox, from int64) (*mailboxStream, error) {
+	if box.Maildir {
+		r, err := openMaildir(box.Path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(io.Discard, r, from); err != nil {
+			r.Close()
+			return nil, err
+		}
+		return &mailboxStream{Reader: r, size: r.size, closer: r, unchanged: func() error { return nil }}, nil
+	}
+	f, err := os.Open(box.Path)
+	if err != nil {
+		return nil, err
+	}
+	snap, size, err := mboxSnapshot(f, box)
+	if err == nil && from > 0 {
+		_, err = f.Seek(from, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &mailboxStream{
+		Reader:    io.LimitReader(f, max(size-from, 0)),
+		size:      size,
+		closer:    f,
+		unchanged: func() error { return mboxUnchanged(box.Path, snap) },
+	}, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// mailtoEscape percent-encodes s for a mailto: URI (RFC 6068): everything
+// outside the unreserved set is escaped, so spaces become %20 rather than +.
+func mailtoEscape(s string, keep string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte("-._~", c) >= 0, strings.IndexByte(keep, c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// mailtoURI builds a mailto: URI. Recipients are comma-separated addresses;
+// body line breaks are normalized to CRLF as RFC 6068 requires.
+func mailtoURI(to, cc, subject, body string, extra ...[2]string) string {
+	var addrs []string
+	for _, a := range strings.Split(to, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, mailtoEscape(a, "@!$'()*+;="))
+		}
+	}
+	var params []string
+	if cc != "" {
+		params = append(params, "cc="+mailtoEscape(strings.Join(strings.Fields(cc), ""), "@,"))
+	}
+	if subject != "" {
+		params = append(params, "subject="+mailtoEscape(subject, ""))
+	}
+	for _, kv := range extra {
+		params = append(params, mailtoEscape(kv[0], "")+"="+mailtoEscape(kv[1], "@"))
+	}
+	if body != "" {
+		body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+		params = append(params, "body="+mailtoEscape(body, ""))
+	}
+	uri := "mailto:" + strings.Join(addrs, ",")
+	if len(params) > 0 {
+		uri += "?" + strings.Join(params, "&")
+	}
+	return uri
+}
+
+// replyMailto builds a mailto: URI replying to a message: Reply-To (or From),
+// a "Re:" subject, and In-Reply-To so the reply threads. Replying to a
+// message sent as one of me goes to its recipients instead, as Thunderbird
+// does. With all the other To and Cc recipients are copied, leaving out me.
+func replyMailto(m MailSummary, raw []byte, me map[string]bool, all bool) string {
+	to := m.From
+	var cc []string
+	if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		if rt := strings.TrimSpace(msg.Header.Get("Reply-To")); rt != "" {
+			to = decodeHeader(rt)
+		}
+	}
+	if sentByMe(m, me) {
+		to = m.To
+		if all {
+			cc = headerAddresses(m.Cc)
+		}
+	} else if all {
+		cc = append(headerAddresses(m.To), headerAddresses(m.Cc)...)
+	}
+	addrs := headerAddresses(to)
+	seen := map[string]bool{}
+	for _, a := range addrs {
+		seen[strings.ToLower(a)] = true
+	}
+	var copies []string
+	for _, a := range cc {
+		if key := strings.ToLower(a); !seen[key] && !me[key] {
+			seen[key] = true
+			copies = append(copies, a)
+		}
+	}
+	subject := m.Subject
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(subject)), "re:") {
+		subject = "Re: " + subject
+	}
+	var extra [][2]string
+	if m.MessageID != "" {
+		extra = append(extra, [2]string{"In-Reply-To", "<" + normalizeMessageID(m.MessageID) + ">"})
+	}
+	return mailtoURI(strings.Join(addrs, ","), strings.Join(copies, ","), subject, "", extra...)
+}
+
+// headerAddresses returns the bare addresses of an address-list header.
+func headerAddresses(h string) []string {
+	if strings.TrimSpace(h) == "" {
+		return nil
+	}
+	var addrs []string
+	if list, err := mail.ParseAddressList(h); err == nil {
+		for _, a := range list {
+			addrs = append(addrs, a.Address)
+		}
+		return addrs
+	}
+	return append(addrs, strings.Trim(senderAddress(h), "<>"))
+}
@@ -3,10 +3,40 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 )
 
+// profileDirOverride is set by the global --profile-dir; it wins over
+// TB_PROFILE_DIR.
+var profileDirOverride string
+
+// globalFlags takes the flags every command accepts out of args, wherever
+// they appear, and returns the rest.
+func globalFlags(args []string) []string {
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(rest, args[i:]...)
+		case arg == "--profile-dir":
+			if i+1 == len(args) {
+				log.Fatalf("--profile-dir needs a directory")
+			}
+			i++
+			profileDirOverride = args[i]
+		case strings.HasPrefix(arg, "--profile-dir="):
+			profileDirOverride = strings.TrimPrefix(arg, "--profile-dir=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest
+}
+
 func main() {
 	log.SetFlags(0)
+	os.Args = append(os.Args[:1], globalFlags(os.Args[1:])...)
 	if len(os.Args) < 2 {
 		usage()
 		return
@@ -17,6 +47,10 @@ func main() {
 	case "search":
 		// Convenience: allow `tb search ...` as shorthand for `tb mail search ...`.
 		mailMain(append([]string{"search"}, os.Args[2:]...))
+	case "daemon":
+		daemonMain(os.Args[2:])
+	case "store":
+		storeMain(os.Args[2:])
 	case "help", "-h", "--help":
 		usage()
 	default:
@@ -25,10 +59,15 @@ func main() {
 }
 
 func usage() {
-	log.Println("Usage: tb <domain> <command> [options]")
+	log.Println("Usage: tb [--profile-dir dir] <domain> <command> [options]")
 	log.Println("Domains:")
 	log.Println("  mail    work with Thunderbird profiles/mailboxes (profiles/folders/recent/search/compose)")
 	log.Println("  search  shorthand for: tb mail search ...")
+	log.Println("  daemon  keep a profile's index warm and serve lookups/searches over a unix socket (daemon status|stop)")
+	log.Println("  store   inspect and maintain the message stores (store ping|profiles|stats|dupes|export|delete|retention)")
+	log.Println()
+	log.Println("Global options:")
+	log.Println("  --profile-dir dir  use the profile in dir (a backup, image, or fixture) without profiles.ini; also TB_PROFILE_DIR")
 	log.Println()
 	log.Println("Examples:")
 	log.Println("  tb mail profiles")
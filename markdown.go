@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlToMarkdown renders an HTML body as readable Markdown for `tb mail show`:
+// headings, emphasis, lists, quotes, and links as [text](url). Scripts, styles,
+// and images without alt text are dropped.
+func htmlToMarkdown(htmlBody string) string {
+	doc, err := html.Parse(strings.NewReader(htmlBody))
+	if err != nil {
+		return htmlToText(htmlBody)
+	}
+	r := &mdRenderer{}
+	r.walk(doc)
+	return cleanMarkdown(r.b.String())
+}
+
+type mdRenderer struct {
+	b     strings.Builder
+	lists []mdList
+	pre   int
+}
+
+type mdList struct {
+	ordered bool
+	n       int
+}
+
+func (r *mdRenderer) block() {
+	r.b.WriteString("\n\n")
+}
+
+func (r *mdRenderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.walk(c)
+	}
+}
+
+// inline renders n's children into a separate buffer so they can be wrapped.
+func (r *mdRenderer) inline(n *html.Node) string {
+	saved := r.b
+	r.b = strings.Builder{}
+	r.children(n)
+	out := r.b.String()
+	r.b = saved
+	return out
+}
+
+func (r *mdRenderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if r.pre > 0 {
+			r.b.WriteString(n.Data)
+			return
+		}
+		r.b.WriteString(collapseSpace(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		r.children(n)
+		return
+	}
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head, atom.Title, atom.Noscript:
+		return
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		r.block()
+		r.b.WriteString(strings.Repeat("#", level) + " " + strings.TrimSpace(r.inline(n)))
+		r.block()
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Center:
+		r.block()
+		r.children(n)
+		r.block()
+	case atom.Br:
+		r.b.WriteString("  \n")
+	case atom.Hr:
+		r.block()
+		r.b.WriteString("---")
+		r.block()
+	case atom.Strong, atom.B:
+		r.emphasis(n, "**")
+	case atom.Em, atom.I:
+		r.emphasis(n, "*")
+	case atom.Code:
+		if r.pre > 0 {
+			r.children(n)
+			return
+		}
+		r.b.WriteString("`" + strings.TrimSpace(r.inline(n)) + "`")
+	case atom.Pre:
+		r.pre++
+		text := r.inline(n)
+		r.pre--
+		r.block()
+		r.b.WriteString("```\n" + strings.Trim(text, "\n") + "\n```")
+		r.block()
+	case atom.A:
+		text := strings.TrimSpace(r.inline(n))
+		href := strings.TrimSpace(attr(n, "href"))
+		switch {
+		case href == "" || strings.HasPrefix(strings.ToLower(href), "javascript:"):
+			r.b.WriteString(text)
+		case text == "" || text == href:
+			r.b.WriteString("<" + href + ">")
+		default:
+			r.b.WriteString("[" + text + "](" + href + ")")
+		}
+	case atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			r.b.WriteString("[image: " + alt + "]")
+		}
+	case atom.Ul, atom.Ol:
+		nested := len(r.lists) > 0
+		r.lists = append(r.lists, mdList{ordered: n.DataAtom == atom.Ol})
+		if !nested {
+			r.b.WriteString("\n")
+		}
+		r.children(n)
+		r.lists = r.lists[:len(r.lists)-1]
+		if !nested {
+			r.b.WriteString("\n")
+		}
+	case atom.Li:
+		depth := len(r.lists)
+		marker := "- "
+		if depth > 0 && r.lists[depth-1].ordered {
+			r.lists[depth-1].n++
+			marker = fmt.Sprintf("%d. ", r.lists[depth-1].n)
+		}
+		indent := ""
+		if depth > 1 {
+			indent = strings.Repeat("  ", depth-1)
+		}
+		r.b.WriteString("\n" + indent + marker + strings.TrimSpace(r.inline(n)))
+	case atom.Blockquote:
+		text := cleanMarkdown(r.inline(n))
+		r.block()
+		for _, line := range strings.Split(text, "\n") {
+			r.b.WriteString("> " + line + "\n")
+		}
+		r.block()
+	case atom.Tr:
+		var cells []string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.DataAtom == atom.Td || c.DataAtom == atom.Th) {
+				if t := strings.TrimSpace(collapseSpace(r.inline(c))); t != "" {
+					cells = append(cells, t)
+				}
+			}
+		}
+		if len(cells) > 0 {
+			r.b.WriteString("\n" + strings.Join(cells, " | ") + "\n")
+		}
+	default:
+		r.children(n)
+	}
+}
+
+// emphasis wraps n's text in mark, keeping surrounding whitespace outside the
+// markers so "<b>word </b>next" does not become "**word **next".
+func (r *mdRenderer) emphasis(n *html.Node, mark string) {
+	text := r.inline(n)
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		r.b.WriteString(text)
+		return
+	}
+	if strings.TrimLeft(text, " ") != text {
+		r.b.WriteString(" ")
+	}
+	r.b.WriteString(mark + trimmed + mark)
+	if strings.TrimRight(text, " ") != text {
+		r.b.WriteString(" ")
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+var (
+	spaceRun   = regexp.MustCompile(`[ \t\r\n\x{00a0}]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+func collapseSpace(s string) string {
+	return spaceRun.ReplaceAllString(s, " ")
+}
+
+// cleanMarkdown trims stray spaces (keeping list indentation and two-space
+// hard breaks) and squeezes runs of blank lines.
+func cleanMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		hardBreak := strings.HasSuffix(l, "  ") && strings.TrimSpace(l) != ""
+		l = strings.TrimRight(l, " ")
+		if body := strings.TrimLeft(l, " "); !listItem.MatchString(body) {
+			l = body
+		}
+		if hardBreak {
+			l += "  "
+		}
+		lines[i] = l
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+var listItem = regexp.MustCompile(`^(- |\d+\. )`)
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"os"
+	"time"
+
+	"github.com/emersion/go-mbox"
+)
+
+// mboxSpan is the byte range of one message (From_ line included) in an mbox file.
+type mboxSpan struct {
+	Offset int64
+	Length int64
+}
+
+var mboxFromLine = []byte("From ")
+
+// scanMboxSpans splits an mbox into messages the way go-mbox does (every line
+// starting with "From " begins a message) and calls fn with each message's span
+// and its bytes as stored on disk.
+func scanMboxSpans(r io.Reader, fn func(span mboxSpan, chunk []byte) error) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var chunk []byte
+	var offset, start int64 = 0, -1
+	atLineStart := true
+	flush := func() error {
+		if start < 0 {
+			return nil
+		}
+		err := fn(mboxSpan{Offset: start, Length: int64(len(chunk))}, chunk)
+		chunk = nil
+		return err
+	}
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			if atLineStart && bytes.HasPrefix(line, mboxFromLine) {
+				if err := flush(); err != nil {
+					return err
+				}
+				start = offset
+			}
+			if start >= 0 {
+				chunk = append(chunk, line...)
+			}
+			offset += int64(len(line))
+			atLineStart = line[len(line)-1] == '\n'
+		}
+		switch err {
+		case nil, bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			return flush()
+		default:
+			return err
+		}
+	}
+}
+
+// rawFromChunk converts an on-disk mbox chunk into the message bytes go-mbox
+// yields (From_ line dropped, >From unescaped, CRLF line endings).
+func rawFromChunk(chunk []byte) ([]byte, error) {
+	msg, err := mbox.NewReader(bytes.NewReader(chunk)).NextMessage()
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(msg)
+}
+
+// readMessageAt reads the message at span directly, without scanning the
+// folder. A maildir folder is read up to span.
+func readMessageAt(path string, span mboxSpan) ([]byte, error) {
+	chunk := make([]byte, span.Length)
+	if isMaildir(path) {
+		r, err := openMaildir(path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if _, err := io.CopyN(io.Discard, r, span.Offset); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := f.ReadAt(chunk, span.Offset); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.HasPrefix(chunk, mboxFromLine) {
+		return nil, fmt.Errorf("no message at offset %d (folder changed since indexing)", span.Offset)
+	}
+	return rawFromChunk(chunk)
+}
+
+// indexMailbox parses every message of box for the local index, recording
+// where each one lives in the file.
+func indexMailbox(box Mailbox, match matcherFunc, since time.Time, tailCount int, accountLabel string) ([]MailSummary, error) {
+	var msgs []MailSummary
+	err := scanIndexMailbox(box, 0, match, since, accountLabel, func(_ int64, m *MailSummary) error {
+		if m == nil {
+			return nil
+		}
+		msgs = append(msgs, *m)
+		if tailCount > 0 && len(msgs) > tailCount {
+			msgs = msgs[1:]
+		}
+		return nil
+	})
+	return msgs, err
+}
+
+// scanIndexMailbox parses box starting at byte offset from, which must be the
+// start of a message. fn sees every message with the offset just past it; m is
+// nil when the message was unreadable, did not match, or is dated before
+// since. Old messages are recognised from their headers alone, so their
+// bodies are never decoded.
+func scanIndexMailbox(box Mailbox, from int64, match matcherFunc, since time.Time, accountLabel string, fn func(end int64, m *MailSummary) error) error {
+	stream, err := openMailboxStream(box, from)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	progress, r := newScanProgress(box.Name, stream, stream.size)
+	progress.read, progress.base = from, from
+	defer progress.done()
+	err = scanMboxSpans(r, func(span mboxSpan, chunk []byte) error {
+		progress.message()
+		span.Offset += from
+		end := span.Offset + span.Length
+		raw, err := rawFromChunk(chunk)
+		if err != nil {
+			return fn(end, nil)
+		}
+		if !since.IsZero() && datedBefore(raw, since) {
+			return fn(end, nil)
+		}
+		summary, searchText, err := parseMessage(bytes.NewReader(raw), box.Name)
+		if err != nil || summary.Expunged() || !match(searchText) {
+			return fn(end, nil)
+		}
+		if accountLabel != "" {
+			summary.Account = accountLabel
+		}
+		summary.FolderTag = folderTag(box.Name)
+		summary.Search = searchText
+		summary.Offset, summary.Length = span.Offset, span.Length
+		return fn(end, &summary)
+	})
+	if err != nil {
+		return err
+	}
+	return stream.unchanged()
+}
+
+// errFolderChanged is returned by scans of a folder that was replaced or
+// shrank while it was read, as when Thunderbird compacts it: the offsets
+// read are void, so the folder is not recorded as done.
+var errFolderChanged = errors.New("changed while it was read (compacted by Thunderbird?); it is rescanned next run")
+
+// mboxSnapshot stats the opened folder f and returns how much of it a scan
+// reads: its size at open, so mail appended meanwhile waits for the next run.
+// For a live box an unfinished message at the end (the file does not end
+// in a newline) is left out as well.
+func mboxSnapshot(f *os.File, box Mailbox) (os.FileInfo, int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := fi.Size()
+	if !box.Live || size == 0 {
+		return fi, size, nil
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return nil, 0, err
+	}
+	if last[0] == '\n' {
+		return fi, size, nil
+	}
+	end, err := lastMessageStart(f, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	log.Printf("warn: %s ends in a message Thunderbird is still writing; it is read next run", box.Name)
+	return fi, end, nil
+}
+
+// lastMessageStart finds the offset of the last From_ line in the first size
+// bytes of f, reading backwards, or 0 when there is none.
+func lastMessageStart(f *os.File, size int64) (int64, error) {
+	const block = 64 * 1024
+	var carry []byte // the first bytes of the block read before, for matches across blocks
+	for end := size; end > 0; {
+		start := max(end-block, 0)
+		buf := make([]byte, end-start, end-start+int64(len(carry)))
+		if _, err := f.ReadAt(buf, start); err != nil {
+			return 0, err
+		}
+		buf = append(buf, carry...)
+		if i := bytes.LastIndex(buf, []byte("\nFrom ")); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		carry = buf[:min(len(buf), len(mboxFromLine))]
+		end = start
+	}
+	return 0, nil
+}
+
+// mboxUnchanged checks that path is still the file snap was taken of and
+// has not shrunk.
+func mboxUnchanged(path string, snap os.FileInfo) error {
+	now, err := os.Stat(path)
+	if err != nil || !os.SameFile(snap, now) || now.Size() < snap.Size() {
+		return errFolderChanged
+	}
+	return nil
+}
+
+// datedBefore reports whether raw has a parseable Date header older than t.
+// Undated messages are kept.
+func datedBefore(raw []byte, t time.Time) bool {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return false
+	}
+	when, ok := parseDateFlexible(msg.Header.Get("Date"))
+	return ok && when.Before(t)
+}
+
+// indexedSpan is where the local index last saw a message.
+type indexedSpan struct {
+	Folder string
+	Span   mboxSpan
+}
+
+// indexedSpans looks ids up through a running tb daemon, else in the local
+// index (SQLite, else JSON), and returns their recorded locations keyed by
+// normalized Message-Id.
+func indexedSpans(profile Profile, ids []string) map[string][]indexedSpan {
+	if resp, err := callDaemon(profile, daemonRequest{Op: "spans", IDs: ids}); err == nil {
+		return resp.Spans
+	}
+	if spans, err := sqliteMessageSpans(sqliteIndexPath(profile), ids); err == nil {
+		return spans
+	}
+	spans := map[string][]indexedSpan{}
+	idx, err := loadIndex(profile)
+	if err != nil {
+		return spans
+	}
+	want := map[string]bool{}
+	for _, id := range ids {
+		want[normalizeMessageID(id)] = true
+	}
+	for _, fi := range idx.Folders {
+		for _, m := range fi.Messages {
+			id := normalizeMessageID(m.MessageID)
+			if m.Length > 0 && want[id] {
+				spans[id] = append(spans[id], indexedSpan{Folder: m.Folder, Span: mboxSpan{Offset: m.Offset, Length: m.Length}})
+			}
+		}
+	}
+	return spans
+}
+
+// readIndexedMessage reads a message through its indexed span and confirms it
+// is still the expected one; ok is false when the folder changed underneath.
+func readIndexedMessage(box Mailbox, span mboxSpan, id string) ([]byte, bool) {
+	raw, err := readMessageAt(box.Path, span)
+	if err != nil {
+		return nil, false
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil || normalizeMessageID(msg.Header.Get("Message-Id")) != normalizeMessageID(id) {
+		return nil, false
+	}
+	return raw, true
+}
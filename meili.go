@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// meiliStore is a Meilisearch index as a Store. Documents are engineDocs;
+// the index is created by the first sync, with the settings below.
+type meiliStore struct {
+	cfg      engineConfig
+	prepared bool // settings applied by this process
+}
+
+func openMeili(*App, Profile) (Store, error) {
+	cfg, err := searchEngineConfig("meili")
+	if err != nil {
+		return nil, err
+	}
+	var health struct {
+		Status string `json:"status"`
+	}
+	if err := cfg.call(context.Background(), http.MethodGet, "/health", nil, &health); err != nil {
+		return nil, fmt.Errorf("meilisearch at %s: %w", cfg.url, err)
+	}
+	return &meiliStore{cfg: cfg}, nil
+}
+
+func (s *meiliStore) config() engineConfig { return s.cfg }
+
+func (s *meiliStore) Close() {}
+
+func (s *meiliStore) path(rest string) string {
+	return "/indexes/" + url.PathEscape(s.cfg.index) + rest
+}
+
+// meiliSettings makes the fields search and stats filter, sort, and facet
+// on usable, and lets a search page through engineWindow hits.
+var meiliSettings = map[string]any{
+	"searchableAttributes": []string{"subject", "from", "to", "cc", "search_text"},
+	"filterableAttributes": []string{"profile", "message_id", "folder", "account", "year", "when", "unread", "flagged", "attachments", "size"},
+	"sortableAttributes":   []string{"when", "size"},
+	"pagination":           map[string]int{"maxTotalHits": engineWindow},
+	"faceting":             map[string]int{"maxValuesPerFacet": engineWindow},
+}
+
+// meiliTask is the reply to a request Meilisearch queues.
+type meiliTask struct {
+	TaskUID int64 `json:"taskUid"`
+}
+
+// wait blocks until the queued task finishes and returns its error.
+func (s *meiliStore) wait(ctx context.Context, task meiliTask) error {
+	delay := 50 * time.Millisecond
+	for {
+		var t struct {
+			Status string `json:"status"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := s.cfg.call(ctx, http.MethodGet, fmt.Sprintf("/tasks/%d", task.TaskUID), nil, &t); err != nil {
+			return err
+		}
+		switch t.Status {
+		case "succeeded":
+			return nil
+		case "failed", "canceled":
+			if t.Error != nil {
+				return fmt.Errorf("meilisearch task %d: %s", task.TaskUID, t.Error.Message)
+			}
+			return fmt.Errorf("meilisearch task %d %s", task.TaskUID, t.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, time.Second)
+	}
+}
+
+// send makes a request that queues a task and waits for it.
+func (s *meiliStore) send(ctx context.Context, method, path string, body any) error {
+	var task meiliTask
+	if err := s.cfg.call(ctx, method, path, body, &task); err != nil {
+		return err
+	}
+	return s.wait(ctx, task)
+}
+
+// Upsert replaces the documents of msgs, creating the index and applying
+// meiliSettings on the first call.
+func (s *meiliStore) Upsert(ctx context.Context, msgs []MailSummary) error {
+	if err := s.cfg.writable(); err != nil {
+		return err
+	}
+	if !s.prepared {
+		if err := s.send(ctx, http.MethodPatch, s.path("/settings"), meiliSettings); err != nil {
+			return fmt.Errorf("meilisearch settings: %w", err)
+		}
+		s.prepared = true
+	}
+	for start := 0; start < len(msgs); start += engineBatch {
+		docs := make([]engineDoc, 0, engineBatch)
+		for _, m := range msgs[start:min(start+engineBatch, len(msgs))] {
+			docs = append(docs, newEngineDoc(m))
+		}
+		if err := s.send(ctx, http.MethodPost, s.path("/documents?primaryKey=id"), docs); err != nil {
+			return fmt.Errorf("upsert %d message(s) of %s: %w", len(docs), docs[0].Folder, err)
+		}
+	}
+	return nil
+}
+
+// meiliQuote renders v as a string in a Meilisearch filter.
+func meiliQuote(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// meiliFilter narrows a search to q's profile and dates; filterMatches
+// applies the rest.
+func meiliFilter(q queryOptions) string {
+	var f []string
+	if q.profile != "" {
+		f = append(f, "profile = "+meiliQuote(q.profile))
+	}
+	if !q.since.IsZero() {
+		f = append(f, fmt.Sprintf("when >= %d", q.since.Unix()))
+	}
+	if !q.till.IsZero() {
+		f = append(f, fmt.Sprintf("when < %d", q.till.Unix()))
+	}
+	return strings.Join(f, " AND ")
+}
+
+// notSynced explains a missing index.
+func (s *meiliStore) notSynced(err error) error {
+	if errors.Is(err, errEngineNotFound) {
+		return fmt.Errorf("no Meilisearch index %s at %s; run tb mail sync --store meili", s.cfg.index, s.cfg.url)
+	}
+	return err
+}
+
+func (s *meiliStore) Search(ctx context.Context, q queryOptions) ([]MailSummary, error) {
+	req := map[string]any{"q": q.query, "showRankingScore": true}
+	if f := meiliFilter(q); f != "" {
+		req["filter"] = f
+	}
+	if byDate, asc, _ := engineSort(q); byDate {
+		req["sort"] = []string{"when:desc"}
+		if asc {
+			req["sort"] = []string{"when:asc"}
+		}
+	}
+	return collectHits(q, func(offset, size int) ([]MailSummary, error) {
+		req["offset"], req["limit"] = offset, size
+		var resp struct {
+			Hits []struct {
+				engineDoc
+				Score float64 `json:"_rankingScore"`
+			} `json:"hits"`
+		}
+		if err := s.cfg.call(ctx, http.MethodPost, s.path("/search"), req, &resp); err != nil {
+			return nil, s.notSynced(err)
+		}
+		page := make([]MailSummary, 0, len(resp.Hits))
+		for _, h := range resp.Hits {
+			m := h.summary()
+			m.Score = h.Score
+			page = append(page, m)
+		}
+		return page, nil
+	})
+}
+
+// Prune lists the profile's documents and deletes those whose Message-Id is
+// not in keepIDs.
+func (s *meiliStore) Prune(ctx context.Context, profile string, keepIDs []string) (int64, error) {
+	if err := s.cfg.writable(); err != nil {
+		return 0, err
+	}
+	if len(keepIDs) == 0 {
+		return 0, nil
+	}
+	stored := map[string]string{}
+	for offset := 0; ; offset += engineBatch {
+		var resp struct {
+			Results []engineDoc `json:"results"`
+		}
+		req := map[string]any{
+			"filter": "profile = " + meiliQuote(profile), "fields": []string{"id", "message_id"},
+			"offset": offset, "limit": engineBatch,
+		}
+		if err := s.cfg.call(ctx, http.MethodPost, s.path("/documents/fetch"), req, &resp); err != nil {
+			return 0, s.notSynced(err)
+		}
+		for _, d := range resp.Results {
+			stored[d.ID] = d.MessageID
+		}
+		if len(resp.Results) < engineBatch {
+			break
+		}
+	}
+	drop := unkeptDocs(stored, keepIDs)
+	for start := 0; start < len(drop); start += engineBatch {
+		if err := s.send(ctx, http.MethodPost, s.path("/documents/delete-batch"), drop[start:min(start+engineBatch, len(drop))]); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(drop)), nil
+}
+
+// Stats counts through facets. Meilisearch cannot sum a field, so
+// SearchBytes is unknown, and its database file is shared by every index.
+func (s *meiliStore) Stats(ctx context.Context, profile string) (StoreStats, error) {
+	st := StoreStats{Backend: "meili", SearchBytes: -1}
+	filter := "profile = " + meiliQuote(profile)
+	var facets struct {
+		Facets map[string]map[string]int64 `json:"facetDistribution"`
+	}
+	if err := s.cfg.call(ctx, http.MethodPost, s.path("/search"), map[string]any{
+		"q": "", "filter": filter, "limit": 0, "facets": []string{"folder", "account", "year"},
+	}, &facets); err != nil {
+		return st, s.notSynced(err)
+	}
+	breakdown := func(counts map[string]int64) []StoreCount {
+		out := make([]StoreCount, 0, len(counts))
+		for k, n := range counts {
+			out = append(out, StoreCount{k, n})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+		return out
+	}
+	st.ByFolder = breakdown(facets.Facets["folder"])
+	st.ByAccount = breakdown(facets.Facets["account"])
+	st.ByYear = breakdown(facets.Facets["year"])
+	st.Folders = int64(len(st.ByFolder))
+	for _, c := range st.ByFolder {
+		st.Messages += c.Messages
+	}
+
+	for _, end := range []struct {
+		sort string
+		t    *time.Time
+	}{{"when:asc", &st.Oldest}, {"when:desc", &st.Newest}} {
+		var resp struct {
+			Hits []engineDoc `json:"hits"`
+		}
+		if err := s.cfg.call(ctx, http.MethodPost, s.path("/search"), map[string]any{
+			"q": "", "filter": filter + " AND when EXISTS", "sort": []string{end.sort}, "limit": 1,
+			"attributesToRetrieve": []string{"when"},
+		}, &resp); err != nil {
+			return st, err
+		}
+		if len(resp.Hits) > 0 && resp.Hits[0].When != nil {
+			*end.t = time.Unix(*resp.Hits[0].When, 0)
+		}
+	}
+
+	var db struct {
+		DatabaseSize     int64 `json:"databaseSize"`
+		UsedDatabaseSize int64 `json:"usedDatabaseSize"` // Meilisearch 1.7 and later
+	}
+	if err := s.cfg.call(ctx, http.MethodGet, "/stats", nil, &db); err != nil {
+		return st, err
+	}
+	reclaim := int64(-1)
+	if db.UsedDatabaseSize > 0 {
+		reclaim = db.DatabaseSize - db.UsedDatabaseSize
+	}
+	st.Storage = []StoreSpace{{Name: "meilisearch database (all indexes)", Bytes: db.DatabaseSize, Reclaimable: reclaim}}
+	return st, nil
+}
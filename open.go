@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// lastSearch remembers the hits of the most recent `tb mail search` so other
+// commands can refer to them by number.
+type lastSearch struct {
+	Hits []lastHit `json:"hits"`
+}
+
+type lastHit struct {
+	MessageID string `json:"message_id"`
+	Folder    string `json:"folder"`
+	Subject   string `json:"subject"`
+	Profile   string `json:"profile,omitempty"`
+}
+
+func lastSearchPath(profile Profile) string {
+	return indexFile(profile, "last-search.json")
+}
+
+func saveLastSearch(profile Profile, hits []MailSummary) error {
+	var ls lastSearch
+	for _, h := range hits {
+		ls.Hits = append(ls.Hits, lastHit{MessageID: h.MessageID, Folder: h.Folder, Subject: h.Subject, Profile: h.Profile})
+	}
+	b, err := json.MarshalIndent(ls, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ensureIndexDir(profile); err != nil {
+		return err
+	}
+	path := lastSearchPath(profile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// lastSearchHit returns hit n (1-based) of the most recent search.
+func lastSearchHit(profile Profile, n int) (lastHit, error) {
+	b, err := os.ReadFile(lastSearchPath(profile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lastHit{}, fmt.Errorf("no previous search for profile %s; run tb mail search first", profile.Name)
+		}
+		return lastHit{}, err
+	}
+	var ls lastSearch
+	if err := json.Unmarshal(b, &ls); err != nil {
+		return lastHit{}, err
+	}
+	if n < 1 || n > len(ls.Hits) {
+		return lastHit{}, fmt.Errorf("hit %d out of range (last search had %d)", n, len(ls.Hits))
+	}
+	return ls.Hits[n-1], nil
+}
+
+// openMessage asks the Thunderbird GUI to display a message via its mid: URL handler.
+func (a *App) openMessage(profileName, messageID string, hit int) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	if messageID == "" {
+		h, err := lastSearchHit(profile, hit)
+		if err != nil {
+			return err
+		}
+		if h.MessageID == "" {
+			return fmt.Errorf("hit %d (%s) has no Message-Id", hit, h.Subject)
+		}
+		messageID = h.MessageID
+		if h.Profile != "" && h.Profile != profile.Name {
+			// The hit came from another profile of an --all-profiles search.
+			if profile, err = a.resolveProfile(h.Profile); err != nil {
+				return err
+			}
+		}
+		log.Printf("info: opening %q in %s", h.Subject, h.Folder)
+	}
+	messageID = normalizeMessageID(messageID)
+	baseCmd := findMailCommand()
+	args := []string{"-P", profile.Name, "mid:" + messageID}
+	cmd := exec.Command(baseCmd[0], append(baseCmd[1:], args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Thunderbird hands the URL to a running instance and exits, or keeps running
+	// as the GUI itself; either way the CLI should not wait for it.
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
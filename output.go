@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// outputOptions controls how search hits are rendered.
+type outputOptions struct {
+	raw      bool
+	color    bool
+	wide     bool // disable column truncation
+	tagNames map[string]string
+	terms    []string // query terms to highlight in Subject/Snippet
+
+	showProfile bool // add a profile column (searches over several profiles)
+	showScore   bool // add a score column (search --rank)
+
+	exportMbox string // write full matching messages to this mbox path
+	exportEml  string // write each matching message as .eml into this directory
+}
+
+const (
+	ansiHighlight = "\x1b[1;33m"
+	ansiReset     = "\x1b[0m"
+)
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// colorEnabled reports whether ANSI highlighting should be used: not disabled by
+// flag or NO_COLOR (https://no-color.org), and stdout is a terminal.
+func colorEnabled(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// highlightTerms collects the terms that explain why a row matched.
+func highlightTerms(q queryOptions) []string {
+	var terms []string
+	for _, text := range []string{q.query, q.subject, q.body} {
+		terms = append(terms, splitQueryTerms(text)...)
+	}
+	return uniqueStrings(terms)
+}
+
+// highlight wraps case-insensitive occurrences of the query terms in ANSI color.
+func (o outputOptions) highlight(s string) string {
+	if !o.color || len(o.terms) == 0 || s == "" {
+		return s
+	}
+	var quoted []string
+	for _, t := range o.terms {
+		quoted = append(quoted, regexp.QuoteMeta(t))
+	}
+	re, err := regexp.Compile("(?i)" + strings.Join(quoted, "|"))
+	if err != nil {
+		return s
+	}
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		return ansiHighlight + m + ansiReset
+	})
+}
+
+func (o outputOptions) truncate(s string, n int) string {
+	if o.wide {
+		return s
+	}
+	return truncate(s, n)
+}
+
+// visibleWidth counts terminal columns, ignoring ANSI color sequences.
+func visibleWidth(s string) int {
+	return displayWidth(ansiEscape.ReplaceAllString(s, ""))
+}
+
+// renderTable prints aligned columns with a dashed rule under the header. Unlike
+// tabwriter it measures cells without ANSI escapes, so highlighted rows line up.
+func renderTable(w io.Writer, header []string, rows [][]string) {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		widths[i] = visibleWidth(h)
+	}
+	for _, r := range rows {
+		for i, c := range r {
+			if i < len(widths) && visibleWidth(c) > widths[i] {
+				widths[i] = visibleWidth(c)
+			}
+		}
+	}
+	writeRow := func(cells []string) {
+		var b strings.Builder
+		for i, c := range cells {
+			b.WriteString(c)
+			if i < len(cells)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-visibleWidth(c)+2))
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+	writeRow(header)
+	rule := make([]string, len(header))
+	for i, h := range header {
+		rule[i] = strings.Repeat("-", visibleWidth(h))
+	}
+	writeRow(rule)
+	for _, r := range rows {
+		writeRow(r)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "sync"
+
+// forEachMailbox calls fn for every box using at most jobs concurrent workers.
+// Once fn returns an error no further boxes are started and the first error
+// is returned after the running workers finish. jobs < 1 means one worker.
+func forEachMailbox(boxes []Mailbox, jobs int, fn func(b Mailbox) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(boxes) {
+		jobs = len(boxes)
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	next := make(chan Mailbox)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range next {
+				if err := fn(b); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, b := range boxes {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		next <- b
+	}
+	close(next)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// securityInfo summarizes the signature and encryption state of a message.
+type securityInfo struct {
+	Kind      string `json:"kind"` // "pgp" or "smime"
+	Signed    bool   `json:"signed"`
+	Encrypted bool   `json:"encrypted"`
+	Decrypted bool   `json:"decrypted"`
+	Status    string `json:"status,omitempty"` // signature status: good, bad, expired, revoked, no-key, unverified
+	Signer    string `json:"signer,omitempty"`
+	KeyID     string `json:"key_id,omitempty"`
+	Trust     string `json:"trust,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// String renders the info as a one-line Security: header for show output.
+func (s *securityInfo) String() string {
+	label := strings.ToUpper(s.Kind)
+	if s.Kind == "smime" {
+		label = "S/MIME"
+	}
+	var parts []string
+	if s.Encrypted {
+		if s.Decrypted {
+			parts = append(parts, "encrypted, decrypted")
+		} else {
+			parts = append(parts, "encrypted, not decrypted")
+		}
+	}
+	if s.Signed {
+		sig := "signed, " + strings.ReplaceAll(s.Status, "-", " ") + " signature"
+		switch s.Status {
+		case "unverified":
+			sig = "signed, not verified"
+		case "no-key":
+			sig = "signed, no public key"
+		}
+		if s.Signer != "" {
+			sig += " from " + s.Signer
+		}
+		if s.KeyID != "" && s.Kind == "smime" {
+			sig += " (serial " + s.KeyID + ")"
+		} else if s.KeyID != "" {
+			sig += " (key " + s.KeyID + ")"
+		}
+		if s.Trust != "" {
+			sig += ", trust " + s.Trust
+		}
+		parts = append(parts, sig)
+	}
+	out := label + " " + strings.Join(parts, "; ")
+	if s.Detail != "" {
+		out += " [" + s.Detail + "]"
+	}
+	return out
+}
+
+// inspectPGP detects PGP/MIME (RFC 3156) and inline PGP, verifying signatures
+// and decrypting through gpg. It returns nil when the message uses no PGP,
+// otherwise the security summary and the body text to display.
+func inspectPGP(raw []byte, bodyText string) (*securityInfo, string) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, bodyText
+	}
+	body, err := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
+	if err != nil {
+		return nil, bodyText
+	}
+	if sec, text, ok := pgpEntity(msg.Header, body, 0); ok {
+		if text == "" {
+			text = bodyText
+		}
+		return sec, text
+	}
+	return inlinePGP(bodyText)
+}
+
+// pgpEntity handles multipart/signed and multipart/encrypted PGP entities.
+func pgpEntity(h mail.Header, body []byte, depth int) (*securityInfo, string, bool) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || depth > 2 {
+		return nil, "", false
+	}
+	protocol := strings.ToLower(params["protocol"])
+	switch {
+	case mediaType == "multipart/signed" && protocol == "application/pgp-signature":
+		parts := splitMultipartRaw(body, params["boundary"])
+		if len(parts) < 2 {
+			return nil, "", false
+		}
+		sec := &securityInfo{Kind: "pgp", Signed: true}
+		sigMsg, err := mail.ReadMessage(bytes.NewReader(parts[1]))
+		if err != nil {
+			sec.Status, sec.Detail = "unverified", "unreadable signature part"
+			return sec, "", true
+		}
+		sigBody, _ := io.ReadAll(sigMsg.Body)
+		sig, err := decodeBodyContent(sigMsg.Header.Get("Content-Transfer-Encoding"), sigBody)
+		if err != nil {
+			sig = sigBody
+		}
+		sigFile, err := os.CreateTemp("", "tb-sig-*.asc")
+		if err != nil {
+			sec.Status, sec.Detail = "unverified", err.Error()
+			return sec, "", true
+		}
+		defer os.Remove(sigFile.Name())
+		sigFile.Write(sig)
+		sigFile.Close()
+		_, status, err := runGPG(canonicalCRLF(parts[0]), "--verify", sigFile.Name(), "-")
+		applyGPGStatus(sec, status, err)
+		return sec, "", true
+	case mediaType == "multipart/encrypted" && protocol == "application/pgp-encrypted":
+		parts := splitMultipartRaw(body, params["boundary"])
+		sec := &securityInfo{Kind: "pgp", Encrypted: true}
+		if len(parts) < 2 {
+			sec.Detail = "malformed encrypted message"
+			return sec, "", true
+		}
+		encMsg, err := mail.ReadMessage(bytes.NewReader(parts[1]))
+		if err != nil {
+			sec.Detail = "unreadable encrypted part"
+			return sec, "", true
+		}
+		armored, _ := io.ReadAll(encMsg.Body)
+		plain, status, err := runGPG(armored, "--decrypt")
+		applyGPGStatus(sec, status, err)
+		if !sec.Decrypted {
+			return sec, "", true
+		}
+		inner, err := mail.ReadMessage(bytes.NewReader(canonicalCRLF(plain)))
+		if err != nil {
+			return sec, string(plain), true
+		}
+		innerBody, _ := io.ReadAll(io.LimitReader(inner.Body, maxMessageBytes))
+		// Sign-then-encrypt puts a multipart/signed entity inside the ciphertext.
+		if innerSec, _, ok := pgpEntity(inner.Header, innerBody, depth+1); ok && innerSec.Signed {
+			sec.Signed, sec.Status, sec.Signer, sec.KeyID, sec.Trust = true, innerSec.Status, innerSec.Signer, innerSec.KeyID, innerSec.Trust
+		}
+		text, fallback := extractTextAs(inner.Header, innerBody, htmlToMarkdown)
+		if text == "" {
+			text = fallback
+		}
+		return sec, text, true
+	}
+	return nil, "", false
+}
+
+// inlinePGP decrypts or verifies an ASCII-armored block embedded in a text body.
+func inlinePGP(bodyText string) (*securityInfo, string) {
+	begin := strings.Index(bodyText, "-----BEGIN PGP MESSAGE-----")
+	endMarker := "-----END PGP MESSAGE-----"
+	sec := &securityInfo{Kind: "pgp", Encrypted: true}
+	if begin < 0 {
+		begin = strings.Index(bodyText, "-----BEGIN PGP SIGNED MESSAGE-----")
+		endMarker = "-----END PGP SIGNATURE-----"
+		sec = &securityInfo{Kind: "pgp", Signed: true}
+	}
+	if begin < 0 {
+		return nil, bodyText
+	}
+	end := strings.Index(bodyText[begin:], endMarker)
+	if end < 0 {
+		return nil, bodyText
+	}
+	end += begin + len(endMarker)
+	plain, status, err := runGPG([]byte(bodyText[begin:end]), "--decrypt")
+	applyGPGStatus(sec, status, err)
+	if sec.Encrypted && !sec.Decrypted {
+		return sec, bodyText
+	}
+	if len(plain) == 0 {
+		return sec, bodyText
+	}
+	return sec, bodyText[:begin] + strings.TrimRight(string(plain), "\r\n") + bodyText[end:]
+}
+
+// runGPG runs gpg non-interactively (gpg-agent may still ask for a passphrase)
+// and returns its output plus the machine-readable status lines.
+func runGPG(stdin []byte, args ...string) ([]byte, string, error) {
+	bin := strings.TrimSpace(os.Getenv("TB_GPG"))
+	if bin == "" {
+		bin = "gpg"
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s not found", bin)
+	}
+	var stdout, status bytes.Buffer
+	cmd := exec.Command(path, append([]string{"--batch", "--no-tty", "--status-fd", "2"}, args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &status
+	err = cmd.Run()
+	return stdout.Bytes(), status.String(), err
+}
+
+// applyGPGStatus fills sec from gpg --status-fd lines.
+func applyGPGStatus(sec *securityInfo, status string, runErr error) {
+	seenSig := false
+	for _, line := range strings.Split(status, "\n") {
+		fields, ok := strings.CutPrefix(strings.TrimSpace(line), "[GNUPG:] ")
+		if !ok {
+			continue
+		}
+		keyword, rest, _ := strings.Cut(fields, " ")
+		keyID, uid, _ := strings.Cut(rest, " ")
+		switch keyword {
+		case "GOODSIG", "BADSIG", "EXPSIG", "EXPKEYSIG", "REVKEYSIG":
+			seenSig = true
+			sec.Signed = true
+			sec.KeyID, sec.Signer = keyID, uid
+			sec.Status = map[string]string{"GOODSIG": "good", "BADSIG": "bad", "EXPSIG": "expired", "EXPKEYSIG": "expired", "REVKEYSIG": "revoked"}[keyword]
+		case "ERRSIG":
+			seenSig = true
+			sec.Signed = true
+			sec.KeyID = keyID
+			if sec.Status == "" {
+				sec.Status = "unverified"
+			}
+		case "NO_PUBKEY":
+			sec.Status = "no-key"
+			sec.KeyID = keyID
+		case "TRUST_UNDEFINED", "TRUST_NEVER", "TRUST_MARGINAL", "TRUST_FULLY", "TRUST_ULTIMATE":
+			sec.Trust = strings.ToLower(strings.TrimPrefix(keyword, "TRUST_"))
+		case "DECRYPTION_OKAY":
+			sec.Decrypted = true
+		case "DECRYPTION_FAILED":
+			sec.Detail = "decryption failed"
+		case "NO_SECKEY":
+			sec.Detail = "no secret key for " + keyID
+		}
+	}
+	if sec.Signed && !seenSig && sec.Status == "" {
+		sec.Status = "unverified"
+	}
+	if runErr != nil && sec.Detail == "" && !sec.Decrypted && sec.Status != "bad" && sec.Status != "good" {
+		sec.Detail = runErr.Error()
+	}
+}
+
+// splitMultipartRaw returns the raw bytes (headers and body) of each part of a
+// multipart body, as needed for signature verification.
+func splitMultipartRaw(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+	delim := []byte("--" + boundary)
+	var parts [][]byte
+	start := -1
+	for pos := 0; pos < len(body); {
+		lineEnd := bytes.IndexByte(body[pos:], '\n')
+		next := len(body)
+		if lineEnd >= 0 {
+			next = pos + lineEnd + 1
+		}
+		line := bytes.TrimRight(body[pos:next], "\r\n")
+		if bytes.HasPrefix(line, delim) {
+			if start >= 0 {
+				end := pos
+				// The line break before the delimiter belongs to the delimiter.
+				if end > start && body[end-1] == '\n' {
+					end--
+					if end > start && body[end-1] == '\r' {
+						end--
+					}
+				}
+				parts = append(parts, body[start:end])
+			}
+			if bytes.Equal(bytes.TrimSpace(line[len(delim):]), []byte("--")) {
+				break
+			}
+			start = next
+		}
+		pos = next
+	}
+	return parts
+}
+
+// canonicalCRLF converts line endings to CRLF, the canonical form signatures cover.
+func canonicalCRLF(b []byte) []byte {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))
+}
@@ -3,39 +3,115 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	flag "github.com/spf13/pflag"
 )
 
 type pgStore struct {
-	pool *pgxpool.Pool
+	pool      *pgxpool.Pool
+	batchSize int  // messages per Upsert transaction; 0 means defaultUpsertBatch
+	readOnly  bool // never migrate or write; see storeReadOnly
 }
 
-func openPG() (*pgStore, error) {
-	dsn := strings.TrimSpace(os.Getenv("TB_PG_DSN"))
-	if dsn == "" {
-		return nil, fmt.Errorf("TB_PG_DSN not set")
+// storeReadOnly is set by --store-readonly. With it, or TB_STORE_READONLY,
+// or a DSN whose sessions are read-only (a hot standby, or
+// options=-c default_transaction_read_only=on), openPG only checks the
+// schema and every write fails with errStoreReadOnly, so a shared archive
+// can be queried with SELECT grants alone.
+var storeReadOnly bool
+
+var errStoreReadOnly = errors.New("the store is read-only (--store-readonly, TB_STORE_READONLY, or a read-only DSN)")
+
+// storeReadOnlyFlag registers --store-readonly on a command that only reads
+// the store.
+func storeReadOnlyFlag(cmd *flag.FlagSet) {
+	cmd.BoolVar(&storeReadOnly, "store-readonly", false, "never write to Postgres: skip schema migration and refuse writes (also TB_STORE_READONLY=1)")
+}
+
+// writable fails fast with errStoreReadOnly before a write.
+func (s *pgStore) writable() error {
+	if s.readOnly {
+		return errStoreReadOnly
 	}
-	ctx := context.Background()
-	pool, err := pgxpool.New(ctx, dsn)
+	return nil
+}
+
+// openPG connects to the store (see pgSettings) and brings its schema up to
+// date, or only checks it when the store is read-only.
+func openPG() (*pgStore, error) {
+	store, _, err := connectPG()
 	if err != nil {
 		return nil, err
 	}
-	store := &pgStore{pool: pool}
-	if err := store.ensureSchema(ctx); err != nil {
-		pool.Close()
+	ctx := context.Background()
+	if store.readOnly {
+		err = store.checkSchema(ctx)
+	} else {
+		err = store.ensureSchema(ctx)
+	}
+	if err != nil {
+		store.Close()
 		return nil, err
 	}
 	return store, nil
 }
 
-func (s *pgStore) ensureSchema(ctx context.Context) error {
-	_, err := s.pool.Exec(ctx, `
+// connectPG opens the pool without touching the schema.
+func connectPG() (*pgStore, pgConnSettings, error) {
+	settings, err := pgSettings()
+	if err != nil {
+		return nil, settings, err
+	}
+	cfg, err := pgxpool.ParseConfig(settings.dsn)
+	if err != nil {
+		return nil, settings, fmt.Errorf("%s: %w", settings.source, err)
+	}
+	readOnly := storeReadOnly || settings.readOnly
+	if v, err := strconv.ParseBool(os.Getenv("TB_STORE_READONLY")); err == nil && v {
+		readOnly = true
+	}
+	if readOnly {
+		// Let the server enforce it too.
+		cfg.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, settings, err
+	}
+	store := &pgStore{pool: pool, readOnly: readOnly}
+	if !readOnly {
+		var ro string
+		if err := pool.QueryRow(ctx, "SHOW transaction_read_only").Scan(&ro); err == nil && ro == "on" {
+			store.readOnly = true
+		}
+	}
+	return store, settings, nil
+}
+
+// pgSearchVector is the expression behind tb_messages_search_idx. Queries
+// must spell it exactly the same way for Postgres to use the index.
+const pgSearchVector = "to_tsvector('simple', coalesce(search_text,''))"
+
+// pgMigrations are the schema changes of the Postgres store, in order:
+// applying pgMigrations[i] brings the schema to version i+1. Applied versions
+// are recorded in tb_schema_migrations. Append new migrations; never edit
+// one that has shipped. The first four are written so that databases created
+// before versioning adopt them without change.
+var pgMigrations = []string{
+	// 1: the original tables.
+	`
 CREATE TABLE IF NOT EXISTS tb_messages (
   message_id text NOT NULL,
   folder text NOT NULL,
@@ -51,7 +127,20 @@ CREATE TABLE IF NOT EXISTS tb_meta (
   key text PRIMARY KEY,
   val text
 );
+`,
+	// 2: per-profile rows, field-scoped search, size/attachment/flag/tag filters.
+	`
 ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS profile text NOT NULL DEFAULT '';
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS to_addrs text;
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS cc_addrs text;
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS body_text text;
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS size_bytes bigint NOT NULL DEFAULT 0;
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS attachments integer NOT NULL DEFAULT 0;
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS moz_status integer NOT NULL DEFAULT 0;
+ALTER TABLE tb_messages ADD COLUMN IF NOT EXISTS tags text NOT NULL DEFAULT '';
+`,
+	// 3: one row per message and profile (the key was message_id alone).
+	`
 DO $$
 BEGIN
   IF NOT EXISTS (
@@ -67,63 +156,264 @@ BEGIN
     ALTER TABLE tb_messages ADD CONSTRAINT tb_messages_pkey PRIMARY KEY (profile, message_id);
   END IF;
 END $$;
+`,
+	// 4: indexes.
+	`
 CREATE INDEX IF NOT EXISTS tb_messages_when_idx ON tb_messages (profile, when_ts DESC NULLS LAST);
-CREATE INDEX IF NOT EXISTS tb_messages_search_idx ON tb_messages USING GIN (to_tsvector('simple', coalesce(search_text,'')));
+CREATE INDEX IF NOT EXISTS tb_messages_search_idx ON tb_messages USING GIN (` + pgSearchVector + `);
 CREATE INDEX IF NOT EXISTS tb_messages_folder_idx ON tb_messages (profile, folder);
 CREATE INDEX IF NOT EXISTS tb_messages_account_idx ON tb_messages (profile, account);
-`)
-	return err
+`,
+	// 5: full bodies stored by sync --bodies.
+	`
+CREATE TABLE IF NOT EXISTS tb_bodies (
+  profile text NOT NULL,
+  message_id text NOT NULL,
+  body_text text NOT NULL DEFAULT '',
+  raw bytea,
+  PRIMARY KEY (profile, message_id),
+  FOREIGN KEY (profile, message_id) REFERENCES tb_messages ON DELETE CASCADE
+);
+`,
+	// 6: attachment metadata recorded by sync.
+	`
+CREATE TABLE IF NOT EXISTS tb_attachments (
+  profile text NOT NULL,
+  message_id text NOT NULL,
+  part text NOT NULL,
+  filename text NOT NULL DEFAULT '',
+  content_type text NOT NULL DEFAULT '',
+  size_bytes bigint NOT NULL DEFAULT 0,
+  sha256 text NOT NULL DEFAULT '',
+  PRIMARY KEY (profile, message_id, part),
+  FOREIGN KEY (profile, message_id) REFERENCES tb_messages ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS tb_attachments_sha256_idx ON tb_attachments (sha256);
+`,
+	// 7: reply graph recorded by sync. msg_key and parents hold normalized
+	// Message-Ids (no angle brackets); parents is the References chain,
+	// oldest first, ending with the In-Reply-To parent.
+	`
+CREATE TABLE IF NOT EXISTS tb_threads (
+  profile text NOT NULL,
+  message_id text NOT NULL,
+  msg_key text NOT NULL,
+  parents text[] NOT NULL DEFAULT '{}',
+  PRIMARY KEY (profile, message_id),
+  FOREIGN KEY (profile, message_id) REFERENCES tb_messages ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS tb_threads_key_idx ON tb_threads (profile, msg_key);
+CREATE INDEX IF NOT EXISTS tb_threads_parents_idx ON tb_threads USING GIN (parents);
+`,
+	// 8: correspondents. tb_addresses holds every From/To/Cc address per
+	// message; tb_contacts aggregates it and is refreshed after each sync.
+	`
+CREATE TABLE IF NOT EXISTS tb_addresses (
+  profile text NOT NULL,
+  message_id text NOT NULL,
+  role text NOT NULL,
+  address text NOT NULL,
+  name text NOT NULL DEFAULT '',
+  PRIMARY KEY (profile, message_id, role, address),
+  FOREIGN KEY (profile, message_id) REFERENCES tb_messages ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS tb_addresses_address_idx ON tb_addresses (profile, address);
+CREATE MATERIALIZED VIEW IF NOT EXISTS tb_contacts AS
+SELECT a.profile,
+       a.address,
+       split_part(a.address, '@', 2) AS domain,
+       coalesce((array_agg(a.name ORDER BY m.when_ts DESC NULLS LAST) FILTER (WHERE a.name <> ''))[1], '') AS name,
+       min(m.when_ts) AS first_seen,
+       max(m.when_ts) AS last_seen,
+       count(DISTINCT a.message_id) AS messages,
+       count(*) FILTER (WHERE a.role = 'from') AS from_count,
+       count(*) FILTER (WHERE a.role <> 'from') AS to_count
+FROM tb_addresses a JOIN tb_messages m USING (profile, message_id)
+GROUP BY a.profile, a.address;
+CREATE UNIQUE INDEX IF NOT EXISTS tb_contacts_address_idx ON tb_contacts (profile, address);
+`,
+	// 9: trigram index for search --like. Where pg_trgm cannot be installed
+	// (no contrib package, no privilege) --like still works by scanning.
+	`
+DO $$
+BEGIN
+  CREATE EXTENSION IF NOT EXISTS pg_trgm;
+  CREATE INDEX IF NOT EXISTS tb_messages_trgm_idx ON tb_messages USING GIN (search_text gin_trgm_ops);
+EXCEPTION WHEN OTHERS THEN
+  RAISE NOTICE 'pg_trgm unavailable, search --like will scan: %', SQLERRM;
+END $$;
+`,
+	// 10: moz_status is NULL for messages without an X-Mozilla-Status
+	// header, so --unread does not take them for unread mail.
+	`
+ALTER TABLE tb_messages ALTER COLUMN moz_status DROP NOT NULL;
+ALTER TABLE tb_messages ALTER COLUMN moz_status DROP DEFAULT;
+`,
+}
+
+// pgSchemaLock is the advisory lock key held while migrating, so tb
+// processes starting together do not apply the same migration twice.
+const pgSchemaLock = 0x74622d73 // "tb-s"
+
+// ensureSchema applies the migrations the database has not seen yet. A
+// database migrated by a newer tb is refused rather than written to with an
+// older idea of the schema.
+func (s *pgStore) ensureSchema(ctx context.Context) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", pgSchemaLock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS tb_schema_migrations (
+  version integer PRIMARY KEY,
+  applied_at timestamptz NOT NULL DEFAULT now()
+)`); err != nil {
+		return err
+	}
+	var current int
+	if err := tx.QueryRow(ctx, "SELECT coalesce(max(version), 0) FROM tb_schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+	if current > len(pgMigrations) {
+		return fmt.Errorf("database schema is at version %d but this tb only knows %d; upgrade tb", current, len(pgMigrations))
+	}
+	for v := current + 1; v <= len(pgMigrations); v++ {
+		if _, err := tx.Exec(ctx, pgMigrations[v-1]); err != nil {
+			return fmt.Errorf("schema migration %d: %w", v, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO tb_schema_migrations (version) VALUES ($1)", v); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// checkSchema is ensureSchema for a read-only store: it reports the version
+// without migrating. An older schema is only a warning, as the commands that
+// do not touch the newer tables still work.
+func (s *pgStore) checkSchema(ctx context.Context) error {
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return fmt.Errorf("read-only store has no tb schema; run tb mail sync against it with write access first")
+	}
+	if current > len(pgMigrations) {
+		return fmt.Errorf("database schema is at version %d but this tb only knows %d; upgrade tb", current, len(pgMigrations))
+	}
+	if current < len(pgMigrations) {
+		log.Printf("warn: read-only store schema is at version %d, this tb expects %d; features needing newer tables will fail", current, len(pgMigrations))
+	}
+	return nil
+}
+
+// schemaVersion is the highest migration applied, 0 for a database tb has
+// never used.
+func (s *pgStore) schemaVersion(ctx context.Context) (int, error) {
+	var current int
+	err := s.pool.QueryRow(ctx, "SELECT coalesce(max(version), 0) FROM tb_schema_migrations").Scan(&current)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" { // undefined_table
+		return 0, nil
+	}
+	return current, err
 }
 
 func (s *pgStore) Close() {
 	s.pool.Close()
 }
 
+// pgUpsertColumns are the tb_messages columns Upsert writes, in COPY order.
+var pgUpsertColumns = []string{"profile", "message_id", "folder", "subject", "sender", "snippet", "search_text", "when_ts", "date_str", "account", "to_addrs", "cc_addrs", "body_text", "size_bytes", "attachments", "moz_status", "tags"}
+
+// defaultUpsertBatch is how many messages Upsert commits at a time unless
+// pgStore.batchSize says otherwise.
+const defaultUpsertBatch = 5000
+
+// Upsert stores msgs, replacing rows with the same profile and Message-Id.
+// Each batch is COPYed into a staging table and merged with one INSERT ...
+// ON CONFLICT, which is far faster than an INSERT per message. When a batch
+// holds one Message-Id twice, the later message wins.
 func (s *pgStore) Upsert(ctx context.Context, msgs []MailSummary) error {
-	if len(msgs) == 0 {
-		return nil
+	if err := s.writable(); err != nil {
+		return err
 	}
+	size := s.batchSize
+	if size <= 0 {
+		size = defaultUpsertBatch
+	}
+	for start := 0; start < len(msgs); start += size {
+		if err := s.upsertBatch(ctx, msgs[start:min(start+size, len(msgs))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pgStore) upsertBatch(ctx context.Context, msgs []MailSummary) error {
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
-	stmt := `
-INSERT INTO tb_messages (profile, message_id, folder, subject, sender, snippet, search_text, when_ts, date_str, account)
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+	cols := strings.Join(pgUpsertColumns, ", ")
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE tb_stage ("+pgStageColumns+", ord integer NOT NULL) ON COMMIT DROP"); err != nil {
+		return err
+	}
+	rows := make([][]any, 0, len(msgs))
+	for i, m := range msgs {
+		var when any
+		if !m.When.IsZero() {
+			when = m.When
+		} else if t, err := time.Parse(time.RFC3339, m.Date); err == nil {
+			when = t
+		}
+		rows = append(rows, []any{
+			m.Profile, forceUTF8(m.MessageID), forceUTF8(m.Folder), forceUTF8(m.Subject), forceUTF8(m.From),
+			forceUTF8(m.Snippet), forceUTF8(m.Search), when, forceUTF8(m.Date), forceUTF8(m.Account),
+			forceUTF8(m.To), forceUTF8(m.Cc), forceUTF8(m.Body), m.Size, m.Attachments, m.statusValue(), forceUTF8(m.Tags), i,
+		})
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"tb_stage"}, append(slices.Clone(pgUpsertColumns), "ord"), pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("upsert: copy %d message(s) of %s: %w", len(msgs), msgs[0].Folder, err)
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO tb_messages (`+cols+`)
+SELECT DISTINCT ON (profile, message_id) `+cols+`
+FROM tb_stage
+ORDER BY profile, message_id, ord DESC
 ON CONFLICT (profile, message_id) DO UPDATE
   SET folder=EXCLUDED.folder,
       subject=EXCLUDED.subject,
       sender=EXCLUDED.sender,
+      to_addrs=EXCLUDED.to_addrs,
+      cc_addrs=EXCLUDED.cc_addrs,
+      body_text=EXCLUDED.body_text,
+      size_bytes=EXCLUDED.size_bytes,
+      attachments=EXCLUDED.attachments,
+      moz_status=EXCLUDED.moz_status,
+      tags=EXCLUDED.tags,
       snippet=EXCLUDED.snippet,
       search_text=EXCLUDED.search_text,
       when_ts=EXCLUDED.when_ts,
       date_str=EXCLUDED.date_str,
-      account=EXCLUDED.account;
-`
-	for _, m := range msgs {
-		when := m.When
-		if when.IsZero() && m.Date != "" {
-			if t, err := time.Parse(time.RFC3339, m.Date); err == nil {
-				when = t
-			}
-		}
-		subject := forceUTF8(m.Subject)
-		sender := forceUTF8(m.From)
-		snippet := forceUTF8(m.Snippet)
-		search := forceUTF8(m.Search)
-		folder := forceUTF8(m.Folder)
-		msgID := forceUTF8(m.MessageID)
-		dateStr := forceUTF8(m.Date)
-		account := forceUTF8(m.Account)
-		if _, err := tx.Exec(ctx, stmt, m.Profile, msgID, folder, subject, sender, snippet, search, when, dateStr, account); err != nil {
-			log.Printf("upsert failed id=%s folder=%s err=%v", msgID, folder, err)
-			return fmt.Errorf("upsert msg=%s folder=%s: %w", msgID, folder, err)
-		}
+      account=EXCLUDED.account
+`); err != nil {
+		return fmt.Errorf("upsert: merge %d message(s) of %s: %w", len(msgs), msgs[0].Folder, err)
 	}
 	return tx.Commit(ctx)
 }
 
+// pgStageColumns declares the staging table for Upsert, matching pgUpsertColumns.
+const pgStageColumns = `profile text, message_id text, folder text, subject text, sender text, snippet text,
+  search_text text, when_ts timestamptz, date_str text, account text, to_addrs text, cc_addrs text,
+  body_text text, size_bytes bigint, attachments integer, moz_status integer, tags text`
+
 func forceUTF8(s string) string {
 	if s == "" {
 		return s
@@ -133,7 +423,9 @@ func forceUTF8(s string) string {
 	return string(bytes.ToValidUTF8(b, nil))
 }
 
-func (s *pgStore) Search(ctx context.Context, q queryOptions) ([]MailSummary, error) {
+// pgWhere renders the filters of q as an SQL condition and its arguments.
+// Further arguments appended by the caller continue the numbering.
+func pgWhere(q queryOptions) (string, []interface{}) {
 	var where []string
 	var args []interface{}
 	arg := func(v interface{}) string {
@@ -143,18 +435,67 @@ func (s *pgStore) Search(ctx context.Context, q queryOptions) ([]MailSummary, er
 	if q.profile != "" {
 		where = append(where, fmt.Sprintf("profile = %s", arg(q.profile)))
 	}
-	if q.query != "" {
-		tokens := strings.Fields(strings.ToLower(q.query))
-		for _, t := range tokens {
+	if q.query != "" && q.like {
+		// Terms tsvector would split or stem (INV-2023-0042, order ids,
+		// word fragments) match verbatim anywhere, through the trigram index.
+		for _, t := range splitQueryTerms(q.query) {
 			where = append(where, fmt.Sprintf("search_text ILIKE '%%' || %s || '%%'", arg(t)))
 		}
+	} else if q.query != "" {
+		// websearch_to_tsquery keeps "quoted phrases" together and reads
+		// or / -term the way search engines do; matching goes through the
+		// GIN index instead of scanning every row.
+		where = append(where, fmt.Sprintf("%s @@ websearch_to_tsquery('simple', %s)", pgSearchVector, arg(q.query)))
 	}
-	if q.account != "" {
-		where = append(where, fmt.Sprintf("account = %s", arg(strings.ToLower(q.account))))
+	fieldTerms := func(column, text string) {
+		for _, t := range splitQueryTerms(text) {
+			where = append(where, fmt.Sprintf("%s ILIKE '%%' || %s || '%%'", column, arg(t)))
+		}
+	}
+	fieldTerms("sender", q.from)
+	fieldTerms("concat_ws(' ', to_addrs, cc_addrs)", q.to)
+	fieldTerms("subject", q.subject)
+	fieldTerms("body_text", q.body)
+	for _, e := range q.excludes {
+		for _, t := range splitQueryTerms(e) {
+			where = append(where, fmt.Sprintf("search_text NOT ILIKE '%%' || %s || '%%'", arg(t)))
+		}
+	}
+	if q.larger > 0 {
+		where = append(where, fmt.Sprintf("size_bytes > %s", arg(q.larger)))
+	}
+	if q.smaller > 0 {
+		where = append(where, fmt.Sprintf("size_bytes < %s", arg(q.smaller)))
+	}
+	if q.hasAttachment {
+		where = append(where, "attachments > 0")
+	}
+	if q.unread {
+		where = append(where, fmt.Sprintf("moz_status & %d = 0", mozFlagRead))
+	}
+	if q.read {
+		where = append(where, fmt.Sprintf("moz_status & %d <> 0", mozFlagRead))
+	}
+	if q.flagged {
+		where = append(where, fmt.Sprintf("moz_status & %d <> 0", mozFlagMarked))
+	}
+	if q.tag != "" {
+		where = append(where, fmt.Sprintf("(' ' || tags || ' ') LIKE '%% ' || %s || ' %%'", arg(q.tag)))
+	}
+	if len(q.accounts) > 0 {
+		where = append(where, fmt.Sprintf("account = ANY(%s)", arg(q.accounts)))
 	}
 	if q.folderLike != "" {
 		where = append(where, fmt.Sprintf("folder ILIKE '%%' || %s || '%%'", arg(q.folderLike)))
 	}
+	for _, t := range folderTagWords {
+		if t.tag == "trash" && q.includeTrash || t.tag == "spam" && q.includeSpam || folderTag(q.folderLike) == t.tag {
+			continue
+		}
+		for _, w := range t.words {
+			where = append(where, fmt.Sprintf("lower(folder) NOT LIKE '%%%s%%'", w))
+		}
+	}
 	if !q.since.IsZero() {
 		where = append(where, fmt.Sprintf("when_ts >= %s", arg(q.since)))
 	}
@@ -165,17 +506,129 @@ func (s *pgStore) Search(ctx context.Context, q queryOptions) ([]MailSummary, er
 	if len(where) > 0 {
 		clause = strings.Join(where, " AND ")
 	}
+	return clause, args
+}
+
+// pgSummaryColumns are the columns scanPGSummaries reads, in order. A NULL
+// moz_status reads as mozStatusUnknown.
+const pgSummaryColumns = "profile, message_id, folder, subject, sender, coalesce(to_addrs, ''), coalesce(cc_addrs, ''), snippet, search_text, when_ts, date_str, account, size_bytes, attachments, coalesce(moz_status, -1), tags"
+
+// pgSummaryDest returns scan destinations for pgSummaryColumns.
+func pgSummaryDest(m *MailSummary, when *time.Time) []any {
+	return []any{&m.Profile, &m.MessageID, &m.Folder, &m.Subject, &m.From, &m.To, &m.Cc, &m.Snippet, &m.Search, when, &m.Date, &m.Account, &m.Size, &m.Attachments, &m.MozStatus, &m.Tags}
+}
+
+func scanPGSummaries(rows pgx.Rows) ([]MailSummary, error) {
+	defer rows.Close()
+	var out []MailSummary
+	for rows.Next() {
+		var m MailSummary
+		var when time.Time
+		if err := rows.Scan(pgSummaryDest(&m, &when)...); err != nil {
+			return nil, err
+		}
+		if !when.IsZero() {
+			m.When = when
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *pgStore) Search(ctx context.Context, q queryOptions) ([]MailSummary, error) {
+	clause, args := pgWhere(q)
 	limitClause := ""
 	if q.limit > 0 {
 		limitClause = fmt.Sprintf("LIMIT %d", q.limit)
 	}
+	if q.rank != "" && q.query != "" {
+		args = append(args, q.query)
+		return s.searchRanked(ctx, clause, pgRankExpr(q.rank, fmt.Sprintf("$%d", len(args))), limitClause, args)
+	}
 	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
-SELECT profile, message_id, folder, subject, sender, snippet, search_text, when_ts, date_str, account
+SELECT %s
 FROM tb_messages
 WHERE %s
-ORDER BY when_ts DESC NULLS LAST, date_str DESC
+ORDER BY %s
 %s
-`, clause, limitClause), args...)
+`, pgSummaryColumns, clause, pgOrderBy(q.sortBy, q.reverse), limitClause), args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanPGSummaries(rows)
+}
+
+// pgGroupColumns is what Group groups by per --group-by key. Sender and
+// domain group the raw From header; senderGroups folds it into addresses.
+var pgGroupColumns = map[string]string{
+	"sender":  "sender",
+	"domain":  "sender",
+	"folder":  "folder",
+	"profile": "profile",
+	"month":   "coalesce(to_char(when_ts, 'YYYY-MM'), 'unknown')",
+}
+
+// Group counts the messages matching q per q.groupBy in one GROUP BY query.
+func (s *pgStore) Group(ctx context.Context, q queryOptions) ([]groupCount, error) {
+	column, ok := pgGroupColumns[q.groupBy]
+	if !ok {
+		return nil, fmt.Errorf("bad --group-by %q", q.groupBy)
+	}
+	clause, args := pgWhere(q)
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+SELECT coalesce(%s, ''), count(*), max(when_ts)
+FROM tb_messages
+WHERE %s
+GROUP BY 1
+`, column, clause), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []groupCount
+	for rows.Next() {
+		var g groupCount
+		var latest *time.Time
+		if err := rows.Scan(&g.Key, &g.Count, &latest); err != nil {
+			return nil, err
+		}
+		if latest != nil {
+			g.Latest = *latest
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return senderGroups(groups, q.groupBy), nil
+}
+
+// pgRecencyHalfLife is how old a message is, in days, when --rank recency
+// has halved its relevance.
+const pgRecencyHalfLife = 90
+
+// pgRankExpr scores a row against the tsquery in param: ts_rank, normalized
+// by document length so long threads do not win on bulk alone, and for
+// recency also halved every pgRecencyHalfLife days. Undated messages score
+// 0 under recency.
+func pgRankExpr(rank, param string) string {
+	score := fmt.Sprintf("ts_rank(%s, websearch_to_tsquery('simple', %s), 1)", pgSearchVector, param)
+	if rank == "recency" {
+		score += fmt.Sprintf(" * coalesce(power(0.5, greatest(extract(epoch FROM now() - when_ts)::float8, 0) / %d), 0)", pgRecencyHalfLife*86400)
+	}
+	return score
+}
+
+// searchRanked is Search for --rank: best score first, newest first among
+// equal scores. --reverse is left to the caller, as for fts hits.
+func (s *pgStore) searchRanked(ctx context.Context, clause, score, limitClause string, args []any) ([]MailSummary, error) {
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+SELECT %s, %s AS score
+FROM tb_messages
+WHERE %s
+ORDER BY score DESC, when_ts DESC NULLS LAST
+%s
+`, pgSummaryColumns, score, clause, limitClause), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -184,25 +637,86 @@ ORDER BY when_ts DESC NULLS LAST, date_str DESC
 	for rows.Next() {
 		var m MailSummary
 		var when time.Time
-		if err := rows.Scan(&m.Profile, &m.MessageID, &m.Folder, &m.Subject, &m.From, &m.Snippet, &m.Search, &when, &m.Date, &m.Account); err != nil {
+		if err := rows.Scan(append(pgSummaryDest(&m, &when), &m.Score)...); err != nil {
 			return nil, err
 		}
-		if !when.IsZero() {
-			m.When = when
-		}
+		m.When = when
 		out = append(out, m)
 	}
 	return out, rows.Err()
 }
 
+// pgOrderBy mirrors sortHits so LIMIT keeps the rows that sort first.
+func pgOrderBy(key string, reverse bool) string {
+	asc, desc := "ASC", "DESC"
+	if reverse {
+		asc, desc = desc, asc
+	}
+	date := fmt.Sprintf("when_ts %s NULLS LAST, date_str %s", desc, desc)
+	switch key {
+	case "from":
+		return fmt.Sprintf("lower(sender) %s, %s", asc, date)
+	case "subject":
+		return fmt.Sprintf("lower(subject) %s, %s", asc, date)
+	case "folder":
+		return fmt.Sprintf("lower(folder) %s, %s", asc, date)
+	case "size":
+		return fmt.Sprintf("size_bytes %s, %s", desc, date)
+	default:
+		return date
+	}
+}
+
 type queryOptions struct {
-	query      string
-	account    string
-	folderLike string
-	since      time.Time
-	till       time.Time
-	limit      int
-	profile    string
+	query         string
+	from          string
+	to            string
+	subject       string
+	body          string
+	excludes      []string
+	larger        int64
+	smaller       int64
+	hasAttachment bool
+	unread        bool
+	read          bool
+	flagged       bool
+	tag           string
+	groupBy       string
+	sortBy        string
+	reverse       bool
+	accounts      []string // lowercased; a message matches any of them
+	folderLike    string
+	since         time.Time
+	till          time.Time
+	limit         int
+	profile       string
+	substring     bool // fts engine: match query terms as substrings rather than words
+	includeTrash  bool
+	includeSpam   bool
+	semantic      string // rank by embedding distance to this text, merged with keyword hits
+	rank          string // pg: order by relevance (ts_rank) or recency (ts_rank decayed by age)
+	like          bool   // pg: match query terms as substrings (pg_trgm) rather than words
+}
+
+// FindMessageFolders returns the folders holding messageID (with or without angle brackets).
+func (s *pgStore) FindMessageFolders(ctx context.Context, profile, messageID string) ([]string, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT DISTINCT folder FROM tb_messages
+WHERE profile = $1 AND message_id IN ($2, '<' || $2 || '>')
+`, profile, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
 }
 
 func (s *pgStore) CountMessages(ctx context.Context, profile string) (int64, error) {
@@ -211,7 +725,137 @@ func (s *pgStore) CountMessages(ctx context.Context, profile string) (int64, err
 	return n, err
 }
 
+// Stats counts the messages of profile. Storage covers the whole database:
+// each tb_ table with its dead rows as the reclaimable share, and each index,
+// whose B-tree bloat is known only when the pgstattuple extension is
+// installed.
+func (s *pgStore) Stats(ctx context.Context, profile string) (StoreStats, error) {
+	st := StoreStats{Backend: "pg"}
+	var oldest, newest *time.Time
+	err := s.pool.QueryRow(ctx, `
+SELECT count(*), count(DISTINCT folder), min(when_ts), max(when_ts), coalesce(sum(octet_length(search_text)), 0)
+FROM tb_messages WHERE profile = $1`, profile).Scan(&st.Messages, &st.Folders, &oldest, &newest, &st.SearchBytes)
+	if err != nil {
+		return st, err
+	}
+	if oldest != nil {
+		st.Oldest, st.Newest = *oldest, *newest
+	}
+	rows, err := s.pool.Query(ctx, `
+SELECT 'folder', folder, count(*) FROM tb_messages WHERE profile = $1 GROUP BY 2
+UNION ALL SELECT 'account', coalesce(account, ''), count(*) FROM tb_messages WHERE profile = $1 GROUP BY 2
+UNION ALL SELECT 'year', coalesce(to_char(when_ts, 'YYYY'), ''), count(*) FROM tb_messages WHERE profile = $1 GROUP BY 2
+ORDER BY 1, 2`, profile)
+	if err != nil {
+		return st, err
+	}
+	for rows.Next() {
+		var kind string
+		var c StoreCount
+		if err := rows.Scan(&kind, &c.Key, &c.Messages); err != nil {
+			rows.Close()
+			return st, err
+		}
+		switch kind {
+		case "folder":
+			st.ByFolder = append(st.ByFolder, c)
+		case "account":
+			st.ByAccount = append(st.ByAccount, c)
+		case "year":
+			st.ByYear = append(st.ByYear, c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return st, err
+	}
+	if st.Storage, err = s.storage(ctx); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+func (s *pgStore) storage(ctx context.Context) ([]StoreSpace, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT c.relname, pg_table_size(c.oid), coalesce(st.n_live_tup, 0), coalesce(st.n_dead_tup, 0)
+FROM pg_class c LEFT JOIN pg_stat_user_tables st ON st.relid = c.oid
+WHERE c.relkind IN ('r', 'm') AND c.relname LIKE 'tb\_%' AND pg_table_is_visible(c.oid)
+ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	var out []StoreSpace
+	for rows.Next() {
+		var sp StoreSpace
+		var live, dead int64
+		if err := rows.Scan(&sp.Name, &sp.Bytes, &live, &dead); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if live+dead > 0 {
+			sp.Reclaimable = sp.Bytes * dead / (live + dead)
+		}
+		out = append(out, sp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var pgstattuple bool
+	if err := s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pgstattuple')").Scan(&pgstattuple); err != nil {
+		return nil, err
+	}
+	rows, err = s.pool.Query(ctx, `
+SELECT i.relname, pg_relation_size(i.oid), am.amname
+FROM pg_index x
+JOIN pg_class i ON i.oid = x.indexrelid
+JOIN pg_class t ON t.oid = x.indrelid
+JOIN pg_am am ON am.oid = i.relam
+WHERE t.relname LIKE 'tb\_%' AND pg_table_is_visible(t.oid)
+ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []StoreSpace
+	var methods []string
+	for rows.Next() {
+		var sp StoreSpace
+		var am string
+		if err := rows.Scan(&sp.Name, &sp.Bytes, &am); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sp.Reclaimable = -1
+		indexes = append(indexes, sp)
+		methods = append(methods, am)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range indexes {
+		if !pgstattuple || methods[i] != "btree" {
+			continue
+		}
+		// A freshly built B-tree packs leaves to its 90% fillfactor.
+		var density float64
+		if err := s.pool.QueryRow(ctx, "SELECT avg_leaf_density FROM pgstatindex($1::regclass)", indexes[i].Name).Scan(&density); err == nil && density > 0 && density < 90 {
+			indexes[i].Reclaimable = int64(float64(indexes[i].Bytes) * (1 - density/90))
+		} else if err == nil {
+			indexes[i].Reclaimable = 0
+		}
+	}
+	for i := range indexes {
+		indexes[i].Name += " (index)"
+	}
+	return append(out, indexes...), nil
+}
+
 func (s *pgStore) SetMeta(ctx context.Context, key, val string) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
 	_, err := s.pool.Exec(ctx, `
 INSERT INTO tb_meta (key, val) VALUES ($1, $2)
 ON CONFLICT (key) DO UPDATE SET val = EXCLUDED.val
@@ -245,13 +889,61 @@ func (s *pgStore) GetMetaPrefix(ctx context.Context, prefix string) (map[string]
 	return out, rows.Err()
 }
 
-func (s *pgStore) PruneMissing(ctx context.Context, profile string, keepIDs []string) error {
+// Prune is PruneMissing, for the Store interface.
+func (s *pgStore) Prune(ctx context.Context, profile string, keepIDs []string) (int64, error) {
+	return s.PruneMissing(ctx, profile, keepIDs)
+}
+
+// PruneMissing drops the rows of a profile whose Message-Id is not in keepIDs,
+// the ids a full rescan of every folder saw. An empty keepIDs deletes nothing,
+// so a scan that failed to read anything cannot wipe the profile.
+func (s *pgStore) PruneMissing(ctx context.Context, profile string, keepIDs []string) (int64, error) {
 	if len(keepIDs) == 0 {
-		return nil
+		return 0, nil
 	}
-	_, err := s.pool.Exec(ctx, `
-DELETE FROM tb_messages
-WHERE profile = $1 AND message_id <> ALL($2)
-`, profile, keepIDs)
-	return err
+	return s.deleteUnkept(ctx, "m.profile = $1", []any{profile}, keepIDs)
+}
+
+// PruneFolder drops the rows of one folder that a complete rescan of it did not
+// see again: messages deleted, expunged, or compacted away since the last ingest.
+func (s *pgStore) PruneFolder(ctx context.Context, profile, folder string, keepIDs []string) (int64, error) {
+	return s.deleteUnkept(ctx, "m.profile = $1 AND m.folder = $2", []any{profile, forceUTF8(folder)}, keepIDs)
+}
+
+// deleteUnkept deletes the tb_messages rows (aliased m) matching where whose
+// Message-Id is not in keepIDs. The ids are staged with COPY into a temporary
+// table and removed with an anti-join: a single array parameter of hundreds
+// of thousands of ids is too large for the protocol, and <> ALL compares
+// every row with every id.
+func (s *pgStore) deleteUnkept(ctx context.Context, where string, args []any, keepIDs []string) (int64, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE tb_keep (message_id text NOT NULL) ON COMMIT DROP"); err != nil {
+		return 0, err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"tb_keep"}, []string{"message_id"},
+		pgx.CopyFromSlice(len(keepIDs), func(i int) ([]any, error) { return []any{keepIDs[i]}, nil })); err != nil {
+		return 0, fmt.Errorf("stage keep ids: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "ANALYZE tb_keep"); err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, `
+DELETE FROM tb_messages m
+WHERE `+where+`
+  AND NOT EXISTS (SELECT 1 FROM tb_keep k WHERE k.message_id = m.message_id)
+`, args...)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// prefMap holds the preferences set in a prefs.js, by name. Values are string,
+// int64, or bool, as the file declares them.
+type prefMap map[string]any
+
+// String returns the named pref as text: strings as they are, numbers and
+// booleans formatted. A missing pref is "".
+func (p prefMap) String(name string) string {
+	switch v := p[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Int returns the named integer pref, and whether it is set as one.
+func (p prefMap) Int(name string) (int64, bool) {
+	v, ok := p[name].(int64)
+	return v, ok
+}
+
+// Bool returns the named boolean pref, and whether it is set as one.
+func (p prefMap) Bool(name string) (bool, bool) {
+	v, ok := p[name].(bool)
+	return v, ok
+}
+
+// profilePrefs reads the preferences Thunderbird runs the profile with:
+// prefs.js, overridden by user.js when there is one, as Thunderbird applies
+// it at every start. Either file may be missing, but not both.
+func profilePrefs(profile Profile) (prefMap, error) {
+	p := prefMap{}
+	found := false
+	for _, name := range []string{"prefs.js", "user.js"} {
+		err := parsePrefsInto(filepath.Join(profile.AbsolutePath, name), p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("no prefs.js in %s", profile.AbsolutePath)
+	}
+	return p, nil
+}
+
+// parsePrefsInto reads a prefs file into p, over what p holds already.
+func parsePrefsInto(path string, p prefMap) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, err := range parsePrefsData(string(b), p) {
+		log.Printf("warn: %s: %v", path, err)
+	}
+	return nil
+}
+
+// parsePrefsData reads the pref(...), user_pref(...), and sticky_pref(...)
+// calls of src into p, and drops the prefs named by clearPref(...), as a
+// user.js may do. It reads them the way Thunderbird's own parser does: values are
+// strings in single or double quotes (with JavaScript escapes, and free to
+// span lines), integers, or true/false, and //, /* */, and # comments are
+// skipped. A malformed statement is reported and skipped up to the next ';'.
+func parsePrefsData(src string, p prefMap) []error {
+	s := &prefScanner{src: src}
+	var errs []error
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.src) {
+			return errs
+		}
+		start := s.line()
+		if err := s.statement(p); err != nil {
+			if len(errs) < 5 {
+				errs = append(errs, fmt.Errorf("line %d: %v", start, err))
+			}
+			s.skipPast(';')
+		}
+	}
+}
+
+type prefScanner struct {
+	src string
+	pos int
+}
+
+func (s *prefScanner) line() int { return 1 + strings.Count(s.src[:s.pos], "\n") }
+
+func (s *prefScanner) statement(p prefMap) error {
+	fn := s.ident()
+	switch fn {
+	case "pref", "user_pref", "sticky_pref":
+	case "clearPref", "user_clearPref":
+		if err := s.expect('('); err != nil {
+			return err
+		}
+		name, err := s.str()
+		if err != nil {
+			return fmt.Errorf("pref name: %v", err)
+		}
+		if err := s.expect(')'); err != nil {
+			return err
+		}
+		if err := s.expect(';'); err != nil {
+			return err
+		}
+		delete(p, name)
+		return nil
+	case "":
+		return fmt.Errorf("unexpected %q", s.peekText())
+	default:
+		return fmt.Errorf("unknown function %s", fn)
+	}
+	if err := s.expect('('); err != nil {
+		return err
+	}
+	name, err := s.str()
+	if err != nil {
+		return fmt.Errorf("pref name: %v", err)
+	}
+	if err := s.expect(','); err != nil {
+		return err
+	}
+	value, err := s.value()
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if err := s.expect(')'); err != nil {
+		return err
+	}
+	if err := s.expect(';'); err != nil {
+		return err
+	}
+	p[name] = value
+	return nil
+}
+
+// skipSpace skips whitespace and comments.
+func (s *prefScanner) skipSpace() {
+	for s.pos < len(s.src) {
+		rest := s.src[s.pos:]
+		switch {
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r' || rest[0] == '\n':
+			s.pos++
+		case strings.HasPrefix(rest, "//") || rest[0] == '#':
+			if i := strings.IndexByte(rest, '\n'); i >= 0 {
+				s.pos += i + 1
+			} else {
+				s.pos = len(s.src)
+			}
+		case strings.HasPrefix(rest, "/*"):
+			if i := strings.Index(rest[2:], "*/"); i >= 0 {
+				s.pos += i + 4
+			} else {
+				s.pos = len(s.src)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *prefScanner) skipPast(c byte) {
+	if i := strings.IndexByte(s.src[s.pos:], c); i >= 0 {
+		s.pos += i + 1
+	} else {
+		s.pos = len(s.src)
+	}
+}
+
+func (s *prefScanner) peekText() string {
+	return truncate(strings.SplitN(s.src[s.pos:], "\n", 2)[0], 20)
+}
+
+func (s *prefScanner) expect(c byte) error {
+	s.skipSpace()
+	if s.pos >= len(s.src) || s.src[s.pos] != c {
+		return fmt.Errorf("expected %q", c)
+	}
+	s.pos++
+	return nil
+}
+
+func (s *prefScanner) ident() string {
+	start := s.pos
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		if c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9' && s.pos > start) {
+			break
+		}
+		s.pos++
+	}
+	return s.src[start:s.pos]
+}
+
+func (s *prefScanner) value() (any, error) {
+	s.skipSpace()
+	if s.pos >= len(s.src) {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch c := s.src[s.pos]; {
+	case c == '"' || c == '\'':
+		return s.str()
+	case c == '-' || c == '+' || '0' <= c && c <= '9':
+		start := s.pos
+		s.pos++
+		for s.pos < len(s.src) && '0' <= s.src[s.pos] && s.src[s.pos] <= '9' {
+			s.pos++
+		}
+		n, err := strconv.ParseInt(strings.TrimPrefix(s.src[start:s.pos], "+"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad integer %q", s.src[start:s.pos])
+		}
+		return n, nil
+	}
+	switch word := s.ident(); word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return nil, fmt.Errorf("bad value %q", s.peekText())
+	}
+}
+
+// str reads a quoted string, decoding \" \' \\ \n \r \t \xHH and \uHHHH.
+func (s *prefScanner) str() (string, error) {
+	s.skipSpace()
+	if s.pos >= len(s.src) || s.src[s.pos] != '"' && s.src[s.pos] != '\'' {
+		return "", fmt.Errorf("expected a string")
+	}
+	quote := s.src[s.pos]
+	s.pos++
+	var b strings.Builder
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		s.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c != '\\':
+			b.WriteByte(c)
+			continue
+		}
+		if s.pos >= len(s.src) {
+			break
+		}
+		e := s.src[s.pos]
+		s.pos++
+		switch e {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'x', 'u':
+			n := 2
+			if e == 'u' {
+				n = 4
+			}
+			if s.pos+n > len(s.src) {
+				return "", fmt.Errorf("short \\%c escape", e)
+			}
+			r, err := strconv.ParseUint(s.src[s.pos:s.pos+n], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("bad \\%c escape", e)
+			}
+			s.pos += n
+			if e == 'u' && utf16.IsSurrogate(rune(r)) && s.pos+6 <= len(s.src) && s.src[s.pos:s.pos+2] == `\u` {
+				if lo, err := strconv.ParseUint(s.src[s.pos+2:s.pos+6], 16, 32); err == nil {
+					if pair := utf16.DecodeRune(rune(r), rune(lo)); pair != utf8.RuneError {
+						r = uint64(pair)
+						s.pos += 6
+					}
+				}
+			}
+			b.WriteRune(rune(r))
+		default:
+			// \" \' \\, and any other character stands for itself.
+			b.WriteByte(e)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
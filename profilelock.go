@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// warnedRunning remembers the profiles already reported as open in
+// Thunderbird, so watch loops warn once rather than every tick.
+var warnedRunning sync.Map
+
+// markLive flags boxes as live when Thunderbird has profile open: they are
+// then read as snapshots of their size at open, an unfinished message at
+// the end is left for the next run, and the index is kept out of the
+// profile. Closing Thunderbird is not required.
+func markLive(profile Profile, boxes []Mailbox) {
+	holder, running := thunderbirdLockHolder(profile.AbsolutePath)
+	if !running {
+		return
+	}
+	if _, seen := warnedRunning.LoadOrStore(profile.AbsolutePath, true); !seen {
+		if holder != "" {
+			holder = " (" + holder + ")"
+		}
+		log.Printf("warn: Thunderbird is running on profile %s%s; reading its folders as snapshots, and folders it compacts meanwhile are rescanned next run", profile.Name, holder)
+	}
+	for i := range boxes {
+		boxes[i].Live = true
+	}
+}
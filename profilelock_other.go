@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// thunderbirdLockHolder reports whether a running Thunderbird has the
+// profile in dir open. On Windows Thunderbird keeps parent.lock open without
+// sharing while it runs, so the file exists but cannot be opened; one left
+// behind by a crash opens fine.
+func thunderbirdLockHolder(dir string) (string, bool) {
+	f, err := os.OpenFile(filepath.Join(dir, "parent.lock"), os.O_RDWR, 0)
+	if err == nil {
+		f.Close()
+		return "", false
+	}
+	return "", !errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// thunderbirdLockHolder reports whether a running Thunderbird has the
+// profile in dir open, and names the process when it can. Thunderbird holds
+// an fcntl lock on .parentlock while it runs, and on Linux also points the
+// lock symlink at "ADDRESS:+PID"; a lock left behind by a crash counts only
+// when its process is still alive.
+func thunderbirdLockHolder(dir string) (string, bool) {
+	if f, err := os.OpenFile(filepath.Join(dir, ".parentlock"), os.O_RDWR, 0); err == nil {
+		lk := syscall.Flock_t{Type: syscall.F_WRLCK}
+		err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk)
+		f.Close()
+		if err == nil && lk.Type != syscall.F_UNLCK {
+			return fmt.Sprintf("pid %d", lk.Pid), true
+		}
+	}
+	target, err := os.Readlink(filepath.Join(dir, "lock"))
+	if err != nil {
+		return "", false
+	}
+	_, pidText, ok := strings.Cut(target, ":+")
+	pid, err := strconv.Atoi(pidText)
+	if !ok || err != nil || pid <= 0 {
+		return "", false
+	}
+	if err := syscall.Kill(pid, 0); err != nil && !errors.Is(err, syscall.EPERM) {
+		return "", false
+	}
+	return fmt.Sprintf("pid %d", pid), true
+}
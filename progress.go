@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressQuiet disables scan progress on stderr (--quiet).
+var progressQuiet bool
+
+// progressInterval is how often a running folder scan reports its position.
+const progressInterval = 2 * time.Second
+
+// scanProgress tracks one folder scan: bytes read from the mbox, messages
+// parsed, and when the next report is due.
+type scanProgress struct {
+	name     string
+	total    int64
+	read     int64
+	base     int64 // offset the scan resumed from
+	messages int
+	started  time.Time
+	next     time.Time
+}
+
+// newScanProgress wraps r so reads are counted against the folder's total size.
+func newScanProgress(name string, r io.Reader, total int64) (*scanProgress, io.Reader) {
+	p := &scanProgress{name: name, total: total, started: time.Now()}
+	p.next = p.started.Add(progressInterval)
+	return p, &progressReader{r: r, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *scanProgress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.read += int64(n)
+	return n, err
+}
+
+// message counts one parsed message and prints a progress line when due.
+func (p *scanProgress) message() {
+	p.messages++
+	if progressQuiet {
+		return
+	}
+	now := time.Now()
+	if now.Before(p.next) {
+		return
+	}
+	p.next = now.Add(progressInterval)
+	line := fmt.Sprintf("  %s: %s / %s", p.name, byteSize(p.read), byteSize(p.total))
+	if p.total > 0 {
+		line += fmt.Sprintf(" (%d%%)", p.read*100/p.total)
+	}
+	line += fmt.Sprintf(", %d messages", p.messages)
+	if p.read > p.base && p.total > p.read {
+		elapsed := now.Sub(p.started)
+		eta := time.Duration(float64(elapsed) * float64(p.total-p.read) / float64(p.read-p.base))
+		line += ", ETA " + eta.Round(time.Second).String()
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
+
+// done prints the folder's totals once the scan is over.
+func (p *scanProgress) done() {
+	if progressQuiet {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "  %s: %d messages, %s in %s\n", p.name, p.messages, byteSize(p.read-p.base), time.Since(p.started).Round(10*time.Millisecond))
+}
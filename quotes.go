@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// minFoldedQuote is the smallest quoted block that gets folded; shorter quotes
+// are usually inline context for the reply and stay visible.
+const minFoldedQuote = 4
+
+var (
+	quoteLeadIn      = regexp.MustCompile(`(?i)(wrote|writes|schrieb|a écrit|ha scritto|escribió|napisał|skrev)\s*:\s*$`)
+	quoteLeadInStart = regexp.MustCompile(`(?i)^\s*(on|am|le|il|el|w dniu|den)\s`)
+	originalMessage  = regexp.MustCompile(`(?i)^\s*-{2,}\s*(original message|forwarded message|ursprüngliche nachricht|message d'origine)\s*-{2,}\s*$`)
+)
+
+// foldQuotes collapses quoted history in a reply: runs of ">" lines (with the
+// "On ... wrote:" line introducing them) and Outlook-style "Original Message"
+// tails become a one-line marker.
+func foldQuotes(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	lines := strings.Split(body, "\n")
+	var out []string
+	for i := 0; i < len(lines); i++ {
+		if originalMessage.MatchString(lines[i]) {
+			hidden := 0
+			for _, l := range lines[i:] {
+				if strings.TrimSpace(l) != "" {
+					hidden++
+				}
+			}
+			out = append(out, fmt.Sprintf("[... %d lines of earlier messages hidden; use --full-quotes]", hidden))
+			break
+		}
+		if !isQuoteLine(lines[i]) {
+			out = append(out, lines[i])
+			continue
+		}
+		end := i
+		for end < len(lines) && (isQuoteLine(lines[end]) || (strings.TrimSpace(lines[end]) == "" && end+1 < len(lines) && isQuoteLine(lines[end+1]))) {
+			end++
+		}
+		if end-i < minFoldedQuote {
+			out = append(out, lines[i:end]...)
+			i = end - 1
+			continue
+		}
+		hidden := end - i
+		// Pull the attribution line(s) into the fold.
+		j := len(out) - 1
+		for j >= 0 && strings.TrimSpace(out[j]) == "" {
+			j--
+		}
+		if j >= 0 && quoteLeadIn.MatchString(out[j]) {
+			if !quoteLeadInStart.MatchString(out[j]) && j > 0 && quoteLeadInStart.MatchString(out[j-1]) {
+				j--
+			}
+			out = out[:j]
+		}
+		out = append(out, fmt.Sprintf("[... %d quoted lines hidden; use --full-quotes]", hidden))
+		i = end - 1
+	}
+	return strings.Join(out, "\n")
+}
+
+func isQuoteLine(l string) bool {
+	return strings.HasPrefix(strings.TrimLeft(l, " \t"), ">")
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionAge is how long messages are kept, e.g. 7y or 30d.
+type retentionAge struct {
+	text                string
+	years, months, days int
+}
+
+// parseRetentionAge reads N followed by d (days), w (weeks), m (months), or
+// y (years).
+func parseRetentionAge(s string) (retentionAge, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return retentionAge{}, fmt.Errorf("bad retention %q (use e.g. 30d, 12w, 6m, 7y)", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return retentionAge{}, fmt.Errorf("bad retention %q (use e.g. 30d, 12w, 6m, 7y)", s)
+	}
+	age := retentionAge{text: s}
+	switch s[len(s)-1] {
+	case 'd':
+		age.days = n
+	case 'w':
+		age.days = 7 * n
+	case 'm':
+		age.months = n
+	case 'y':
+		age.years = n
+	default:
+		return retentionAge{}, fmt.Errorf("bad retention %q (use e.g. 30d, 12w, 6m, 7y)", s)
+	}
+	return age, nil
+}
+
+// cutoff is the date before which messages are past age at now.
+func (r retentionAge) cutoff(now time.Time) time.Time {
+	return now.AddDate(-r.years, -r.months, -r.days)
+}
+
+// folderRetention keeps the messages of folders whose name contains folder
+// for age, instead of the policy's default.
+type folderRetention struct {
+	folder string
+	age    retentionAge
+}
+
+// retentionPolicy is what tb store retention enforces. Folder rules are
+// tried in order and the first match wins; other folders get keep, or are
+// kept forever when keep is unset. Undated messages are always kept.
+type retentionPolicy struct {
+	keep    *retentionAge
+	folders []folderRetention
+}
+
+// parseRetentionPolicy reads --keep and --folder-policy ("Trash=30d,Junk=7d").
+func parseRetentionPolicy(keep, folders string) (retentionPolicy, error) {
+	var p retentionPolicy
+	if strings.TrimSpace(keep) != "" {
+		age, err := parseRetentionAge(keep)
+		if err != nil {
+			return p, err
+		}
+		p.keep = &age
+	}
+	for _, rule := range strings.Split(folders, ",") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+		name, ageText, ok := strings.Cut(rule, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return p, fmt.Errorf("bad folder policy %q (use Folder=AGE, e.g. Trash=30d)", rule)
+		}
+		age, err := parseRetentionAge(ageText)
+		if err != nil {
+			return p, fmt.Errorf("folder policy %s: %w", name, err)
+		}
+		p.folders = append(p.folders, folderRetention{name, age})
+	}
+	return p, nil
+}
+
+// configRetentionPolicy is the [retention] section of the config file
+// (keep = 7y, folders = Trash=30d,Junk=7d), which every sync --store pg
+// enforces. It is empty when the section is missing.
+func configRetentionPolicy() (retentionPolicy, error) {
+	cfg, err := readConfigSection(configPath(), "retention")
+	if err != nil {
+		return retentionPolicy{}, err
+	}
+	p, err := parseRetentionPolicy(cfg["keep"], cfg["folders"])
+	if err != nil {
+		return p, fmt.Errorf("%s [retention]: %w", configPath(), err)
+	}
+	return p, nil
+}
+
+func (p retentionPolicy) empty() bool { return p.keep == nil && len(p.folders) == 0 }
+
+// retentionRule is one rule of a policy applied at a point in time.
+type retentionRule struct {
+	name    string // as logged: Trash=30d, or keep=7y for the default
+	cutoff  time.Time
+	expired []MailSummary
+	deleted int64
+}
+
+// rules lists the policy's rules in the order they are tried, with their
+// cutoffs at now.
+func (p retentionPolicy) rules(now time.Time) []*retentionRule {
+	var rules []*retentionRule
+	for _, f := range p.folders {
+		rules = append(rules, &retentionRule{name: f.folder + "=" + f.age.text, cutoff: f.age.cutoff(now)})
+	}
+	if p.keep != nil {
+		rules = append(rules, &retentionRule{name: "keep=" + p.keep.text, cutoff: p.keep.cutoff(now)})
+	}
+	return rules
+}
+
+// expire files each dated message of msgs under the first rule matching its
+// folder when it is older than that rule's cutoff.
+func (p retentionPolicy) expire(rules []*retentionRule, msgs []MailSummary) {
+	for _, m := range msgs {
+		if m.When.IsZero() {
+			continue
+		}
+		r := rules[len(rules)-1]
+		lower := strings.ToLower(m.Folder)
+		matched := false
+		for i, f := range p.folders {
+			if strings.Contains(lower, strings.ToLower(f.folder)) {
+				r, matched = rules[i], true
+				break
+			}
+		}
+		if !matched && p.keep == nil {
+			continue
+		}
+		if m.When.Before(r.cutoff) {
+			r.expired = append(r.expired, m)
+		}
+	}
+}
+
+// newestCutoff is the latest cutoff of rules: nothing newer can be expired,
+// so the store search can stop there.
+func newestCutoff(rules []*retentionRule) time.Time {
+	var newest time.Time
+	for _, r := range rules {
+		if r.cutoff.After(newest) {
+			newest = r.cutoff
+		}
+	}
+	return newest
+}
+
+// logRetention appends one line per rule to retention.log next to the
+// config file, so every enforcement run leaves a record.
+func logRetention(now time.Time, profile, store string, rules []*retentionRule, dryRun bool) error {
+	path := configFile("retention.log")
+	if path == "" {
+		return fmt.Errorf("no config directory for the retention log")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	stamp := now.UTC().Format(time.RFC3339)
+	for _, r := range rules {
+		fmt.Fprintf(&b, "%s profile=%q store=%s rule=%q cutoff=%s expired=%d", stamp, profile, store, r.name, r.cutoff.Format("2006-01-02"), len(r.expired))
+		if dryRun {
+			b.WriteString(" dry-run\n")
+		} else {
+			fmt.Fprintf(&b, " deleted=%d\n", r.deleted)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// applyRetention deletes the messages of profile past policy from store,
+// which must be a messageDeleter, and logs the run. With dryRun it only
+// finds and logs them.
+func applyRetention(ctx context.Context, store Store, storeName string, profile Profile, policy retentionPolicy, dryRun bool) ([]*retentionRule, error) {
+	now := time.Now()
+	rules := policy.rules(now)
+	msgs, err := store.Search(ctx, queryOptions{
+		profile: profile.Name, till: newestCutoff(rules), includeTrash: true, includeSpam: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	policy.expire(rules, msgs)
+	if !dryRun {
+		for _, r := range rules {
+			n, err := store.(messageDeleter).Delete(ctx, profile.Name, r.expired)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.name, err)
+			}
+			r.deleted = n
+		}
+	}
+	if err := logRetention(now, profile.Name, storeName, rules, dryRun); err != nil {
+		return rules, fmt.Errorf("retention log: %w", err)
+	}
+	return rules, nil
+}
+
+// storeRetention enforces policy (else the config file's) on one profile's
+// store and prints what each rule expired. With candidates it also lists
+// the messages still in the mbox files that are past the policy, read from
+// the local index, for deleting in Thunderbird.
+func (a *App) storeRetention(profileName, storeName string, policy retentionPolicy, dryRun, candidates bool) error {
+	if policy.empty() {
+		var err error
+		if policy, err = configRetentionPolicy(); err != nil {
+			return err
+		}
+		if policy.empty() {
+			return fmt.Errorf("no policy: give --keep and/or --folder-policy, or set them in a [retention] section of %s", configPath())
+		}
+	}
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+
+	var onDisk []*retentionRule
+	if candidates {
+		unlock, err := lockIndex(profile, false)
+		if err != nil {
+			return fmt.Errorf("lock index: %w", err)
+		}
+		msgs, err := a.localMessages(profile, "")
+		unlock()
+		if err != nil {
+			return err
+		}
+		onDisk = policy.rules(time.Now())
+		policy.expire(onDisk, msgs)
+	}
+
+	store, closeStore, err := a.openDeletableStore(profile, storeName, dryRun)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	rules, err := applyRetention(context.Background(), store, storeName, profile, policy, dryRun)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(rules))
+	for _, r := range rules {
+		deleted := strconv.FormatInt(r.deleted, 10)
+		if dryRun {
+			deleted = "-"
+		}
+		rows = append(rows, []string{r.name, r.cutoff.Format("2006-01-02"), strconv.Itoa(len(r.expired)), deleted})
+	}
+	renderTable(os.Stdout, []string{"Rule", "Cutoff", "Expired", "Deleted"}, rows)
+	fmt.Printf("\nLogged to %s\n", configFile("retention.log"))
+
+	if candidates {
+		var rows [][]string
+		for _, r := range onDisk {
+			for _, m := range r.expired {
+				rows = append(rows, []string{m.When.In(time.Local).Format("2006-01-02"), truncate(m.Folder, 30), r.name, m.MessageID, truncate(m.Subject, 40)})
+			}
+		}
+		fmt.Println()
+		if len(rows) == 0 {
+			fmt.Println("No messages in the mbox files are past the policy.")
+			return nil
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i][1]+rows[i][0] < rows[j][1]+rows[j][0] })
+		renderTable(os.Stdout, []string{"Date", "Folder", "Rule", "Message-Id", "Subject"}, rows)
+		fmt.Printf("\n%d message(s) in the mbox files are past the policy; delete them in Thunderbird to remove them for good.\n", len(rows))
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Meilisearch and Elasticsearch are search-engine stores: tb mail sync
+// --store meili|es pushes the local index's MailSummary rows to them as
+// documents, one per profile and Message-Id like tb_messages, and search
+// --store meili|es queries them with the engine's typo-tolerant ranking.
+// Their own UIs (Meilisearch's dashboard, Kibana) work on the same index.
+
+// engineBatch is how many documents go to the engine per request.
+const engineBatch = 1000
+
+// engineWindow caps how many hits a search pages through: the most either
+// engine returns for one query by default.
+const engineWindow = 10000
+
+// engineConfig says where a search engine lives.
+type engineConfig struct {
+	kind     string // meili or es
+	url      string // base URL, no trailing slash
+	index    string // index name; default tb_messages
+	key      string // Meilisearch API key, or Elasticsearch API key
+	user     string // Elasticsearch basic auth, when there is no API key
+	password string
+	readOnly bool // readonly = true in the config section, or --store-readonly
+}
+
+// engineSections maps a store name to its config file section and
+// environment variable prefix.
+var engineSections = map[string]struct{ section, env string }{
+	"meili": {"meili", "TB_MEILI_"},
+	"es":    {"elasticsearch", "TB_ES_"},
+}
+
+// searchEngineConfig reads the connection for kind: TB_MEILI_URL,
+// TB_MEILI_KEY, and TB_MEILI_INDEX (TB_ES_URL, TB_ES_API_KEY or
+// TB_ES_USER/TB_ES_PASSWORD, TB_ES_INDEX), else the url/key/index/user/
+// password keys of the [meili] ([elasticsearch]) section of the config file.
+func searchEngineConfig(kind string) (engineConfig, error) {
+	sec := engineSections[kind]
+	path := configPath()
+	cfg, err := readConfigSection(path, sec.section)
+	if err != nil {
+		return engineConfig{}, fmt.Errorf("read %s: %w", path, err)
+	}
+	get := func(key, envKey string) string {
+		if v := strings.TrimSpace(os.Getenv(sec.env + envKey)); v != "" {
+			return v
+		}
+		return strings.TrimSpace(cfg[key])
+	}
+	c := engineConfig{
+		kind:     kind,
+		url:      strings.TrimRight(get("url", "URL"), "/"),
+		index:    firstNonEmpty(get("index", "INDEX"), "tb_messages"),
+		key:      firstNonEmpty(get("key", "KEY"), get("api_key", "API_KEY")),
+		user:     get("user", "USER"),
+		password: get("password", "PASSWORD"),
+		readOnly: storeReadOnly,
+	}
+	if v, ok := cfg["readonly"]; ok {
+		ro, err := strconv.ParseBool(v)
+		if err != nil {
+			return engineConfig{}, fmt.Errorf("%s: [%s] readonly: %w", path, sec.section, err)
+		}
+		c.readOnly = c.readOnly || ro
+	}
+	if c.url == "" {
+		return engineConfig{}, fmt.Errorf("no %s configured: set %sURL or add a [%s] section to %s", kind, sec.env, sec.section, path)
+	}
+	if cfg["key"] != "" || cfg["api_key"] != "" || cfg["password"] != "" {
+		if fi, err := os.Stat(path); err == nil && fi.Mode().Perm()&0o077 != 0 {
+			log.Printf("warn: %s holds a %s secret but is readable by others; chmod 600 it", path, sec.section)
+		}
+	}
+	return c, nil
+}
+
+// target names the index for messages and for the sync state, which is
+// kept per index.
+func (c engineConfig) target() string {
+	return c.url + "/" + c.index
+}
+
+func (c engineConfig) writable() error {
+	if c.readOnly {
+		return errStoreReadOnly
+	}
+	return nil
+}
+
+// errEngineNotFound is what call returns for a 404, so callers can tell a
+// missing index from a broken server.
+var errEngineNotFound = errors.New("not found")
+
+// call sends one JSON request (NDJSON when body is []byte) to the engine and
+// decodes the reply into out, when given.
+func (c engineConfig) call(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r, contentType = bytes.NewReader(b), "application/x-ndjson"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, r)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case c.kind == "meili" && c.key != "":
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	case c.key != "":
+		req.Header.Set("Authorization", "ApiKey "+c.key)
+	case c.user != "":
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s %s: %w", method, path, errEngineNotFound)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// engineDoc is a MailSummary as the engines store it.
+type engineDoc struct {
+	ID          string `json:"id"` // engineDocID
+	Profile     string `json:"profile"`
+	MessageID   string `json:"message_id"`
+	Folder      string `json:"folder"`
+	Subject     string `json:"subject"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Cc          string `json:"cc"`
+	Date        string `json:"date"`
+	When        *int64 `json:"when,omitempty"` // unix seconds; absent when undated
+	Year        string `json:"year"`
+	Snippet     string `json:"snippet"`
+	Search      string `json:"search_text"`
+	SearchBytes int    `json:"search_bytes"`
+	Body        string `json:"body_text"`
+	Account     string `json:"account"`
+	Size        int64  `json:"size"`
+	Attachments int    `json:"attachments"`
+	MozStatus   int    `json:"moz_status"`
+	Tags        string `json:"tags"`
+	Unread      bool   `json:"unread"`
+	Flagged     bool   `json:"flagged"`
+}
+
+// engineDocID keys a document by profile and Message-Id, the primary key of
+// tb_messages, hashed because Meilisearch ids allow only [A-Za-z0-9_-].
+func engineDocID(profile, messageID string) string {
+	h := sha1.Sum([]byte(profile + "\x00" + messageID))
+	return hex.EncodeToString(h[:])
+}
+
+func newEngineDoc(m MailSummary) engineDoc {
+	d := engineDoc{
+		ID: engineDocID(m.Profile, m.MessageID), Profile: m.Profile, MessageID: forceUTF8(m.MessageID),
+		Folder: forceUTF8(m.Folder), Subject: forceUTF8(m.Subject), From: forceUTF8(m.From),
+		To: forceUTF8(m.To), Cc: forceUTF8(m.Cc), Date: forceUTF8(m.Date), Year: statsYear(m),
+		Snippet: forceUTF8(m.Snippet), Search: forceUTF8(m.Search), SearchBytes: len(m.Search),
+		Body: forceUTF8(m.Body), Account: forceUTF8(m.Account), Size: m.Size, Attachments: m.Attachments, MozStatus: m.MozStatus,
+		Tags: forceUTF8(m.Tags), Unread: m.Unread(), Flagged: m.Flagged(),
+	}
+	if !m.When.IsZero() {
+		when := m.When.Unix()
+		d.When = &when
+	}
+	return d
+}
+
+func (d engineDoc) summary() MailSummary {
+	m := MailSummary{
+		Profile: d.Profile, MessageID: d.MessageID, Folder: d.Folder, Subject: d.Subject, From: d.From,
+		To: d.To, Cc: d.Cc, Date: d.Date, Snippet: d.Snippet, Search: d.Search, Body: d.Body, Account: d.Account,
+		Size: d.Size, Attachments: d.Attachments, MozStatus: d.MozStatus, Tags: d.Tags,
+	}
+	if d.When != nil {
+		m.When = time.Unix(*d.When, 0)
+	}
+	return m
+}
+
+// engineSort says how a search should have the engine order its hits so
+// that the first q.limit are the right ones: by date when the results are
+// sorted by date, else by relevance. Other sorts need every hit and return
+// false for paged.
+func engineSort(q queryOptions) (byDate, ascending, paged bool) {
+	switch q.sortBy {
+	case "":
+		return false, false, true
+	case "date":
+		return true, q.reverse, true
+	}
+	return false, false, false
+}
+
+// collectHits pages through an engine's hits with fetch, keeping those that
+// pass filterMatches, until q.limit are found, the hits run out, or
+// engineWindow is reached.
+func collectHits(q queryOptions, fetch func(offset, size int) ([]MailSummary, error)) ([]MailSummary, error) {
+	_, _, paged := engineSort(q)
+	want := q.limit
+	if !paged {
+		want = 0
+	}
+	size := engineBatch
+	if want > 0 {
+		size = min(max(want*2, 50), engineBatch)
+	}
+	var hits []MailSummary
+	for offset := 0; offset < engineWindow; offset += size {
+		page, err := fetch(offset, min(size, engineWindow-offset))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range page {
+			if filterMatches(m, q) {
+				hits = append(hits, m)
+			}
+		}
+		if want > 0 && len(hits) >= want {
+			return hits[:want], nil
+		}
+		if len(page) < size {
+			break
+		}
+	}
+	return hits, nil
+}
+
+// unkeptDocs returns the ids of the documents in stored (id to Message-Id)
+// whose Message-Id is not in keepIDs. An empty keepIDs keeps everything,
+// as in Postgres.
+func unkeptDocs(stored map[string]string, keepIDs []string) []string {
+	if len(keepIDs) == 0 {
+		return nil
+	}
+	keep := make(map[string]bool, len(keepIDs))
+	for _, id := range keepIDs {
+		keep[id] = true
+	}
+	var drop []string
+	for id, messageID := range stored {
+		if !keep[messageID] {
+			drop = append(drop, id)
+		}
+	}
+	return drop
+}
+
+// searchEngine is a Store kept by a search engine, which sync feeds from the
+// local index.
+type searchEngine interface {
+	Store
+	config() engineConfig
+}
+
+// openSearchEngine opens the meili or es store for sync.
+func openSearchEngine(a *App, name string) (searchEngine, error) {
+	backend := storeBackends[name]
+	st, err := backend.open(a, Profile{})
+	if err != nil {
+		return nil, fmt.Errorf("%s required for sync: %w", backend.label, err)
+	}
+	engine := st.(searchEngine)
+	if err := engine.config().writable(); err != nil {
+		engine.Close()
+		return nil, err
+	}
+	return engine, nil
+}
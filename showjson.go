@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// messageJSON is the `tb mail show --json` representation of one message.
+type messageJSON struct {
+	MessageID string              `json:"message_id"`
+	Folder    string              `json:"folder"`
+	Account   string              `json:"account,omitempty"`
+	Date      string              `json:"date"`
+	Time      *time.Time          `json:"time,omitempty"`
+	From      string              `json:"from"`
+	To        string              `json:"to,omitempty"`
+	Cc        string              `json:"cc,omitempty"`
+	Subject   string              `json:"subject"`
+	Unread    bool                `json:"unread"`
+	Flagged   bool                `json:"flagged"`
+	Tags      []string            `json:"tags,omitempty"`
+	Size      int64               `json:"size"`
+	Headers   map[string][]string `json:"headers"`
+	Body      string              `json:"body"`
+	Parts     []partJSON          `json:"parts"`
+	Links     []string            `json:"links,omitempty"`
+	Security  *securityInfo       `json:"security,omitempty"`
+	Events    []calendarEvent     `json:"events,omitempty"`
+	Contacts  []vcardContact      `json:"contacts,omitempty"`
+	Auth      *authReport         `json:"auth,omitempty"`
+}
+
+// partJSON describes one MIME leaf part.
+type partJSON struct {
+	Index       string `json:"index"`
+	ContentType string `json:"content_type"`
+	Charset     string `json:"charset,omitempty"`
+	Disposition string `json:"disposition,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Size        int64  `json:"size"` // decoded size in bytes
+	Attachment  bool   `json:"attachment"`
+}
+
+// messageParts lists every leaf MIME part of a raw message.
+func messageParts(raw []byte) ([]partJSON, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
+	if err != nil {
+		return nil, err
+	}
+	parts := []partJSON{}
+	walkMIME(msg.Header, body, "", func(p mimePart) {
+		ctype, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil || ctype == "" {
+			ctype = "text/plain"
+		}
+		disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+		parts = append(parts, partJSON{
+			Index:       p.Index,
+			ContentType: ctype,
+			Charset:     params["charset"],
+			Disposition: disposition,
+			Filename:    strings.TrimPrefix(partFilename(p), "."),
+			Size:        int64(len(decodedPart(p))),
+			Attachment:  isAttachment(p),
+		})
+	})
+	return parts, nil
+}
+
+func newMessageJSON(sm shownMessage, sec *securityInfo, auth *authReport, stripTracking bool) messageJSON {
+	m := sm.summary
+	out := messageJSON{
+		MessageID: m.MessageID,
+		Folder:    m.Folder,
+		Account:   m.Account,
+		Date:      m.Date,
+		From:      m.From,
+		To:        m.To,
+		Cc:        m.Cc,
+		Subject:   m.Subject,
+		Unread:    m.Unread(),
+		Flagged:   m.Flagged(),
+		Tags:      strings.Fields(m.Tags),
+		Size:      m.Size,
+		Headers:   map[string][]string{},
+		Body:      strings.ReplaceAll(sm.bodyText, "\r\n", "\n"),
+		Security:  sec,
+		Auth:      auth,
+	}
+	if !m.When.IsZero() {
+		when := m.When
+		out.Time = &when
+	}
+	for _, f := range parseHeaderFields(sm.raw) {
+		key := textproto.CanonicalMIMEHeaderKey(f.Name)
+		out.Headers[key] = append(out.Headers[key], f.Value)
+	}
+	if parts, err := messageParts(sm.raw); err == nil {
+		out.Parts = parts
+	}
+	if events, err := messageEvents(sm.raw); err == nil {
+		out.Events = events
+	}
+	if cards, err := messageVCards(sm.raw); err == nil {
+		out.Contacts = cards
+	}
+	if links, err := listLinks(sm.raw, stripTracking); err == nil {
+		out.Links = links
+	}
+	return out
+}
+
+// printMessageJSON writes one message as a single line of JSON (NDJSON when
+// several messages are shown).
+func printMessageJSON(sm shownMessage, sec *securityInfo, auth *authReport, stripTracking bool) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(newMessageJSON(sm, sec, auth, stripTracking))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// inspectSMIME detects S/MIME (RFC 8551) signed and enveloped messages and
+// verifies/decrypts them with openssl. Signatures are checked against the
+// system trust store; decryption needs the private key in TB_SMIME_KEY (PEM,
+// optionally with TB_SMIME_CERT) because Thunderbird keeps its keys in NSS.
+// It returns nil when the message is not S/MIME.
+func inspectSMIME(raw []byte, bodyText string) (*securityInfo, string) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, bodyText
+	}
+	body, err := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
+	if err != nil {
+		return nil, bodyText
+	}
+	sec := &securityInfo{Kind: "smime"}
+	text, ok := smimeEntity(sec, msg.Header, body, 0)
+	if !ok {
+		return nil, bodyText
+	}
+	if text == "" {
+		text = bodyText
+	}
+	return sec, text
+}
+
+// smimeEntity fills sec for one S/MIME entity and returns its text when the
+// content had to be unwrapped (opaque signed or enveloped data).
+func smimeEntity(sec *securityInfo, h mail.Header, body []byte, depth int) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || depth > 2 {
+		return "", false
+	}
+	protocol := strings.ToLower(params["protocol"])
+	switch {
+	case mediaType == "multipart/signed" && (protocol == "application/pkcs7-signature" || protocol == "application/x-pkcs7-signature"):
+		sec.Signed = true
+		parts := splitMultipartRaw(body, params["boundary"])
+		if len(parts) < 2 {
+			sec.Status, sec.Detail = "unverified", "malformed signed message"
+			return "", true
+		}
+		sigMsg, err := mail.ReadMessage(bytes.NewReader(parts[1]))
+		if err != nil {
+			sec.Status, sec.Detail = "unverified", "unreadable signature part"
+			return "", true
+		}
+		sigBody, _ := io.ReadAll(sigMsg.Body)
+		sig, err := decodeBodyContent(sigMsg.Header.Get("Content-Transfer-Encoding"), sigBody)
+		if err != nil {
+			sig = sigBody
+		}
+		smimeVerify(sec, sig, canonicalCRLF(parts[0]))
+		return "", true
+	case mediaType == "application/pkcs7-mime" || mediaType == "application/x-pkcs7-mime":
+		der, err := decodeBodyContent(h.Get("Content-Transfer-Encoding"), body)
+		if err != nil {
+			der = body
+		}
+		var content []byte
+		if strings.EqualFold(params["smime-type"], "signed-data") {
+			sec.Signed = true
+			content = smimeVerify(sec, der, nil)
+		} else {
+			sec.Encrypted = true
+			content = smimeDecrypt(sec, der)
+		}
+		if content == nil {
+			return "", true
+		}
+		inner, err := mail.ReadMessage(bytes.NewReader(canonicalCRLF(content)))
+		if err != nil {
+			return string(content), true
+		}
+		innerBody, _ := io.ReadAll(io.LimitReader(inner.Body, maxMessageBytes))
+		// Enveloped data usually wraps a signed entity.
+		if text, ok := smimeEntity(sec, inner.Header, innerBody, depth+1); ok && text != "" {
+			return text, true
+		}
+		text, fallback := extractTextAs(inner.Header, innerBody, htmlToMarkdown)
+		if text == "" {
+			text = fallback
+		}
+		return text, true
+	}
+	return "", false
+}
+
+// smimeVerify checks a CMS signature, detached when content is non-nil, and
+// returns the signed content for opaque signatures.
+func smimeVerify(sec *securityInfo, der, content []byte) []byte {
+	dir, err := os.MkdirTemp("", "tb-smime-")
+	if err != nil {
+		sec.Status, sec.Detail = "unverified", err.Error()
+		return nil
+	}
+	defer os.RemoveAll(dir)
+	sigPath := filepath.Join(dir, "sig.p7s")
+	signerPath := filepath.Join(dir, "signer.pem")
+	if err := os.WriteFile(sigPath, der, 0o600); err != nil {
+		sec.Status, sec.Detail = "unverified", err.Error()
+		return nil
+	}
+	args := []string{"cms", "-verify", "-binary", "-inform", "DER", "-in", sigPath, "-signer", signerPath}
+	if content != nil {
+		contentPath := filepath.Join(dir, "content")
+		if err := os.WriteFile(contentPath, content, 0o600); err != nil {
+			sec.Status, sec.Detail = "unverified", err.Error()
+			return nil
+		}
+		args = append(args, "-content", contentPath)
+	}
+	out, errOut, err := runOpenSSL(args...)
+	switch {
+	case err == nil:
+		sec.Status = "good"
+	default:
+		// Distinguish a broken signature from a signer we do not trust.
+		retryOut, _, retryErr := runOpenSSL(append(args, "-noverify")...)
+		if retryErr == nil {
+			sec.Status = "untrusted"
+			sec.Detail = opensslReason(errOut)
+			out = retryOut
+		} else {
+			sec.Status = "bad"
+			if strings.Contains(errOut, "not found") || strings.Contains(errOut, "No such file") {
+				sec.Status = "unverified"
+			}
+			sec.Detail = opensslReason(errOut)
+			out = nil
+		}
+	}
+	if pemBytes, err := os.ReadFile(signerPath); err == nil {
+		sec.Signer, sec.KeyID = certIdentity(pemBytes)
+	}
+	if content != nil {
+		return nil
+	}
+	return out
+}
+
+// smimeDecrypt decrypts CMS enveloped data with the key from TB_SMIME_KEY.
+func smimeDecrypt(sec *securityInfo, der []byte) []byte {
+	key := strings.TrimSpace(os.Getenv("TB_SMIME_KEY"))
+	if key == "" {
+		sec.Detail = "set TB_SMIME_KEY to a PEM private key to decrypt"
+		return nil
+	}
+	f, err := os.CreateTemp("", "tb-smime-*.p7m")
+	if err != nil {
+		sec.Detail = err.Error()
+		return nil
+	}
+	defer os.Remove(f.Name())
+	f.Write(der)
+	f.Close()
+	args := []string{"cms", "-decrypt", "-binary", "-inform", "DER", "-in", f.Name(), "-inkey", key}
+	if cert := strings.TrimSpace(os.Getenv("TB_SMIME_CERT")); cert != "" {
+		args = append(args, "-recip", cert)
+	}
+	out, errOut, err := runOpenSSL(args...)
+	if err != nil {
+		sec.Detail = "decryption failed: " + opensslReason(errOut)
+		return nil
+	}
+	sec.Decrypted = true
+	return out
+}
+
+func runOpenSSL(args ...string) ([]byte, string, error) {
+	bin := strings.TrimSpace(os.Getenv("TB_OPENSSL"))
+	if bin == "" {
+		bin = "openssl"
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return nil, bin + " not found", fmt.Errorf("%s not found", bin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	return stdout.Bytes(), stderr.String(), err
+}
+
+// certIdentity returns "CN <email>" and the serial number of the first PEM certificate.
+func certIdentity(pemBytes []byte) (string, string) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return "", ""
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", ""
+	}
+	name := cert.Subject.CommonName
+	email := ""
+	if len(cert.EmailAddresses) > 0 {
+		email = cert.EmailAddresses[0]
+	} else {
+		// Older certificates carry the address in the subject (PKCS #9 emailAddress).
+		for _, n := range cert.Subject.Names {
+			if n.Type.String() == "1.2.840.113549.1.9.1" {
+				email, _ = n.Value.(string)
+			}
+		}
+	}
+	if email != "" {
+		if name != "" && name != email {
+			name += " <" + email + ">"
+		} else {
+			name = email
+		}
+	}
+	if issuer := cert.Issuer.CommonName; issuer != "" && issuer != cert.Subject.CommonName {
+		name += ", issued by " + issuer
+	}
+	return name, fmt.Sprintf("%X", cert.SerialNumber)
+}
+
+// opensslReason picks the most telling line of openssl's error output.
+func opensslReason(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for _, l := range lines {
+		if i := strings.Index(l, "Verify error:"); i >= 0 {
+			return strings.TrimSpace(l[i+len("Verify error:"):])
+		}
+	}
+	return strings.TrimSpace(lines[0])
+}
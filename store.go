@@ -0,0 +1,691 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store is where search reads messages from. The local index (SQLite or
+// the JSON shards), Postgres, and Meilisearch or Elasticsearch implement
+// it, and search picks one by name with --store (or $TB_STORE), so a new
+// backend only has to register in storeBackends.
+type Store interface {
+	// Upsert adds msgs or replaces the stored copies with the same Message-Id.
+	Upsert(ctx context.Context, msgs []MailSummary) error
+	// Search returns the messages matching q; q.profile names the profile.
+	Search(ctx context.Context, q queryOptions) ([]MailSummary, error)
+	// Prune drops the messages of profile whose Message-Id is not in keepIDs.
+	Prune(ctx context.Context, profile string, keepIDs []string) (int64, error)
+	// Stats summarizes what the store holds for profile.
+	Stats(ctx context.Context, profile string) (StoreStats, error)
+	Close()
+}
+
+// groupStore is a Store that counts matches per --group-by key in its
+// database, so search --group-by does not read every matching message.
+type groupStore interface {
+	// Group counts the messages matching q per q.groupBy.
+	Group(ctx context.Context, q queryOptions) ([]groupCount, error)
+}
+
+// StoreStats is what Store.Stats reports.
+type StoreStats struct {
+	Backend        string
+	Messages       int64
+	Folders        int64
+	Oldest, Newest time.Time
+	SearchBytes    int64 // total size of the stored search text; -1 when unknown
+
+	// Message counts, sorted by key. Undated messages count under year "".
+	ByFolder, ByAccount, ByYear []StoreCount
+
+	// Storage lists the tables, indexes, or files behind the store.
+	Storage []StoreSpace
+}
+
+// StoreCount is one row of a StoreStats breakdown.
+type StoreCount struct {
+	Key      string
+	Messages int64
+}
+
+// StoreSpace is the size of one table, index, or file of a store and how
+// much of it is dead weight a VACUUM or reindex would reclaim; -1 when the
+// backend cannot tell.
+type StoreSpace struct {
+	Name        string
+	Bytes       int64
+	Reclaimable int64
+}
+
+// countBy tallies msgs into a sorted breakdown.
+func countBy(msgs []MailSummary, key func(m MailSummary) string) []StoreCount {
+	n := map[string]int64{}
+	for _, m := range msgs {
+		n[key(m)]++
+	}
+	out := make([]StoreCount, 0, len(n))
+	for k, v := range n {
+		out = append(out, StoreCount{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// statsYear is the ByYear key of m.
+func statsYear(m MailSummary) string {
+	if m.When.IsZero() {
+		return ""
+	}
+	return strconv.Itoa(m.When.Year())
+}
+
+// storeBackend opens one kind of Store.
+type storeBackend struct {
+	label string // for messages, e.g. "postgres"
+	open  func(a *App, profile Profile) (Store, error)
+	// local is set for backends kept per profile next to the index, which
+	// tb mail index refreshes; the others are shared by every profile.
+	local bool
+	// ranked is set for backends that order hits by relevance, which search
+	// keeps unless --sort is given.
+	ranked bool
+}
+
+var storeBackends = map[string]storeBackend{
+	"pg": {label: "postgres", open: func(*App, Profile) (Store, error) {
+		s, err := openPG()
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}},
+	"sqlite": {label: "the SQLite index", open: openSQLiteFTSStore, local: true, ranked: true},
+	"json":   {label: "the JSON index", open: openJSONStore, local: true},
+	"index":  {label: "the local index", open: (*App).openLocalStore, local: true},
+	"bleve":  {label: "the Bleve index", open: openBleveStore, local: true, ranked: true},
+	"meili":  {label: "meilisearch", open: openMeili, ranked: true},
+	"es":     {label: "elasticsearch", open: openES, ranked: true},
+}
+
+// storeNames lists the registered backends for help and error messages.
+func storeNames() string {
+	names := make([]string, 0, len(storeBackends))
+	for name := range storeBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// defaultStore is the backend used when --store is not given.
+func defaultStore() string {
+	return strings.TrimSpace(os.Getenv("TB_STORE"))
+}
+
+// openLocalStore opens the profile's local index: SQLite when it exists,
+// else the JSON shards.
+func (a *App) openLocalStore(profile Profile) (Store, error) {
+	if _, err := os.Stat(sqliteIndexPath(profile)); err == nil {
+		return openSQLiteStore(a, profile)
+	}
+	return openJSONStore(a, profile)
+}
+
+// localBoxes maps folder names to the mailboxes of profile, so local stores
+// can file upserted messages under their mbox path.
+func (a *App) localBoxes(profile Profile) (map[string]Mailbox, error) {
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]Mailbox{}
+	for _, b := range boxes {
+		byName[b.Name] = b
+	}
+	return byName, nil
+}
+
+// sqliteStore is the SQLite local index as a Store.
+type sqliteStore struct {
+	db    string
+	a     *App
+	local Profile
+	boxes map[string]Mailbox // loaded on the first Upsert
+	fts   bool               // rank query matches through messages_fts
+}
+
+func openSQLiteStore(a *App, profile Profile) (Store, error) {
+	db := sqliteIndexPath(profile)
+	if _, err := os.Stat(db); err != nil {
+		return nil, fmt.Errorf("no SQLite index for %s; run tb mail index", profile.Name)
+	}
+	if err := ensureSQLiteSchema(db); err != nil {
+		return nil, err
+	}
+	return &sqliteStore{db: db, a: a, local: profile}, nil
+}
+
+// openSQLiteFTSStore is --store sqlite: the SQLite index searched through
+// its FTS5 table, best match first.
+func openSQLiteFTSStore(a *App, profile Profile) (Store, error) {
+	st, err := openSQLiteStore(a, profile)
+	if err != nil {
+		return nil, err
+	}
+	s := st.(*sqliteStore)
+	if s.fts = sqliteHasFTS(s.db); !s.fts {
+		return nil, fmt.Errorf("%s has no FTS5 table; rebuild it with tb mail index --full", s.db)
+	}
+	return s, nil
+}
+
+// Search matches terms as substrings the way Postgres does. Folders whose
+// trigram filter rules out a term are skipped without reading their messages.
+// Opened as --store sqlite, it instead matches whole words through FTS5 and
+// returns the best matches first, unless q.substring asks for substrings.
+func (s *sqliteStore) Search(_ context.Context, q queryOptions) ([]MailSummary, error) {
+	if match := sqliteMatchQuery(q.query); s.fts && !q.substring && match != "" {
+		msgs, err := sqliteRankedMessages(s.db, match)
+		if err != nil {
+			return nil, err
+		}
+		hits := msgs[:0]
+		for _, m := range msgs {
+			if filterMatches(m, q) {
+				hits = append(hits, m)
+			}
+		}
+		return hits, nil
+	}
+	terms := splitQueryTerms(q.query)
+	blooms, err := sqliteFolderBlooms(s.db)
+	if err != nil {
+		return nil, err
+	}
+	var args []any
+	for path, b := range blooms {
+		if b.mayContain(terms) {
+			args = append(args, path)
+		}
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	where := []string{"folder_path IN (" + sqlPlaceholders(len(args)) + ")"}
+	for _, t := range terms {
+		where = append(where, "instr(search_text, ?) > 0")
+		args = append(args, t)
+	}
+	msgs, err := sqliteIndexedMessages(s.db, strings.Join(where, " AND "), args...)
+	if err != nil {
+		return nil, err
+	}
+	var hits []MailSummary
+	for _, m := range msgs {
+		if filterMatches(m, q) {
+			hits = append(hits, m)
+		}
+	}
+	return hits, nil
+}
+
+// sqliteGroupColumns is what Group groups by per --group-by key. Sender and
+// domain group the raw From header; senderGroups folds it into addresses.
+// The index holds one profile, named by q.profile.
+var sqliteGroupColumns = map[string]string{
+	"sender":  "m.sender",
+	"domain":  "m.sender",
+	"folder":  "m.folder",
+	"profile": "''",
+	"month":   "coalesce(strftime('%Y-%m', m.when_ts, 'unixepoch', 'localtime'), 'unknown')",
+}
+
+// Group counts the messages matching q per q.groupBy in one GROUP BY query,
+// matching terms the way Search does.
+func (s *sqliteStore) Group(_ context.Context, q queryOptions) ([]groupCount, error) {
+	column, ok := sqliteGroupColumns[q.groupBy]
+	if !ok {
+		return nil, fmt.Errorf("bad --group-by %q", q.groupBy)
+	}
+	where, args := sqliteWhere(q)
+	if match := sqliteMatchQuery(q.query); s.fts && !q.substring && match != "" {
+		where = append(where, "m.rowid IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)")
+		args = append(args, match)
+	} else {
+		for _, t := range splitQueryTerms(q.query) {
+			where = append(where, "instr(m.search_text, ?) > 0")
+			args = append(args, t)
+		}
+	}
+	clause := "1=1"
+	if len(where) > 0 {
+		clause = strings.Join(where, " AND ")
+	}
+	var groups []groupCount
+	err := sqliteEach(s.db, func(rows *sql.Rows) error {
+		var g groupCount
+		var latest sql.NullInt64
+		if err := rows.Scan(&g.Key, &g.Count, &latest); err != nil {
+			return err
+		}
+		if latest.Valid {
+			g.Latest = time.Unix(latest.Int64, 0)
+		}
+		if q.groupBy == "profile" {
+			g.Key = q.profile
+		}
+		groups = append(groups, g)
+		return nil
+	}, fmt.Sprintf("SELECT coalesce(%s, ''), count(*), max(m.when_ts) FROM messages m WHERE %s GROUP BY 1", column, clause), args...)
+	if err != nil {
+		return nil, err
+	}
+	return senderGroups(groups, q.groupBy), nil
+}
+
+// sqliteWhere renders the filters filterMatches applies as SQL conditions on
+// messages m, with their arguments, the way pgWhere does for Postgres.
+func sqliteWhere(q queryOptions) ([]string, []any) {
+	var where []string
+	var args []any
+	fieldTerms := func(column, text string) {
+		for _, t := range splitQueryTerms(text) {
+			where = append(where, fmt.Sprintf("instr(tb_lower(%s), ?) > 0", column))
+			args = append(args, t)
+		}
+	}
+	fieldTerms("m.sender", q.from)
+	fieldTerms("coalesce(m.to_addrs, '') || ' ' || coalesce(m.cc_addrs, '')", q.to)
+	fieldTerms("m.subject", q.subject)
+	fieldTerms("m.body_text", q.body)
+	for _, e := range q.excludes {
+		for _, t := range splitQueryTerms(e) {
+			where = append(where, "instr(coalesce(m.search_text, ''), ?) = 0")
+			args = append(args, t)
+		}
+	}
+	if q.larger > 0 {
+		where = append(where, "m.size_bytes > ?")
+		args = append(args, q.larger)
+	}
+	if q.smaller > 0 {
+		where = append(where, "m.size_bytes < ?")
+		args = append(args, q.smaller)
+	}
+	if q.hasAttachment {
+		where = append(where, "m.attachments > 0")
+	}
+	if q.unread {
+		where = append(where, fmt.Sprintf("m.moz_status & %d = 0", mozFlagRead))
+	}
+	if q.read {
+		where = append(where, fmt.Sprintf("m.moz_status & %d <> 0", mozFlagRead))
+	}
+	if q.flagged {
+		where = append(where, fmt.Sprintf("m.moz_status & %d <> 0", mozFlagMarked))
+	}
+	if q.tag != "" {
+		where = append(where, "instr(' ' || coalesce(m.tags, '') || ' ', ?) > 0")
+		args = append(args, " "+q.tag+" ")
+	}
+	if len(q.accounts) > 0 {
+		where = append(where, "tb_lower(m.account) IN ("+sqlPlaceholders(len(q.accounts))+")")
+		for _, acct := range q.accounts {
+			args = append(args, acct)
+		}
+	}
+	if q.folderLike != "" {
+		where = append(where, "instr(tb_lower(m.folder), ?) > 0")
+		args = append(args, strings.ToLower(q.folderLike))
+	}
+	for _, t := range folderTagWords {
+		if t.tag == "trash" && q.includeTrash || t.tag == "spam" && q.includeSpam || folderTag(q.folderLike) == t.tag {
+			continue
+		}
+		for _, w := range t.words {
+			where = append(where, "instr(tb_lower(m.folder), ?) = 0")
+			args = append(args, w)
+		}
+	}
+	if !q.since.IsZero() {
+		where = append(where, "m.when_ts >= ?")
+		args = append(args, q.since.Unix())
+	}
+	if !q.till.IsZero() {
+		where = append(where, "m.when_ts < ?")
+		args = append(args, q.till.Unix())
+	}
+	return where, args
+}
+
+// Upsert files msgs under the folders they name, which must already be in
+// the index, and refreshes those folders' trigram filters.
+func (s *sqliteStore) Upsert(_ context.Context, msgs []MailSummary) error {
+	if s.boxes == nil {
+		boxes, err := s.a.localBoxes(s.local)
+		if err != nil {
+			return err
+		}
+		s.boxes = boxes
+	}
+	states, err := sqliteFolderStates(s.db)
+	if err != nil {
+		return err
+	}
+	touched := map[string]Mailbox{}
+	for _, m := range msgs {
+		box, ok := s.boxes[m.Folder]
+		if _, indexed := states[box.Path]; !ok || !indexed {
+			return fmt.Errorf("folder %s is not in the local index; run tb mail index", m.Folder)
+		}
+		touched[box.Path] = box
+	}
+	err = sqliteTx(s.db, func(tx *sql.Tx) error {
+		for _, m := range msgs {
+			box := s.boxes[m.Folder]
+			if _, err := tx.Exec("DELETE FROM messages WHERE folder_path = ? AND message_id = ?", box.Path, m.MessageID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(sqliteInsertMessage, sqliteInsertArgs(box.Path, m)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, box := range touched {
+		if err := sqliteStoreBloom(s.db, box); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune ignores profile: the local index only ever holds its own. As in
+// Postgres, an empty keepIDs deletes nothing.
+func (s *sqliteStore) Prune(_ context.Context, _ string, keepIDs []string) (int64, error) {
+	if len(keepIDs) == 0 {
+		return 0, nil
+	}
+	var n int64
+	err := sqliteTx(s.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("CREATE TEMP TABLE keep (id TEXT PRIMARY KEY)"); err != nil {
+			return err
+		}
+		for start := 0; start < len(keepIDs); start += 500 {
+			batch := keepIDs[start:min(start+500, len(keepIDs))]
+			args := make([]any, len(batch))
+			for i, id := range batch {
+				args[i] = id
+			}
+			if _, err := tx.Exec("INSERT OR IGNORE INTO keep (id) VALUES "+strings.TrimSuffix(strings.Repeat("(?), ", len(args)), ", "), args...); err != nil {
+				return err
+			}
+		}
+		res, err := tx.Exec("DELETE FROM messages WHERE message_id NOT IN (SELECT id FROM keep)")
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		_, err = tx.Exec("DROP TABLE temp.keep")
+		return err
+	})
+	return n, err
+}
+
+// Stats ignores profile, like Prune. Reclaimable space is the free pages
+// a VACUUM would drop.
+func (s *sqliteStore) Stats(context.Context, string) (StoreStats, error) {
+	var oldest, newest sql.NullInt64
+	var searchBytes, size, free int64
+	st := StoreStats{Backend: "sqlite"}
+	err := sqliteEach(s.db, func(rows *sql.Rows) error {
+		return rows.Scan(&st.Messages, &st.Folders, &oldest, &newest, &searchBytes, &size, &free)
+	}, `SELECT count(*), count(DISTINCT folder_path), min(when_ts), max(when_ts),
+	coalesce(sum(length(CAST(search_text AS BLOB))), 0),
+	(SELECT page_count FROM pragma_page_count()) * (SELECT page_size FROM pragma_page_size()),
+	(SELECT freelist_count FROM pragma_freelist_count()) * (SELECT page_size FROM pragma_page_size())
+FROM messages`)
+	if err != nil {
+		return StoreStats{}, fmt.Errorf("stats: %v", err)
+	}
+	st.SearchBytes = searchBytes
+	st.Storage = []StoreSpace{{Name: filepath.Base(s.db), Bytes: size, Reclaimable: free}}
+	if oldest.Valid {
+		st.Oldest, st.Newest = time.Unix(oldest.Int64, 0), time.Unix(newest.Int64, 0)
+	}
+	err = sqliteEach(s.db, func(rows *sql.Rows) error {
+		var kind string
+		var row StoreCount
+		if err := rows.Scan(&kind, &row.Key, &row.Messages); err != nil {
+			return err
+		}
+		switch kind {
+		case "folder":
+			st.ByFolder = append(st.ByFolder, row)
+		case "account":
+			st.ByAccount = append(st.ByAccount, row)
+		case "year":
+			st.ByYear = append(st.ByYear, row)
+		}
+		return nil
+	}, `SELECT 'folder' AS kind, folder AS k, count(*) AS n FROM messages GROUP BY 2
+UNION ALL SELECT 'account', coalesce(account, ''), count(*) FROM messages GROUP BY 2
+UNION ALL SELECT 'year', coalesce(strftime('%Y', when_ts, 'unixepoch'), ''), count(*) FROM messages GROUP BY 2
+ORDER BY 1, 2`)
+	if err != nil {
+		return StoreStats{}, fmt.Errorf("stats: %v", err)
+	}
+	return st, nil
+}
+
+func (s *sqliteStore) Close() {}
+
+// jsonStore is the JSON (or gob) shard index as a Store.
+type jsonStore struct {
+	a     *App
+	local Profile
+	idx   *IndexFile
+	boxes map[string]Mailbox // loaded on the first Upsert
+}
+
+func openJSONStore(a *App, profile Profile) (Store, error) {
+	if !hasFallbackIndex(profile) {
+		return nil, fmt.Errorf("no local index for %s; run tb mail index", profile.Name)
+	}
+	idx, err := loadIndex(profile)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonStore{a: a, local: profile, idx: idx}, nil
+}
+
+func (s *jsonStore) Search(_ context.Context, q queryOptions) ([]MailSummary, error) {
+	terms := splitQueryTerms(q.query)
+	var hits []MailSummary
+	for _, fi := range s.idx.Folders {
+		if !fi.Bloom.mayContain(terms) {
+			continue
+		}
+	messages:
+		for _, m := range fi.Messages {
+			for _, t := range terms {
+				if !strings.Contains(m.Search, t) {
+					continue messages
+				}
+			}
+			if filterMatches(m, q) {
+				hits = append(hits, m)
+			}
+		}
+	}
+	return hits, nil
+}
+
+// Upsert files msgs under the folders they name, which must already be in
+// the index, and rewrites those folders' shards.
+func (s *jsonStore) Upsert(_ context.Context, msgs []MailSummary) error {
+	if s.boxes == nil {
+		boxes, err := s.a.localBoxes(s.local)
+		if err != nil {
+			return err
+		}
+		s.boxes = boxes
+	}
+	touched := map[string]bool{}
+	for _, m := range msgs {
+		box, ok := s.boxes[m.Folder]
+		fi, indexed := s.idx.Folders[box.Path]
+		if !ok || !indexed {
+			return fmt.Errorf("folder %s is not in the local index; run tb mail index", m.Folder)
+		}
+		replaced := false
+		for i := range fi.Messages {
+			if fi.Messages[i].MessageID == m.MessageID {
+				fi.Messages[i], replaced = m, true
+				break
+			}
+		}
+		if !replaced {
+			fi.Messages = append(fi.Messages, m)
+		}
+		s.idx.Folders[box.Path] = fi
+		touched[box.Path] = true
+	}
+	for path := range touched {
+		fi := s.idx.Folders[path]
+		fi.Bloom = bloomForMessages(fi.Messages)
+		s.idx.Folders[path] = fi
+		if err := saveIndexShard(s.local, s.idx, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune ignores profile: the local index only ever holds its own. As in
+// Postgres, an empty keepIDs deletes nothing.
+func (s *jsonStore) Prune(_ context.Context, _ string, keepIDs []string) (int64, error) {
+	if len(keepIDs) == 0 {
+		return 0, nil
+	}
+	keep := make(map[string]bool, len(keepIDs))
+	for _, id := range keepIDs {
+		keep[id] = true
+	}
+	var removed int64
+	for path, fi := range s.idx.Folders {
+		kept := fi.Messages[:0]
+		for _, m := range fi.Messages {
+			if keep[m.MessageID] {
+				kept = append(kept, m)
+			}
+		}
+		if n := len(fi.Messages) - len(kept); n > 0 {
+			removed += int64(n)
+			fi.Messages = kept
+			s.idx.Folders[path] = fi
+			if err := saveIndexShard(s.local, s.idx, path); err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// Stats ignores profile, like Prune. The shards of folders whose mbox is
+// gone count as reclaimable; the next tb mail index run removes them.
+func (s *jsonStore) Stats(context.Context, string) (StoreStats, error) {
+	st := StoreStats{Backend: "json", Folders: int64(len(s.idx.Folders))}
+	var all []MailSummary
+	shards := StoreSpace{Name: "shards/"}
+	for path, fi := range s.idx.Folders {
+		st.Messages += int64(len(fi.Messages))
+		all = append(all, fi.Messages...)
+		for _, m := range fi.Messages {
+			st.SearchBytes += int64(len(m.Search))
+			if m.When.IsZero() {
+				continue
+			}
+			if st.Oldest.IsZero() || m.When.Before(st.Oldest) {
+				st.Oldest = m.When
+			}
+			if m.When.After(st.Newest) {
+				st.Newest = m.When
+			}
+		}
+		if info, err := os.Stat(indexShardPath(s.local, path)); err == nil {
+			shards.Bytes += info.Size()
+			if _, err := os.Stat(path); err != nil {
+				shards.Reclaimable += info.Size()
+			}
+		}
+	}
+	st.ByFolder = countBy(all, func(m MailSummary) string { return m.Folder })
+	st.ByAccount = countBy(all, func(m MailSummary) string { return m.Account })
+	st.ByYear = countBy(all, statsYear)
+	if s.idx.legacy {
+		if info, err := os.Stat(indexPath(s.local)); err == nil {
+			st.Storage = append(st.Storage, StoreSpace{Name: filepath.Base(indexPath(s.local)), Bytes: info.Size(), Reclaimable: -1})
+		}
+	}
+	if shards.Bytes > 0 {
+		st.Storage = append(st.Storage, shards)
+	}
+	return st, nil
+}
+
+func (s *jsonStore) Close() {}
+
+// filterMatches applies the non-text filters of q the way pgStore.Search does.
+func filterMatches(m MailSummary, q queryOptions) bool {
+	containsAll := func(field, text string) bool {
+		field = strings.ToLower(field)
+		for _, t := range splitQueryTerms(text) {
+			if !strings.Contains(field, t) {
+				return false
+			}
+		}
+		return true
+	}
+	if !containsAll(m.From, q.from) || !containsAll(m.To+" "+m.Cc, q.to) ||
+		!containsAll(m.Subject, q.subject) || !containsAll(m.Body, q.body) {
+		return false
+	}
+	for _, e := range q.excludes {
+		for _, t := range splitQueryTerms(e) {
+			if strings.Contains(m.Search, t) {
+				return false
+			}
+		}
+	}
+	switch {
+	case q.larger > 0 && m.Size <= q.larger,
+		q.smaller > 0 && m.Size >= q.smaller,
+		q.hasAttachment && m.Attachments == 0,
+		q.unread && !m.Unread(),
+		q.read && !m.Read(),
+		q.flagged && !m.Flagged(),
+		q.tag != "" && !strings.Contains(" "+m.Tags+" ", " "+q.tag+" "),
+		len(q.accounts) > 0 && !slices.Contains(q.accounts, strings.ToLower(m.Account)),
+		q.folderLike != "" && !strings.Contains(strings.ToLower(m.Folder), strings.ToLower(q.folderLike)),
+		skipTaggedFolder(m.Folder, q.folderLike, q.includeTrash, q.includeSpam),
+		!q.since.IsZero() && (m.When.IsZero() || m.When.Before(q.since)),
+		!q.till.IsZero() && (m.When.IsZero() || !m.When.Before(q.till)):
+		return false
+	}
+	return true
+}
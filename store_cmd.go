@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// storedProfile is one row of tb store profiles.
+type storedProfile struct {
+	name         string
+	messages     int64
+	folders      int64
+	newest       time.Time
+	lastSync     time.Time
+	lastFullSync time.Time
+	local        bool // listed in profiles.ini
+}
+
+// Profiles summarizes every profile with rows in tb_messages or a sync time
+// in tb_meta, sorted by name.
+func (s *pgStore) Profiles(ctx context.Context) ([]storedProfile, error) {
+	byName := map[string]*storedProfile{}
+	get := func(name string) *storedProfile {
+		p := byName[name]
+		if p == nil {
+			p = &storedProfile{name: name}
+			byName[name] = p
+		}
+		return p
+	}
+	rows, err := s.pool.Query(ctx, `
+SELECT profile, count(*), count(DISTINCT folder), max(when_ts)
+FROM tb_messages GROUP BY profile`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			name   string
+			newest *time.Time
+			p      storedProfile
+		)
+		if err := rows.Scan(&name, &p.messages, &p.folders, &newest); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if newest != nil {
+			p.newest = *newest
+		}
+		p.name = name
+		*get(name) = p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, m := range []struct {
+		prefix string
+		set    func(p *storedProfile, t time.Time)
+	}{
+		{"last_scan.", func(p *storedProfile, t time.Time) { p.lastSync = t }},
+		{"last_full_scan.", func(p *storedProfile, t time.Time) { p.lastFullSync = t }},
+	} {
+		vals, err := s.GetMetaPrefix(ctx, m.prefix)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vals {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				continue
+			}
+			m.set(get(strings.TrimPrefix(k, m.prefix)), t)
+		}
+	}
+	out := make([]storedProfile, 0, len(byName))
+	for _, p := range byName {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+// listStoredProfiles prints what the store holds per profile. Profiles in
+// profiles.ini that were never synced are listed too, so a missing
+// sync --all-profiles run shows up.
+func (a *App) listStoredProfiles() error {
+	store, err := openPG()
+	if err != nil {
+		return fmt.Errorf("postgres required for store profiles: %w", err)
+	}
+	defer store.Close()
+	stored, err := store.Profiles(context.Background())
+	if err != nil {
+		return err
+	}
+	seen := map[string]int{}
+	for i, p := range stored {
+		seen[p.name] = i
+	}
+	// A missing profiles.ini only loses the Local column.
+	if local, err := a.loadProfiles(); err == nil {
+		for _, lp := range local {
+			if i, ok := seen[lp.Name]; ok {
+				stored[i].local = true
+				continue
+			}
+			stored = append(stored, storedProfile{name: lp.Name, local: true})
+		}
+		sort.Slice(stored, func(i, j int) bool { return stored[i].name < stored[j].name })
+	}
+	if len(stored) == 0 {
+		fmt.Println("No profiles in the store.")
+		return nil
+	}
+	stamp := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.In(time.Local).Format("2006-01-02 15:04")
+	}
+	rows := make([][]string, 0, len(stored))
+	for _, p := range stored {
+		local := "no"
+		if p.local {
+			local = "yes"
+		}
+		newest := "-"
+		if !p.newest.IsZero() {
+			newest = p.newest.In(time.Local).Format("2006-01-02")
+		}
+		rows = append(rows, []string{
+			p.name, local, strconv.FormatInt(p.messages, 10), strconv.FormatInt(p.folders, 10),
+			newest, stamp(p.lastSync), stamp(p.lastFullSync),
+		})
+	}
+	renderTable(os.Stdout, []string{"Profile", "Local", "Messages", "Folders", "Newest", "Last sync", "Last full sync"}, rows)
+	return nil
+}
+
+// pingStore connects the way every store command would and reports what it
+// reached, without migrating anything. It fails when the server cannot be
+// reached or the schema is newer than this tb.
+func pingStore() error {
+	store, settings, err := connectPG()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+	start := time.Now()
+	if err := store.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("%s: %w", settings.source, err)
+	}
+	latency := time.Since(start)
+	var version, user, db, addr string
+	var tls bool
+	if err := store.pool.QueryRow(ctx, `
+SELECT current_setting('server_version'), current_user, current_database(),
+       coalesce(host(inet_server_addr()) || ':' || inet_server_port(), 'local socket'),
+       coalesce((SELECT ssl FROM pg_stat_ssl WHERE pid = pg_backend_pid()), false)`).Scan(&version, &user, &db, &addr, &tls); err != nil {
+		return err
+	}
+	current, err := store.schemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("schema version: %w", err)
+	}
+	mode, tlsState := "read-write", "no"
+	if store.readOnly {
+		mode = "read-only"
+	}
+	if tls {
+		tlsState = "yes"
+	}
+	fmt.Printf("Config:   %s\n", settings.source)
+	fmt.Printf("Server:   PostgreSQL %s at %s, database %s as %s\n", version, addr, db, user)
+	fmt.Printf("Latency:  %s\n", latency.Round(10*time.Microsecond))
+	fmt.Printf("TLS:      %s\n", tlsState)
+	fmt.Printf("Access:   %s\n", mode)
+	want := len(pgMigrations)
+	switch {
+	case current == 0:
+		fmt.Printf("Schema:   none yet (created by the first sync)\n")
+	case current < want:
+		fmt.Printf("Schema:   version %d of %d (migrated on the next read-write run)\n", current, want)
+	case current > want:
+		fmt.Printf("Schema:   version %d\n", current)
+		return fmt.Errorf("database schema is at version %d but this tb only knows %d; upgrade tb", current, want)
+	default:
+		fmt.Printf("Schema:   version %d (current)\n", current)
+	}
+	return nil
+}
+
+// storeStats prints what one backend holds for a profile, folder by folder
+// against the folders on disk, so a sync that silently skipped a folder
+// shows up as "not in store".
+func (a *App) storeStats(profileName, storeName string) error {
+	backend, ok := storeBackends[storeName]
+	if !ok {
+		return fmt.Errorf("unknown --store %q (use %s)", storeName, storeNames())
+	}
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	store, err := backend.open(a, profile)
+	if err != nil {
+		return fmt.Errorf("%s required for store stats: %w", backend.label, err)
+	}
+	defer store.Close()
+	st, err := store.Stats(context.Background(), profile.Name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Store:       %s (profile %s)\n", st.Backend, profile.Name)
+	fmt.Printf("Messages:    %d in %d folder(s)\n", st.Messages, st.Folders)
+	if !st.Oldest.IsZero() {
+		fmt.Printf("Dates:       %s to %s\n", st.Oldest.In(time.Local).Format("2006-01-02"), st.Newest.In(time.Local).Format("2006-01-02"))
+	}
+	if st.SearchBytes >= 0 {
+		fmt.Printf("Search text: %s\n", byteSize(st.SearchBytes))
+	}
+
+	stored := map[string]int64{}
+	for _, c := range st.ByFolder {
+		stored[c.Key] = c.Messages
+	}
+	var rows [][]string
+	onDisk := map[string]bool{}
+	if boxes, err := a.listMailboxes(profile); err == nil {
+		for _, b := range boxes {
+			onDisk[b.Name] = true
+			if _, ok := stored[b.Name]; ok || b.Size == 0 || skipTaggedFolder(b.Name, "", false, false) {
+				continue
+			}
+			rows = append(rows, []string{b.Name, "0", "not in store"})
+		}
+	}
+	for _, c := range st.ByFolder {
+		note := ""
+		if len(onDisk) > 0 && !onDisk[c.Key] {
+			note = "not on disk"
+		}
+		rows = append(rows, []string{c.Key, strconv.FormatInt(c.Messages, 10), note})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	fmt.Println()
+	renderTable(os.Stdout, []string{"Folder", "Messages", "Note"}, rows)
+
+	counts := func(header string, cs []StoreCount, blank string) {
+		rows := make([][]string, 0, len(cs))
+		for _, c := range cs {
+			key := c.Key
+			if key == "" {
+				key = blank
+			}
+			rows = append(rows, []string{key, strconv.FormatInt(c.Messages, 10)})
+		}
+		fmt.Println()
+		renderTable(os.Stdout, []string{header, "Messages"}, rows)
+	}
+	counts("Account", st.ByAccount, "(none)")
+	counts("Year", st.ByYear, "undated")
+
+	if len(st.Storage) > 0 {
+		rows = rows[:0]
+		for _, sp := range st.Storage {
+			reclaim := "-"
+			if sp.Reclaimable >= 0 && sp.Bytes > 0 {
+				reclaim = fmt.Sprintf("%s (%d%%)", byteSize(sp.Reclaimable), sp.Reclaimable*100/sp.Bytes)
+			} else if sp.Reclaimable >= 0 {
+				reclaim = byteSize(sp.Reclaimable)
+			}
+			rows = append(rows, []string{sp.Name, byteSize(sp.Bytes), reclaim})
+		}
+		fmt.Println()
+		renderTable(os.Stdout, []string{"Storage", "Size", "Reclaimable"}, rows)
+	}
+	return nil
+}
+
+func storeMain(args []string) {
+	if len(args) == 0 {
+		storeUsage()
+		return
+	}
+	app := newApp()
+	switch args[0] {
+	case "profiles":
+		cmd := flag.NewFlagSet("profiles", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		cmd.Parse(args[1:])
+		if err := app.listStoredProfiles(); err != nil {
+			log.Fatalf("store profiles: %v", err)
+		}
+	case "ping":
+		cmd := flag.NewFlagSet("ping", flag.ExitOnError)
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		cmd.Parse(args[1:])
+		if err := pingStore(); err != nil {
+			log.Fatalf("store ping: %v", err)
+		}
+	case "stats":
+		cmd := flag.NewFlagSet("stats", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		storeName := cmd.String("store", firstNonEmpty(defaultStore(), "pg"), "which store to report on: "+storeNames()+" (default $TB_STORE, else pg)")
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+		cmd.Parse(args[1:])
+		indexDirOverride = *indexDir
+		if err := app.storeStats(*profileName, *storeName); err != nil {
+			log.Fatalf("store stats: %v", err)
+		}
+	case "dupes":
+		cmd := flag.NewFlagSet("dupes", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		storeName := cmd.String("store", "", "local index to read: sqlite, json, or index (default: SQLite when present)")
+		format := cmd.String("format", "text", "output format: text, or json (one object per duplicated message)")
+		limit := cmd.Int("limit", 0, "show at most N duplicated messages, most copies first (0 = all)")
+		indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+		cmd.Parse(args[1:])
+		indexDirOverride = *indexDir
+		switch defaultStore() {
+		case "sqlite", "json", "index":
+			if *storeName == "" {
+				*storeName = defaultStore()
+			}
+		}
+		switch *storeName {
+		case "", "sqlite", "json", "index":
+		case "pg", "meili", "es":
+			log.Fatalf("store dupes: %s keeps one row per Message-Id, so it has no duplicates to find; use --store sqlite or json", storeBackends[*storeName].label)
+		default:
+			log.Fatalf("store dupes: unknown --store %q (use sqlite, json, or index)", *storeName)
+		}
+		if *format != "text" && *format != "json" {
+			log.Fatalf("store dupes: unknown --format %q (use text or json)", *format)
+		}
+		if err := app.reportDupes(*profileName, *storeName, *format, *limit); err != nil {
+			log.Fatalf("store dupes: %v", err)
+		}
+	case "export":
+		cmd := flag.NewFlagSet("export", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		storeName := cmd.String("store", firstNonEmpty(defaultStore(), "pg"), "which store to export: pg, sqlite, or json (default $TB_STORE, else pg)")
+		format := cmd.String("format", "parquet", "output format: parquet")
+		allProfiles := cmd.Bool("all-profiles", false, "export every profile (all of tb_messages with --store pg)")
+		withText := cmd.Bool("text", false, "also export each message's search text (subject, addresses, and body)")
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+		cmd.Parse(args[1:])
+		indexDirOverride = *indexDir
+		if cmd.NArg() != 1 {
+			log.Fatalf("store export: need one output file (- for stdout)")
+		}
+		if *allProfiles && *profileName != "" {
+			log.Fatalf("store export: --all-profiles cannot be combined with --profile")
+		}
+		if err := app.storeExport(*profileName, *storeName, *format, cmd.Arg(0), *allProfiles, *withText); err != nil {
+			log.Fatalf("store export: %v", err)
+		}
+	case "delete":
+		cmd := flag.NewFlagSet("delete", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		storeName := cmd.String("store", "pg", "which store to delete from: pg or sqlite (the local index)")
+		query := cmd.String("query", "", "delete messages matching this text, as tb mail search does")
+		fromQ := cmd.String("from", "", "only messages whose From header contains this")
+		toQ := cmd.String("to", "", "only messages whose To/Cc headers contain this")
+		subjectQ := cmd.String("subject", "", "only messages whose Subject contains this")
+		folderLike := cmd.String("folder", "", "only folders containing this name")
+		accounts := accountFlags(cmd)
+		before := cmd.String("before", "", "only messages dated before YYYY-MM-DD")
+		since := cmd.String("since", "", "only messages dated on/after YYYY-MM-DD")
+		dryRun := cmd.Bool("dry-run", false, "list the matching messages without deleting them")
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+		cmd.Parse(args[1:])
+		indexDirOverride = *indexDir
+		q := queryOptions{
+			query: *query, from: *fromQ, to: *toQ, subject: *subjectQ, folderLike: *folderLike, accounts: accounts(),
+			includeTrash: true, includeSpam: true,
+		}
+		for _, d := range []struct {
+			flag string
+			t    *time.Time
+		}{{*before, &q.till}, {*since, &q.since}} {
+			if d.flag == "" {
+				continue
+			}
+			t, err := time.Parse("2006-01-02", d.flag)
+			if err != nil {
+				log.Fatalf("store delete: bad date %q (use YYYY-MM-DD)", d.flag)
+			}
+			*d.t = t
+		}
+		if q.query == "" && q.from == "" && q.to == "" && q.subject == "" && q.folderLike == "" && len(q.accounts) == 0 && q.till.IsZero() && q.since.IsZero() {
+			log.Fatalf("store delete: give at least one of --query, --from, --to, --subject, --folder, --account, --before, or --since")
+		}
+		if err := app.storeDelete(*profileName, *storeName, q, *dryRun); err != nil {
+			log.Fatalf("store delete: %v", err)
+		}
+	case "retention":
+		cmd := flag.NewFlagSet("retention", flag.ExitOnError)
+		profileName := cmd.String("profile", "", "profile name or path")
+		storeName := cmd.String("store", "pg", "which store to prune: pg or sqlite (the local index)")
+		keep := cmd.String("keep", "", "delete messages older than this (e.g. 7y, 6m, 12w, 30d); default: the config file's [retention] keep")
+		folderPolicy := cmd.String("folder-policy", "", "per-folder windows overriding --keep, first match wins (e.g. \"Trash=30d,Junk=7d\")")
+		dryRun := cmd.Bool("dry-run", false, "count and log what is past the policy without deleting it")
+		candidates := cmd.Bool("candidates", false, "also list messages still in the mbox files that are past the policy")
+		pgDSNFlag(cmd)
+		storeReadOnlyFlag(cmd)
+		indexDir := cmd.String("index-dir", "", "where the local index lives (default $TB_INDEX_DIR, else $XDG_CACHE_HOME/tb/<profile>)")
+		cmd.Parse(args[1:])
+		indexDirOverride = *indexDir
+		policy, err := parseRetentionPolicy(*keep, *folderPolicy)
+		if err != nil {
+			log.Fatalf("store retention: %v", err)
+		}
+		if err := app.storeRetention(*profileName, *storeName, policy, *dryRun, *candidates); err != nil {
+			log.Fatalf("store retention: %v", err)
+		}
+	case "help", "-h", "--help":
+		storeUsage()
+	default:
+		storeUsage()
+	}
+}
+
+func storeUsage() {
+	log.Println("Usage: tb store <command> [options]")
+	log.Println("Commands:")
+	log.Println("  ping       connect to the Postgres store and report the server, TLS, access mode, and schema version")
+	log.Println("  stats [--store pg|sqlite|json|meili|es] [--profile p]  message counts per folder, account, and year, search text size, and reclaimable space")
+	log.Println("  dupes [--store sqlite|json] [--profile p] [--format text|json] [--limit N]  messages the local index holds in more than one folder or account")
+	log.Println("  export [--format parquet] [--store pg|sqlite|json] [--profile p|--all-profiles] [--text] out.parquet  dump the stored messages for DuckDB, pandas, or Spark")
+	log.Println("  delete [--store pg|sqlite] [--profile p] [--query text] [--from s] [--to s] [--subject s] [--folder f] [--account/--ac email]... [--before YYYY-MM-DD] [--since YYYY-MM-DD] [--dry-run]  remove matching messages from the store (never the mbox files)")
+	log.Println("  retention [--keep 7y] [--folder-policy \"Trash=30d,Junk=7d\"] [--store pg|sqlite] [--profile p] [--dry-run] [--candidates]  delete stored messages past their retention window and log the run (sync --store pg applies the config file's [retention] policy)")
+	log.Println("  profiles   list the profiles in the Postgres store (TB_PG_DSN) with message counts and last sync times")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// messageDeleter is a Store that tb store delete can remove chosen messages
+// from. The mbox files are never touched.
+type messageDeleter interface {
+	// Delete drops msgs, as returned by Search, from the store.
+	Delete(ctx context.Context, profile string, msgs []MailSummary) (int64, error)
+}
+
+// Delete drops the rows of profile with the Message-Ids of msgs, with their
+// bodies, attachments, reply links, and addresses, and recomputes
+// tb_contacts in the same transaction. The ids are staged with COPY as in
+// deleteUnkept.
+func (s *pgStore) Delete(ctx context.Context, profile string, msgs []MailSummary) (int64, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE tb_drop (message_id text NOT NULL) ON COMMIT DROP"); err != nil {
+		return 0, err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"tb_drop"}, []string{"message_id"},
+		pgx.CopyFromSlice(len(msgs), func(i int) ([]any, error) { return []any{msgs[i].MessageID}, nil })); err != nil {
+		return 0, fmt.Errorf("stage ids: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `
+DELETE FROM tb_messages m
+WHERE m.profile = $1
+  AND EXISTS (SELECT 1 FROM tb_drop d WHERE d.message_id = m.message_id)
+`, profile)
+	if err != nil {
+		return 0, err
+	}
+	// tb_contacts would keep the deleted correspondents' names and dates
+	// until the next sync; a deletion request has to take them out now.
+	if tag.RowsAffected() > 0 {
+		if _, err := tx.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY tb_contacts"); err != nil {
+			return 0, fmt.Errorf("refresh contacts: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Delete ignores profile, like Prune, and drops each message from the
+// folder it was found in; copies in other folders stay.
+func (s *sqliteStore) Delete(_ context.Context, _ string, msgs []MailSummary) (int64, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	var n int64
+	err := sqliteTx(s.db, func(tx *sql.Tx) error {
+		del, err := tx.Prepare("DELETE FROM messages WHERE folder = ? AND message_id = ?")
+		if err != nil {
+			return err
+		}
+		defer del.Close()
+		for _, m := range msgs {
+			res, err := del.Exec(m.Folder, m.MessageID)
+			if err != nil {
+				return err
+			}
+			k, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			n += k
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// openDeletableStore opens a store tb store delete and retention can delete
+// from: Postgres, or the SQLite index under the index lock. The SQLite index
+// is searched by substring, as Postgres matches, rather than through FTS.
+func (a *App) openDeletableStore(profile Profile, storeName string, readOnly bool) (Store, func(), error) {
+	backend, ok := storeBackends[storeName]
+	if !ok || storeName != "pg" && storeName != "sqlite" {
+		return nil, nil, fmt.Errorf("unknown --store %q (use pg or sqlite)", storeName)
+	}
+	if storeName == "pg" {
+		store, err := openPG()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s required: %w", backend.label, err)
+		}
+		if !readOnly {
+			if err := store.writable(); err != nil {
+				store.Close()
+				return nil, nil, err
+			}
+		}
+		return store, store.Close, nil
+	}
+	unlock, err := lockIndex(profile, !readOnly)
+	if err != nil {
+		return nil, nil, fmt.Errorf("lock index: %w", err)
+	}
+	store, err := openSQLiteStore(a, profile)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return store, func() { store.Close(); unlock() }, nil
+}
+
+// storeDelete removes the messages matching q from a Postgres or SQLite
+// store, listing them instead with dryRun.
+func (a *App) storeDelete(profileName, storeName string, q queryOptions, dryRun bool) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	store, closeStore, err := a.openDeletableStore(profile, storeName, dryRun)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	label := storeBackends[storeName].label
+
+	ctx := context.Background()
+	q.profile = profile.Name
+	q.sortBy = "date"
+	msgs, err := store.Search(ctx, q)
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		fmt.Println("No matching messages.")
+		return nil
+	}
+	if dryRun {
+		rows := make([][]string, 0, len(msgs))
+		for _, m := range msgs {
+			date := "-"
+			if !m.When.IsZero() {
+				date = m.When.In(time.Local).Format("2006-01-02")
+			}
+			rows = append(rows, []string{date, truncate(m.Folder, 30), truncate(m.From, 30), truncate(m.Subject, 50)})
+		}
+		renderTable(os.Stdout, []string{"Date", "Folder", "From", "Subject"}, rows)
+		fmt.Printf("\nWould delete %d message(s) from %s; run again without --dry-run to delete them.\n", len(msgs), label)
+		return nil
+	}
+	n, err := store.(messageDeleter).Delete(ctx, profile.Name, msgs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d message(s) from %s. The mbox files are unchanged; a full rescan of their folders stores them again.\n", n, label)
+	return nil
+}
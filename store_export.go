@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRowGroupSize is how many messages go in one Parquet row group.
+const parquetRowGroupSize = 50000
+
+// exportRow is one row of tb store export, a column per field in order.
+type exportRow struct {
+	Profile     string `parquet:"profile"`
+	Account     string `parquet:"account"`
+	Folder      string `parquet:"folder"`
+	MessageID   string `parquet:"message_id"`
+	Time        int64  `parquet:"time,optional,timestamp(millisecond)"` // Unix ms; 0 (undated) is null
+	Date        string `parquet:"date"`
+	From        string `parquet:"from"`
+	To          string `parquet:"to"`
+	Cc          string `parquet:"cc"`
+	Subject     string `parquet:"subject"`
+	Size        int64  `parquet:"size"`
+	Attachments int32  `parquet:"attachments"`
+	Unread      bool   `parquet:"unread"`
+	Flagged     bool   `parquet:"flagged"`
+	Tags        string `parquet:"tags"`
+	Snippet     string `parquet:"snippet"`
+}
+
+// exportTextRow is an exportRow with search_text, written with --text.
+type exportTextRow struct {
+	exportRow
+	SearchText string `parquet:"search_text"`
+}
+
+// newExportRow converts m, forcing its strings to valid UTF-8 as Parquet
+// requires.
+func newExportRow(m MailSummary) exportRow {
+	row := exportRow{
+		Profile:     forceUTF8(m.Profile),
+		Account:     forceUTF8(m.Account),
+		Folder:      forceUTF8(m.Folder),
+		MessageID:   forceUTF8(m.MessageID),
+		Date:        forceUTF8(m.Date),
+		From:        forceUTF8(m.From),
+		To:          forceUTF8(m.To),
+		Cc:          forceUTF8(m.Cc),
+		Subject:     forceUTF8(m.Subject),
+		Size:        m.Size,
+		Attachments: int32(m.Attachments),
+		Unread:      m.Unread(),
+		Flagged:     m.Flagged(),
+		Tags:        forceUTF8(m.Tags),
+		Snippet:     forceUTF8(m.Snippet),
+	}
+	if !m.When.IsZero() {
+		row.Time = m.When.UnixMilli()
+	}
+	return row
+}
+
+// writeParquet writes rows to w as one gzip-compressed Parquet file.
+func writeParquet[T any](w io.Writer, rows []T) error {
+	pw := parquet.NewGenericWriter[T](w,
+		parquet.Compression(&parquet.Gzip),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize))
+	if _, err := pw.Write(rows); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+// exportMessages reads every message a store holds for profileName, or for
+// every profile with allProfiles: all of tb_messages from Postgres, else
+// each profile's local index.
+func (a *App) exportMessages(profileName, storeName string, allProfiles bool) ([]MailSummary, error) {
+	switch _, ok := storeBackends[storeName]; {
+	case !ok:
+		return nil, fmt.Errorf("unknown --store %q (use pg, sqlite, or json)", storeName)
+	case storeName != "pg" && storeName != "sqlite" && storeName != "json" && storeName != "index":
+		return nil, fmt.Errorf("export reads pg, sqlite, json, or index, not %s", storeName)
+	}
+	if storeName == "pg" {
+		q := queryOptions{includeTrash: true, includeSpam: true, sortBy: "folder"}
+		if !allProfiles {
+			profile, err := a.resolveProfile(profileName)
+			if err != nil {
+				return nil, err
+			}
+			q.profile = profile.Name
+		}
+		store, err := openPG()
+		if err != nil {
+			return nil, fmt.Errorf("postgres required for export: %w", err)
+		}
+		defer store.Close()
+		return store.Search(context.Background(), q)
+	}
+
+	var profiles []Profile
+	if allProfiles {
+		all, err := a.loadProfiles()
+		if err != nil {
+			return nil, fmt.Errorf("load profiles: %w", err)
+		}
+		profiles = all
+	} else {
+		profile, err := a.resolveProfile(profileName)
+		if err != nil {
+			return nil, err
+		}
+		profiles = []Profile{profile}
+	}
+	var msgs []MailSummary
+	for _, p := range profiles {
+		unlock, err := lockIndex(p, false)
+		if err != nil {
+			return nil, fmt.Errorf("lock index: %w", err)
+		}
+		pm, err := a.localMessages(p, storeName)
+		unlock()
+		if err != nil {
+			if allProfiles {
+				log.Printf("warn: skip profile %s: %v", p.Name, err)
+				continue
+			}
+			return nil, err
+		}
+		for i := range pm {
+			pm[i].Profile = p.Name
+		}
+		msgs = append(msgs, pm...)
+	}
+	return msgs, nil
+}
+
+// storeExport writes the messages of a store to out ("-" for stdout) as
+// Parquet, one row per message, for DuckDB, pandas, or Spark.
+func (a *App) storeExport(profileName, storeName, format, out string, allProfiles, withText bool) error {
+	if format != "parquet" {
+		return fmt.Errorf("unknown --format %q (use parquet)", format)
+	}
+	msgs, err := a.exportMessages(profileName, storeName, allProfiles)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if out != "-" {
+		if f, err = os.CreateTemp(filepath.Dir(out), ".tb-export-*"); err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		w = f
+	}
+	rows := make([]exportRow, len(msgs))
+	for i, m := range msgs {
+		rows[i] = newExportRow(m)
+	}
+	if withText {
+		textRows := make([]exportTextRow, len(msgs))
+		for i, m := range msgs {
+			textRows[i] = exportTextRow{rows[i], forceUTF8(m.Search)}
+		}
+		err = writeParquet(w, textRows)
+	} else {
+		err = writeParquet(w, rows)
+	}
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return nil
+	}
+	if err := f.Chmod(0o644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), out); err != nil {
+		return err
+	}
+	log.Printf("info: exported %d message(s) to %s", len(msgs), out)
+	return nil
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncOptions controls tb mail sync.
+type syncOptions struct {
+	store     string // pg, or meili or es (see syncEngine)
+	prune     bool   // also drop stored messages no longer on disk
+	batchSize int    // messages per store transaction
+	bodies    bool   // also store full bodies for show --store pg
+	rawBodies bool   // with bodies, keep the raw message too
+	daemon    bool   // keep syncing every interval until interrupted
+	interval  time.Duration
+	index     indexOptions
+}
+
+// syncStore brings the profile's local index up to date and then upserts
+// the changed folders into the store, ending with a per-folder summary.
+// Attachment metadata, reply links, and correspondents are always recorded,
+// for attachments search, thread --store pg, and contacts.
+func (a *App) syncStore(profileName string, opts syncOptions) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	if _, ok := engineSections[opts.store]; ok {
+		engine, err := openSearchEngine(a, opts.store)
+		if err != nil {
+			return err
+		}
+		defer engine.Close()
+		return a.syncEngine(engine, profile, opts)
+	}
+	if opts.store != "pg" {
+		return fmt.Errorf("unknown --store %q (use pg, meili, or es)", opts.store)
+	}
+	store, err := openPG()
+	if err != nil {
+		return fmt.Errorf("postgres required for sync: %w", err)
+	}
+	defer store.Close()
+	if err := store.writable(); err != nil {
+		return err
+	}
+	store.batchSize = opts.batchSize
+
+	if err := a.syncOne(store, profile, opts); err != nil {
+		return err
+	}
+	if opts.daemon {
+		return a.syncDaemon(store, profile, opts)
+	}
+	return nil
+}
+
+// syncAllProfiles runs syncStore's single pass for every profile in
+// profiles.ini over one connection. Rows stay apart by their profile
+// column; see tb store profiles.
+func (a *App) syncAllProfiles(opts syncOptions) error {
+	_, isEngine := engineSections[opts.store]
+	if opts.store != "pg" && !isEngine {
+		return fmt.Errorf("unknown --store %q (use pg, meili, or es)", opts.store)
+	}
+	profiles, err := a.loadProfiles()
+	if err != nil {
+		return fmt.Errorf("load profiles: %w", err)
+	}
+	var syncProfile func(Profile) error
+	if isEngine {
+		engine, err := openSearchEngine(a, opts.store)
+		if err != nil {
+			return err
+		}
+		defer engine.Close()
+		syncProfile = func(p Profile) error { return a.syncEngine(engine, p, opts) }
+	} else {
+		store, err := openPG()
+		if err != nil {
+			return fmt.Errorf("postgres required for sync: %w", err)
+		}
+		defer store.Close()
+		if err := store.writable(); err != nil {
+			return err
+		}
+		store.batchSize = opts.batchSize
+		syncProfile = func(p Profile) error { return a.syncOne(store, p, opts) }
+	}
+
+	failed := 0
+	for i, p := range profiles {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Profile %s:\n", p.Name)
+		if err := syncProfile(p); err != nil {
+			log.Printf("warn: profile %s: %v", p.Name, err)
+			failed++
+		}
+	}
+	if failed == len(profiles) {
+		return fmt.Errorf("no profile could be synced")
+	}
+	return nil
+}
+
+// syncOne updates profile's local index, upserts its changed folders, and
+// prints the per-folder summary. A [retention] policy in the config file is
+// enforced afterwards, so rows a rescan stored again are dropped again.
+func (a *App) syncOne(store *pgStore, profile Profile, opts syncOptions) error {
+	if err := a.buildIndex(profile.AbsolutePath, opts.index); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	rows, upserted, err := a.syncPass(context.Background(), store, profile, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	renderTable(os.Stdout, []string{"Folder", "Upserted", "Status"}, rows)
+	fmt.Printf("\nUpserted %d message(s) from %s into Postgres\n", upserted, profile.Name)
+
+	policy, err := configRetentionPolicy()
+	if err != nil || policy.empty() {
+		return err
+	}
+	rules, err := applyRetention(context.Background(), store, "pg", profile, policy, false)
+	if err != nil {
+		return fmt.Errorf("retention: %w", err)
+	}
+	var deleted int64
+	for _, r := range rules {
+		deleted += r.deleted
+	}
+	fmt.Printf("Retention: deleted %d message(s) past the [retention] policy (logged to %s)\n", deleted, configFile("retention.log"))
+	return nil
+}
+
+// syncPass upserts the folders changed since the last pass and returns one
+// Folder/Upserted/Status row per folder with the total upserted.
+func (a *App) syncPass(ctx context.Context, store *pgStore, profile Profile, opts syncOptions) ([][]string, int, error) {
+	var (
+		mu       sync.Mutex
+		rows     [][]string
+		upserted int
+	)
+	err := a.ingestProfile(ctx, store, profile, ingestOptions{
+		accounts:    opts.index.accounts,
+		folderLike:  opts.index.folderLike,
+		fullRescan:  opts.index.full,
+		prune:       opts.prune,
+		jobs:        opts.index.jobs,
+		bodies:      opts.bodies,
+		rawBodies:   opts.rawBodies,
+		attachments: true,
+		threads:     true,
+		contacts:    true,
+		watermarks:  true,
+		onFolder: func(b Mailbox, n int, unchanged bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			if unchanged {
+				rows = append(rows, []string{b.Name, "-", "unchanged"})
+				return
+			}
+			upserted += n
+			rows = append(rows, []string{b.Name, strconv.Itoa(n), "synced"})
+		},
+	})
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return rows, upserted, err
+}
+
+// syncDaemon polls the profile's folders every interval and runs a pass once
+// a change has held still for one interval, like index --watch. Passes read
+// only what was appended to grown folders (see watermarkKey), so a quiet
+// mailbox costs a stat per folder per interval. Later passes update the
+// store only and never rescan or prune in full.
+func (a *App) syncDaemon(store *pgStore, profile Profile, opts syncOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if opts.interval < time.Second {
+		opts.interval = time.Second
+	}
+	opts.index.full, opts.prune = false, false
+
+	boxes, err := a.listMailboxes(profile)
+	if err != nil {
+		return err
+	}
+	synced := folderStamps(boxes)
+	last := synced
+	fmt.Printf("Syncing %s every %s (Ctrl-C to stop)\n", profile.Name, opts.interval)
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		// Re-list each tick so new folders are picked up.
+		if boxes, err = a.listMailboxes(profile); err != nil {
+			log.Printf("warn: %v", err)
+			continue
+		}
+		now := folderStamps(boxes)
+		settled := sameStamps(now, last)
+		last = now
+		if !settled || sameStamps(now, synced) {
+			continue
+		}
+		rows, upserted, err := a.syncPass(ctx, store, profile, opts)
+		if err != nil {
+			log.Printf("warn: sync: %v", err)
+			continue
+		}
+		changed := 0
+		for _, r := range rows {
+			if r[2] != "unchanged" {
+				changed++
+			}
+		}
+		fmt.Printf("[%s] upserted %d message(s) from %d changed folder(s)\n", time.Now().Format("15:04:05"), upserted, changed)
+		synced = now
+	}
+}
+
+// syncEngine is sync for Meilisearch and Elasticsearch, which take
+// documents only (no bodies, attachments, or reply links): it updates
+// profile's local index and pushes every folder whose index entry changed
+// since the last push to this engine index, then prunes with --prune.
+func (a *App) syncEngine(engine searchEngine, profile Profile, opts syncOptions) error {
+	if err := a.buildIndex(profile.AbsolutePath, opts.index); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	ctx := context.Background()
+	statePath := filepath.Join(indexDir(profile), "pushed-"+opts.store+".json")
+	state := loadPushState(statePath, engine.config().target())
+
+	type push struct {
+		folder indexedFolder
+		stamp  string // "" when only some accounts were pushed
+		msgs   []MailSummary
+	}
+	var (
+		pushes []push
+		rows   [][]string
+		keep   []string
+	)
+	// Read what to push under the index lock, then release it for the
+	// slow part.
+	collect := func() error {
+		folders, load, err := localIndexFolders(profile)
+		if err != nil {
+			return err
+		}
+		for _, f := range folders {
+			if opts.index.folderLike != "" && !strings.Contains(strings.ToLower(f.Name), strings.ToLower(opts.index.folderLike)) {
+				continue
+			}
+			stamp := fmt.Sprintf("%d %d %d %s", f.ModTime, f.Size, f.Messages, f.Fingerprint)
+			if !opts.index.full && !opts.prune && state.Folders[f.Path] == stamp {
+				rows = append(rows, []string{f.Name, "-", "unchanged"})
+				continue
+			}
+			msgs, err := load(f.Path)
+			if err != nil {
+				return err
+			}
+			if len(opts.index.accounts) > 0 {
+				msgs = slices.DeleteFunc(msgs, func(m MailSummary) bool {
+					return !slices.Contains(opts.index.accounts, strings.ToLower(m.Account))
+				})
+				stamp = ""
+			}
+			pushes = append(pushes, push{f, stamp, msgs})
+		}
+		if opts.prune {
+			all, err := loadIndexedMessages(profile)
+			if err != nil {
+				return err
+			}
+			for _, m := range all {
+				keep = append(keep, m.MessageID)
+			}
+		}
+		return nil
+	}
+	unlock, err := lockIndex(profile, false)
+	if err != nil {
+		return fmt.Errorf("lock index: %w", err)
+	}
+	err = collect()
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	upserted := 0
+	for _, p := range pushes {
+		for i := range p.msgs {
+			p.msgs[i].Profile = profile.Name
+		}
+		if err := engine.Upsert(ctx, p.msgs); err != nil {
+			state.save(statePath)
+			return err
+		}
+		if p.stamp != "" {
+			state.Folders[p.folder.Path] = p.stamp
+		}
+		upserted += len(p.msgs)
+		rows = append(rows, []string{p.folder.Name, strconv.Itoa(len(p.msgs)), "synced"})
+	}
+	if err := state.save(statePath); err != nil {
+		log.Printf("warn: remember pushed folders: %v", err)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	fmt.Println()
+	renderTable(os.Stdout, []string{"Folder", "Upserted", "Status"}, rows)
+	fmt.Printf("\nUpserted %d message(s) from %s into %s\n", upserted, profile.Name, storeBackends[opts.store].label)
+	if opts.prune {
+		n, err := engine.Prune(ctx, profile.Name, keep)
+		if err != nil {
+			return fmt.Errorf("prune: %w", err)
+		}
+		fmt.Printf("Pruned %d message(s) no longer on disk\n", n)
+	}
+	return nil
+}
+
+// localIndexFolders lists the folders of profile's local index, with a
+// function reading one folder's messages, from SQLite when it exists, else
+// from the JSON shards.
+func localIndexFolders(profile Profile) ([]indexedFolder, func(path string) ([]MailSummary, error), error) {
+	db := sqliteIndexPath(profile)
+	if _, err := os.Stat(db); err == nil {
+		folders, err := sqliteIndexedFolders(db)
+		return folders, func(path string) ([]MailSummary, error) {
+			return sqliteIndexedMessages(db, "folder_path = ?", path)
+		}, err
+	}
+	idx, err := loadIndex(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return jsonIndexedFolders(idx), func(path string) ([]MailSummary, error) {
+		return idx.Folders[path].Messages, nil
+	}, nil
+}
+
+// pushState remembers which local index entries a search engine index has
+// been sent, by folder path, so a sync only pushes the folders that changed.
+type pushState struct {
+	Target  string            `json:"target"` // engineConfig.target; another index starts over
+	Folders map[string]string `json:"folders"`
+}
+
+func loadPushState(path, target string) *pushState {
+	st := &pushState{}
+	if b, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(b, st)
+	}
+	if st.Target != target || st.Folders == nil {
+		st = &pushState{Target: target, Folders: map[string]string{}}
+	}
+	return st
+}
+
+func (st *pushState) save(path string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// setTerminalEcho is unsupported without stty; a password prompt shows what
+// is typed there.
+func setTerminalEcho(on bool) error {
+	return errors.New("cannot turn off terminal echo here")
+}
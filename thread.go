@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+// parseMessageIDList extracts the <...> Message-Ids from a References/In-Reply-To header.
+func parseMessageIDList(h string) []string {
+	return messageIDPattern.FindAllString(h, -1)
+}
+
+func firstMessageID(h string) string {
+	if ids := parseMessageIDList(h); len(ids) > 0 {
+		return ids[0]
+	}
+	return strings.TrimSpace(h)
+}
+
+// threadKey identifies a message in the reply graph; messages without a
+// Message-Id fall back to a key that cannot collide with real ids.
+func threadKey(m MailSummary) string {
+	if id := normalizeMessageID(m.MessageID); id != "" {
+		return id
+	}
+	return "nomsgid:" + m.Folder + "|" + m.Date + "|" + m.Subject
+}
+
+// threadContainer is a node of the JWZ threading algorithm
+// (https://www.jwz.org/doc/threading.html). Containers exist for every referenced
+// id, even when the message itself is missing.
+type threadContainer struct {
+	id       string
+	msg      *MailSummary
+	parent   *threadContainer
+	children []*threadContainer
+}
+
+func (c *threadContainer) root() *threadContainer {
+	for c.parent != nil {
+		c = c.parent
+	}
+	return c
+}
+
+func (c *threadContainer) isAncestorOf(other *threadContainer) bool {
+	for p := other; p != nil; p = p.parent {
+		if p == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *threadContainer) setParent(parent *threadContainer) {
+	if c.parent == parent || c == parent || c.isAncestorOf(parent) {
+		return
+	}
+	if c.parent != nil {
+		siblings := c.parent.children
+		for i, s := range siblings {
+			if s == c {
+				c.parent.children = append(siblings[:i], siblings[i+1:]...)
+				break
+			}
+		}
+	}
+	c.parent = parent
+	parent.children = append(parent.children, c)
+}
+
+// messageParents returns the reference chain of m, oldest first, ending with its direct parent.
+func messageParents(m MailSummary) []string {
+	refs := strings.Fields(m.References)
+	if m.InReplyTo != "" {
+		if len(refs) == 0 || normalizeMessageID(refs[len(refs)-1]) != normalizeMessageID(m.InReplyTo) {
+			refs = append(refs, m.InReplyTo)
+		}
+	}
+	out := make([]string, 0, len(refs))
+	for _, r := range refs {
+		if id := normalizeMessageID(r); id != "" {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// buildThreadContainers links msgs into reply trees using References and
+// In-Reply-To, then merges root sets that share a normalized subject.
+func buildThreadContainers(msgs []MailSummary) map[string]*threadContainer {
+	table := map[string]*threadContainer{}
+	get := func(id string) *threadContainer {
+		c, ok := table[id]
+		if !ok {
+			c = &threadContainer{id: id}
+			table[id] = c
+		}
+		return c
+	}
+	for i := range msgs {
+		m := &msgs[i]
+		c := get(threadKey(*m))
+		if c.msg == nil {
+			c.msg = m
+		}
+		var prev *threadContainer
+		for _, ref := range messageParents(*m) {
+			rc := get(ref)
+			if prev != nil && rc.parent == nil {
+				rc.setParent(prev)
+			}
+			prev = rc
+		}
+		if prev != nil {
+			c.setParent(prev)
+		}
+	}
+	// Group roots by subject (JWZ step 5) so replies whose clients dropped the
+	// headers still join their conversation.
+	bySubject := map[string]*threadContainer{}
+	for _, c := range table {
+		if c.parent != nil || c.msg == nil {
+			continue
+		}
+		subj := normalizeSubject(c.msg.Subject)
+		if subj == "" {
+			continue
+		}
+		existing, ok := bySubject[subj]
+		if !ok || (isReplySubject(existing.msg.Subject) && !isReplySubject(c.msg.Subject)) {
+			bySubject[subj] = c
+		}
+	}
+	for _, c := range table {
+		if c.parent != nil || c.msg == nil || !isReplySubject(c.msg.Subject) {
+			continue
+		}
+		if target := bySubject[normalizeSubject(c.msg.Subject)]; target != nil && target != c {
+			c.setParent(target)
+		}
+	}
+	return table
+}
+
+func isReplySubject(sub string) bool {
+	return normalizeSubject(sub) != strings.ToLower(strings.TrimSpace(sub))
+}
+
+// threadMembers returns the keys of every message in the same conversation as seed.
+func threadMembers(msgs []MailSummary, seed string) map[string]bool {
+	table := buildThreadContainers(msgs)
+	members := map[string]bool{seed: true}
+	start, ok := table[seed]
+	if !ok {
+		return members
+	}
+	var walk func(c *threadContainer)
+	walk = func(c *threadContainer) {
+		if c.msg != nil {
+			members[threadKey(*c.msg)] = true
+		}
+		for _, child := range c.children {
+			walk(child)
+		}
+	}
+	walk(start.root())
+	return members
+}
+
+// threadTree renders the conversation around the newest message matching the
+// query (or --message-id) as an indented reply tree.
+func (a *App) threadTree(profileName string, opts showOptions) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	targets, err := a.showTargets(profile, opts)
+	if err != nil {
+		return err
+	}
+	match := makeMatcher(opts.query, opts.fuzzy)
+	var graph []MailSummary
+	var seed *MailSummary
+	err = scanShownMessages(targets, func(sm shownMessage) (bool, error) {
+		m := sm.summary
+		m.Body = ""
+		graph = append(graph, m)
+		var hit bool
+		if opts.messageID != "" {
+			hit = normalizeMessageID(m.MessageID) == normalizeMessageID(opts.messageID)
+		} else {
+			hit = match(strings.ToLower(strings.Join([]string{m.Subject, m.From, sm.bodyText}, " ")))
+		}
+		if hit && (seed == nil || newerFirst(m, *seed)) {
+			seed = &m
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if seed == nil {
+		fmt.Println("No matches.")
+		return nil
+	}
+	return printThread(graph, *seed)
+}
+
+// printThread links graph into reply trees and prints the one holding seed.
+func printThread(graph []MailSummary, seed MailSummary) error {
+	table := buildThreadContainers(graph)
+	start, ok := table[threadKey(seed)]
+	if !ok {
+		return fmt.Errorf("message %s not in thread table", threadKey(seed))
+	}
+	root := start.root()
+	if root.msg != nil {
+		fmt.Printf("Thread: %s\n", root.msg.Subject)
+	} else {
+		fmt.Printf("Thread: %s\n", seed.Subject)
+	}
+	printThreadNode(root, "", true, true, threadKey(seed))
+	return nil
+}
+
+// printThreadNode prints c and its replies (oldest first) with box-drawing guides.
+// The seed message is marked with an arrow.
+func printThreadNode(c *threadContainer, prefix string, last, top bool, seed string) {
+	branch, childPrefix := "├─ ", prefix+"│  "
+	if last {
+		branch, childPrefix = "└─ ", prefix+"   "
+	}
+	if top {
+		branch, childPrefix = "", ""
+	}
+	line := "(message not in scanned folders)"
+	if c.msg != nil {
+		m := c.msg
+		name := m.From
+		if addr, err := mail.ParseAddress(m.From); err == nil && addr.Name != "" {
+			name = addr.Name
+		}
+		line = fmt.Sprintf("%s  %s  %s", m.Date, truncate(name, 30), truncate(m.Snippet, 80))
+		if threadKey(*m) == seed {
+			line = "→ " + line
+		}
+	}
+	fmt.Println(prefix + branch + line)
+	children := append([]*threadContainer(nil), c.children...)
+	sort.SliceStable(children, func(i, j int) bool {
+		a, b := children[i].msg, children[j].msg
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return newerFirst(*b, *a)
+	})
+	for i, child := range children {
+		printThreadNode(child, childPrefix, i == len(children)-1, false, seed)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UpsertThreads records the reply links of msgs in tb_threads. Messages
+// tb_messages does not hold are skipped.
+func (s *pgStore) UpsertThreads(ctx context.Context, msgs []MailSummary) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	var rows [][]any
+	for _, m := range msgs {
+		key := normalizeMessageID(m.MessageID)
+		if key == "" {
+			continue
+		}
+		parents := messageParents(m)
+		for i := range parents {
+			parents[i] = forceUTF8(parents[i])
+		}
+		rows = append(rows, []any{m.Profile, forceUTF8(m.MessageID), forceUTF8(key), parents, len(rows)})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE tb_thread_stage (profile text, message_id text, msg_key text, parents text[], ord integer NOT NULL) ON COMMIT DROP"); err != nil {
+		return err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"tb_thread_stage"}, []string{"profile", "message_id", "msg_key", "parents", "ord"}, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("threads: copy %d row(s): %w", len(rows), err)
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO tb_threads (profile, message_id, msg_key, parents)
+SELECT DISTINCT ON (s.profile, s.message_id) s.profile, s.message_id, s.msg_key, s.parents
+FROM tb_thread_stage s
+JOIN tb_messages m USING (profile, message_id)
+ORDER BY s.profile, s.message_id, s.ord DESC
+ON CONFLICT (profile, message_id) DO UPDATE
+  SET msg_key = EXCLUDED.msg_key,
+      parents = EXCLUDED.parents
+`); err != nil {
+		return fmt.Errorf("threads: merge %d row(s): %w", len(rows), err)
+	}
+	return tx.Commit(ctx)
+}
+
+// Conversation returns every stored message linked to seed through
+// References/In-Reply-To in either direction, whatever folder or account it
+// is filed in. Each round adds the parents of the messages found so far and
+// the messages replying to them, until nothing new turns up.
+func (s *pgStore) Conversation(ctx context.Context, profile, seed string) ([]MailSummary, error) {
+	seen := map[string]bool{normalizeMessageID(seed): true}
+	frontier := []string{normalizeMessageID(seed)}
+	for len(frontier) > 0 {
+		rows, err := s.pool.Query(ctx, `
+SELECT msg_key, parents FROM tb_threads
+WHERE profile = $1 AND (msg_key = ANY($2) OR parents && $2)
+`, profile, frontier)
+		if err != nil {
+			return nil, err
+		}
+		var next []string
+		add := func(id string) {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				next = append(next, id)
+			}
+		}
+		for rows.Next() {
+			var key string
+			var parents []string
+			if err := rows.Scan(&key, &parents); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			add(key)
+			for _, p := range parents {
+				add(p)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		frontier = next
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	rows, err := s.pool.Query(ctx, `
+SELECT `+pgSummaryColumns+`, t.parents
+FROM tb_messages JOIN tb_threads t USING (profile, message_id)
+WHERE profile = $1 AND t.msg_key = ANY($2)`, profile, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []MailSummary
+	for rows.Next() {
+		var (
+			m       MailSummary
+			when    time.Time
+			parents []string
+		)
+		if err := rows.Scan(append(pgSummaryDest(&m, &when), &parents)...); err != nil {
+			return nil, err
+		}
+		m.When = when
+		// messageParents reads the chain back from References.
+		m.References = strings.Join(parents, " ")
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// ShowConversation returns the stored messages of the conversation holding
+// the first message ScanBodies yields that selected picks, following
+// tb_threads into every folder rather than scanning them.
+func (s *pgStore) ShowConversation(ctx context.Context, profile string, opts showOptions, selected func(shownMessage) bool) ([]shownMessage, error) {
+	var seed *shownMessage
+	if err := s.ScanBodies(ctx, profile, opts, func(sm shownMessage) (bool, error) {
+		if !selected(sm) {
+			return true, nil
+		}
+		seed = &sm
+		return false, nil
+	}); err != nil || seed == nil {
+		return nil, err
+	}
+	graph, err := s.Conversation(ctx, profile, seed.summary.MessageID)
+	if err != nil || len(graph) == 0 {
+		return []shownMessage{*seed}, err
+	}
+	keys := make([]string, len(graph))
+	for i, m := range graph {
+		keys[i] = normalizeMessageID(m.MessageID)
+	}
+	var msgs []shownMessage
+	err = s.scanBodies(ctx, "profile = $1 AND message_id IN (SELECT message_id FROM tb_threads WHERE profile = $1 AND msg_key = ANY($2))",
+		[]any{profile, keys}, func(sm shownMessage) (bool, error) {
+			msgs = append(msgs, sm)
+			return true, nil
+		})
+	return msgs, err
+}
+
+// threadTreePG renders the conversation holding messageID from the reply
+// graph sync stored in Postgres.
+func (a *App) threadTreePG(profileName, messageID string) error {
+	profile, err := a.resolveProfile(profileName)
+	if err != nil {
+		return err
+	}
+	store, err := openPG()
+	if err != nil {
+		return fmt.Errorf("postgres required for --store pg: %w", err)
+	}
+	defer store.Close()
+	graph, err := store.Conversation(context.Background(), profile.Name, messageID)
+	if err != nil {
+		return err
+	}
+	for i := range graph {
+		if normalizeMessageID(graph[i].MessageID) == normalizeMessageID(messageID) {
+			return printThread(graph, graph[i])
+		}
+	}
+	fmt.Println("No matches.")
+	return nil
+}
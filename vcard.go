@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// vcardContact holds the fields of a vCard shown by `tb mail show`.
+type vcardContact struct {
+	Name   string   `json:"name"`
+	Org    string   `json:"org,omitempty"`
+	Title  string   `json:"title,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+	Phones []string `json:"phones,omitempty"`
+	raw    string
+}
+
+// messageVCards returns the contacts of every text/vcard or .vcf part of a raw message.
+func messageVCards(raw []byte) ([]vcardContact, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(msg.Body, maxMessageBytes))
+	if err != nil {
+		return nil, err
+	}
+	var cards []vcardContact
+	walkMIME(msg.Header, body, "", func(p mimePart) {
+		mediaType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if mediaType != "text/vcard" && mediaType != "text/x-vcard" && !strings.HasSuffix(strings.ToLower(partFilename(p)), ".vcf") {
+			return
+		}
+		data := decodedPart(p)
+		if cs := params["charset"]; cs != "" && !strings.EqualFold(cs, "utf-8") {
+			if conv, err := convertCharset(data, cs); err == nil {
+				data = conv
+			}
+		}
+		cards = append(cards, parseVCards(string(data))...)
+	})
+	return cards, nil
+}
+
+// parseVCards reads vCard 2.1/3.0/4.0 text, which shares iCalendar's line format.
+func parseVCards(text string) []vcardContact {
+	var cards []vcardContact
+	var cur *vcardContact
+	for _, p := range icalProps(text) {
+		// Property names may carry a group prefix (item1.EMAIL).
+		name := p.name
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(p.value, "VCARD"):
+			cur = &vcardContact{}
+		case cur == nil:
+			continue
+		case name == "END" && strings.EqualFold(p.value, "VCARD"):
+			cards = append(cards, *cur)
+			cur = nil
+		case name == "FN":
+			cur.Name = icalText(p.value)
+		case name == "N" && cur.Name == "":
+			// N:Family;Given;Additional;Prefix;Suffix
+			parts := strings.Split(p.value, ";")
+			for len(parts) < 2 {
+				parts = append(parts, "")
+			}
+			cur.Name = strings.TrimSpace(icalText(parts[1]) + " " + icalText(parts[0]))
+		case name == "ORG":
+			cur.Org = icalText(strings.ReplaceAll(strings.TrimRight(p.value, ";"), ";", ", "))
+		case name == "TITLE":
+			cur.Title = icalText(p.value)
+		case name == "EMAIL":
+			cur.Emails = append(cur.Emails, vcardTyped(p, strings.TrimPrefix(p.value, "mailto:")))
+		case name == "TEL":
+			cur.Phones = append(cur.Phones, vcardTyped(p, strings.TrimPrefix(p.value, "tel:")))
+		}
+	}
+	// Keep the original text of each card for export.
+	if blocks := vcardBlock.FindAllString(text, -1); len(blocks) == len(cards) {
+		for i := range cards {
+			cards[i].raw = blocks[i] + "\r\n"
+		}
+	}
+	return cards
+}
+
+var vcardBlock = regexp.MustCompile(`(?is)BEGIN:VCARD.*?END:VCARD`)
+
+// vcardTyped appends the TYPE parameter (work, home, cell, ...) to a value.
+func vcardTyped(p icalProp, value string) string {
+	var types []string
+	for _, t := range strings.Split(strings.ToLower(p.params["TYPE"]), ",") {
+		if t = strings.TrimSpace(t); t != "" && t != "pref" && t != "internet" && t != "voice" {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		return value
+	}
+	return value + " (" + strings.Join(types, ", ") + ")"
+}
+
+func printVCards(cards []vcardContact) {
+	for _, c := range cards {
+		fmt.Println()
+		fmt.Printf("Contact: %s\n", c.Name)
+		if c.Org != "" || c.Title != "" {
+			fmt.Printf("  Org:   %s\n", strings.Trim(strings.Join([]string{c.Title, c.Org}, ", "), ", "))
+		}
+		for _, e := range c.Emails {
+			fmt.Printf("  Email: %s\n", e)
+		}
+		for _, t := range c.Phones {
+			fmt.Printf("  Phone: %s\n", t)
+		}
+	}
+}
+
+// saveVCards writes each contact as <name>.vcf into dir without overwriting.
+func saveVCards(dir string, cards []vcardContact) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for i, c := range cards {
+		name := strings.Trim(emlNameUnsafe.ReplaceAllString(c.Name, "_"), "_.")
+		if name == "" {
+			name = fmt.Sprintf("contact-%d", i+1)
+		}
+		path, err := uniquePath(filepath.Join(dir, name+".vcf"))
+		if err != nil {
+			return paths, err
+		}
+		if err := os.WriteFile(path, []byte(c.raw), 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}